@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// accountStatus describes one authenticated user for the /accounts endpoint
+type accountStatus struct {
+	Email             string     `json:"email"`
+	Provider          string     `json:"provider"`
+	HasRefreshToken   bool       `json:"has_refresh_token"`
+	TokenExpiry       *time.Time `json:"token_expiry"`
+	WatchActive       bool       `json:"watch_active"`
+	WatchExpiration   *time.Time `json:"watch_expiration"`
+	WatchExpiringSoon bool       `json:"watch_expiring_soon"` // Within WATCH_EXPIRY_WARNING of expiry
+	LastHistoryID     uint64     `json:"last_history_id"`
+}
+
+// accountsHandler lists the mailboxes with stored tokens, along with their token and watch state
+// A user signed in with several providers is listed once per provider.
+func (s *Server) accountsHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	window := watchExpiryWarning()
+	accounts := []accountStatus{}
+
+	s.tokenStore.RLock()
+	for key, token := range s.tokenStore.tokens {
+		account := accountStatus{Email: key.Email, Provider: key.Provider, HasRefreshToken: token.RefreshToken != ""}
+		if !token.Expiry.IsZero() {
+			expiry := token.Expiry
+			account.TokenExpiry = &expiry
+		}
+		accounts = append(accounts, account)
+	}
+	s.tokenStore.RUnlock()
+
+	s.historyStore.RLock()
+	s.watchStore.RLock()
+	for i := range accounts {
+		key := accountKey{accounts[i].Provider, accounts[i].Email}
+		accounts[i].LastHistoryID = s.historyStore.history[key]
+		if expiration, ok := s.watchStore.expirations[key]; ok {
+			accounts[i].WatchExpiration = &expiration
+			accounts[i].WatchActive = expiration.After(now)
+			accounts[i].WatchExpiringSoon = expiration.Sub(now) <= window
+		}
+	}
+	s.watchStore.RUnlock()
+	s.historyStore.RUnlock()
+
+	sort.Slice(accounts, func(i, j int) bool {
+		if accounts[i].Email != accounts[j].Email {
+			return accounts[i].Email < accounts[j].Email
+		}
+		return accounts[i].Provider < accounts[j].Provider
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"count":    len(accounts),
+		"accounts": accounts,
+	})
+}
+
+// tokenStatusHandler reports whether a user has a stored token for the
+// provider and when it expires, from the token store alone without calling the provider
+// expired only describes the access token; users with a refresh token get a
+// new one on their next request.
+func (s *Server) tokenStatusHandler(w http.ResponseWriter, r *http.Request) {
+	userEmail, ok := s.requestUser(w, r, r.URL.Query().Get("userEmail"))
+	if !ok {
+		return
+	}
+	provider, ok := s.requestProvider(w, r, userEmail)
+	if !ok {
+		return
+	}
+
+	token, exists := s.userToken(provider.Name(), userEmail)
+	if !exists {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	var expiresAt *time.Time
+	if !token.Expiry.IsZero() {
+		expiry := token.Expiry
+		expiresAt = &expiry
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"authenticated":     true,
+		"provider":          provider.Name(),
+		"expires_at":        expiresAt,
+		"has_refresh_token": token.RefreshToken != "",
+		"expired":           expiresAt != nil && !expiresAt.After(time.Now()),
+	})
+}
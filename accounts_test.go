@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestAccountsHandlerRequiresAdmin(t *testing.T) {
+	tests := []struct {
+		name       string
+		adminToken string
+		header     string
+		value      string
+		want       int
+	}{
+		{"admin disabled", "", "X-Admin-Token", "anything", http.StatusForbidden},
+		{"no token", "admin-secret", "", "", http.StatusUnauthorized},
+		{"wrong token", "admin-secret", "X-Admin-Token", "guess", http.StatusUnauthorized},
+		{"header token", "admin-secret", "X-Admin-Token", "admin-secret", http.StatusOK},
+		{"bearer token", "admin-secret", "Authorization", "Bearer admin-secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ADMIN_TOKEN", tt.adminToken)
+			req := httptest.NewRequest(http.MethodGet, "/accounts", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			rec := httptest.NewRecorder()
+			newTestServer(t).Handler().ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccountsHandler(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+	s := newTestServer(t)
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	s.storeToken(providerGmail, "b@example.com", &oauth2.Token{AccessToken: "access", RefreshToken: "refresh", Expiry: expiry})
+	s.storeToken(providerGmail, "a@example.com", &oauth2.Token{AccessToken: "access"})
+	s.historyStore.history[accountKey{providerGmail, "b@example.com"}] = 4242
+	s.watchStore.expirations[accountKey{providerGmail, "b@example.com"}] = time.Now().Add(6 * 24 * time.Hour)
+	s.watchStore.expirations[accountKey{providerGmail, "a@example.com"}] = time.Now().Add(-time.Hour)
+
+	req := httptest.NewRequest(http.MethodGet, "/accounts", nil)
+	req.Header.Set("X-Admin-Token", "admin-secret")
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	var got struct {
+		Count    int                      `json:"count"`
+		Accounts []map[string]interface{} `json:"accounts"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.Count != 2 || len(got.Accounts) != 2 {
+		t.Fatalf("got %d accounts (count %d), want 2", len(got.Accounts), got.Count)
+	}
+	for _, account := range got.Accounts {
+		for _, key := range []string{"email", "provider", "has_refresh_token", "token_expiry", "watch_active", "watch_expiration", "watch_expiring_soon", "last_history_id"} {
+			if _, ok := account[key]; !ok {
+				t.Errorf("account %v lacks %q", account["email"], key)
+			}
+		}
+	}
+
+	a, b := got.Accounts[0], got.Accounts[1]
+	if a["email"] != "a@example.com" || b["email"] != "b@example.com" {
+		t.Fatalf("accounts = %v, want sorted by email", got.Accounts)
+	}
+	if a["has_refresh_token"] != false || a["token_expiry"] != nil || a["watch_active"] != false || a["last_history_id"] != float64(0) {
+		t.Errorf("a@example.com = %v, want no refresh token, expiry or active watch", a)
+	}
+	if b["has_refresh_token"] != true || b["token_expiry"] != expiry.Format(time.RFC3339Nano) || b["watch_active"] != true || b["last_history_id"] != float64(4242) {
+		t.Errorf("b@example.com = %v", b)
+	}
+}
+
+func TestTokenStatusHandler(t *testing.T) {
+	s := newTestServer(t)
+	fake := newFakeGmail(t, "valid@example.com")
+	fake.use(s)
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	s.storeToken(providerGmail, "valid@example.com", &oauth2.Token{AccessToken: "access", RefreshToken: "refresh", Expiry: expiry})
+	s.storeToken(providerGmail, "expired@example.com", &oauth2.Token{AccessToken: "access", Expiry: time.Now().Add(-time.Minute)})
+	s.storeToken(providerGmail, "noexpiry@example.com", &oauth2.Token{AccessToken: "access"})
+
+	tests := []struct {
+		name       string
+		user       string
+		want       int
+		expired    bool
+		hasExpiry  bool
+		hasRefresh bool
+	}{
+		{"authenticated and valid", "valid@example.com", http.StatusOK, false, true, true},
+		{"expired", "expired@example.com", http.StatusOK, true, true, false},
+		{"no expiry", "noexpiry@example.com", http.StatusOK, false, false, false},
+		{"unknown user", "unknown@example.com", http.StatusNotFound, false, false, false},
+		{"missing userEmail", "", http.StatusBadRequest, false, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/token/status?userEmail="+tt.user, nil))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			var got struct {
+				Authenticated   bool       `json:"authenticated"`
+				ExpiresAt       *time.Time `json:"expires_at"`
+				HasRefreshToken bool       `json:"has_refresh_token"`
+				Expired         bool       `json:"expired"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if !got.Authenticated || got.Expired != tt.expired || (got.ExpiresAt != nil) != tt.hasExpiry || got.HasRefreshToken != tt.hasRefresh {
+				t.Errorf("got %+v", got)
+			}
+			if tt.user == "valid@example.com" && !got.ExpiresAt.Equal(expiry) {
+				t.Errorf("expires_at = %v, want %v", got.ExpiresAt, expiry)
+			}
+		})
+	}
+
+	if calls := fake.calls(); len(calls) != 0 {
+		t.Errorf("Gmail calls = %v, want none", calls)
+	}
+}
+
+func TestTokenStatusHandlerSeveralProviders(t *testing.T) {
+	t.Setenv("OUTLOOK_CLIENT_ID", "client-id")
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+	s := newTestServer(t)
+	s.storeToken(providerGmail, "both@example.com", &oauth2.Token{AccessToken: "gmail", RefreshToken: "refresh"})
+	s.storeToken(providerOutlook, "both@example.com", &oauth2.Token{AccessToken: "outlook"})
+
+	tests := []struct {
+		query       string
+		want        int
+		wantRefresh bool
+	}{
+		{"&provider=gmail", http.StatusOK, true},
+		{"&provider=outlook", http.StatusOK, false},
+		{"&provider=imap", http.StatusNotFound, false},
+		{"&provider=yahoo", http.StatusBadRequest, false},
+		{"", http.StatusBadRequest, false},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/token/status?userEmail=both@example.com"+tt.query, nil))
+		if rec.Code != tt.want {
+			t.Errorf("%q: status = %d: %s", tt.query, rec.Code, rec.Body)
+			continue
+		}
+		if tt.want != http.StatusOK {
+			continue
+		}
+		var got struct {
+			Provider        string `json:"provider"`
+			HasRefreshToken bool   `json:"has_refresh_token"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if "&provider="+got.Provider != tt.query || got.HasRefreshToken != tt.wantRefresh {
+			t.Errorf("%q: got %+v", tt.query, got)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/accounts", nil)
+	req.Header.Set("X-Admin-Token", "admin-secret")
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	var listed struct {
+		Accounts []accountStatus `json:"accounts"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&listed); err != nil {
+		t.Fatalf("decode accounts: %v", err)
+	}
+	if accounts := listed.Accounts; len(accounts) != 2 || accounts[0].Provider != providerGmail || accounts[1].Provider != providerOutlook {
+		t.Errorf("accounts = %+v", listed.Accounts)
+	}
+}
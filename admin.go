@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// errPastHistoryWindow stops history paging once records pass endHistoryId
+var errPastHistoryWindow = errors.New("past the end of the history window")
+
+// reprocessHandler re-runs the push pipeline over a user's history window
+// Parameters: userEmail, startHistoryId, and optional endHistoryId (inclusive).
+// Responds with per-outcome counts of the messages processed.
+func (s *Server) reprocessHandler(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r.Context())
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parameters may come from the query string or a form-encoded body
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form body", http.StatusBadRequest)
+		return
+	}
+
+	userEmail := r.FormValue("userEmail")
+	if userEmail == "" {
+		http.Error(w, "Missing userEmail parameter", http.StatusBadRequest)
+		return
+	}
+	startHistoryID, err := strconv.ParseUint(r.FormValue("startHistoryId"), 10, 64)
+	if err != nil || startHistoryID == 0 {
+		http.Error(w, "Missing or invalid startHistoryId parameter", http.StatusBadRequest)
+		return
+	}
+	var endHistoryID uint64
+	if value := r.FormValue("endHistoryId"); value != "" {
+		endHistoryID, err = strconv.ParseUint(value, 10, 64)
+		if err != nil || endHistoryID < startHistoryID {
+			http.Error(w, "Invalid endHistoryId parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Only the user's Gmail mailbox can be read this way, whatever else they signed in with
+	token, exists := s.userToken(providerGmail, userEmail)
+	if !exists && s.isAuthenticated(userEmail) {
+		http.Error(w, "History replay is only available for Gmail accounts", http.StatusBadRequest)
+		return
+	}
+	if !exists {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	// The listing shares one Gmail deadline; each message gets its own below
+	ctx, cancel := gmailContext(r)
+	defer cancel()
+	srv, err := s.getUserGmailService(userEmail, token)
+	if err != nil {
+		logger.Printf("Unable to create Gmail service: %v", err)
+		http.Error(w, "Failed to create Gmail service", http.StatusInternalServerError)
+		return
+	}
+
+	// Collect message IDs added within the window, in history order; records
+	// are listed oldest first, so paging stops at the first one past the end
+	var msgIDs []string
+	seen := make(map[string]bool)
+	err = srv.Users.History.List(userID(userEmail)).StartHistoryId(startHistoryID).HistoryTypes("messageAdded").Context(ctx).
+		Pages(ctx, func(page *gmail.ListHistoryResponse) error {
+			for _, record := range page.History {
+				if endHistoryID != 0 && record.Id > endHistoryID {
+					return errPastHistoryWindow
+				}
+				for _, added := range record.MessagesAdded {
+					if added.Message != nil && !seen[added.Message.Id] {
+						seen[added.Message.Id] = true
+						msgIDs = append(msgIDs, added.Message.Id)
+					}
+				}
+			}
+			return nil
+		})
+	if err != nil && !errors.Is(err, errPastHistoryWindow) {
+		logger.Printf("Unable to get history: %v", err)
+		http.Error(w, "Failed to get history", gmailErrorStatus(err))
+		return
+	}
+
+	logger.Printf("Reprocessing %d messages for %s (history %d-%d)", len(msgIDs), userEmail, startHistoryID, endHistoryID)
+	outcomes := make(map[string]int)
+	for _, msgID := range msgIDs {
+		msgCtx, cancel := context.WithTimeout(r.Context(), gmailTimeout())
+		outcomes[s.processPushedMessage(msgCtx, logger, srv, userEmail, msgID)]++
+		cancel()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user_email":       userEmail,
+		"start_history_id": startHistoryID,
+		"end_history_id":   endHistoryID,
+		"messages":         len(msgIDs),
+		"outcomes":         outcomes,
+	})
+}
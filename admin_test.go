@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// reprocess posts form to /admin/reprocess with the admin token
+func reprocess(s *Server, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/admin/reprocess", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Admin-Token", "admin-secret")
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestReprocessHandlerValidation(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+	s := newTestServer(t)
+	authenticate(s, "user@example.com")
+
+	tests := []struct {
+		name string
+		form url.Values
+		want int
+	}{
+		{"missing userEmail", url.Values{"startHistoryId": {"1000"}}, http.StatusBadRequest},
+		{"missing start", url.Values{"userEmail": {"user@example.com"}}, http.StatusBadRequest},
+		{"invalid start", url.Values{"userEmail": {"user@example.com"}, "startHistoryId": {"abc"}}, http.StatusBadRequest},
+		{"end before start", url.Values{"userEmail": {"user@example.com"}, "startHistoryId": {"1000"}, "endHistoryId": {"999"}}, http.StatusBadRequest},
+		{"unknown user", url.Values{"userEmail": {"stranger@example.com"}, "startHistoryId": {"1000"}}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := reprocess(s, tt.form); rec.Code != tt.want {
+				t.Errorf("status = %d (%s), want %d", rec.Code, strings.TrimSpace(rec.Body.String()), tt.want)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/reprocess?userEmail=user@example.com&startHistoryId=1000", nil)
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status without admin token = %d, want 401", rec.Code)
+	}
+}
+
+func TestReprocessHistoryRange(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+	s := newTestServer(t)
+	fake := newFakeGmail(t, "user@example.com")
+	fake.use(s)
+	authenticate(s, "user@example.com")
+
+	now := time.Now()
+	alert := map[string]string{"Subject": "Transaction alert", "From": "alerts@examplebank.com"}
+	fake.addMessage("m1", alert, "Rs.100.00 spent on your credit card XX1234 at UBER on 10 Nov, 2025", "", now)
+	fake.addMessage("m2", map[string]string{"Subject": "Weekly deals", "From": "news@shop.example"}, "Save 20% on your next order.", "", now)
+	fake.addMessage("m3", alert, "Rs.424.00 spent on your credit card XX1234 at AMAZON on 11 Nov, 2025", "", now)
+	fake.addMessage("m4", alert, "Rs.999.00 spent on your credit card XX1234 at FLIPKART on 12 Nov, 2025", "", now)
+
+	// History after 1001 up to 1003 holds m2 and m3
+	rec := reprocess(s, url.Values{"userEmail": {"user@example.com"}, "startHistoryId": {"1001"}, "endHistoryId": {"1003"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var got struct {
+		Messages int            `json:"messages"`
+		Outcomes map[string]int `json:"outcomes"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.Messages != 2 || got.Outcomes[outcomeTransaction] != 1 || got.Outcomes[outcomeNonTransaction] != 1 {
+		t.Errorf("messages %d outcomes %v, want one transaction and one non-transaction", got.Messages, got.Outcomes)
+	}
+	if q := fake.query(http.MethodGet, "/gmail/v1/users/me/history"); q.Get("startHistoryId") != "1001" {
+		t.Errorf("history.list query = %v, want startHistoryId 1001", q)
+	}
+
+	records, _, err := s.transactions.Query("user@example.com", TransactionFilter{})
+	if err != nil || len(records) != 1 || records[0].Merchant != "AMAZON" {
+		t.Errorf("recorded %v (%v), want only the AMAZON transaction", records, err)
+	}
+	for _, call := range fake.calls() {
+		if strings.HasSuffix(call, "/messages/m1") || strings.HasSuffix(call, "/messages/m4") {
+			t.Errorf("fetched %s outside the history range", call)
+		}
+	}
+}
+
+func TestReprocessStopsPagingPastEnd(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+	s := newTestServer(t)
+	fake := newFakeGmail(t, "user@example.com")
+	fake.pageSize = 1
+	fake.use(s)
+	authenticate(s, "user@example.com")
+
+	now := time.Now()
+	for _, id := range []string{"m1", "m2", "m3", "m4", "m5"} {
+		fake.addMessage(id, map[string]string{"Subject": "Weekly deals", "From": "news@shop.example"}, "Save 20% on your next order.", "", now)
+	}
+
+	// Records 1002 and 1003 are in range; the page holding 1004 ends the listing
+	rec := reprocess(s, url.Values{"userEmail": {"user@example.com"}, "startHistoryId": {"1001"}, "endHistoryId": {"1003"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	pages := 0
+	for _, call := range fake.calls() {
+		if strings.HasSuffix(call, "/history") {
+			pages++
+		}
+	}
+	if pages != 3 {
+		t.Errorf("history.list pages fetched = %d, want 3", pages)
+	}
+}
+
+func TestReprocessGivesEachMessageItsOwnDeadline(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+	t.Setenv("GMAIL_TIMEOUT", "200ms")
+	s := newTestServer(t)
+	fake := newFakeGmail(t, "user@example.com")
+	fake.use(s)
+	authenticate(s, "user@example.com")
+
+	// Together the fetches outlast one GMAIL_TIMEOUT, each one alone does not
+	now := time.Now()
+	for _, id := range []string{"m1", "m2", "m3", "m4"} {
+		fake.addMessage(id, map[string]string{"Subject": "Weekly deals", "From": "news@shop.example"}, "Save 20% on your next order.", "", now)
+	}
+	fake.delay = 80 * time.Millisecond
+
+	rec := reprocess(s, url.Values{"userEmail": {"user@example.com"}, "startHistoryId": {"1000"}})
+	var got struct {
+		Outcomes map[string]int `json:"outcomes"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode %d %s: %v", rec.Code, rec.Body, err)
+	}
+	if got.Outcomes[outcomeFailed] != 0 || got.Outcomes[outcomeNonTransaction] != 4 {
+		t.Errorf("outcomes = %v, want 4 non-transactions", got.Outcomes)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Types of alertRule
+const (
+	alertSingleTransaction = "single_txn"
+	alertMonthlyCategory   = "monthly_category"
+)
+
+// maxAlertRules caps how many rules one user may define
+const maxAlertRules = 50
+
+// alertRule is a per-user spend limit checked as each new transaction is recorded
+// MaxAmount is in minor units.
+type alertRule struct {
+	ID        string `json:"id"`
+	Type      string `json:"type"`
+	Category  string `json:"category,omitempty"` // For monthly_category
+	MaxAmount int64  `json:"max_amount"`
+}
+
+// spendAlert is a triggered alert rule, delivered to the notification sinks
+// AmountMinorUnits is the transaction's amount for single_txn rules and the
+// month's category total for monthly_category rules.
+type spendAlert struct {
+	Rule             alertRule              `json:"rule"`
+	AmountMinorUnits int64                  `json:"amount_minor_units"`
+	Currency         string                 `json:"currency"`
+	Transaction      *CreditCardTransaction `json:"transaction"`
+}
+
+// validate checks a rule submitted to PUT /alerts
+func (rule alertRule) validate() error {
+	if rule.MaxAmount <= 0 {
+		return fmt.Errorf("max_amount must be positive")
+	}
+	switch rule.Type {
+	case alertSingleTransaction:
+		if rule.Category != "" {
+			return fmt.Errorf("category is only valid for %s rules", alertMonthlyCategory)
+		}
+	case alertMonthlyCategory:
+		if rule.Category == "" {
+			return fmt.Errorf("category is required for %s rules", alertMonthlyCategory)
+		}
+	default:
+		return fmt.Errorf("unknown type %q (use %s or %s)", rule.Type, alertSingleTransaction, alertMonthlyCategory)
+	}
+	return nil
+}
+
+// evaluateAlerts checks a newly recorded transaction against the user's alert rules
+// and logs every rule it triggers and sends it to the notification sinks
+func (s *Server) evaluateAlerts(logger *log.Logger, record TransactionRecord) {
+	if !record.CountsTowardSpend() {
+		return
+	}
+	rules, err := s.transactions.AlertRules(record.UserEmail)
+	if err != nil {
+		logger.Printf("Unable to load alert rules for %s: %v", record.UserEmail, err)
+		return
+	}
+
+	for _, rule := range rules {
+		switch rule.Type {
+		case alertSingleTransaction:
+			if record.AmountMinorUnits > rule.MaxAmount {
+				s.triggerAlert(logger, rule, record, record.AmountMinorUnits)
+			}
+		case alertMonthlyCategory:
+			if record.Category != rule.Category {
+				continue
+			}
+			total, err := s.monthlyCategorySpend(record)
+			if err != nil {
+				logger.Printf("Unable to evaluate alert %s for %s: %v", rule.ID, record.UserEmail, err)
+				continue
+			}
+			// Only the transaction that crosses the budget triggers, not every one after it
+			if total > rule.MaxAmount && total-record.AmountMinorUnits <= rule.MaxAmount {
+				s.triggerAlert(logger, rule, record, total)
+			}
+		}
+	}
+}
+
+// monthlyCategorySpend returns the net spend in the record's category, currency,
+// and calendar month (in TZ), including the record itself
+func (s *Server) monthlyCategorySpend(record TransactionRecord) (int64, error) {
+	ts := record.Timestamp.In(transactionLocation())
+	start := time.Date(ts.Year(), ts.Month(), 1, 0, 0, 0, 0, ts.Location())
+	records, _, err := s.transactions.Query(record.UserEmail, TransactionFilter{From: start, To: start.AddDate(0, 1, 0)})
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, group := range aggregateSpend(records, func(r TransactionRecord) string { return r.Category }) {
+		if group.Key == record.Category && group.Currency == record.Currency {
+			total = group.TotalMinorUnits
+		}
+	}
+	return total, nil
+}
+
+// triggerAlert logs a triggered alert rule and fans it out to the notification sinks
+func (s *Server) triggerAlert(logger *log.Logger, rule alertRule, record TransactionRecord, amount int64) {
+	logAlert(logger, rule, record, amount)
+	txn := record.CreditCardTransaction
+	s.notifiers.alert(logger, record.UserEmail, record.MessageID, record.Subject, &spendAlert{
+		Rule:             rule,
+		AmountMinorUnits: amount,
+		Currency:         record.Currency,
+		Transaction:      &txn,
+	})
+}
+
+// alertText renders a triggered alert for the chat sinks, e.g.
+// "Shopping spend this month is ₹1100.00, over the ₹1000.00 budget"
+func alertText(alert *spendAlert) string {
+	amount := formatAmount(alert.AmountMinorUnits, alert.Currency)
+	limit := formatAmount(alert.Rule.MaxAmount, alert.Currency)
+	if alert.Rule.Type == alertMonthlyCategory {
+		return fmt.Sprintf("%s spend this month is %s, over the %s budget", alert.Rule.Category, amount, limit)
+	}
+	merchant := alert.Transaction.Merchant
+	if merchant == "" {
+		merchant = "unknown merchant"
+	}
+	return fmt.Sprintf("%s at %s is over the %s single-transaction limit", amount, merchant, limit)
+}
+
+// logAlert logs a triggered alert rule
+func logAlert(logger *log.Logger, rule alertRule, record TransactionRecord, amount int64) {
+	logger.Printf("=== SPEND ALERT TRIGGERED ===")
+	logger.Printf("  User: %s", record.UserEmail)
+	if rule.Category != "" {
+		logger.Printf("  Rule: %s (%s, %s)", rule.ID, rule.Type, rule.Category)
+	} else {
+		logger.Printf("  Rule: %s (%s)", rule.ID, rule.Type)
+	}
+	logger.Printf("  Amount: %.2f %s, limit %.2f", float64(amount)/100, record.Currency, float64(rule.MaxAmount)/100)
+	logger.Printf("  Transaction: %.2f %s at %s (message %s)", record.AmountValue, record.Currency, record.Merchant, record.MessageID)
+	logger.Printf("================================")
+}
+
+// alertsHandler manages a user's alert rules: GET lists them, PUT replaces them
+// with a JSON array of rules, and DELETE removes one by id (or all without id)
+func (s *Server) alertsHandler(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r.Context())
+
+	userEmail, ok := s.requestUser(w, r, r.URL.Query().Get("userEmail"))
+	if !ok {
+		return
+	}
+	if !s.isAuthenticated(userEmail) {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var rules []alertRule
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&rules); err != nil {
+			http.Error(w, "Invalid JSON body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(rules) > maxAlertRules {
+			http.Error(w, fmt.Sprintf("At most %d rules are allowed", maxAlertRules), http.StatusBadRequest)
+			return
+		}
+		for i := range rules {
+			if err := rules[i].validate(); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid rule %d: %v", i, err), http.StatusBadRequest)
+				return
+			}
+			rules[i].ID = strconv.Itoa(i + 1)
+		}
+
+		s.alertRulesMu.Lock()
+		err := s.transactions.SetAlertRules(userEmail, rules)
+		s.alertRulesMu.Unlock()
+		if err != nil {
+			logger.Printf("Unable to store alert rules for %s: %v", userEmail, err)
+			http.Error(w, "Unable to store alert rules", http.StatusInternalServerError)
+			return
+		}
+		logger.Printf("Stored %d alert rules for %s", len(rules), userEmail)
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		// Serialize the read-modify-write so concurrent deletes don't resurrect rules
+		s.alertRulesMu.Lock()
+		rules, err := s.transactions.AlertRules(userEmail)
+		kept := []alertRule{}
+		for _, rule := range rules {
+			if id != "" && rule.ID != id {
+				kept = append(kept, rule)
+			}
+		}
+		if err == nil {
+			err = s.transactions.SetAlertRules(userEmail, kept)
+		}
+		s.alertRulesMu.Unlock()
+		if err != nil {
+			logger.Printf("Unable to delete alert rules for %s: %v", userEmail, err)
+			http.Error(w, "Unable to delete alert rules", http.StatusInternalServerError)
+			return
+		}
+		removed := len(rules) - len(kept)
+		if id != "" && removed == 0 {
+			http.Error(w, "Alert rule not found", http.StatusNotFound)
+			return
+		}
+		logger.Printf("Deleted %d alert rules for %s", removed, userEmail)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rules, err := s.transactions.AlertRules(userEmail)
+	if err != nil {
+		logger.Printf("Unable to load alert rules for %s: %v", userEmail, err)
+		http.Error(w, "Unable to load alert rules", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user_email": userEmail,
+		"rules":      rules,
+	})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// putAlerts replaces the user's alert rules with body
+func putAlerts(s *Server, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/alerts?userEmail=user@example.com", strings.NewReader(body)))
+	return rec
+}
+
+func TestAlertsHandlerValidation(t *testing.T) {
+	s := newTestServer(t)
+	authenticate(s, "user@example.com")
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"camelCase key", `[{"type":"single_txn","maxAmount":100}]`, "unknown field"},
+		{"no limit", `[{"type":"single_txn"}]`, "max_amount must be positive"},
+		{"category on single_txn", `[{"type":"single_txn","category":"Food","max_amount":100}]`, "category is only valid"},
+		{"monthly without category", `[{"type":"monthly_category","max_amount":100}]`, "category is required"},
+		{"unknown type", `[{"type":"weekly","max_amount":100}]`, "unknown type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := putAlerts(s, tt.body)
+			if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), tt.want) {
+				t.Errorf("got %d %q, want 400 containing %q", rec.Code, rec.Body, tt.want)
+			}
+		})
+	}
+
+	rec := putAlerts(s, `[{"type":"single_txn","max_amount":50000},{"type":"monthly_category","category":"Shopping","max_amount":100000}]`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT status = %d: %s", rec.Code, rec.Body)
+	}
+	var got struct {
+		Rules []map[string]interface{} `json:"rules"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil || len(got.Rules) != 2 {
+		t.Fatalf("rules = %v (%v), want 2", got.Rules, err)
+	}
+	if got.Rules[1]["id"] != "2" || got.Rules[1]["max_amount"] != float64(100000) {
+		t.Errorf("second rule = %v", got.Rules[1])
+	}
+}
+
+// fakeAlertSink is a Notifier that records the spend alerts it receives
+type fakeAlertSink struct {
+	alerts chan *spendAlert
+}
+
+func (f fakeAlertSink) Name() string { return "fake" }
+func (f fakeAlertSink) NotifyTransaction(ctx context.Context, userEmail string, txn *CreditCardTransaction) error {
+	return nil
+}
+func (f fakeAlertSink) NotifyBill(ctx context.Context, userEmail string, bill *BillReminder) error {
+	return nil
+}
+func (f fakeAlertSink) NotifyAlert(ctx context.Context, userEmail string, alert *spendAlert) error {
+	f.alerts <- alert
+	return nil
+}
+
+func TestEvaluateAlerts(t *testing.T) {
+	s := newTestServer(t)
+	authenticate(s, "user@example.com")
+	sink := fakeAlertSink{alerts: make(chan *spendAlert, 4)}
+	s.notifiers.notifiers = []Notifier{sink}
+	if rec := putAlerts(s, `[{"type":"single_txn","max_amount":70000},{"type":"monthly_category","category":"Shopping","max_amount":100000}]`); rec.Code != http.StatusOK {
+		t.Fatalf("PUT status = %d: %s", rec.Code, rec.Body)
+	}
+
+	month := time.Date(2025, 11, 2, 12, 0, 0, 0, transactionLocation())
+	tests := []struct {
+		name    string
+		minor   int64
+		trigger string // Rule type expected to fire, empty for none
+	}{
+		{"under both limits", 40000, ""},
+		{"crosses the monthly budget", 65000, "monthly_category"},
+		{"already over budget", 10000, ""},
+		{"over the single limit", 80000, "single_txn"},
+	}
+	for i, tt := range tests {
+		record := storeRecord("user@example.com", string(rune('a'+i)), tt.minor, "AMAZON", channelCreditCard, month.Add(time.Duration(i)*time.Hour))
+		record.Category = "Shopping"
+		if _, err := s.transactions.Add(record); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+
+		var buf bytes.Buffer
+		s.evaluateAlerts(log.New(&buf, "", 0), record)
+		out := buf.String()
+		fired := strings.Contains(out, "SPEND ALERT TRIGGERED")
+		if fired != (tt.trigger != "") || (fired && !strings.Contains(out, "("+tt.trigger)) {
+			t.Errorf("%s: alert log %q, want trigger %q", tt.name, out, tt.trigger)
+		}
+		if !fired {
+			continue
+		}
+		select {
+		case alert := <-sink.alerts:
+			if alert.Rule.Type != tt.trigger || alert.Transaction.ReferenceID != record.ReferenceID {
+				t.Errorf("%s: sink got %s alert for %s", tt.name, alert.Rule.Type, alert.Transaction.ReferenceID)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%s: sink received no alert", tt.name)
+		}
+	}
+	select {
+	case alert := <-sink.alerts:
+		t.Errorf("unexpected %s alert for %s", alert.Rule.Type, alert.Transaction.ReferenceID)
+	default:
+	}
+}
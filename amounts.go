@@ -0,0 +1,93 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// amountFormat describes the decimal and digit grouping separators of written amounts
+type amountFormat struct {
+	decimal string
+	group   string
+}
+
+var (
+	// pointDecimalFormat reads "1,234.56" and Indian "1,23,456.78"
+	pointDecimalFormat = amountFormat{decimal: ".", group: ","}
+	// commaDecimalFormat reads European "1.234,56"
+	commaDecimalFormat = amountFormat{decimal: ",", group: "."}
+)
+
+// amountFormatsByLanguage maps LOCALE languages (and "eu") to their amount format
+var amountFormatsByLanguage = map[string]amountFormat{
+	"en": pointDecimalFormat, "hi": pointDecimalFormat, "ja": pointDecimalFormat,
+	"zh": pointDecimalFormat, "ko": pointDecimalFormat, "th": pointDecimalFormat,
+	"ms": pointDecimalFormat, "us": pointDecimalFormat, "in": pointDecimalFormat,
+
+	"eu": commaDecimalFormat, "de": commaDecimalFormat, "fr": commaDecimalFormat,
+	"es": commaDecimalFormat, "it": commaDecimalFormat, "pt": commaDecimalFormat,
+	"nl": commaDecimalFormat, "da": commaDecimalFormat, "sv": commaDecimalFormat,
+	"nb": commaDecimalFormat, "no": commaDecimalFormat, "fi": commaDecimalFormat,
+	"pl": commaDecimalFormat, "cs": commaDecimalFormat, "ru": commaDecimalFormat,
+	"tr": commaDecimalFormat, "id": commaDecimalFormat, "el": commaDecimalFormat,
+	"ro": commaDecimalFormat, "hu": commaDecimalFormat,
+}
+
+// lookupAmountFormat returns the amount format for a locale like "de", "de_DE", "pt-BR" or "eu"
+// An empty locale is the point-decimal default.
+func lookupAmountFormat(locale string) (amountFormat, bool) {
+	locale = strings.ToLower(strings.TrimSpace(locale))
+	if locale == "" {
+		return pointDecimalFormat, true
+	}
+	language, _, _ := strings.Cut(strings.ReplaceAll(locale, "-", "_"), "_")
+	format, ok := amountFormatsByLanguage[language]
+	return format, ok
+}
+
+// defaultAmountFormat returns the amount format configured with LOCALE
+// Unknown locales fall back to point decimals ("1,234.56").
+func defaultAmountFormat() amountFormat {
+	locale := os.Getenv("LOCALE")
+	format, ok := lookupAmountFormat(locale)
+	if !ok {
+		log.Printf("Unknown LOCALE %q, using point decimals for amounts", locale)
+		return pointDecimalFormat
+	}
+	return format
+}
+
+// hasTwoDecimals reports whether amount is written with exactly two decimal places
+func (f amountFormat) hasTwoDecimals(amount string) bool {
+	sep := strings.LastIndex(amount, f.decimal)
+	return sep >= 0 && len(amount)-sep == 3
+}
+
+// minTransactionAmount returns the amount, in major units of any currency, below
+// which transactions are flagged LowValue, read from MIN_TRANSACTION_AMOUNT;
+// 0 (the default) flags nothing
+func minTransactionAmount() float64 {
+	value := strings.TrimSpace(os.Getenv("MIN_TRANSACTION_AMOUNT"))
+	if value == "" {
+		return 0
+	}
+	if min, err := strconv.ParseFloat(value, 64); err == nil && min >= 0 {
+		return min
+	}
+	log.Printf("Invalid MIN_TRANSACTION_AMOUNT %q, flagging no transactions", value)
+	return 0
+}
+
+// skipLowValueTransactions reports whether MIN_TRANSACTION_SKIP=true, which keeps
+// LowValue transactions out of alerts, notification sinks and the spreadsheet
+func skipLowValueTransactions() bool {
+	return strings.EqualFold(os.Getenv("MIN_TRANSACTION_SKIP"), "true")
+}
+
+// isLowValue reports whether a parsed amount is below minTransactionAmount,
+// such as a ₹1 card verification charge
+func isLowValue(txn *CreditCardTransaction) bool {
+	return txn.Amount != "" && txn.AmountValue < minTransactionAmount()
+}
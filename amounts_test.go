@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestLowValueTransactions(t *testing.T) {
+	t.Setenv("MIN_TRANSACTION_AMOUNT", "10")
+	tests := []struct {
+		name     string
+		body     string
+		lowValue bool
+	}{
+		{"verification charge", "Rs.1.00 spent on your credit card XX1234 at GOOGLE on 11 Nov, 2025", true},
+		{"just under", "Rs.9.99 spent on your credit card XX1234 at AMAZON on 11 Nov, 2025", true},
+		{"at the minimum", "Rs.10.00 spent on your credit card XX1234 at AMAZON on 11 Nov, 2025", false},
+		{"normal spend", "Rs.500.00 spent on your credit card XX1234 at SWIGGY on 11 Nov, 2025", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if ok, reason := classifyTransactionEmailFromSender("alerts@examplebank.com", "Transaction alert", tt.body); !ok {
+				t.Fatalf("not classified as a transaction: %s", reason)
+			}
+			txns := parseTransactionsFromSender("alerts@examplebank.com", "Transaction alert", tt.body)
+			if len(txns) != 1 {
+				t.Fatalf("parsed %d transactions, want 1", len(txns))
+			}
+			if txns[0].LowValue != tt.lowValue {
+				t.Errorf("low_value = %v for %v, want %v", txns[0].LowValue, txns[0].AmountValue, tt.lowValue)
+			}
+		})
+	}
+}
+
+func TestMinTransactionAmountConfig(t *testing.T) {
+	for value, want := range map[string]float64{"": 0, "10": 10, " 2.5 ": 2.5, "-1": 0, "ten": 0} {
+		t.Setenv("MIN_TRANSACTION_AMOUNT", value)
+		if got := minTransactionAmount(); got != want {
+			t.Errorf("MIN_TRANSACTION_AMOUNT=%q: got %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestRecordLowValueTransactions(t *testing.T) {
+	t.Setenv("MIN_TRANSACTION_AMOUNT", "10")
+	for _, skip := range []string{"", "true"} {
+		t.Run("skip="+skip, func(t *testing.T) {
+			t.Setenv("MIN_TRANSACTION_SKIP", skip)
+			s := newTestServer(t)
+			var txns []*CreditCardTransaction
+			for _, body := range []string{
+				"Rs.1.00 spent on your credit card XX1234 at GOOGLE on 11 Nov, 2025",
+				"Rs.500.00 spent on your credit card XX1234 at SWIGGY on 11 Nov, 2025",
+			} {
+				txns = append(txns, parseTransactionsFromSender("alerts@examplebank.com", "Transaction alert", body)...)
+			}
+
+			var buf bytes.Buffer
+			s.recordTransactions(log.New(&buf, "", 0), "user@example.com", "m1", "Transaction alert", txns)
+
+			// Low-value transactions are always stored, flagged
+			records, _, err := s.transactions.Query("user@example.com", TransactionFilter{})
+			if err != nil || len(records) != 2 {
+				t.Fatalf("stored %d records (%v), want 2", len(records), err)
+			}
+			for _, record := range records {
+				if record.LowValue != (record.AmountMinorUnits == 100) {
+					t.Errorf("%s: low_value = %v", record.Merchant, record.LowValue)
+				}
+			}
+			skipped := strings.Count(buf.String(), "below MIN_TRANSACTION_AMOUNT")
+			if want := map[string]int{"": 0, "true": 1}[skip]; skipped != want {
+				t.Errorf("skipped %d transactions, want %d:\n%s", skipped, want, buf.String())
+			}
+		})
+	}
+}
+
+func TestAmountLocales(t *testing.T) {
+	tests := []struct {
+		name   string
+		locale string
+		amount string
+		want   int64 // Minor units
+	}{
+		{"default", "", "1,234.56", 123456},
+		{"us", "en_US", "1,234.56", 123456},
+		{"us whole", "en_US", "1,234", 123400},
+		{"us small", "en_US", "1.23", 123},
+		{"indian", "hi_IN", "1,23,456.78", 12345678},
+		{"indian lakh default", "", "12,34,567.00", 123456700},
+		{"eu", "eu", "1.234,56", 123456},
+		{"german", "de_DE", "1.234,56", 123456},
+		{"german million", "de", "1.234.567,89", 123456789},
+		{"french", "fr-FR", "12,50", 1250},
+		{"portuguese", "pt_BR", "1.234", 123400},
+		{"unknown locale uses point decimals", "xx", "1,234.56", 123456},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("LOCALE", tt.locale)
+			got, err := parseAmountMinorUnits(tt.amount)
+			if err != nil || got != tt.want {
+				t.Errorf("parseAmountMinorUnits(%q) = %d, %v; want %d", tt.amount, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAmountLocaleInAlerts(t *testing.T) {
+	tests := []struct {
+		locale string
+		body   string
+		want   int64
+	}{
+		{"", "USD 1,234.56 spent on your credit card XX1234 at AMAZON", 123456},
+		{"de", "EUR 1.234,56 spent on your credit card XX1234 at AMAZON", 123456},
+		{"en_IN", "Rs.1,23,456.78 spent on your credit card XX1234 at AMAZON", 12345678},
+	}
+	for _, tt := range tests {
+		t.Setenv("LOCALE", tt.locale)
+		txn := parseCreditCardTransaction("Transaction alert", tt.body)
+		if txn.AmountMinorUnits != tt.want || txn.AmountValue != float64(tt.want)/100 {
+			t.Errorf("LOCALE=%q %q: amount = %d (%v), want %d", tt.locale, tt.body, txn.AmountMinorUnits, txn.AmountValue, tt.want)
+		}
+	}
+}
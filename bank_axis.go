@@ -0,0 +1,17 @@
+package main
+
+import "regexp"
+
+// Axis Bank credit card alerts, e.g.
+//
+//	Transaction Amount: INR 424 Merchant Name: SWIGGY Axis Bank Credit Card No. XX0000 Date & Time: 11-11-2025, 12:38:53 IST
+//	INR 424.00 spent on Axis Bank Card no. XX0000 at SWIGGY on 11-11-25
+func init() {
+	registerBankParser("Axis Bank", `@axisbank\.com$`, patternBankParser{
+		defaultCurrency: "INR",
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?is)Transaction Amount:\s*(?P<currency>INR|Rs\.?)\s*(?P<amount>\d[\d,]*(?:\.\d+)?)\s+Merchant Name:\s*(?P<merchant>.+?)\s+Axis Bank Credit Card No\.?\s*XX(?P<card>\d{4})\s+Date & Time:\s*(?P<date>\d{2}-\d{2}-\d{4}),?\s*(?P<time>\d{2}:\d{2}:\d{2})`),
+			regexp.MustCompile(`(?i)(?P<currency>INR|Rs\.?)\s*(?P<amount>\d[\d,]*(?:\.\d+)?) spent on Axis Bank (?:Credit )?Card no\.?\s*XX(?P<card>\d{4}) at (?P<merchant>.+?) on (?P<date>\d{2}-\d{2}-\d{2,4})`),
+		},
+	})
+}
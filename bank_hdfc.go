@@ -0,0 +1,17 @@
+package main
+
+import "regexp"
+
+// HDFC Bank credit card alerts, e.g.
+//
+//	Rs.424.00 spent on HDFC Bank Card x0000 at SWIGGY on 2025-11-11:12:38:53
+//	Thank you for using your HDFC Bank Credit Card ending 0000 for Rs 424.00 at SWIGGY on 11-11-2025 12:38:53
+func init() {
+	registerBankParser("HDFC Bank", `@hdfcbank\.(?:net|com|bank\.in)$`, patternBankParser{
+		defaultCurrency: "INR",
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)(?P<currency>Rs\.?|INR)\s*(?P<amount>\d[\d,]*(?:\.\d+)?) (?:spent|debited) on HDFC Bank (?:Credit |Debit )?Card (?:ending |x|XX)(?P<card>\d{4}) at (?P<merchant>.+?) on (?P<date>\d{4}-\d{2}-\d{2}|\d{2}-\d{2}-\d{4})(?::(?P<time>\d{2}:\d{2}:\d{2}))?`),
+			regexp.MustCompile(`(?i)HDFC Bank (?:Credit |Debit )?Card ending (?P<card>\d{4}) for (?P<currency>Rs\.?|INR)\s*(?P<amount>\d[\d,]*(?:\.\d+)?) at (?P<merchant>.+?) on (?P<date>\d{2}-\d{2}-\d{4})(?: (?P<time>\d{2}:\d{2}:\d{2}))?`),
+		},
+	})
+}
@@ -0,0 +1,17 @@
+package main
+
+import "regexp"
+
+// ICICI Bank credit card alerts, which often name the merchant before the amount, e.g.
+//
+//	INR 424.00 spent using ICICI Bank Card XX0000 on 11-Nov-25 on SWIGGY. Avl Limit: INR 45,000.00
+//	Transaction alert: SWIGGY, INR 424.00 on ICICI Bank Credit Card XX0000 on 11-Nov-25
+func init() {
+	registerBankParser("ICICI Bank", `@icicibank\.com$`, patternBankParser{
+		defaultCurrency: "INR",
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)(?P<currency>INR|Rs\.?)\s*(?P<amount>\d[\d,]*(?:\.\d+)?) spent using ICICI Bank (?:Credit )?Card XX(?P<card>\d{4}) on (?P<date>\d{2}-[A-Za-z]{3}-\d{2,4}) on (?P<merchant>.+?)\.(?:\s|$)`),
+			regexp.MustCompile(`(?i)Transaction alert:\s*(?P<merchant>[^,]+?),\s*(?P<currency>INR|Rs\.?)\s*(?P<amount>\d[\d,]*(?:\.\d+)?) on ICICI Bank (?:Credit )?Card XX(?P<card>\d{4})(?: on (?P<date>\d{2}-[A-Za-z]{3}-\d{2,4}))?`),
+		},
+	})
+}
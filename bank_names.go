@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/mail"
+	"os"
+	"strings"
+)
+
+// defaultBankDomains maps sender domains to bank names; BANK_NAMES adds to and
+// overrides these
+var defaultBankDomains = map[string]string{
+	"hdfcbank.net":         "HDFC Bank",
+	"hdfcbank.com":         "HDFC Bank",
+	"icicibank.com":        "ICICI Bank",
+	"axisbank.com":         "Axis Bank",
+	"sbicard.com":          "SBI Card",
+	"sbi.co.in":            "State Bank of India",
+	"kotak.com":            "Kotak Mahindra Bank",
+	"kotak.bank.in":        "Kotak Mahindra Bank",
+	"yesbank.in":           "Yes Bank",
+	"indusind.com":         "IndusInd Bank",
+	"idfcfirstbank.com":    "IDFC FIRST Bank",
+	"aubank.in":            "AU Small Finance Bank",
+	"rblbank.com":          "RBL Bank",
+	"federalbank.co.in":    "Federal Bank",
+	"bankofbaroda.com":     "Bank of Baroda",
+	"bankofbaroda.co.in":   "Bank of Baroda",
+	"pnb.co.in":            "Punjab National Bank",
+	"canarabank.com":       "Canara Bank",
+	"unionbankofindia.com": "Union Bank of India",
+	"americanexpress.com":  "American Express",
+	"aexp.com":             "American Express",
+	"citi.com":             "Citibank",
+	"hsbc.co.in":           "HSBC",
+	"sc.com":               "Standard Chartered",
+	"onecard.app":          "OneCard",
+	"paytm.com":            "Paytm",
+	"phonepe.com":          "PhonePe",
+	"amazonpay.in":         "Amazon Pay",
+	"getsimpl.com":         "Simpl",
+	"simpl.com":            "Simpl",
+	"chase.com":            "Chase",
+	"capitalone.com":       "Capital One",
+}
+
+// bankDomains returns the domain→bank map, with BANK_NAMES entries
+// ("mybank.com=My Bank,other.com=Other Bank") layered over the defaults
+func bankDomains() map[string]string {
+	domains := make(map[string]string, len(defaultBankDomains))
+	for domain, name := range defaultBankDomains {
+		domains[domain] = name
+	}
+	for _, entry := range strings.Split(os.Getenv("BANK_NAMES"), ",") {
+		domain, name, ok := strings.Cut(entry, "=")
+		domain = strings.ToLower(strings.Trim(strings.TrimSpace(domain), "@."))
+		if ok && domain != "" && strings.TrimSpace(name) != "" {
+			domains[domain] = strings.TrimSpace(name)
+		}
+	}
+	return domains
+}
+
+// bankNameFor names the bank that sent an email: the mapped name for the From
+// domain or a parent domain, else a mapped name mentioned in the display name,
+// else the raw domain
+func bankNameFor(from string) string {
+	address := senderAddress(from)
+	domain := address[strings.LastIndex(address, "@")+1:]
+	domains := bankDomains()
+
+	for candidate := domain; strings.Contains(candidate, "."); {
+		if name, ok := domains[candidate]; ok {
+			return name
+		}
+		_, candidate, _ = strings.Cut(candidate, ".")
+	}
+
+	if addr, err := mail.ParseAddress(from); err == nil && addr.Name != "" {
+		displayName := strings.ToLower(addr.Name)
+		best := ""
+		for _, name := range domains {
+			if len(name) > len(best) && strings.Contains(displayName, strings.ToLower(name)) {
+				best = name
+			}
+		}
+		if best != "" {
+			return best
+		}
+	}
+	return domain
+}
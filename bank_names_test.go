@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestBankNameFor(t *testing.T) {
+	t.Setenv("BANK_NAMES", "mybank.example=My Bank, @hdfcbank.net = HDFC Bank Cards")
+	tests := []struct {
+		from string
+		want string
+	}{
+		{"alerts@icicibank.com", "ICICI Bank"},
+		{"Axis Bank Alerts <alerts@axisbank.com>", "Axis Bank"},
+		{"onlinesbicard@sbicard.com", "SBI Card"},
+		{"alerts@mail.kotak.com", "Kotak Mahindra Bank"},
+		{"ALERTS@CHASE.COM", "Chase"},
+		{"Simpl <noreply@getsimpl.com>", "Simpl"},
+		// BANK_NAMES adds domains and overrides the defaults
+		{"alerts@mybank.example", "My Bank"},
+		{"alerts@hdfcbank.net", "HDFC Bank Cards"},
+		// Unmapped domain, named bank in the display name
+		{"Yes Bank <noreply@notifications.example>", "Yes Bank"},
+		// Unknown sender falls back to the raw domain
+		{"Card Alerts <alerts@unknownbank.example>", "unknownbank.example"},
+	}
+	for _, tt := range tests {
+		if got := bankNameFor(tt.from); got != tt.want {
+			t.Errorf("bankNameFor(%q) = %q, want %q", tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestParsedTransactionBank(t *testing.T) {
+	t.Setenv("BANK_NAMES", "")
+	body := "Rs.424.00 spent on your credit card XX1234 at AMAZON on 11 Nov, 2025"
+	for from, want := range map[string]string{
+		"alerts@rblbank.com":      "RBL Bank",
+		"alerts@cards.example.in": "cards.example.in",
+	} {
+		txns := parseTransactionsFromSender(from, "Transaction alert", body)
+		if len(txns) != 1 || txns[0].Bank != want {
+			t.Errorf("%s: parsed %v, want one transaction from %q", from, txns, want)
+		}
+	}
+}
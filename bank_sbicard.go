@@ -0,0 +1,15 @@
+package main
+
+import "regexp"
+
+// SBI Card alerts, e.g.
+//
+//	Rs.424.00 spent on your SBI Credit Card ending 0000 at SWIGGY on 11/11/25. Trxn. not done by you? Report at ...
+func init() {
+	registerBankParser("SBI Card", `@sbicard\.com$`, patternBankParser{
+		defaultCurrency: "INR",
+		patterns: []*regexp.Regexp{
+			regexp.MustCompile(`(?i)(?P<currency>Rs\.?|INR)\s*(?P<amount>\d[\d,]*(?:\.\d+)?) spent on your SBI Credit Card ending (?:with )?(?P<card>\d{4}) at (?P<merchant>.+?) on (?P<date>\d{2}/\d{2}/\d{2,4})`),
+		},
+	})
+}
@@ -0,0 +1,21 @@
+package main
+
+import "regexp"
+
+// walletPaymentPatterns cover wallet and pay-later alerts, e.g.
+//
+//	You paid ₹180 to Blinkit using Paytm Wallet
+//	Paid ₹250.00 to Zomato. Debited via UPI linked to HDFC Bank
+//	₹450 paid to Swiggy using Simpl
+var walletPaymentPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\b(?:paid|sent)\s+(?P<currency>Rs\.?|₹|INR)\s*(?P<amount>\d[\d,]*(?:\.\d+)?)\s+to\s+(?P<merchant>[A-Za-z0-9][A-Za-z0-9 &.'-]*?)(?:\s+(?:using|via|through|from|on)\b|[.,]\s|[.,]?$)`),
+	regexp.MustCompile(`(?i)(?P<currency>Rs\.?|₹|INR)\s*(?P<amount>\d[\d,]*(?:\.\d+)?)\s+(?:paid|spent)\s+(?:to|at|on)\s+(?P<merchant>[A-Za-z0-9][A-Za-z0-9 &.'-]*?)(?:\s+(?:using|via|through|from|on)\b|[.,]\s|[.,]?$)`),
+}
+
+// Wallet and buy-now-pay-later providers send their own payment alerts
+func init() {
+	registerBankParser("Paytm", `@paytm\.com$`, patternBankParser{defaultCurrency: "INR", channel: channelWallet, patterns: walletPaymentPatterns})
+	registerBankParser("PhonePe", `@phonepe\.com$`, patternBankParser{defaultCurrency: "INR", channel: channelWallet, patterns: walletPaymentPatterns})
+	registerBankParser("Amazon Pay", `@amazonpay\.in$`, patternBankParser{defaultCurrency: "INR", channel: channelWallet, patterns: walletPaymentPatterns})
+	registerBankParser("Simpl", `@(?:getsimpl|simpl)\.com$`, patternBankParser{defaultCurrency: "INR", channel: channelBNPL, patterns: walletPaymentPatterns})
+}
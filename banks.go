@@ -0,0 +1,197 @@
+package main
+
+import (
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+// BankParser parses transaction alerts in one bank's format
+// Parse returns false when the email doesn't match the bank's known formats.
+type BankParser interface {
+	Parse(subject, body string) (*CreditCardTransaction, bool)
+}
+
+// bankParserEntry associates a BankParser with the senders it handles
+type bankParserEntry struct {
+	name    string
+	senders *regexp.Regexp
+	parser  BankParser
+}
+
+// bankParsers holds the registered bank-specific parsers, in registration order
+var bankParsers []bankParserEntry
+
+// registerBankParser registers a parser for senders whose address matches senderPattern
+// Each bank lives in its own bank_*.go file and registers itself from init.
+func registerBankParser(name, senderPattern string, parser BankParser) {
+	bankParsers = append(bankParsers, bankParserEntry{
+		name:    name,
+		senders: regexp.MustCompile(senderPattern),
+		parser:  parser,
+	})
+}
+
+// bankParserFor returns the registered parser entry for a From header, if any
+func bankParserFor(from string) (bankParserEntry, bool) {
+	address := senderAddress(from)
+	if address == "" {
+		return bankParserEntry{}, false
+	}
+	for _, entry := range bankParsers {
+		if entry.senders.MatchString(address) {
+			return entry, true
+		}
+	}
+	return bankParserEntry{}, false
+}
+
+// parseTransactionsFromSender parses an alert using the sender's bank parser,
+// falling back to the generic parser when no bank parser matches. Extraction
+// rules from PARSER_RULES_FILE are applied on top of the result.
+func parseTransactionsFromSender(from, subject, body string) []*CreditCardTransaction {
+	txns, bankParsed := parseBuiltinTransactions(from, subject, body)
+	applyParserRules(from, subject, body, txns)
+
+	// Infer the issuer and currency from the sender when the alert doesn't state them
+	if entry, ok := bankParserFor(from); ok {
+		currency := ""
+		if p, ok := entry.parser.(interface{ DefaultCurrency() string }); ok {
+			currency = p.DefaultCurrency()
+		}
+		for _, txn := range txns {
+			if txn.Issuer == "" {
+				txn.Issuer = entry.name
+			}
+			if txn.Currency == "" && txn.Amount != "" {
+				txn.Currency = currency
+			}
+		}
+	}
+
+	knownSender := len(ruleGroupsFor(from)) > 0
+	if _, ok := bankParserFor(from); ok {
+		knownSender = true
+	}
+	bank := bankNameFor(from)
+	for i, txn := range txns {
+		if len(txns) > 1 {
+			txn.Segment = i + 1
+		}
+		txn.Bank = bank
+		txn.Category = categorizeMerchant(txn.Merchant)
+		txn.LowValue = isLowValue(txn)
+		scoreTransaction(txn, subject+" "+body, knownSender, bankParsed)
+	}
+	return txns
+}
+
+// parseBuiltinTransactions parses an alert with the built-in bank and generic parsers,
+// reporting whether a bank-specific parser matched
+func parseBuiltinTransactions(from, subject, body string) ([]*CreditCardTransaction, bool) {
+	if entry, ok := bankParserFor(from); ok {
+		if txn, ok := entry.parser.Parse(subject, body); ok {
+			debugf("Parsed transaction with %s parser", entry.name)
+			return []*CreditCardTransaction{txn}, true
+		}
+		debugf("%s parser did not match, using generic parser", entry.name)
+	}
+	return parseCreditCardTransactions(subject, body), false
+}
+
+// senderAddress extracts the lowercased email address from a From header
+func senderAddress(from string) string {
+	if addr, err := mail.ParseAddress(from); err == nil {
+		return strings.ToLower(addr.Address)
+	}
+	return strings.ToLower(strings.Trim(strings.TrimSpace(from), "<>"))
+}
+
+// patternBankParser parses alerts with regexps whose named groups
+// (amount, currency, card, merchant, date, time) map to transaction fields
+// The first matching pattern wins; currency defaults to defaultCurrency.
+// Patterns describe spend alerts, so the direction defaults to debit.
+type patternBankParser struct {
+	patterns        []*regexp.Regexp
+	defaultCurrency string
+	channel         string // Overrides the detected channel when set, e.g. for wallet providers
+}
+
+// DefaultCurrency returns the currency assumed when an alert has no currency symbol
+func (p patternBankParser) DefaultCurrency() string {
+	return p.defaultCurrency
+}
+
+// Parse implements BankParser
+func (p patternBankParser) Parse(subject, body string) (*CreditCardTransaction, bool) {
+	text := subject + " " + body
+	for _, pattern := range p.patterns {
+		loc := pattern.FindStringSubmatchIndex(text)
+		if loc == nil {
+			continue
+		}
+
+		txn := &CreditCardTransaction{Channel: detectChannel(text), Currency: p.defaultCurrency}
+		if p.channel != "" {
+			txn.Channel = p.channel
+		}
+		txn.Direction, txn.IsRefund = detectDirection(text)
+		if txn.Direction == directionUnknown {
+			txn.Direction = directionDebit
+		}
+		txn.Status = detectStatus(text, txn.Direction)
+		txn.Network, txn.Issuer = detectCardNetworkAndIssuer(text)
+		for i, name := range pattern.SubexpNames() {
+			if loc[2*i] < 0 {
+				continue
+			}
+			value := strings.TrimSpace(text[loc[2*i]:loc[2*i+1]])
+			if value == "" {
+				continue
+			}
+			switch name {
+			case "amount":
+				txn.Amount = value
+			case "currency":
+				txn.Currency = currencyCode(value)
+			case "card":
+				txn.CardNumber = value
+			case "merchant":
+				txn.Merchant = normalizeMerchant(value)
+			case "date":
+				txn.Date = value
+			case "time":
+				txn.Time = value
+			}
+		}
+
+		txn.RawAmount = rawAmountText(text, loc, pattern)
+		if minor, err := parseAmountMinorUnits(txn.Amount); err == nil {
+			txn.AmountMinorUnits = minor
+			txn.AmountValue = float64(minor) / 100
+		}
+		if txn.Channel == channelWallet || txn.Channel == channelBNPL {
+			applyFundingSource(txn, text)
+		}
+		applyReferenceAndBalance(txn, text)
+		applyTimestamp(txn)
+		return txn, true
+	}
+	return nil, false
+}
+
+// rawAmountText returns the matched text spanning the currency and amount groups
+func rawAmountText(text string, loc []int, pattern *regexp.Regexp) string {
+	amount := pattern.SubexpIndex("amount")
+	if amount < 0 || loc[2*amount] < 0 {
+		return ""
+	}
+	start, end := loc[2*amount], loc[2*amount+1]
+	if currency := pattern.SubexpIndex("currency"); currency >= 0 && loc[2*currency] >= 0 {
+		start = min(start, loc[2*currency])
+		if loc[2*currency+1] > end {
+			end = loc[2*currency+1]
+		}
+	}
+	return strings.TrimSpace(text[start:end])
+}
@@ -0,0 +1,114 @@
+package main
+
+import "testing"
+
+func TestBankParsers(t *testing.T) {
+	tests := []struct {
+		name     string
+		from     string
+		subject  string
+		body     string
+		bank     string // Bank parser expected to match; empty for the generic parser
+		amount   int64
+		card     string
+		merchant string
+		date     string
+	}{
+		{"hdfc spent", "HDFC Bank InstaAlerts <alerts@hdfcbank.net>", "Alert : Update on your HDFC Bank Credit Card",
+			"Rs.424.00 spent on HDFC Bank Card x1234 at SWIGGY on 2025-11-11:12:38:53", "HDFC Bank", 42400, "1234", "SWIGGY", "2025-11-11"},
+		{"hdfc thank you", "alerts@hdfcbank.bank.in", "Transaction alert",
+			"Thank you for using your HDFC Bank Credit Card ending 1234 for Rs 1,299.00 at AMAZON PAY on 11-11-2025 12:38:53", "HDFC Bank", 129900, "1234", "AMAZON PAY", "11-11-2025"},
+		{"icici amount first", "ICICI Bank <credit_cards@icicibank.com>", "Transaction alert for your ICICI Bank Credit Card",
+			"INR 424.00 spent using ICICI Bank Card XX5678 on 11-Nov-25 on SWIGGY. Avl Limit: INR 45,000.00", "ICICI Bank", 42400, "5678", "SWIGGY", "11-Nov-25"},
+		{"icici merchant first", "credit_cards@icicibank.com", "Transaction alert",
+			"Transaction alert: BIGBASKET, INR 2,150.50 on ICICI Bank Credit Card XX5678 on 12-Nov-25", "ICICI Bank", 215050, "5678", "BIGBASKET", "12-Nov-25"},
+		{"sbi card", "SBI Card <onlinesbicard@sbicard.com>", "Transaction Alert from SBI Card",
+			"Rs.799.00 spent on your SBI Credit Card ending 9012 at NETFLIX on 11/11/25. Trxn. not done by you? Report at https://sbicard.com/Dispute", "SBI Card", 79900, "9012", "NETFLIX", "11/11/25"},
+		{"axis labelled", "Axis Bank Alerts <alerts@axisbank.com>", "Transaction alert on Axis Bank Credit Card",
+			"Transaction Amount: INR 424 Merchant Name: SWIGGY Axis Bank Credit Card No. XX3456 Date & Time: 11-11-2025, 12:38:53 IST", "Axis Bank", 42400, "3456", "SWIGGY", "11-11-2025"},
+		{"axis sentence", "alerts@axisbank.com", "Transaction alert",
+			"INR 3,000.00 spent on Axis Bank Card no. XX3456 at DMART on 11-11-25", "Axis Bank", 300000, "3456", "DMART", "11-11-25"},
+		{"bank sender in another format", "alerts@hdfcbank.net", "Transaction alert",
+			"Rs.250.00 spent on your credit card XX1234 at ZOMATO on 11 Nov, 2025", "", 25000, "", "ZOMATO", "11 Nov, 2025"},
+		{"unknown sender", "alerts@examplebank.com", "Transaction alert",
+			"Rs.424.00 spent on your credit card XX1234 at AMAZON on 11 Nov, 2025", "", 42400, "", "AMAZON", "11 Nov, 2025"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			txns, bankParsed := parseBuiltinTransactions(tt.from, tt.subject, tt.body)
+			if bankParsed != (tt.bank != "") {
+				t.Errorf("bank parser matched = %v, want %v", bankParsed, tt.bank != "")
+			}
+			if len(txns) != 1 {
+				t.Fatalf("parsed %d transactions, want 1", len(txns))
+			}
+			txn := txns[0]
+			if txn.AmountMinorUnits != tt.amount || txn.Merchant != tt.merchant || txn.Date != tt.date {
+				t.Errorf("got amount %d merchant %q date %q, want %d %q %q", txn.AmountMinorUnits, txn.Merchant, txn.Date, tt.amount, tt.merchant, tt.date)
+			}
+			if tt.card != "" && txn.CardNumber != tt.card {
+				t.Errorf("card = %q, want %q", txn.CardNumber, tt.card)
+			}
+			if tt.bank != "" && txn.Currency != "INR" {
+				t.Errorf("currency = %q, want INR", txn.Currency)
+			}
+		})
+	}
+}
+
+func TestBankParserFor(t *testing.T) {
+	tests := []struct {
+		from string
+		want string
+	}{
+		{"HDFC Bank InstaAlerts <alerts@hdfcbank.net>", "HDFC Bank"},
+		{"ALERTS@HDFCBANK.NET", "HDFC Bank"},
+		{"<credit_cards@icicibank.com>", "ICICI Bank"},
+		{"onlinesbicard@sbicard.com", "SBI Card"},
+		{"alerts@axisbank.com", "Axis Bank"},
+		{"alerts@hdfcbank.net.evil.example", ""},
+		{"alerts@nothdfcbank.com", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		entry, ok := bankParserFor(tt.from)
+		if ok != (tt.want != "") || entry.name != tt.want {
+			t.Errorf("bankParserFor(%q) = %q, %v; want %q", tt.from, entry.name, ok, tt.want)
+		}
+	}
+}
+
+func TestCardNetworkAndIssuer(t *testing.T) {
+	tests := []struct {
+		name    string
+		from    string
+		body    string
+		network string
+		issuer  string
+	}{
+		{"hdfc visa", "alerts@examplebank.com", "Rs.424.00 spent on your HDFC Bank Visa Credit Card ending 0000 at AMAZON on 11 Nov, 2025", "Visa", "HDFC Bank"},
+		{"icici mastercard", "alerts@examplebank.com", "INR 1,299.00 spent on ICICI Bank Mastercard Credit Card ending 5678 at SWIGGY", "Mastercard", "ICICI Bank"},
+		{"amex", "alerts@examplebank.com", "You've spent Rs.2,000.00 on your American Express Card ending 1001 at ZOMATO", "American Express", "American Express"},
+		{"sbi rupay", "alerts@examplebank.com", "Rs.799.00 spent on your SBI RuPay Credit Card ending 9012 at NETFLIX", "RuPay", "SBI Card"},
+		{"hdfc diners", "alerts@examplebank.com", "Your HDFC Bank Diners Club Credit Card ending 4321 was used for Rs.5,000.00 at MAKEMYTRIP", "Diners Club", "HDFC Bank"},
+		{"kotak master card", "alerts@examplebank.com", "Rs.150.00 spent on Kotak Master Card ending 1111 at UBER", "Mastercard", "Kotak Mahindra Bank"},
+		{"first mention wins", "alerts@examplebank.com", "Rs.150.00 spent on your IndusInd Bank Credit Card ending 2222 at AMAZON. Pay your HDFC bill too.", "", "IndusInd Bank"},
+		{"issuer from sender", "alerts@axisbank.com", "INR 3,000.00 spent on your credit card ending 3456 at DMART", "", "Axis Bank"},
+		{"body beats sender", "alerts@axisbank.com", "INR 3,000.00 spent on your RBL Bank Visa credit card ending 3456 at DMART", "Visa", "RBL Bank"},
+		{"nothing mentioned", "alerts@examplebank.com", "Rs.424.00 spent on your credit card ending 0000 at AMAZON", "", ""},
+		// The payee's UPI handle names the payee's bank, not the card's
+		{"upi handle", "alerts@examplebank.com", "Rs.250.00 debited from account 1234 to VPA swiggy@icici via UPI. Ref 432912345678", "", ""},
+		{"email address", "alerts@examplebank.com", "Rs.424.00 spent on your credit card ending 0000 at AMAZON. Questions? Write to cards@hdfc.example", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			txns := parseTransactionsFromSender(tt.from, "Transaction alert", tt.body)
+			if len(txns) != 1 {
+				t.Fatalf("parsed %d transactions, want 1", len(txns))
+			}
+			if txns[0].Network != tt.network || txns[0].Issuer != tt.issuer {
+				t.Errorf("network %q issuer %q, want %q %q", txns[0].Network, txns[0].Issuer, tt.network, tt.issuer)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// BillReminder represents a credit card statement or bill-due email
+type BillReminder struct {
+	TotalDue             string `json:"total_due"`
+	TotalDueMinorUnits   int64  `json:"total_due_minor_units"`
+	MinimumDue           string `json:"minimum_due"`
+	MinimumDueMinorUnits int64  `json:"minimum_due_minor_units"`
+	Currency             string `json:"currency"`
+	DueDate              string `json:"due_date"`
+	CardNumber           string `json:"card_number"`
+	StatementPeriod      string `json:"statement_period"`
+	Issuer               string `json:"issuer"`
+}
+
+var (
+	// billKeywordPattern matches statement and bill-due language in lowercased text
+	billKeywordPattern = regexp.MustCompile(`\bstatement\b|\bbill\b|payment due|\bdue date\b`)
+
+	// totalDuePattern matches "Total due Rs 23,400", "Total Amount Due: INR 23,400.00"
+	totalDuePattern = regexp.MustCompile(`(?i)\btotal (?:amount )?(?:due|outstanding)\s*(?:is|of)?\s*:?\s*(Rs\.?|₹|INR|USD|US\$|\$)?\s*(\d(?:[\d,.]*\d)?)`)
+
+	// minimumDuePattern matches "minimum due Rs 1,170", "Min. Amount Due: INR 1,170.00"
+	minimumDuePattern = regexp.MustCompile(`(?i)\bmin(?:imum|\.)?\s*(?:amount )?due\s*(?:is|of)?\s*:?\s*(Rs\.?|₹|INR|USD|US\$|\$)?\s*(\d(?:[\d,.]*\d)?)`)
+
+	// dueDatePattern matches "due date 05-Dec-2025", "Payment Due Date: 05/12/2025", "due by 5 Dec, 2025"
+	dueDatePattern = regexp.MustCompile(`(?i)\bdue (?:date|by|on)\s*(?:is)?\s*:?\s*(\d{1,2}[-/ ](?:[A-Za-z]{3,9}|\d{1,2})[-/ ,]*\d{2,4})`)
+
+	// maskedCardPattern matches masked card numbers like "Card XX1234", "card no. ****1234"
+	maskedCardPattern = regexp.MustCompile(`(?i)\bcard\s*(?:no\.?\s*)?[Xx*]+(\d{4})\b`)
+
+	// statementPeriodPattern matches "statement for Nov", "Statement period: 12 Oct 2025 to 11 Nov 2025",
+	// "Statement period: 16/10/2025 - 15/11/2025"
+	statementPeriodPattern = regexp.MustCompile(`(?i)\bstatement (?:for|period|dated)\s*(?:the month of\s*)?:?\s*((?:\d{1,2}[-/.]\d{1,2}[-/.]\d{2,4}|(?:\d{1,2}[-/ ])?[A-Za-z]{3,9}(?:[-/ ,]*\d{4})?)(?:\s*(?:to|-)\s*(?:\d{1,2}[-/.]\d{1,2}[-/.]\d{2,4}|(?:\d{1,2}[-/ ])?[A-Za-z]{3,9}(?:[-/ ,]*\d{4})?))?)`)
+)
+
+// classifyBillReminder decides whether an email is a statement or bill-due reminder
+// It needs statement/bill language together with a total due, minimum due, or due date.
+func classifyBillReminder(subject, body string) (bool, string) {
+	combined := subject + " " + body
+	if !billKeywordPattern.MatchString(strings.ToLower(combined)) {
+		return false, "no statement keywords"
+	}
+	if totalDuePattern.MatchString(combined) || minimumDuePattern.MatchString(combined) || dueDatePattern.MatchString(combined) {
+		return true, "matched statement/bill-due keywords"
+	}
+	return false, "statement keywords without amounts or due date"
+}
+
+// parseBillReminder extracts the amounts, due date, card, and period of a statement email
+func parseBillReminder(subject, body string) *BillReminder {
+	combined := subject + " " + body
+	bill := &BillReminder{}
+
+	if matches := totalDuePattern.FindStringSubmatch(combined); len(matches) > 2 {
+		bill.TotalDue = strings.TrimRight(matches[2], ",")
+		bill.TotalDueMinorUnits, _ = parseAmountMinorUnits(bill.TotalDue)
+		bill.Currency = currencyCode(matches[1])
+	}
+	if matches := minimumDuePattern.FindStringSubmatch(combined); len(matches) > 2 {
+		bill.MinimumDue = strings.TrimRight(matches[2], ",")
+		bill.MinimumDueMinorUnits, _ = parseAmountMinorUnits(bill.MinimumDue)
+		if bill.Currency == "" {
+			bill.Currency = currencyCode(matches[1])
+		}
+	}
+	if matches := dueDatePattern.FindStringSubmatch(combined); len(matches) > 1 {
+		bill.DueDate = strings.TrimSpace(matches[1])
+	}
+	if matches := statementPeriodPattern.FindStringSubmatch(combined); len(matches) > 1 {
+		bill.StatementPeriod = strings.TrimSpace(matches[1])
+	}
+	for _, pattern := range append([]*regexp.Regexp{maskedCardPattern}, cardPatterns...) {
+		if matches := pattern.FindStringSubmatch(combined); len(matches) > 1 {
+			bill.CardNumber = matches[1]
+			break
+		}
+	}
+	_, bill.Issuer = detectCardNetworkAndIssuer(combined)
+	return bill
+}
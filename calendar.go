@@ -0,0 +1,265 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// billRetention is how long past its due date a bill stays in the calendar feed
+const billRetention = 90 * 24 * time.Hour
+
+// defaultBillAlarmDays is used when BILL_ALARM_DAYS is unset
+const defaultBillAlarmDays = 3
+
+// icsEscaper escapes iCalendar TEXT values (RFC 5545 section 3.3.11)
+var icsEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// billAlarmDays returns how many days before a due date the feed's reminder
+// fires, from BILL_ALARM_DAYS; 0 leaves events without an alarm
+func billAlarmDays() int {
+	value := strings.TrimSpace(os.Getenv("BILL_ALARM_DAYS"))
+	if value == "" {
+		return defaultBillAlarmDays
+	}
+	if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+		return n
+	}
+	log.Printf("Invalid BILL_ALARM_DAYS %q, using %d", value, defaultBillAlarmDays)
+	return defaultBillAlarmDays
+}
+
+// calendarTokensPath returns the file feed tokens are saved to, from CALENDAR_TOKENS_PATH
+func calendarTokensPath() string {
+	if value := strings.TrimSpace(os.Getenv("CALENDAR_TOKENS_PATH")); value != "" {
+		return value
+	}
+	return "calendar_tokens.json"
+}
+
+// calendarToken returns the user's feed secret, generating one when the user
+// has none or rotate is set
+// New tokens are saved at once, so subscribed feed URLs survive a restart.
+func (s *Server) calendarToken(userEmail string, rotate bool) (string, error) {
+	s.calendarTokens.Lock()
+	defer s.calendarTokens.Unlock()
+	s.loadCalendarTokensLocked()
+	if token, ok := s.calendarTokens.tokens[userEmail]; ok && !rotate {
+		return token, nil
+	}
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("unable to generate calendar token: %v", err)
+	}
+	token := hex.EncodeToString(b[:])
+	s.calendarTokens.tokens[userEmail] = token
+	s.saveCalendarTokensLocked()
+	return token, nil
+}
+
+// validCalendarToken reports whether token is the user's current feed secret
+func (s *Server) validCalendarToken(userEmail, token string) bool {
+	s.calendarTokens.Lock()
+	s.loadCalendarTokensLocked()
+	expected, ok := s.calendarTokens.tokens[userEmail]
+	s.calendarTokens.Unlock()
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
+
+// loadCalendarTokensLocked reads the saved feed tokens from disk once; s.calendarTokens must be locked
+func (s *Server) loadCalendarTokensLocked() {
+	if s.calendarTokens.loaded {
+		return
+	}
+	s.calendarTokens.loaded = true
+
+	data, err := os.ReadFile(calendarTokensPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Unable to read calendar tokens: %v", err)
+		}
+		return
+	}
+	var tokens map[string]string
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		log.Printf("Unable to parse calendar tokens: %v", err)
+		return
+	}
+	for userEmail, token := range tokens {
+		s.calendarTokens.tokens[userEmail] = token
+	}
+}
+
+// saveCalendarTokensLocked writes the feed tokens to disk; s.calendarTokens must be locked
+func (s *Server) saveCalendarTokensLocked() {
+	data, err := json.MarshalIndent(s.calendarTokens.tokens, "", "  ")
+	if err != nil {
+		log.Printf("Unable to encode calendar tokens: %v", err)
+		return
+	}
+	if err := os.WriteFile(calendarTokensPath(), data, 0600); err != nil {
+		log.Printf("Unable to write calendar tokens: %v", err)
+	}
+}
+
+// billSummary renders a calendar event title, e.g. "HDFC card payment due ₹23,400"
+func billSummary(bill *BillReminder) string {
+	issuer := strings.TrimSpace(bill.Issuer)
+	if issuer == "" {
+		issuer = "Credit"
+	}
+	amount := strings.TrimSpace(bill.TotalDue + " " + bill.Currency)
+	if symbol, ok := currencySymbols[bill.Currency]; ok && bill.TotalDue != "" {
+		amount = symbol + bill.TotalDue
+	}
+	return strings.TrimSpace(issuer + " card payment due " + amount)
+}
+
+// writeICSLine writes one content line, folded at 75 octets without splitting
+// UTF-8 sequences (RFC 5545 section 3.1)
+func writeICSLine(b *strings.Builder, line string) {
+	for limit := 75; len(line) > limit; limit = 74 {
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+	}
+	b.WriteString(line + "\r\n")
+}
+
+// billsCalendar renders bills as an iCalendar feed with one all-day event per due date
+func billsCalendar(userEmail string, bills []*BillReminder, alarmDays int, now time.Time) string {
+	var b strings.Builder
+	writeICSLine(&b, "BEGIN:VCALENDAR")
+	writeICSLine(&b, "VERSION:2.0")
+	writeICSLine(&b, "PRODID:-//read-emails//Bill due dates//EN")
+	writeICSLine(&b, "CALSCALE:GREGORIAN")
+	writeICSLine(&b, "METHOD:PUBLISH")
+	writeICSLine(&b, "X-WR-CALNAME:Card bills")
+
+	stamp := now.UTC().Format("20060102T150405Z")
+	for _, bill := range bills {
+		due, ok := billDueDate(bill)
+		if !ok {
+			continue
+		}
+		// The UID stays stable across fetches so calendar apps update events in place
+		sum := sha256.Sum256([]byte(userEmail + "|" + bill.Issuer + "|" + bill.CardNumber + "|" + due.Format("2006-01-02")))
+		summary := billSummary(bill)
+
+		description := []string{"Total due: " + strings.TrimSpace(bill.TotalDue+" "+bill.Currency)}
+		if bill.MinimumDue != "" {
+			description = append(description, "Minimum due: "+strings.TrimSpace(bill.MinimumDue+" "+bill.Currency))
+		}
+		if bill.CardNumber != "" {
+			description = append(description, "Card: XX"+bill.CardNumber)
+		}
+		if bill.StatementPeriod != "" {
+			description = append(description, "Statement: "+bill.StatementPeriod)
+		}
+
+		writeICSLine(&b, "BEGIN:VEVENT")
+		writeICSLine(&b, "UID:"+hex.EncodeToString(sum[:16])+"@read-emails")
+		writeICSLine(&b, "DTSTAMP:"+stamp)
+		writeICSLine(&b, "DTSTART;VALUE=DATE:"+due.Format("20060102"))
+		writeICSLine(&b, "DTEND;VALUE=DATE:"+due.AddDate(0, 0, 1).Format("20060102"))
+		writeICSLine(&b, "SUMMARY:"+icsEscaper.Replace(summary))
+		writeICSLine(&b, "DESCRIPTION:"+icsEscaper.Replace(strings.Join(description, "\n")))
+		writeICSLine(&b, "TRANSP:TRANSPARENT")
+		if alarmDays > 0 {
+			writeICSLine(&b, "BEGIN:VALARM")
+			writeICSLine(&b, "ACTION:DISPLAY")
+			writeICSLine(&b, fmt.Sprintf("TRIGGER:-P%dD", alarmDays))
+			writeICSLine(&b, "DESCRIPTION:"+icsEscaper.Replace(summary))
+			writeICSLine(&b, "END:VALARM")
+		}
+		writeICSLine(&b, "END:VEVENT")
+	}
+	writeICSLine(&b, "END:VCALENDAR")
+	return b.String()
+}
+
+// billsCalendarHandler serves GET /bills/calendar.ics?userEmail=...&token=...
+// The token is the user's feed secret from /bills/calendar-token, so the URL can
+// be subscribed to from a calendar app without a session or the admin token.
+func (s *Server) billsCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r.Context())
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userEmail := r.URL.Query().Get("userEmail")
+	if userEmail == "" {
+		http.Error(w, "Missing userEmail parameter", http.StatusBadRequest)
+		return
+	}
+	if !s.validCalendarToken(userEmail, r.URL.Query().Get("token")) {
+		http.Error(w, "Invalid calendar token", http.StatusUnauthorized)
+		return
+	}
+
+	now := time.Now()
+	bills := s.storedBills(userEmail, now.Add(-billRetention), now)
+	logger.Printf("Serving bill calendar for %s: %d events", userEmail, len(bills))
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="bills.ics"`)
+	fmt.Fprint(w, billsCalendar(userEmail, bills, billAlarmDays(), now))
+}
+
+// calendarTokenHandler returns a user's feed token and URL (GET), or issues a
+// new token that invalidates the old URL (POST)
+// The user comes from the session, or from userEmail as for the other user endpoints.
+func (s *Server) calendarTokenHandler(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r.Context())
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form body", http.StatusBadRequest)
+		return
+	}
+	userEmail, ok := s.requestUser(w, r, r.FormValue("userEmail"))
+	if !ok {
+		return
+	}
+	if !s.isAuthenticated(userEmail) {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	token, err := s.calendarToken(userEmail, r.Method == http.MethodPost)
+	if err != nil {
+		logger.Printf("Unable to issue calendar token for %s: %v", userEmail, err)
+		http.Error(w, "Failed to issue calendar token", http.StatusInternalServerError)
+		return
+	}
+
+	scheme := "http"
+	if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		scheme = "https"
+	}
+	feed := url.URL{Scheme: scheme, Host: r.Host, Path: "/bills/calendar.ics",
+		RawQuery: url.Values{"userEmail": {userEmail}, "token": {token}}.Encode()}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user_email": userEmail,
+		"token":      token,
+		"url":        feed.String(),
+	})
+}
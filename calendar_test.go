@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCalendarTokenHandler(t *testing.T) {
+	s := newTestServer(t)
+	authenticate(s, "user@example.com")
+	handler := s.Handler()
+
+	issue := func(method string) map[string]string {
+		t.Helper()
+		req := httptest.NewRequest(method, "/bills/calendar-token?userEmail=user@example.com", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s status = %d: %s", method, rec.Code, rec.Body)
+		}
+		var got map[string]string
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return got
+	}
+
+	first := issue(http.MethodGet)
+	if first["user_email"] != "user@example.com" || first["token"] == "" {
+		t.Fatalf("GET = %v, want user_email and a token", first)
+	}
+	feed, err := url.Parse(first["url"])
+	if err != nil || feed.Path != "/bills/calendar.ics" || feed.Query().Get("token") != first["token"] {
+		t.Errorf("url = %q, want the feed with the token", first["url"])
+	}
+	if again := issue(http.MethodGet); again["token"] != first["token"] {
+		t.Errorf("GET issued a new token %q, want %q kept", again["token"], first["token"])
+	}
+	rotated := issue(http.MethodPost)
+	if rotated["token"] == first["token"] {
+		t.Errorf("POST kept token %q, want a new one", rotated["token"])
+	}
+
+	// A restarted server loads the saved tokens, so subscribed feed URLs keep working
+	restarted := NewServer(s.oauthConfig)
+	if !restarted.validCalendarToken("user@example.com", rotated["token"]) || restarted.validCalendarToken("user@example.com", first["token"]) {
+		t.Errorf("after restart, want the rotated token valid and the revoked one rejected")
+	}
+}
+
+func TestCalendarTokenHandlerSessions(t *testing.T) {
+	t.Setenv("SESSION_SECRET", "test-secret")
+	t.Setenv("ADMIN_TOKEN", "admin")
+	s := newTestServer(t)
+	authenticate(s, "user@example.com")
+	handler := s.Handler()
+
+	tests := []struct {
+		name   string
+		query  string
+		cookie bool
+		admin  bool
+		want   int
+	}{
+		{"signed-in user", "", true, false, http.StatusOK},
+		{"not signed in", "", false, false, http.StatusUnauthorized},
+		{"userEmail without admin token", "?userEmail=user@example.com", true, false, http.StatusForbidden},
+		{"userEmail with admin token", "?userEmail=user@example.com", false, true, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/bills/calendar-token"+tt.query, nil)
+			if tt.cookie {
+				req.AddCookie(sessionCookie(t, "user@example.com"))
+			}
+			if tt.admin {
+				req.Header.Set("X-Admin-Token", "admin")
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if tt.want == http.StatusOK && !strings.Contains(rec.Body.String(), `"user_email":"user@example.com"`) {
+				t.Errorf("body = %s, want the signed-in user's token", rec.Body)
+			}
+		})
+	}
+}
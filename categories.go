@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// categoryUncategorized is the Category of merchants no mapping matches
+const categoryUncategorized = "uncategorized"
+
+// merchantCategory maps merchants to a category in the rules file's "categories" list
+// Merchant is an exact name, a prefix ending in "*" ("SWIGGY*"), or a /regex/,
+// all matched case-insensitively.
+type merchantCategory struct {
+	Merchant string `json:"merchant"`
+	Category string `json:"category"`
+}
+
+// compiledMerchantCategory is a merchantCategory ready for matching
+type compiledMerchantCategory struct {
+	exact    string
+	prefix   string
+	pattern  *regexp.Regexp
+	category string
+}
+
+// defaultMerchantCategories covers common Indian and US merchants
+// Mappings from the rules file are checked first.
+var defaultMerchantCategories = []merchantCategory{
+	{"SWIGGY*", "food_delivery"},
+	{"ZOMATO*", "food_delivery"},
+	{"UBER EATS*", "food_delivery"},
+	{"UBEREATS*", "food_delivery"},
+	{"DOORDASH*", "food_delivery"},
+	{"GRUBHUB*", "food_delivery"},
+	{"DOMINOS*", "restaurants"},
+	{"MCDONALDS*", "restaurants"},
+	{"STARBUCKS*", "restaurants"},
+	{"BIGBASKET*", "groceries"},
+	{"BLINKIT*", "groceries"},
+	{"ZEPTO*", "groceries"},
+	{"DMART*", "groceries"},
+	{"JIOMART*", "groceries"},
+	{"INSTAMART*", "groceries"},
+	{"WHOLE FOODS*", "groceries"},
+	{"KROGER*", "groceries"},
+	{"TRADER JOE*", "groceries"},
+	{"SAFEWAY*", "groceries"},
+	{"INDIAN OIL*", "fuel"},
+	{"IOCL*", "fuel"},
+	{"BHARAT PETROLEUM*", "fuel"},
+	{"BPCL*", "fuel"},
+	{"HPCL*", "fuel"},
+	{"HINDUSTAN PETROLEUM*", "fuel"},
+	{"SHELL*", "fuel"},
+	{"CHEVRON*", "fuel"},
+	{"EXXON*", "fuel"},
+	{"NETFLIX*", "subscriptions"},
+	{"SPOTIFY*", "subscriptions"},
+	{"HOTSTAR*", "subscriptions"},
+	{"DISNEY*", "subscriptions"},
+	{"YOUTUBE*", "subscriptions"},
+	{"APPLE.COM*", "subscriptions"},
+	{"GOOGLE *", "subscriptions"},
+	{"AMAZON PRIME*", "subscriptions"},
+	{"HULU*", "subscriptions"},
+	{"IRCTC*", "travel"},
+	{"MAKEMYTRIP*", "travel"},
+	{"GOIBIBO*", "travel"},
+	{"CLEARTRIP*", "travel"},
+	{"INDIGO*", "travel"},
+	{"AIR INDIA*", "travel"},
+	{"EXPEDIA*", "travel"},
+	{"AIRBNB*", "travel"},
+	{"DELTA AIR*", "travel"},
+	{"UNITED AIRLINES*", "travel"},
+	{"UBER*", "transport"},
+	{"OLA*", "transport"},
+	{"RAPIDO*", "transport"},
+	{"LYFT*", "transport"},
+	{"AMAZON*", "shopping"},
+	{"AMZN*", "shopping"},
+	{"FLIPKART*", "shopping"},
+	{"MYNTRA*", "shopping"},
+	{"AJIO*", "shopping"},
+	{"NYKAA*", "shopping"},
+	{"WALMART*", "shopping"},
+	{"TARGET*", "shopping"},
+	{"COSTCO*", "shopping"},
+	{"BEST BUY*", "shopping"},
+	{"BOOKMYSHOW*", "entertainment"},
+	{"PVR*", "entertainment"},
+	{"AIRTEL*", "utilities"},
+	{"JIO*", "utilities"},
+	{"VODAFONE*", "utilities"},
+	{"BESCOM*", "utilities"},
+	{"TATA POWER*", "utilities"},
+	{"VERIZON*", "utilities"},
+	{"AT&T*", "utilities"},
+	{"COMCAST*", "utilities"},
+	{"APOLLO PHARMACY*", "health"},
+	{"PHARMEASY*", "health"},
+	{"1MG*", "health"},
+	{"CVS*", "health"},
+	{"WALGREENS*", "health"},
+}
+
+// builtinMerchantCategories is defaultMerchantCategories compiled at startup
+var builtinMerchantCategories = mustCompileMerchantCategories(defaultMerchantCategories)
+
+// compileMerchantCategory validates and compiles a single merchant mapping
+func compileMerchantCategory(mapping merchantCategory) (compiledMerchantCategory, error) {
+	merchant := strings.TrimSpace(mapping.Merchant)
+	category := strings.TrimSpace(mapping.Category)
+	if merchant == "" || category == "" {
+		return compiledMerchantCategory{}, fmt.Errorf("merchant and category are required")
+	}
+
+	compiled := compiledMerchantCategory{category: category}
+	switch {
+	case len(merchant) > 2 && strings.HasPrefix(merchant, "/") && strings.HasSuffix(merchant, "/"):
+		pattern, err := regexp.Compile("(?i)" + merchant[1:len(merchant)-1])
+		if err != nil {
+			return compiledMerchantCategory{}, fmt.Errorf("invalid merchant pattern %q: %v", merchant, err)
+		}
+		compiled.pattern = pattern
+	case strings.HasSuffix(merchant, "*"):
+		compiled.prefix = strings.ToUpper(strings.TrimSuffix(merchant, "*"))
+	default:
+		compiled.exact = strings.ToUpper(merchant)
+	}
+	return compiled, nil
+}
+
+// mustCompileMerchantCategories compiles built-in mappings, panicking on a bad entry
+func mustCompileMerchantCategories(mappings []merchantCategory) []compiledMerchantCategory {
+	compiled := make([]compiledMerchantCategory, 0, len(mappings))
+	for _, mapping := range mappings {
+		c, err := compileMerchantCategory(mapping)
+		if err != nil {
+			panic(fmt.Sprintf("merchant category %q: %v", mapping.Merchant, err))
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled
+}
+
+// matches reports whether the mapping applies to an upper-cased merchant name
+func (c compiledMerchantCategory) matches(merchant string) bool {
+	switch {
+	case c.pattern != nil:
+		return c.pattern.MatchString(merchant)
+	case c.prefix != "":
+		return strings.HasPrefix(merchant, c.prefix)
+	default:
+		return merchant == c.exact
+	}
+}
+
+// categorizeMerchant returns the category of a merchant, trying the rules file's
+// mappings before the built-in ones
+func categorizeMerchant(merchant string) string {
+	merchant = strings.ToUpper(strings.TrimSpace(merchant))
+	if merchant == "" {
+		return categoryUncategorized
+	}
+
+	parserRules.RLock()
+	custom := parserRules.categories
+	parserRules.RUnlock()
+	for _, mappings := range [][]compiledMerchantCategory{custom, builtinMerchantCategories} {
+		for _, mapping := range mappings {
+			if mapping.matches(merchant) {
+				return mapping.category
+			}
+		}
+	}
+	return categoryUncategorized
+}
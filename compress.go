@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultGzipMinBytes is used when GZIP_MIN_BYTES is unset
+const defaultGzipMinBytes = 1024
+
+// gzipMinBytes returns the response size from which gzipMiddleware compresses,
+// from GZIP_MIN_BYTES
+func gzipMinBytes() int {
+	value := strings.TrimSpace(os.Getenv("GZIP_MIN_BYTES"))
+	if value == "" {
+		return defaultGzipMinBytes
+	}
+	if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+		return n
+	}
+	log.Printf("Invalid GZIP_MIN_BYTES %q, using %d", value, defaultGzipMinBytes)
+	return defaultGzipMinBytes
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip, honoring q=0
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipMiddleware compresses responses of at least GZIP_MIN_BYTES for clients
+// whose Accept-Encoding allows gzip. Smaller responses are sent as-is.
+func gzipMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w, status: http.StatusOK, threshold: gzipMinBytes()}
+		defer gw.finish()
+		next(gw, r)
+	}
+}
+
+// gzipResponseWriter buffers a response until it reaches threshold, then
+// switches to gzip; responses that finish below it are written uncompressed
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	threshold   int
+	buf         bytes.Buffer
+	gz          *gzip.Writer
+	passthrough bool
+}
+
+// WriteHeader records the status; it is sent once the encoding is decided
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+// Write buffers p, starting compression once the threshold is reached
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	switch {
+	case w.gz != nil:
+		return w.gz.Write(p)
+	case w.passthrough:
+		return w.ResponseWriter.Write(p)
+	}
+
+	w.buf.Write(p)
+	if w.buf.Len() >= w.threshold {
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush sends what has been buffered so far, so streaming responses keep working
+func (w *gzipResponseWriter) Flush() {
+	if w.gz == nil && !w.passthrough {
+		w.start(w.buf.Len() >= w.threshold)
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// start sends the headers and the buffered body, compressed or not. Responses
+// that already carry a Content-Encoding or have no body are never compressed.
+func (w *gzipResponseWriter) start(compress bool) error {
+	header := w.ResponseWriter.Header()
+	if header.Get("Content-Encoding") != "" || w.status == http.StatusNoContent || w.status == http.StatusNotModified {
+		compress = false
+	}
+
+	if !compress {
+		w.passthrough = true
+		w.ResponseWriter.WriteHeader(w.status)
+		_, err := w.ResponseWriter.Write(w.buf.Bytes())
+		w.buf.Reset()
+		return err
+	}
+
+	header.Set("Content-Encoding", "gzip")
+	header.Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.status)
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+	_, err := w.gz.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+// finish completes the response after the handler returns
+func (w *gzipResponseWriter) finish() {
+	switch {
+	case w.gz != nil:
+		if err := w.gz.Close(); err != nil {
+			log.Printf("Unable to finish gzip response: %v", err)
+		}
+	case !w.passthrough:
+		w.start(false)
+	}
+}
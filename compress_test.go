@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGzipMiddleware(t *testing.T) {
+	t.Setenv("GZIP_MIN_BYTES", "")
+	large := strings.Repeat(`{"subject":"Transaction alert","body":"Rs.424.00 spent at AMAZON"}`, 100)
+	small := `{"ok":true}`
+
+	tests := []struct {
+		name           string
+		body           string
+		acceptEncoding string
+		gzipped        bool
+	}{
+		{"large with gzip", large, "gzip, deflate", true},
+		{"large with wildcard", large, "*", true},
+		{"large without header", large, "", false},
+		{"large with gzip refused", large, "gzip;q=0, identity", false},
+		{"small with gzip", small, "gzip", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := gzipMiddleware(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				// Written in pieces so the threshold is crossed mid-response
+				for i := 0; i < len(tt.body); i += 100 {
+					io.WriteString(w, tt.body[i:min(i+100, len(tt.body))])
+				}
+			})
+			req := httptest.NewRequest(http.MethodGet, "/emails/raw", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			if rec.Code != http.StatusCreated {
+				t.Errorf("status = %d, want 201", rec.Code)
+			}
+			if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary = %q, want Accept-Encoding", got)
+			}
+			body := rec.Body.Bytes()
+			if tt.gzipped {
+				if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+					t.Fatalf("Content-Encoding = %q, want gzip", got)
+				}
+				if len(body) >= len(tt.body) {
+					t.Errorf("compressed body is %d bytes, original %d", len(body), len(tt.body))
+				}
+				zr, err := gzip.NewReader(bytes.NewReader(body))
+				if err != nil {
+					t.Fatalf("gzip reader: %v", err)
+				}
+				if body, err = io.ReadAll(zr); err != nil {
+					t.Fatalf("decompress: %v", err)
+				}
+			} else if got := rec.Header().Get("Content-Encoding"); got != "" {
+				t.Errorf("Content-Encoding = %q, want none", got)
+			}
+			if string(body) != tt.body {
+				t.Errorf("body = %.80q, want %.80q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestGzipSkipsPushEndpoint(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/gmail/push", strings.NewReader(strings.Repeat("x", 4096)))
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	newTestServer(t).Handler().ServeHTTP(rec, req)
+	if got := rec.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("Content-Encoding = %q, want the push endpoint uncompressed", got)
+	}
+	if got := rec.Header().Get("Vary"); got != "" {
+		t.Errorf("Vary = %q, want the push endpoint outside gzipMiddleware", got)
+	}
+}
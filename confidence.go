@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// confidenceWeights are the score contributions of each extracted field or signal
+// Positive weights add up to 1.
+var confidenceWeights = map[string]float64{
+	"amount":         0.35,
+	"merchant":       0.15,
+	"card":           0.15,
+	"date":           0.10,
+	"known_sender":   0.10,
+	"bank_parser":    0.15,
+	"otp_language":   -0.20,
+	"promo_language": -0.20,
+}
+
+// scoreTransaction sets a transaction's Confidence and ConfidenceSignals from the
+// fields that were extracted, whether the sender is a known bank and its parser
+// matched, and whether the OTP or promotional exclusions nearly fired
+func scoreTransaction(txn *CreditCardTransaction, text string, knownSender, bankParsed bool) {
+	var signals []string
+	if txn.AmountMinorUnits > 0 {
+		signals = append(signals, "amount")
+	}
+	if txn.Merchant != "" {
+		signals = append(signals, "merchant")
+	}
+	if txn.CardNumber != "" || txn.AccountLast4 != "" {
+		signals = append(signals, "card")
+	}
+	if txn.TimestampSource == timestampSourceBody {
+		signals = append(signals, "date")
+	}
+	if knownSender {
+		signals = append(signals, "known_sender")
+	}
+	if bankParsed {
+		signals = append(signals, "bank_parser")
+	}
+
+	lower := strings.ToLower(text)
+	if otpPattern.MatchString(lower) {
+		signals = append(signals, "otp_language")
+	}
+	if promoPattern.MatchString(lower) {
+		signals = append(signals, "promo_language")
+	}
+
+	score := 0.0
+	for _, signal := range signals {
+		score += confidenceWeights[signal]
+	}
+	txn.Confidence = math.Round(math.Max(0, math.Min(1, score))*100) / 100
+	txn.ConfidenceSignals = signals
+}
+
+// minConfidence returns MIN_CONFIDENCE, the score below which parsed transactions
+// are logged as needing review instead of emitted; 0 (the default) emits everything
+func minConfidence() float64 {
+	value := strings.TrimSpace(os.Getenv("MIN_CONFIDENCE"))
+	if value == "" {
+		return 0
+	}
+	threshold, err := strconv.ParseFloat(value, 64)
+	if err != nil || threshold < 0 || threshold > 1 {
+		log.Printf("Invalid MIN_CONFIDENCE %q, emitting all transactions", value)
+		return 0
+	}
+	return threshold
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+)
+
+// dateZoneAbbreviations maps zone names some senders put in place of a numeric
+// offset to that offset; net/mail reads unknown names as UTC
+var dateZoneAbbreviations = map[string]string{
+	"IST": "+0530",
+}
+
+// normalizeDates reports whether NORMALIZE_DATES=true, which returns Date
+// headers as RFC 3339 in DISPLAY_TZ instead of as sent
+func normalizeDates() bool {
+	return strings.EqualFold(os.Getenv("NORMALIZE_DATES"), "true")
+}
+
+// displayLocation returns the timezone for normalized dates from DISPLAY_TZ,
+// falling back to the transaction timezone (TZ)
+func displayLocation() *time.Location {
+	name := strings.TrimSpace(os.Getenv("DISPLAY_TZ"))
+	if name == "" {
+		return transactionLocation()
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("Unknown DISPLAY_TZ %q, using TZ: %v", name, err)
+		return transactionLocation()
+	}
+	return loc
+}
+
+// parseDateHeader parses an RFC 5322 Date header, including trailing
+// comments such as "(IST)" and the bare zone names in dateZoneAbbreviations
+func parseDateHeader(value string) (time.Time, error) {
+	fields := strings.Fields(value)
+	if n := len(fields); n > 0 {
+		if offset, ok := dateZoneAbbreviations[strings.ToUpper(fields[n-1])]; ok {
+			fields[n-1] = offset
+		}
+	}
+	return mail.ParseDate(strings.Join(fields, " "))
+}
+
+// displayDate returns a Date header as clients see it: RFC 3339 in
+// displayLocation when NORMALIZE_DATES=true, otherwise (or when the header
+// can't be parsed) as sent
+func displayDate(value string) string {
+	if !normalizeDates() || value == "" {
+		return value
+	}
+	t, err := parseDateHeader(value)
+	if err != nil {
+		debugf("Unable to parse Date header %q: %v", value, err)
+		return value
+	}
+	return t.In(displayLocation()).Format(time.RFC3339)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseDateHeader(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string // RFC 3339 in Asia/Kolkata, empty when the header should not parse
+	}{
+		{"Mon, 10 Nov 2025 09:30:00 +0530", "2025-11-10T09:30:00+05:30"},
+		{"Mon, 10 Nov 2025 09:30:00 +0530 (IST)", "2025-11-10T09:30:00+05:30"},
+		{"Mon, 10 Nov 2025 04:00:00 +0000 (UTC)", "2025-11-10T09:30:00+05:30"},
+		{"Mon, 10 Nov 2025 09:30:00 IST", "2025-11-10T09:30:00+05:30"},
+		{"Mon, 10 Nov 2025 09:30:00 ist", "2025-11-10T09:30:00+05:30"},
+		{"10 Nov 2025 04:00:00 GMT", "2025-11-10T09:30:00+05:30"},
+		{"Sun, 9 Nov 2025 22:30:00 -0500", "2025-11-10T09:00:00+05:30"},
+		{"Mon,  10 Nov 2025 09:30 +0530", "2025-11-10T09:30:00+05:30"},
+		{"Mon, 10 Nov 2025 09:30:00 +0530 (India Standard Time)", "2025-11-10T09:30:00+05:30"},
+		{"yesterday", ""},
+		{"", ""},
+	}
+	ist, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skipf("no timezone data: %v", err)
+	}
+	for _, tt := range tests {
+		got, err := parseDateHeader(tt.value)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("parseDateHeader(%q) = %v, want an error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseDateHeader(%q): %v", tt.value, err)
+			continue
+		}
+		if formatted := got.In(ist).Format(time.RFC3339); formatted != tt.want {
+			t.Errorf("parseDateHeader(%q) = %s, want %s", tt.value, formatted, tt.want)
+		}
+	}
+}
+
+func TestDisplayDate(t *testing.T) {
+	const header = "Mon, 10 Nov 2025 04:00:00 +0000 (UTC)"
+	tests := []struct {
+		name      string
+		normalize string
+		tz        string
+		value     string
+		want      string
+	}{
+		{"disabled", "", "Asia/Kolkata", header, header},
+		{"display timezone", "true", "Asia/Kolkata", header, "2025-11-10T09:30:00+05:30"},
+		{"other timezone", "true", "America/New_York", header, "2025-11-09T23:00:00-05:00"},
+		{"unknown timezone uses TZ", "true", "Mars/Olympus", header, "2025-11-10T04:00:00Z"},
+		{"unparseable kept as sent", "true", "Asia/Kolkata", "not a date", "not a date"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TZ", "UTC")
+			t.Setenv("NORMALIZE_DATES", tt.normalize)
+			t.Setenv("DISPLAY_TZ", tt.tz)
+			if got := displayDate(tt.value); got != tt.want {
+				t.Errorf("displayDate(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummaryDateRaw(t *testing.T) {
+	t.Setenv("NORMALIZE_DATES", "true")
+	t.Setenv("DISPLAY_TZ", "Asia/Kolkata")
+	s := newTestServer(t)
+	fake := newFakeGmail(t, "user@example.com")
+	fake.use(s)
+	authenticate(s, "user@example.com")
+	const header = "Mon, 10 Nov 2025 09:30:00 +0530 (IST)"
+	fake.addMessage("m1", map[string]string{"Subject": "Statement", "Date": header}, "Statement is ready", "", time.Now())
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/emails/summary?userEmail=user@example.com", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var got struct {
+		LatestEmail struct {
+			Date    string `json:"date"`
+			DateRaw string `json:"date_raw"`
+		} `json:"latest_email"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.LatestEmail.Date != "2025-11-10T09:30:00+05:30" || got.LatestEmail.DateRaw != header {
+		t.Errorf("date %q, date_raw %q", got.LatestEmail.Date, got.LatestEmail.DateRaw)
+	}
+}
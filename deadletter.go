@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// redeliveryTimeout bounds a requeued delivery made from /admin/deadletter
+const redeliveryTimeout = 10 * time.Second
+
+// maxDeadLetters caps the dead letters kept in memory; the oldest are dropped first
+const maxDeadLetters = 1000
+
+// deadLetter is an outbound delivery that failed after every retry
+type deadLetter struct {
+	ID        int64           `json:"id"`
+	Target    string          `json:"target"` // Destination URL
+	Event     string          `json:"event"`  // e.g. "transaction.created"
+	Payload   json.RawMessage `json:"payload"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"last_error"`
+	FailedAt  time.Time       `json:"failed_at"`
+}
+
+// addDeadLetter records a permanently failed delivery for inspection and requeue
+// Entries without an ID get the next one; requeued entries keep theirs. Once
+// maxDeadLetters are held the oldest are dropped and counted.
+func (s *Server) addDeadLetter(entry deadLetter) {
+	s.deadLetters.Lock()
+	defer s.deadLetters.Unlock()
+	if entry.ID == 0 {
+		s.deadLetters.nextID++
+		entry.ID = s.deadLetters.nextID
+	}
+	if entry.FailedAt.IsZero() {
+		entry.FailedAt = time.Now()
+	}
+	s.deadLetters.entries = append(s.deadLetters.entries, entry)
+	if excess := len(s.deadLetters.entries) - maxDeadLetters; excess > 0 {
+		s.deadLetters.entries = append(s.deadLetters.entries[:0], s.deadLetters.entries[excess:]...)
+		s.deadLetters.dropped += int64(excess)
+		addCounter("dead_letters_dropped_total", int64(excess))
+	}
+}
+
+// takeDeadLetter removes and returns the dead letter with the given ID
+func (s *Server) takeDeadLetter(id int64) (deadLetter, bool) {
+	s.deadLetters.Lock()
+	defer s.deadLetters.Unlock()
+	for i, entry := range s.deadLetters.entries {
+		if entry.ID == id {
+			s.deadLetters.entries = append(s.deadLetters.entries[:i], s.deadLetters.entries[i+1:]...)
+			return entry, true
+		}
+	}
+	return deadLetter{}, false
+}
+
+// deadLetterHandler lists failed deliveries (GET), or with POST id and
+// action=requeue|delete, retries or discards one. A requeued delivery that
+// fails again goes back on the list under the same ID.
+func (s *Server) deadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+		s.deadLetters.Lock()
+		entries := append([]deadLetter{}, s.deadLetters.entries...)
+		dropped := s.deadLetters.dropped
+		s.deadLetters.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"count":        len(entries),
+			"dropped":      dropped,
+			"dead_letters": entries,
+		})
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form body", http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Missing or invalid id parameter", http.StatusBadRequest)
+		return
+	}
+	action := r.FormValue("action")
+	if action != "requeue" && action != "delete" {
+		http.Error(w, "Invalid action parameter (use requeue or delete)", http.StatusBadRequest)
+		return
+	}
+	if action == "requeue" && s.redeliver == nil {
+		http.Error(w, "No delivery sink configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	entry, ok := s.takeDeadLetter(id)
+	if !ok {
+		http.Error(w, "Dead letter not found", http.StatusNotFound)
+		return
+	}
+
+	status := "deleted"
+	if action == "requeue" {
+		ctx, cancel := context.WithTimeout(r.Context(), redeliveryTimeout)
+		defer cancel()
+		attempts, err := s.redeliver(ctx, entry)
+		if err != nil {
+			logger.Printf("Requeued delivery %d to %s failed after %d attempts: %v", entry.ID, entry.Target, attempts, err)
+			entry.Attempts += attempts
+			entry.LastError = err.Error()
+			entry.FailedAt = time.Time{}
+			s.addDeadLetter(entry)
+			status = "failed"
+		} else {
+			status = "delivered"
+		}
+	}
+	logger.Printf("Dead letter %d to %s: %s", id, entry.Target, status)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":     id,
+		"status": status,
+	})
+}
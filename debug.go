@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// debugEndpointsEnabled reports whether debug endpoints are served (DEBUG_ENDPOINTS=true)
+func debugEndpointsEnabled() bool {
+	return strings.EqualFold(os.Getenv("DEBUG_ENDPOINTS"), "true")
+}
+
+// debugParseHandler classifies and parses a subject/body pair without touching Gmail
+func debugParseHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Subject string `json:"subject"`
+		Body    string `json:"body"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Failed to parse request", http.StatusBadRequest)
+		return
+	}
+
+	// Quoted text is stripped as in the push pipeline; both texts are reported
+	body, stripped := stripQuotedTextIfEnabled(req.Body)
+	isTransaction, reason := classifyTransactionEmail(req.Subject, body)
+	response := map[string]interface{}{
+		"kind":           classifyEmail(req.Subject, body),
+		"is_transaction": isTransaction,
+		"reason":         reason,
+		"transaction":    nil,
+		"raw_body":       req.Body,
+		"body":           body,
+		"stripped":       stripped,
+	}
+	if isTransaction {
+		response["transaction"] = parseCreditCardTransaction(req.Subject, body)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// parserTestHandler runs the push pipeline's classification and parsing on a
+// posted {"subject", "body", "from"} and reports which patterns matched.
+// It needs no userEmail and makes no Gmail calls.
+func parserTestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Subject string `json:"subject"`
+		Body    string `json:"body"`
+		From    string `json:"from"`
+		To      string `json:"to"`
+		Cc      string `json:"cc"`
+		Snippet string `json:"snippet"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Failed to parse request", http.StatusBadRequest)
+		return
+	}
+
+	headers := map[string]string{"Subject": req.Subject, "From": req.From, "To": req.To, "Cc": req.Cc}
+	analysis := analyzeEmail(headers, req.Body, forwardedMessage{}, req.Snippet)
+
+	var transaction *CreditCardTransaction
+	if len(analysis.Transactions) > 0 {
+		transaction = analysis.Transactions[0]
+	}
+	response := map[string]interface{}{
+		"kind":             analysis.Kind,
+		"is_transaction":   analysis.IsTransaction,
+		"reason":           analysis.Reason,
+		"from_snippet":     analysis.FromSnippet,
+		"transaction":      transaction,
+		"transactions":     analysis.Transactions,
+		"bill_reminder":    analysis.BillReminder,
+		"matched_patterns": matchedPatterns(req.From, req.Subject, req.Body+" "+req.Snippet),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// matchedPatterns lists the detection and extraction patterns that match an email,
+// named after the parser.go variables, plus the sender's bank parser and rule groups
+func matchedPatterns(from, subject, body string) []string {
+	text := subject + " " + body
+	lower := strings.ToLower(text)
+
+	matched := []string{}
+	single := []struct {
+		name    string
+		pattern *regexp.Regexp
+		text    string
+	}{
+		{"transactionKeywordPattern", transactionKeywordPattern, lower},
+		{"otpPattern", otpPattern, lower},
+		{"securityAlertPattern", securityAlertPattern, lower},
+		{"promoPattern", promoPattern, lower},
+		{"upiKeywordPattern", upiKeywordPattern, lower},
+		{"netbankingKeywordPattern", netbankingKeywordPattern, lower},
+		{"accountDebitPattern", accountDebitPattern, lower},
+		{"debitVerbPattern", debitVerbPattern, text},
+		{"creditVerbPattern", creditVerbPattern, text},
+		{"refundPattern", refundPattern, text},
+		{"amountPattern", amountPattern, text},
+		{"bareAmountPattern", bareAmountPattern, text},
+		{"vpaPattern", vpaPattern, text},
+		{"upiNarrationPattern", upiNarrationPattern, text},
+		{"accountPattern", accountPattern, text},
+		{"referencePattern", referencePattern, text},
+		{"availableBalancePattern", availableBalancePattern, text},
+		{"timePattern", timePattern, text},
+	}
+	for _, p := range single {
+		if p.pattern.MatchString(p.text) {
+			matched = append(matched, p.name)
+		}
+	}
+
+	lists := []struct {
+		name     string
+		patterns []*regexp.Regexp
+	}{
+		{"upiRefPatterns", upiRefPatterns},
+		{"upiMerchantPatterns", upiMerchantPatterns},
+		{"cardPatterns", cardPatterns},
+		{"merchantPatterns", merchantPatterns},
+		{"datePatterns", datePatterns},
+	}
+	for _, list := range lists {
+		for i, pattern := range list.patterns {
+			if pattern.MatchString(text) {
+				matched = append(matched, fmt.Sprintf("%s[%d]", list.name, i))
+			}
+		}
+	}
+
+	if entry, ok := bankParserFor(from); ok {
+		matched = append(matched, "bank parser "+entry.name)
+	}
+	for _, group := range ruleGroupsFor(from) {
+		matched = append(matched, "rule group "+group.name)
+	}
+	return matched
+}
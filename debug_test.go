@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// update rewrites the golden files under testdata: go test -run TestParserFixtures -update
+var update = flag.Bool("update", false, "rewrite golden files")
+
+func TestDebugParseHandler(t *testing.T) {
+	t.Setenv("DEBUG_ENDPOINTS", "true")
+	handler := newTestServer(t).Handler()
+
+	tests := []struct {
+		name        string
+		body        string
+		transaction bool
+		amount      float64
+	}{
+		{"transaction", `{"subject":"Transaction alert","body":"Rs.424.00 spent on your credit card XX1234 at AMAZON on 11 Nov, 2025"}`, true, 42400},
+		{"newsletter", `{"subject":"Weekly deals","body":"Save 20% on your next order. Unsubscribe any time."}`, false, 0},
+		{"otp", `{"subject":"OTP","body":"Your OTP for the transaction of Rs.424.00 on your credit card is 123456. Do not share it."}`, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/debug/parse", strings.NewReader(tt.body)))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			var got struct {
+				IsTransaction bool                   `json:"is_transaction"`
+				Reason        string                 `json:"reason"`
+				Transaction   map[string]interface{} `json:"transaction"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if got.IsTransaction != tt.transaction || got.Reason == "" {
+				t.Errorf("is_transaction = %v (%s), want %v", got.IsTransaction, got.Reason, tt.transaction)
+			}
+			if !tt.transaction {
+				if got.Transaction != nil {
+					t.Errorf("transaction = %v, want null", got.Transaction)
+				}
+				return
+			}
+			if got.Transaction["amount_minor_units"] != tt.amount {
+				t.Errorf("transaction = %v, want %v minor units", got.Transaction, tt.amount)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/parse", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET status = %d, want 405", rec.Code)
+	}
+}
+
+func TestDebugParseDisabledByDefault(t *testing.T) {
+	t.Setenv("DEBUG_ENDPOINTS", "")
+	rec := httptest.NewRecorder()
+	newTestServer(t).Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/debug/parse", strings.NewReader(`{"subject":"x","body":"y"}`)))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404 with DEBUG_ENDPOINTS unset", rec.Code)
+	}
+}
+
+// TestParserFixtures posts each testdata/parser/*.json email to /parser/test
+// and compares the response with the matching .golden file
+func TestParserFixtures(t *testing.T) {
+	t.Setenv("TZ", "Asia/Kolkata")
+	t.Setenv("BANK_DOMAINS", "")
+	t.Setenv("PARSER_RULES_FILE", "")
+	handler := newTestServer(t).Handler()
+
+	inputs, err := filepath.Glob(filepath.Join("testdata", "parser", "*.json"))
+	if err != nil || len(inputs) == 0 {
+		t.Fatalf("no fixtures found: %v", err)
+	}
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".json")
+		t.Run(name, func(t *testing.T) {
+			body, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/parser/test", bytes.NewReader(body)))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			var got bytes.Buffer
+			if err := json.Indent(&got, rec.Body.Bytes(), "", "  "); err != nil {
+				t.Fatalf("indent response: %v", err)
+			}
+
+			golden := strings.TrimSuffix(input, ".json") + ".golden"
+			if *update {
+				if err := os.WriteFile(golden, got.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create it)", err)
+			}
+			if !bytes.Equal(got.Bytes(), want) {
+				t.Errorf("response differs from %s (run with -update after checking the change):\n%s", golden, got.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// pushedEmail is a fetched and classified message handed to the detectors
+type pushedEmail struct {
+	UserEmail    string
+	MessageID    string
+	InternalDate int64             // Milliseconds since epoch
+	Headers      map[string]string // Raw header values by name
+	Subject      string
+	Snippet      string
+	Analysis     emailAnalysis
+	Logger       *log.Logger
+}
+
+// from returns the decoded From header
+func (e *pushedEmail) from() string {
+	return decodeHeader(e.Headers["From"])
+}
+
+// Detector acts on pushed emails it matches
+// Every matching detector runs, in registration order; the first match's Name
+// is the message's outcome.
+type Detector interface {
+	Name() string
+	Match(email *pushedEmail) bool
+	Handle(ctx context.Context, email *pushedEmail) error
+}
+
+// detectorFactories builds each registered detector for a Server, in registration order
+var detectorFactories []func(s *Server) Detector
+
+// registerDetector adds a detector to every Server created afterwards
+func registerDetector(factory func(s *Server) Detector) {
+	detectorFactories = append(detectorFactories, factory)
+}
+
+// newDetectors builds the registered detectors for s
+func newDetectors(s *Server) []Detector {
+	detectors := make([]Detector, 0, len(detectorFactories))
+	for _, factory := range detectorFactories {
+		detectors = append(detectors, factory(s))
+	}
+	return detectors
+}
+
+// runDetectors hands email to every matching detector and returns the first
+// match's name, or outcomeSkipped when none matched. Handler errors are logged
+// and don't stop the detectors after it.
+func (s *Server) runDetectors(ctx context.Context, email *pushedEmail) string {
+	outcome := ""
+	for _, detector := range s.detectors {
+		if !detector.Match(email) {
+			continue
+		}
+		if outcome == "" {
+			outcome = detector.Name()
+		}
+		if err := detector.Handle(ctx, email); err != nil {
+			email.Logger.Printf("Detector %s failed on message %s: %v", detector.Name(), email.MessageID, err)
+		}
+	}
+	if outcome == "" {
+		email.Logger.Printf("No detector matched message %s", email.MessageID)
+		return outcomeSkipped
+	}
+	return outcome
+}
+
+// The built-in detectors; bill reminders come first since statements mention cards and amounts too
+func init() {
+	registerDetector(func(s *Server) Detector { return billReminderDetector{s} })
+	registerDetector(func(s *Server) Detector { return transactionDetector{s} })
+	registerDetector(func(s *Server) Detector {
+		return otherEmailDetector{s, outcomeOTP, emailKindOTP, "=== OTP EMAIL ==="}
+	})
+	registerDetector(func(s *Server) Detector {
+		return otherEmailDetector{s, outcomeSecurityAlert, emailKindSecurityAlert, "=== SECURITY ALERT EMAIL ==="}
+	})
+	registerDetector(func(s *Server) Detector {
+		return otherEmailDetector{s, outcomeNonTransaction, emailKindOther, "=== NON CREDIT CARD INFO EMAIL ==="}
+	})
+}
+
+// billReminderDetector logs, notifies and remembers statement and bill-due emails
+type billReminderDetector struct {
+	s *Server
+}
+
+// Name returns the detector's outcome
+func (d billReminderDetector) Name() string { return outcomeBillReminder }
+
+// Match reports whether the email was parsed as a bill reminder
+func (d billReminderDetector) Match(email *pushedEmail) bool {
+	return email.Analysis.BillReminder != nil
+}
+
+// Handle logs the bill reminder and sends it to the notification sinks
+func (d billReminderDetector) Handle(ctx context.Context, email *pushedEmail) error {
+	logger, bill := email.Logger, email.Analysis.BillReminder
+	logger.Printf("=== BILL REMINDER DETECTED ===")
+	logger.Printf("New email received for %s:", email.UserEmail)
+	logger.Printf("  Message ID: %s", email.MessageID)
+	logger.Printf("  Subject: %s", email.Subject)
+	logger.Printf("  From: %s", email.Headers["From"])
+	logger.Printf("  Issuer: %s", bill.Issuer)
+	logger.Printf("  Card Number: %s", bill.CardNumber)
+	logger.Printf("  Statement Period: %s", bill.StatementPeriod)
+	logger.Printf("  Total Due: %s %s", bill.TotalDue, bill.Currency)
+	logger.Printf("  Minimum Due: %s %s", bill.MinimumDue, bill.Currency)
+	logger.Printf("  Due Date: %s", bill.DueDate)
+	logger.Printf("================================")
+	d.s.notifiers.bill(logger, email.UserEmail, email.MessageID, email.Subject, bill)
+	d.s.rememberBill(email.UserEmail, bill)
+	d.s.outbound.publishEmailMetadata(logger, email.UserEmail, email.MessageID, email.Subject, email.from(), email.Analysis.Kind)
+	return nil
+}
+
+// transactionDetector logs and records the transactions parsed from an email
+type transactionDetector struct {
+	s *Server
+}
+
+// Name returns the detector's outcome
+func (d transactionDetector) Name() string { return outcomeTransaction }
+
+// Match reports whether the email was classified as a transaction
+func (d transactionDetector) Match(email *pushedEmail) bool {
+	return email.Analysis.BillReminder == nil && email.Analysis.IsTransaction
+}
+
+// Handle fills in timestamps and recurring flags, logs each transaction and
+// records those scoring at least MIN_CONFIDENCE
+func (d transactionDetector) Handle(ctx context.Context, email *pushedEmail) error {
+	logger, analysis := email.Logger, email.Analysis
+	txns := analysis.Transactions
+	applyInternalDateFallback(txns, email.InternalDate)
+
+	// Flag charges matching a subscription seen in earlier transactions
+	if subs, err := d.s.userSubscriptions(email.UserEmail); err == nil {
+		markRecurring(txns, subs)
+	} else {
+		logger.Printf("Unable to detect subscriptions for %s: %v", email.UserEmail, err)
+	}
+
+	logger.Printf("=== CREDIT CARD TRANSACTION DETECTED ===")
+	logger.Printf("New email received for %s:", email.UserEmail)
+	logger.Printf("  Message ID: %s", email.MessageID)
+	logger.Printf("  Forwarded: %t", analysis.Forwarded)
+	if analysis.Forwarded && analysis.Embedded.From != "" {
+		logger.Printf("  Forwarded From: %s", analysis.Embedded.From)
+	}
+	logger.Printf("  Subject: %s", email.Subject)
+	logger.Printf("  From: %s", email.Headers["From"])
+	logger.Printf("  Date: %s", email.Headers["Date"])
+	threshold := minConfidence()
+	var confident []*CreditCardTransaction
+	for i, txn := range txns {
+		switch {
+		case txn.Confidence < threshold:
+			logger.Printf("--- NEEDS REVIEW: confidence %.2f below %.2f, not recorded (%d of %d) ---", txn.Confidence, threshold, i+1, len(txns))
+		case txn.Status == statusDeclined:
+			logger.Printf("--- DECLINED Transaction, excluded from spend (%d of %d) ---", i+1, len(txns))
+		default:
+			logger.Printf("--- Transaction Details (%d of %d) ---", i+1, len(txns))
+		}
+		logger.Printf("  Status: %s", txn.Status)
+		logger.Printf("  Confidence: %.2f %v", txn.Confidence, txn.ConfidenceSignals)
+		logger.Printf("  Channel: %s", txn.Channel)
+		logger.Printf("  Direction: %s (refund: %t)", txn.Direction, txn.IsRefund)
+		logger.Printf("  Amount: %.2f %s (raw: %s)", txn.AmountValue, txn.Currency, txn.RawAmount)
+		logger.Printf("  Card Number: %s", txn.CardNumber)
+		logger.Printf("  Network: %s, Issuer: %s, Bank: %s", txn.Network, txn.Issuer, txn.Bank)
+		if txn.Channel == channelUPI || txn.Channel == channelNetbanking {
+			logger.Printf("  Account: %s", txn.AccountLast4)
+			logger.Printf("  VPA: %s", txn.VPA)
+			logger.Printf("  UPI Ref: %s", txn.UPIRef)
+		}
+		if txn.Channel == channelWallet || txn.Channel == channelBNPL {
+			logger.Printf("  Funding Source: %s", txn.FundingSource)
+		}
+		logger.Printf("  Merchant: %s", txn.Merchant)
+		logger.Printf("  Category: %s", txn.Category)
+		logger.Printf("  Recurring: %t", txn.IsRecurring)
+		logger.Printf("  Date: %s", txn.Date)
+		logger.Printf("  Time: %s", txn.Time)
+		logger.Printf("  Timestamp: %s (source: %s)", txn.Timestamp.Format(time.RFC3339), txn.TimestampSource)
+		logger.Printf("  Reference ID: %s", txn.ReferenceID)
+		logger.Printf("  Available Balance: %.2f", txn.AvailableBalanceValue)
+		if txn.Confidence >= threshold {
+			confident = append(confident, txn)
+		}
+	}
+	logger.Printf("================================")
+	d.s.recordTransactions(logger, email.UserEmail, email.MessageID, email.Subject, confident)
+	return nil
+}
+
+// otherEmailDetector logs one kind of non-transaction email and publishes its metadata
+// OTP and security emails are flagged apart from other non-transaction email.
+type otherEmailDetector struct {
+	s       *Server
+	outcome string
+	kind    EmailKind
+	banner  string
+}
+
+// Name returns the detector's outcome
+func (d otherEmailDetector) Name() string { return d.outcome }
+
+// Match reports whether the email is a non-transaction email of the detector's kind
+func (d otherEmailDetector) Match(email *pushedEmail) bool {
+	return email.Analysis.BillReminder == nil && !email.Analysis.IsTransaction && email.Analysis.Kind == d.kind
+}
+
+// Handle logs the email's headers and snippet
+func (d otherEmailDetector) Handle(ctx context.Context, email *pushedEmail) error {
+	logger := email.Logger
+	logger.Printf("%s", d.banner)
+	logger.Printf("New email received for %s:", email.UserEmail)
+	logger.Printf("  Message ID: %s", email.MessageID)
+	logger.Printf("  Subject: %s", email.Subject)
+	logger.Printf("  From: %s", email.Headers["From"])
+	logger.Printf("  Date: %s", email.Headers["Date"])
+	logger.Printf("  Snippet: %s", email.Snippet)
+	logger.Printf("================================")
+	d.s.outbound.publishEmailMetadata(logger, email.UserEmail, email.MessageID, email.Subject, email.from(), email.Analysis.Kind)
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTransactionDetectorSkipsLowConfidence(t *testing.T) {
+	t.Setenv("MIN_CONFIDENCE", "0.5")
+	s := newTestServer(t)
+
+	low := parseCreditCardTransaction("Alert", "Rs.100.00 spent")
+	low.Confidence = 0.3
+	high := parseCreditCardTransaction("Alert", "Rs.200.00 spent on your credit card XX1234 at AMAZON")
+	high.Confidence = 0.9
+	email := &pushedEmail{
+		UserEmail: "user@example.com",
+		MessageID: "msg-1",
+		Headers:   map[string]string{},
+		Analysis:  emailAnalysis{IsTransaction: true, Transactions: []*CreditCardTransaction{low, high}},
+		Logger:    discardLogger(),
+	}
+	if err := (transactionDetector{s}).Handle(context.Background(), email); err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+
+	records, total, err := s.transactions.Query("user@example.com", TransactionFilter{})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if total != 1 || records[0].AmountMinorUnits != 20000 {
+		t.Fatalf("recorded %+v, want only the 200.00 transaction", records)
+	}
+}
+
+// shipmentDetector is a custom detector for shipping notifications, recording what it handled
+type shipmentDetector struct {
+	mu      *sync.Mutex
+	handled *[]string
+	err     error
+}
+
+func (d shipmentDetector) Name() string { return "shipment" }
+
+func (d shipmentDetector) Match(email *pushedEmail) bool {
+	return strings.Contains(email.Subject, "shipped")
+}
+
+func (d shipmentDetector) Handle(ctx context.Context, email *pushedEmail) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	*d.handled = append(*d.handled, email.UserEmail+" "+email.MessageID)
+	return d.err
+}
+
+func TestCustomDetector(t *testing.T) {
+	var mu sync.Mutex
+	var handled []string
+	saved := detectorFactories
+	t.Cleanup(func() { detectorFactories = saved })
+	registerDetector(func(s *Server) Detector {
+		return shipmentDetector{&mu, &handled, errors.New("tracking service unavailable")}
+	})
+
+	logs := captureLog(t)
+	s := newTestServer(t)
+	fake := newFakeGmail(t, "user@example.com")
+	fake.use(s)
+	authenticate(s, "user@example.com")
+	s.historyStore.history[accountKey{providerGmail, "user@example.com"}] = 1000
+	fake.addMessage("m1", map[string]string{"Subject": "Your order has shipped", "From": "orders@shop.example"},
+		"Your parcel is on its way and arrives Thursday.", "", time.Now())
+	msg := fake.addMessage("m2", map[string]string{"Subject": "Transaction alert", "From": "alerts@examplebank.com"},
+		"Rs.424.00 spent on your credit card XX1234 at AMAZON on 11 Nov, 2025", "", time.Now())
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, pushRequest(base64.StdEncoding, "user@example.com", msg.HistoryId))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !equalStrings(handled, []string{"user@example.com m1"}) {
+		t.Errorf("custom detector handled %v, want only m1", handled)
+	}
+	// A failing detector is logged and doesn't stop the built-in ones
+	if !strings.Contains(logs.String(), "Detector shipment failed on message m1: tracking service unavailable") {
+		t.Errorf("log lacks the detector error:\n%s", logs)
+	}
+	if records, _, err := s.transactions.Query("user@example.com", TransactionFilter{}); err != nil || len(records) != 1 {
+		t.Errorf("recorded %v (%v), want the m2 transaction", records, err)
+	}
+}
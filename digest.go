@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// digestTopMerchants is how many merchants a digest lists
+	digestTopMerchants = 5
+
+	// digestBillWindow is how far ahead a digest looks for bills due
+	digestBillWindow = 7 * 24 * time.Hour
+
+	// digestCatchUp is how late after DIGEST_TIME a restarted server still sends that day's digest
+	digestCatchUp = time.Hour
+)
+
+// dailyDigest summarizes one user's transactions for a calendar day in TZ
+type dailyDigest struct {
+	Date         string           `json:"date"`              // 2006-01-02
+	Count        int              `json:"count"`             // Every transaction that day, credits included
+	Spent        map[string]int64 `json:"spent_minor_units"` // Debits counting toward spend, by currency
+	TopMerchants []digestMerchant `json:"top_merchants"`
+	BillsDue     []*BillReminder  `json:"bills_due"` // Due within digestBillWindow
+}
+
+// digestMerchant is one merchant's spend in a digest
+type digestMerchant struct {
+	Merchant        string `json:"merchant"`
+	Currency        string `json:"currency"`
+	SpentMinorUnits int64  `json:"spent_minor_units"`
+	Count           int    `json:"count"`
+}
+
+// digestClock returns DIGEST_TIME ("21:00") as minutes after midnight in TZ;
+// ok is false when digests are off
+func digestClock() (minutes int, ok bool) {
+	value := strings.TrimSpace(os.Getenv("DIGEST_TIME"))
+	if value == "" {
+		return 0, false
+	}
+	minutes, err := parseClock(value)
+	if err != nil {
+		log.Printf("Invalid DIGEST_TIME %q, digests are off: %v", value, err)
+		return 0, false
+	}
+	return minutes, true
+}
+
+// digestSendEmpty reports whether users with no transactions get a "no spend"
+// digest, from DIGEST_SEND_EMPTY
+func digestSendEmpty() bool {
+	return strings.EqualFold(os.Getenv("DIGEST_SEND_EMPTY"), "true")
+}
+
+// digestStatePath returns the file recording the last digest sent to each user, from DIGEST_STATE_PATH
+func digestStatePath() string {
+	if value := strings.TrimSpace(os.Getenv("DIGEST_STATE_PATH")); value != "" {
+		return value
+	}
+	return "digest_state.json"
+}
+
+// digestAt returns the digest time on day's calendar date in day's location
+func digestAt(day time.Time, minutes int) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), minutes/60, minutes%60, 0, 0, day.Location())
+}
+
+// nextDigestTime returns the first digest time in TZ after now
+func nextDigestTime(now time.Time, minutes int) time.Time {
+	now = now.In(transactionLocation())
+	at := digestAt(now, minutes)
+	if !at.After(now) {
+		at = digestAt(time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location()), minutes)
+	}
+	return at
+}
+
+// runDigests sends each user's digest at DIGEST_TIME every day until stop is closed
+func (s *Server) runDigests(minutes int, stop <-chan struct{}) {
+	// A restart shortly after the digest time still sends today's digests;
+	// the sent record keeps users who already got one from getting it twice
+	now := time.Now().In(transactionLocation())
+	if today := digestAt(now, minutes); !now.Before(today) && now.Sub(today) < digestCatchUp {
+		s.sendDigests(today)
+	}
+
+	for {
+		next := nextDigestTime(time.Now(), minutes)
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+			s.sendDigests(next)
+		case <-stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// sendDigests sends the digest for at's day to every authenticated user who
+// hasn't had one yet, skipping users without transactions unless DIGEST_SEND_EMPTY=true
+func (s *Server) sendDigests(at time.Time) {
+	// Users signed in with several providers get one digest
+	s.tokenStore.RLock()
+	seen := make(map[string]bool, len(s.tokenStore.tokens))
+	users := make([]string, 0, len(s.tokenStore.tokens))
+	for key := range s.tokenStore.tokens {
+		if !seen[key.Email] {
+			seen[key.Email] = true
+			users = append(users, key.Email)
+		}
+	}
+	s.tokenStore.RUnlock()
+	sort.Strings(users)
+
+	date := at.Format("2006-01-02")
+	for _, userEmail := range users {
+		if s.digestSent(userEmail, date) {
+			continue
+		}
+		digest, err := s.buildDigest(userEmail, at)
+		if err != nil {
+			log.Printf("Unable to build digest for %s: %v", userEmail, err)
+			continue
+		}
+		if digest.Count == 0 && !digestSendEmpty() {
+			continue
+		}
+		s.notifiers.digest(log.Default(), userEmail, digest)
+		s.markDigestSent(userEmail, date)
+		log.Printf("Sent %s digest to %s: %d transactions", date, userEmail, digest.Count)
+	}
+}
+
+// buildDigest summarizes a user's transactions on at's calendar day, up to at
+func (s *Server) buildDigest(userEmail string, at time.Time) (*dailyDigest, error) {
+	start := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, at.Location())
+	end := time.Date(at.Year(), at.Month(), at.Day()+1, 0, 0, 0, 0, at.Location())
+	records, _, err := s.transactions.Query(userEmail, TransactionFilter{From: start, To: end})
+	if err != nil {
+		return nil, err
+	}
+
+	digest := &dailyDigest{
+		Date:     start.Format("2006-01-02"),
+		Count:    len(records),
+		Spent:    make(map[string]int64),
+		BillsDue: s.billsDueSoon(userEmail, start),
+	}
+	merchants := make(map[string]*digestMerchant)
+	for i := range records {
+		txn := &records[i].CreditCardTransaction
+		if !txn.CountsTowardSpend() {
+			continue
+		}
+		digest.Spent[txn.Currency] += txn.AmountMinorUnits
+
+		name := txn.Merchant
+		if name == "" {
+			name = "unknown"
+		}
+		merchant, ok := merchants[name+"|"+txn.Currency]
+		if !ok {
+			merchant = &digestMerchant{Merchant: name, Currency: txn.Currency}
+			merchants[name+"|"+txn.Currency] = merchant
+		}
+		merchant.SpentMinorUnits += txn.AmountMinorUnits
+		merchant.Count++
+	}
+
+	for _, merchant := range merchants {
+		digest.TopMerchants = append(digest.TopMerchants, *merchant)
+	}
+	sort.Slice(digest.TopMerchants, func(i, j int) bool {
+		a, b := digest.TopMerchants[i], digest.TopMerchants[j]
+		if a.SpentMinorUnits != b.SpentMinorUnits {
+			return a.SpentMinorUnits > b.SpentMinorUnits
+		}
+		return a.Merchant < b.Merchant
+	})
+	if len(digest.TopMerchants) > digestTopMerchants {
+		digest.TopMerchants = digest.TopMerchants[:digestTopMerchants]
+	}
+	return digest, nil
+}
+
+// digestBillLine renders a bill due soon, e.g. "5-Dec-2025: HDFC card XX1234, 23,400 INR"
+func digestBillLine(bill *BillReminder) string {
+	label := bill.Issuer
+	if bill.CardNumber != "" {
+		label = strings.TrimSpace(label + " card XX" + bill.CardNumber)
+	}
+	if label == "" {
+		label = "Card"
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s: %s, %s %s", bill.DueDate, label, bill.TotalDue, bill.Currency))
+}
+
+// rememberBill keeps a bill reminder with a parseable due date for digests
+// and the calendar feed. Reminders for the same card and due date replace each other.
+func (s *Server) rememberBill(userEmail string, bill *BillReminder) {
+	if _, ok := billDueDate(bill); !ok {
+		return
+	}
+	s.billsDue.Lock()
+	defer s.billsDue.Unlock()
+	bills := s.billsDue.bills[userEmail]
+	for i, existing := range bills {
+		if existing.Issuer == bill.Issuer && existing.CardNumber == bill.CardNumber && existing.DueDate == bill.DueDate {
+			bills[i] = bill
+			return
+		}
+	}
+	s.billsDue.bills[userEmail] = append(bills, bill)
+}
+
+// billDueDate parses a bill's due date as midnight in TZ
+func billDueDate(bill *BillReminder) (time.Time, bool) {
+	return parseTransactionTimestamp(bill.DueDate, "", transactionLocation())
+}
+
+// storedBills returns the user's bills due on or after since, soonest first,
+// and forgets those more than billRetention past due
+func (s *Server) storedBills(userEmail string, since, now time.Time) []*BillReminder {
+	due := func(bill *BillReminder) time.Time {
+		t, _ := billDueDate(bill)
+		return t
+	}
+	cutoff := now.Add(-billRetention)
+
+	s.billsDue.Lock()
+	defer s.billsDue.Unlock()
+	var kept, matched []*BillReminder
+	for _, bill := range s.billsDue.bills[userEmail] {
+		if due(bill).Before(cutoff) {
+			continue
+		}
+		kept = append(kept, bill)
+		if !due(bill).Before(since) {
+			matched = append(matched, bill)
+		}
+	}
+	if len(kept) == 0 {
+		delete(s.billsDue.bills, userEmail)
+	} else {
+		s.billsDue.bills[userEmail] = kept
+	}
+	sort.SliceStable(matched, func(i, j int) bool { return due(matched[i]).Before(due(matched[j])) })
+	return matched
+}
+
+// billsDueSoon returns the user's bills due from day to digestBillWindow later, soonest first
+func (s *Server) billsDueSoon(userEmail string, day time.Time) []*BillReminder {
+	var soon []*BillReminder
+	for _, bill := range s.storedBills(userEmail, day, day) {
+		if due, _ := billDueDate(bill); due.Before(day.Add(digestBillWindow)) {
+			soon = append(soon, bill)
+		}
+	}
+	return soon
+}
+
+// digestSent reports whether the user's digest for date already went out
+func (s *Server) digestSent(userEmail, date string) bool {
+	s.digests.Lock()
+	defer s.digests.Unlock()
+	s.loadDigestStateLocked()
+	return s.digests.sent[userEmail] >= date
+}
+
+// markDigestSent records the user's digest for date and saves the record to disk
+func (s *Server) markDigestSent(userEmail, date string) {
+	s.digests.Lock()
+	defer s.digests.Unlock()
+	s.loadDigestStateLocked()
+	s.digests.sent[userEmail] = date
+	data, err := json.Marshal(s.digests.sent)
+	if err != nil {
+		log.Printf("Unable to encode digest state: %v", err)
+		return
+	}
+	if err := os.WriteFile(digestStatePath(), data, 0600); err != nil {
+		log.Printf("Unable to write digest state: %v", err)
+	}
+}
+
+// loadDigestStateLocked reads the sent record from disk once; s.digests must be locked
+func (s *Server) loadDigestStateLocked() {
+	if s.digests.loaded {
+		return
+	}
+	s.digests.loaded = true
+
+	data, err := os.ReadFile(digestStatePath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Unable to read digest state: %v", err)
+		}
+		return
+	}
+	var sent map[string]string
+	if err := json.Unmarshal(data, &sent); err != nil {
+		log.Printf("Unable to parse digest state: %v", err)
+		return
+	}
+	for userEmail, date := range sent {
+		s.digests.sent[userEmail] = date
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Embed colors by direction and kind
+const (
+	discordColorDebit  = 0xE74C3C
+	discordColorCredit = 0x2ECC71
+	discordColorDigest = 0x5865F2
+	discordColorAlert  = 0xF1C40F
+)
+
+// discordMaxAttempts bounds posts per message when Discord rate limits us
+const discordMaxAttempts = 3
+
+// discordDigestLines caps the transactions listed in a quiet-hours digest
+const discordDigestLines = 20
+
+// discordNotifier posts transaction embeds to Discord webhooks
+// During DISCORD_QUIET_HOURS transactions are queued per webhook and posted as
+// one digest embed when the window ends.
+type discordNotifier struct {
+	sync.Mutex
+	queued    map[string][]*CreditCardTransaction // By webhook URL
+	scheduled bool
+}
+
+// The Discord sink is registered when DISCORD_WEBHOOK_URL or DISCORD_WEBHOOK_MAP is set
+func init() {
+	registerNotifier("discord", func(s *Server) Notifier {
+		if strings.TrimSpace(os.Getenv("DISCORD_WEBHOOK_URL")) == "" && strings.TrimSpace(os.Getenv("DISCORD_WEBHOOK_MAP")) == "" {
+			return nil
+		}
+		return newDiscordNotifier()
+	})
+}
+
+// newDiscordNotifier creates a discordNotifier with nothing queued
+func newDiscordNotifier() *discordNotifier {
+	return &discordNotifier{queued: make(map[string][]*CreditCardTransaction)}
+}
+
+// discordWebhookFor returns the user's DISCORD_WEBHOOK_MAP entry
+// ("alice@example.com=https://discord.com/api/webhooks/...,..."), else
+// DISCORD_WEBHOOK_URL; empty disables Discord
+func discordWebhookFor(userEmail string) string {
+	for _, entry := range strings.Split(os.Getenv("DISCORD_WEBHOOK_MAP"), ",") {
+		user, target, ok := strings.Cut(entry, "=")
+		if ok && strings.EqualFold(strings.TrimSpace(user), userEmail) {
+			return strings.TrimSpace(target)
+		}
+	}
+	return strings.TrimSpace(os.Getenv("DISCORD_WEBHOOK_URL"))
+}
+
+// parseClock parses "HH:MM" into minutes after midnight
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// discordQuietHours returns the DISCORD_QUIET_HOURS window ("22:00-07:00", local
+// time in TZ) as minutes after midnight; ok is false when unset or invalid
+func discordQuietHours() (start, end int, ok bool) {
+	value := strings.TrimSpace(os.Getenv("DISCORD_QUIET_HOURS"))
+	if value == "" {
+		return 0, 0, false
+	}
+	from, to, found := strings.Cut(value, "-")
+	start, err1 := parseClock(from)
+	end, err2 := parseClock(to)
+	if !found || err1 != nil || err2 != nil || start == end {
+		log.Printf("Invalid DISCORD_QUIET_HOURS %q, expected HH:MM-HH:MM", value)
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// quietHoursEnd returns when the quiet window containing now ends, or the zero
+// time when now is outside quiet hours
+func quietHoursEnd(now time.Time) time.Time {
+	start, end, ok := discordQuietHours()
+	if !ok {
+		return time.Time{}
+	}
+	local := now.In(transactionLocation())
+	minute := local.Hour()*60 + local.Minute()
+
+	inside := minute >= start && minute < end
+	if start > end {
+		// The window wraps past midnight
+		inside = minute >= start || minute < end
+	}
+	if !inside {
+		return time.Time{}
+	}
+
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+	ends := midnight.Add(time.Duration(end) * time.Minute)
+	if !ends.After(local) {
+		ends = ends.AddDate(0, 0, 1)
+	}
+	return ends
+}
+
+// Name identifies the sink in logs and metrics
+func (n *discordNotifier) Name() string { return "discord" }
+
+// NotifyTransaction posts a transaction embed, or queues it during quiet hours
+func (n *discordNotifier) NotifyTransaction(ctx context.Context, userEmail string, txn *CreditCardTransaction) error {
+	target := discordWebhookFor(userEmail)
+	if target == "" {
+		return nil
+	}
+
+	if ends := quietHoursEnd(time.Now()); !ends.IsZero() {
+		logger := notificationFrom(ctx).Logger
+		n.Lock()
+		n.queued[target] = append(n.queued[target], txn)
+		if !n.scheduled {
+			n.scheduled = true
+			time.AfterFunc(time.Until(ends), func() { n.flush(logger) })
+		}
+		n.Unlock()
+		return nil
+	}
+
+	return n.post(ctx, target, discordTransactionEmbed(txn))
+}
+
+// NotifyBill does nothing; Discord only receives transactions
+func (n *discordNotifier) NotifyBill(ctx context.Context, userEmail string, bill *BillReminder) error {
+	return nil
+}
+
+// NotifyAlert posts a triggered spend alert embed; alerts are not held for quiet hours
+func (n *discordNotifier) NotifyAlert(ctx context.Context, userEmail string, alert *spendAlert) error {
+	target := discordWebhookFor(userEmail)
+	if target == "" {
+		return nil
+	}
+	return n.post(ctx, target, map[string]interface{}{
+		"title":       "Spend alert",
+		"color":       discordColorAlert,
+		"description": alertText(alert),
+	})
+}
+
+// flush posts one digest embed per webhook for the transactions queued during quiet hours
+func (n *discordNotifier) flush(logger *log.Logger) {
+	n.Lock()
+	queued := n.queued
+	n.queued = make(map[string][]*CreditCardTransaction)
+	n.scheduled = false
+	n.Unlock()
+
+	for target, txns := range queued {
+		if err := n.post(context.Background(), target, discordDigestEmbed(txns)); err != nil {
+			logger.Printf("Dropping Discord digest: %v", err)
+		}
+	}
+}
+
+// post sends one embed, waiting out 429 responses for their retry_after
+func (n *discordNotifier) post(ctx context.Context, target string, embed map[string]interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{"embeds": []interface{}{embed}})
+	if err != nil {
+		return fmt.Errorf("unable to encode Discord embed: %v", err)
+	}
+
+	for attempt := 1; ; attempt++ {
+		retryAfter, err := postDiscord(ctx, target, body)
+		if err == nil {
+			return nil
+		}
+		if retryAfter <= 0 || attempt == discordMaxAttempts {
+			return fmt.Errorf("failed after %d attempts: %v", attempt, err)
+		}
+		select {
+		case <-time.After(retryAfter):
+		case <-ctx.Done():
+			return fmt.Errorf("%v (retry cancelled: %v)", err, ctx.Err())
+		}
+	}
+}
+
+// postDiscord sends one webhook request; on 429 it returns how long to wait
+func postDiscord(ctx context.Context, target string, body []byte) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, webhookClient.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("invalid Discord webhook: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		// The error includes the URL, and with it the webhook token
+		return 0, fmt.Errorf("request failed: %v", errors.Unwrap(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		var limit struct {
+			RetryAfter float64 `json:"retry_after"` // Seconds
+		}
+		json.NewDecoder(resp.Body).Decode(&limit)
+		wait := time.Duration(limit.RetryAfter * float64(time.Second))
+		if wait <= 0 {
+			if secs, err := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64); err == nil {
+				wait = time.Duration(secs * float64(time.Second))
+			}
+		}
+		if wait <= 0 {
+			wait = time.Second
+		}
+		return wait, fmt.Errorf("Discord returned %s", resp.Status)
+	}
+	if resp.StatusCode >= 300 {
+		return 0, fmt.Errorf("Discord returned %s", resp.Status)
+	}
+	return 0, nil
+}
+
+// discordTransactionEmbed builds the embed for one transaction, red for debits
+// and green for credits and refunds
+func discordTransactionEmbed(txn *CreditCardTransaction) map[string]interface{} {
+	title, color := "Debit", discordColorDebit
+	switch {
+	case txn.IsRefund:
+		title, color = "Refund", discordColorCredit
+	case txn.Direction == directionCredit:
+		title, color = "Credit", discordColorCredit
+	}
+
+	merchant, card, when := txn.Merchant, txn.CardNumber, txn.Date+" "+txn.Time
+	if merchant == "" {
+		merchant = "Unknown"
+	}
+	if card == "" {
+		card = txn.AccountLast4
+	}
+	if card != "" {
+		card = "XX" + card
+	} else {
+		card = "—"
+	}
+	if !txn.Timestamp.IsZero() {
+		when = txn.Timestamp.In(transactionLocation()).Format("2 Jan 2006 15:04 MST")
+	}
+	if strings.TrimSpace(when) == "" {
+		when = "—"
+	}
+
+	embed := map[string]interface{}{
+		"title": title,
+		"color": color,
+		"fields": []map[string]interface{}{
+			{"name": "Merchant", "value": merchant, "inline": true},
+			{"name": "Amount", "value": formatAmount(txn.AmountMinorUnits, txn.Currency), "inline": true},
+			{"name": "Card", "value": card, "inline": true},
+			{"name": "Time", "value": when, "inline": true},
+		},
+	}
+	if !txn.Timestamp.IsZero() {
+		embed["timestamp"] = txn.Timestamp.Format(time.RFC3339)
+	}
+	return embed
+}
+
+// discordDigestEmbed summarizes the transactions queued during quiet hours
+func discordDigestEmbed(txns []*CreditCardTransaction) map[string]interface{} {
+	var lines []string
+	for i, txn := range txns {
+		if i == discordDigestLines {
+			lines = append(lines, fmt.Sprintf("…and %d more", len(txns)-i))
+			break
+		}
+		sign := "-"
+		if txn.Direction == directionCredit {
+			sign = "+"
+		}
+		lines = append(lines, fmt.Sprintf("%s%s at %s", sign, formatAmount(txn.AmountMinorUnits, txn.Currency), txn.Merchant))
+	}
+	title := fmt.Sprintf("%d transactions during quiet hours", len(txns))
+	if len(txns) == 1 {
+		title = "1 transaction during quiet hours"
+	}
+	return map[string]interface{}{
+		"title":       title,
+		"color":       discordColorDigest,
+		"description": strings.Join(lines, "\n"),
+	}
+}
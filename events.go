@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// sseHeartbeatInterval is how often an idle /events stream sends a comment,
+	// keeping proxies from closing the connection
+	sseHeartbeatInterval = 20 * time.Second
+
+	// sseReplayWindow is how long published events are kept for Last-Event-ID replay
+	sseReplayWindow = 5 * time.Minute
+
+	// sseSubscriberBuffer is the number of events queued per subscriber; a
+	// subscriber that falls further behind misses events
+	sseSubscriberBuffer = 64
+)
+
+// Event names sent on /events
+const (
+	sseEventTransaction  = "transaction"
+	sseEventBillReminder = "bill_reminder"
+	sseEventAlert        = "alert"
+)
+
+// sseEvent is one published event; IDs increase across all users
+type sseEvent struct {
+	ID          int64
+	UserEmail   string
+	Name        string
+	Data        []byte
+	PublishedAt time.Time
+}
+
+// eventBroker fans published events out to each user's /events subscribers and
+// buffers recent ones for replay
+type eventBroker struct {
+	sync.Mutex
+	subscribers map[string]map[chan sseEvent]struct{}
+	recent      []sseEvent
+	nextID      int64
+}
+
+// newEventBroker creates an eventBroker with no subscribers
+func newEventBroker() *eventBroker {
+	return &eventBroker{subscribers: make(map[string]map[chan sseEvent]struct{})}
+}
+
+// The event stream sink is always registered; it only costs anything with subscribers
+func init() {
+	registerNotifier("events", func(s *Server) Notifier { return s.events })
+}
+
+// Name identifies the sink in logs and metrics
+func (b *eventBroker) Name() string { return "events" }
+
+// NotifyTransaction publishes a transaction event with its source message
+func (b *eventBroker) NotifyTransaction(ctx context.Context, userEmail string, txn *CreditCardTransaction) error {
+	meta := notificationFrom(ctx)
+	b.publish(meta.Logger, userEmail, sseEventTransaction, TransactionRecord{
+		UserEmail:             userEmail,
+		MessageID:             meta.MessageID,
+		Subject:               meta.Subject,
+		CreditCardTransaction: *txn,
+	})
+	return nil
+}
+
+// NotifyBill publishes a bill reminder event
+func (b *eventBroker) NotifyBill(ctx context.Context, userEmail string, bill *BillReminder) error {
+	meta := notificationFrom(ctx)
+	b.publish(meta.Logger, userEmail, sseEventBillReminder, map[string]interface{}{"message_id": meta.MessageID, "bill_reminder": bill})
+	return nil
+}
+
+// NotifyAlert publishes a triggered spend alert event
+func (b *eventBroker) NotifyAlert(ctx context.Context, userEmail string, alert *spendAlert) error {
+	meta := notificationFrom(ctx)
+	b.publish(meta.Logger, userEmail, sseEventAlert, map[string]interface{}{"message_id": meta.MessageID, "alert": alert})
+	return nil
+}
+
+// publish sends payload as a named event to the user's subscribers
+func (b *eventBroker) publish(logger *log.Logger, userEmail, name string, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		logger.Printf("Unable to encode %s event: %v", name, err)
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	b.nextID++
+	event := sseEvent{ID: b.nextID, UserEmail: userEmail, Name: name, Data: data, PublishedAt: time.Now()}
+	b.pruneLocked(event.PublishedAt)
+	b.recent = append(b.recent, event)
+
+	for ch := range b.subscribers[userEmail] {
+		select {
+		case ch <- event:
+		default:
+			logger.Printf("Events subscriber for %s is not keeping up, dropped event %d", userEmail, event.ID)
+		}
+	}
+}
+
+// pruneLocked drops buffered events older than sseReplayWindow; b must be locked
+func (b *eventBroker) pruneLocked(now time.Time) {
+	cutoff := now.Add(-sseReplayWindow)
+	keep := 0
+	for keep < len(b.recent) && b.recent[keep].PublishedAt.Before(cutoff) {
+		keep++
+	}
+	b.recent = b.recent[keep:]
+}
+
+// subscribe registers a channel for the user's events and returns it with the
+// buffered events after lastEventID (none when it is negative), taken
+// atomically so nothing is missed between the two
+func (b *eventBroker) subscribe(userEmail string, lastEventID int64) (chan sseEvent, []sseEvent) {
+	ch := make(chan sseEvent, sseSubscriberBuffer)
+
+	b.Lock()
+	defer b.Unlock()
+	if b.subscribers[userEmail] == nil {
+		b.subscribers[userEmail] = make(map[chan sseEvent]struct{})
+	}
+	b.subscribers[userEmail][ch] = struct{}{}
+
+	var replay []sseEvent
+	if lastEventID >= 0 {
+		b.pruneLocked(time.Now())
+		for _, event := range b.recent {
+			if event.ID > lastEventID && event.UserEmail == userEmail {
+				replay = append(replay, event)
+			}
+		}
+	}
+	return ch, replay
+}
+
+// unsubscribe removes a channel registered by subscribe
+func (b *eventBroker) unsubscribe(userEmail string, ch chan sseEvent) {
+	b.Lock()
+	defer b.Unlock()
+	delete(b.subscribers[userEmail], ch)
+	if len(b.subscribers[userEmail]) == 0 {
+		delete(b.subscribers, userEmail)
+	}
+}
+
+// writeSSEEvent writes one event in text/event-stream framing
+func writeSSEEvent(w http.ResponseWriter, event sseEvent) error {
+	_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Name, event.Data)
+	return err
+}
+
+// eventsHandler streams the user's new transactions and bill reminders as
+// Server-Sent Events. A Last-Event-ID header (or lastEventId parameter) replays
+// events from the last sseReplayWindow that the client has not seen.
+func (s *Server) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r.Context())
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userEmail, ok := s.requestUser(w, r, r.URL.Query().Get("userEmail"))
+	if !ok {
+		return
+	}
+	if !s.isAuthenticated(userEmail) {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+	after := int64(-1)
+	if lastEventID != "" {
+		var err error
+		if after, err = strconv.ParseInt(lastEventID, 10, 64); err != nil {
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ch, replay := s.events.subscribe(userEmail, after)
+	defer s.events.unsubscribe(userEmail, ch)
+	logger.Printf("Events subscriber connected for %s, replaying %d events", userEmail, len(replay))
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	for _, event := range replay {
+		if err := writeSSEEvent(w, event); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			logger.Printf("Events subscriber disconnected for %s", userEmail)
+			return
+		case event := <-ch:
+			if err := writeSSEEvent(w, event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
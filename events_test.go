@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readSSEEvent reads the next event from an /events stream, skipping comments
+func readSSEEvent(t *testing.T, r *bufio.Reader) (id, name string, data []byte) {
+	t.Helper()
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && name != "":
+			return id, name, data
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = []byte(strings.TrimPrefix(line, "data: "))
+		}
+	}
+}
+
+// openEvents connects to /events for userEmail, with lastEventID when set
+func openEvents(t *testing.T, server *httptest.Server, userEmail, lastEventID string) *bufio.Reader {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events?userEmail="+userEmail, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("GET /events: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("GET /events = %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+func TestEventsStream(t *testing.T) {
+	s := newTestServer(t)
+	authenticate(s, "user@example.com")
+	server := httptest.NewServer(s.Handler())
+	t.Cleanup(server.Close) // Runs after the streams opened below are closed
+
+	first := openEvents(t, server, "user@example.com", "")
+	second := openEvents(t, server, "user@example.com", "")
+
+	ctx := context.WithValue(context.Background(), notificationKey{}, notification{MessageID: "m1", Subject: "Transaction alert"})
+	s.events.NotifyTransaction(ctx, "other@example.com", sampleTransaction())
+	s.events.NotifyTransaction(ctx, "user@example.com", sampleTransaction())
+	bill := parseBillReminder("Your credit card statement", "Total amount due: Rs.12,345.00. Payment due date: 05 Dec 2025.")
+	s.events.NotifyBill(ctx, "user@example.com", bill)
+
+	for _, stream := range []*bufio.Reader{first, second} {
+		id, name, data := readSSEEvent(t, stream)
+		var txn TransactionRecord
+		if err := json.Unmarshal(data, &txn); err != nil {
+			t.Fatalf("decode transaction %s: %v", data, err)
+		}
+		if id != "2" || name != sseEventTransaction || txn.MessageID != "m1" || txn.Merchant == "" {
+			t.Errorf("first event = %s %s %s, want user@example.com's transaction", id, name, data)
+		}
+
+		id, name, data = readSSEEvent(t, stream)
+		var payload map[string]json.RawMessage
+		if err := json.Unmarshal(data, &payload); err != nil {
+			t.Fatalf("decode bill reminder %s: %v", data, err)
+		}
+		if id != "3" || name != sseEventBillReminder || string(payload["message_id"]) != `"m1"` || payload["bill_reminder"] == nil {
+			t.Errorf("second event = %s %s %s, want the bill reminder", id, name, data)
+		}
+	}
+
+	// A client reconnecting after event 2 gets only what it missed
+	replay := openEvents(t, server, "user@example.com", "2")
+	if id, name, _ := readSSEEvent(t, replay); id != "3" || name != sseEventBillReminder {
+		t.Errorf("replayed event = %s %s, want 3 bill_reminder", id, name)
+	}
+}
+
+func TestEventsUnsubscribeOnDisconnect(t *testing.T) {
+	s := newTestServer(t)
+	authenticate(s, "user@example.com")
+	server := httptest.NewServer(s.Handler())
+	t.Cleanup(server.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events?userEmail=user@example.com", nil)
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("GET /events: %v", err)
+	}
+	cancel()
+	resp.Body.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		s.events.Lock()
+		subscribers := len(s.events.subscribers["user@example.com"])
+		s.events.Unlock()
+		if subscribers == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d subscribers left after disconnect", subscribers)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// exportFlushEvery is how many rows are written between flushes while streaming an export
+const exportFlushEvery = 200
+
+// exportPageSize is how many records are read from the store at a time while exporting
+const exportPageSize = 500
+
+// exportCSVColumns is the CSV header; the order is part of the export format
+var exportCSVColumns = []string{
+	"timestamp", "date", "time", "merchant", "amount", "currency", "direction", "status",
+	"category", "card_number", "account_last4", "bank", "issuer", "channel", "reference_id", "message_id",
+}
+
+// exportFormats are the supported format values with their content types and file extensions
+var exportFormats = map[string]struct{ contentType, extension string }{
+	"csv":       {"text/csv; charset=utf-8", "csv"},
+	"beancount": {"text/plain; charset=utf-8", "beancount"},
+	"json":      {"application/json", "json"},
+}
+
+// currencyExponents are the ISO 4217 decimal places of currencies that don't use two
+var currencyExponents = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0, "PYG": 0,
+	"RWF": 0, "UGX": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+}
+
+// formatMinorUnits renders an amount in hundredths, as parseAmountMinorUnits
+// stores every currency, with currency's decimal places: -1424.00 INR, 1500 JPY
+// (rounded half away from zero), 12.340 KWD
+func formatMinorUnits(minorUnits int64, currency string) string {
+	sign := ""
+	if minorUnits < 0 {
+		sign, minorUnits = "-", -minorUnits
+	}
+	whole, cents := minorUnits/100, minorUnits%100
+	exponent, ok := currencyExponents[strings.ToUpper(currency)]
+	if !ok {
+		exponent = 2
+	}
+	switch exponent {
+	case 0:
+		if cents >= 50 {
+			whole++
+		}
+		return fmt.Sprintf("%s%d", sign, whole)
+	case 3:
+		return fmt.Sprintf("%s%d.%02d0", sign, whole, cents)
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, whole, cents)
+}
+
+// exportTimestamp renders a record's timestamp in TZ, or "" when unknown
+func exportTimestamp(record TransactionRecord) string {
+	if record.Timestamp.IsZero() {
+		return ""
+	}
+	return record.Timestamp.In(transactionLocation()).Format(time.RFC3339)
+}
+
+// exportRowWriter writes records one at a time, flushing the response as it goes
+type exportRowWriter interface {
+	begin() error
+	write(record TransactionRecord) error
+	end() error
+}
+
+// csvExportWriter writes exportCSVColumns rows
+type csvExportWriter struct {
+	w *csv.Writer
+}
+
+// begin writes the header row
+func (e *csvExportWriter) begin() error {
+	return e.w.Write(exportCSVColumns)
+}
+
+// write writes one row in exportCSVColumns order
+func (e *csvExportWriter) write(record TransactionRecord) error {
+	return e.w.Write([]string{
+		exportTimestamp(record), record.Date, record.Time, record.Merchant,
+		formatMinorUnits(record.AmountMinorUnits, record.Currency), record.Currency, record.Direction, record.Status,
+		record.Category, record.CardNumber, record.AccountLast4, record.Bank, record.Issuer,
+		record.Channel, record.ReferenceID, record.MessageID,
+	})
+}
+
+// end flushes buffered rows
+func (e *csvExportWriter) end() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// jsonExportWriter writes a JSON array, one record per line
+type jsonExportWriter struct {
+	w     *bufio.Writer
+	first bool
+}
+
+// begin opens the array
+func (e *jsonExportWriter) begin() error {
+	e.first = true
+	_, err := e.w.WriteString("[")
+	return err
+}
+
+// write appends one record to the array
+func (e *jsonExportWriter) write(record TransactionRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if !e.first {
+		e.w.WriteString(",")
+	}
+	e.first = false
+	e.w.WriteString("\n")
+	_, err = e.w.Write(data)
+	return err
+}
+
+// end closes the array
+func (e *jsonExportWriter) end() error {
+	_, err := e.w.WriteString("\n]\n")
+	return err
+}
+
+// beancountAccounts maps records to the two accounts of their postings
+// BEANCOUNT_CATEGORY_ACCOUNTS ("food_delivery=Expenses:Food,...") maps categories to
+// expense accounts and BEANCOUNT_FUNDING_ACCOUNTS ("0000=Liabilities:CreditCard:HDFC-0000,...")
+// maps card or account digits to funding accounts; other names are derived from
+// the record. Incoming money that isn't a refund is booked to BEANCOUNT_INCOME_ACCOUNT.
+type beancountAccounts struct {
+	categories map[string]string
+	funding    map[string]string
+	income     string
+}
+
+// beancountAccountsFromEnv reads the account mappings from the environment
+func beancountAccountsFromEnv() beancountAccounts {
+	accounts := beancountAccounts{
+		categories: parseAccountMap(os.Getenv("BEANCOUNT_CATEGORY_ACCOUNTS")),
+		funding:    parseAccountMap(os.Getenv("BEANCOUNT_FUNDING_ACCOUNTS")),
+		income:     strings.TrimSpace(os.Getenv("BEANCOUNT_INCOME_ACCOUNT")),
+	}
+	if accounts.income == "" {
+		accounts.income = "Income:Uncategorized"
+	}
+	return accounts
+}
+
+// parseAccountMap parses "key=Account,key=Account" with case-insensitive keys
+func parseAccountMap(value string) map[string]string {
+	accounts := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		key, account, ok := strings.Cut(entry, "=")
+		if ok && strings.TrimSpace(key) != "" && strings.TrimSpace(account) != "" {
+			accounts[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(account)
+		}
+	}
+	return accounts
+}
+
+// beancountComponent turns text into a valid account name component: letters,
+// digits and dashes, starting with an uppercase letter or digit
+func beancountComponent(text string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range text {
+		if unicode.IsLetter(r) && r < unicode.MaxASCII || unicode.IsDigit(r) && r < unicode.MaxASCII {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			dash = false
+			b.WriteRune(r)
+		} else {
+			dash = true
+		}
+	}
+	component := b.String()
+	if component == "" {
+		return "Unknown"
+	}
+	return strings.ToUpper(component[:1]) + component[1:]
+}
+
+// expenseAccount returns the expense (or income) side of a record
+func (a beancountAccounts) expenseAccount(record TransactionRecord) string {
+	if record.Direction == directionCredit && !record.IsRefund {
+		return a.income
+	}
+	category := record.Category
+	if category == "" {
+		category = categoryUncategorized
+	}
+	if account, ok := a.categories[strings.ToLower(category)]; ok {
+		return account
+	}
+	var parts []string
+	for _, word := range strings.FieldsFunc(category, func(r rune) bool { return r == '_' || r == ' ' || r == '-' }) {
+		parts = append(parts, beancountComponent(word))
+	}
+	// A category of separators alone would leave "Expenses:" with no component
+	if len(parts) == 0 {
+		return "Expenses:Uncategorized"
+	}
+	return "Expenses:" + strings.Join(parts, "")
+}
+
+// fundingAccount returns the card or bank account side of a record
+func (a beancountAccounts) fundingAccount(record TransactionRecord) string {
+	digits, kind := record.CardNumber, "CreditCard"
+	switch {
+	case record.Channel == channelDebitCard:
+		kind = "DebitCard"
+	case digits == "":
+		digits, kind = record.AccountLast4, "Bank"
+	}
+	if account, ok := a.funding[strings.ToLower(digits)]; ok && digits != "" {
+		return account
+	}
+
+	root := "Liabilities"
+	if kind != "CreditCard" {
+		root = "Assets"
+	}
+	name := record.Bank
+	if name == "" {
+		name = record.Issuer
+	}
+	component := beancountComponent(name)
+	if digits != "" {
+		component += "-" + beancountComponent(digits)
+	}
+	return root + ":" + kind + ":" + component
+}
+
+// beancountExportable reports whether a record can be booked: it must have
+// happened, have a date, a currency and a direction
+func beancountExportable(record TransactionRecord) bool {
+	return record.Status != statusDeclined && !record.Timestamp.IsZero() &&
+		record.Currency != "" && record.Direction != directionUnknown && record.Direction != ""
+}
+
+// beancountString quotes text for a Beancount string literal
+func beancountString(text string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", " ").Replace(text) + `"`
+}
+
+// beancountOpenDates records in opened the first date each of a record's
+// accounts is used; exports gather them in a pass over the store before writing
+func (a beancountAccounts) beancountOpenDates(opened map[string]string, record TransactionRecord) {
+	if !beancountExportable(record) {
+		return
+	}
+	date := record.Timestamp.In(transactionLocation()).Format("2006-01-02")
+	for _, account := range []string{a.expenseAccount(record), a.fundingAccount(record)} {
+		if first, ok := opened[account]; !ok || date < first {
+			opened[account] = date
+		}
+	}
+}
+
+// beancountExportWriter writes open directives for every account used, then
+// one balanced transaction per exportable record
+type beancountExportWriter struct {
+	w        *bufio.Writer
+	accounts beancountAccounts
+	opened   map[string]string // Account to the date of its open directive
+	now      time.Time         // Dates the header comment
+}
+
+// begin writes an open directive for each account, dated at its first use
+func (e *beancountExportWriter) begin() error {
+	names := make([]string, 0, len(e.opened))
+	for account := range e.opened {
+		names = append(names, account)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(e.w, "; Exported by read-emails on %s\n\n", e.now.In(transactionLocation()).Format("2006-01-02"))
+	for _, account := range names {
+		fmt.Fprintf(e.w, "%s open %s\n", e.opened[account], account)
+	}
+	_, err := e.w.WriteString("\n")
+	return err
+}
+
+// write writes one transaction, or a comment when the record can't be booked
+func (e *beancountExportWriter) write(record TransactionRecord) error {
+	if !beancountExportable(record) {
+		_, err := fmt.Fprintf(e.w, "; Skipped %s from message %s: declined or missing date, currency, or direction\n\n", record.DedupKey(), record.MessageID)
+		return err
+	}
+
+	amount := record.AmountMinorUnits
+	if record.Direction == directionCredit {
+		amount = -amount
+	}
+	payee := record.Merchant
+	if payee == "" {
+		payee = "Unknown"
+	}
+	flag := "*"
+	if record.Status == statusPending {
+		flag = "!"
+	}
+
+	fmt.Fprintf(e.w, "%s %s %s %s\n", record.Timestamp.In(transactionLocation()).Format("2006-01-02"), flag, beancountString(payee), beancountString(record.Category))
+	fmt.Fprintf(e.w, "  message_id: %s\n", beancountString(record.MessageID))
+	if record.ReferenceID != "" {
+		fmt.Fprintf(e.w, "  reference_id: %s\n", beancountString(record.ReferenceID))
+	}
+	fmt.Fprintf(e.w, "  %s  %s %s\n", e.accounts.expenseAccount(record), formatMinorUnits(amount, record.Currency), record.Currency)
+	_, err := fmt.Fprintf(e.w, "  %s  %s %s\n\n", e.accounts.fundingAccount(record), formatMinorUnits(-amount, record.Currency), record.Currency)
+	return err
+}
+
+// end has nothing to close
+func (e *beancountExportWriter) end() error {
+	return nil
+}
+
+// transactionsExportHandler streams a user's stored transactions, oldest first,
+// as csv, beancount, or json (format parameter; csv by default)
+// Filters are the same as /transactions, without paging.
+func (s *Server) transactionsExportHandler(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r.Context())
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form body", http.StatusBadRequest)
+		return
+	}
+
+	userEmail, ok := s.requestUser(w, r, r.FormValue("userEmail"))
+	if !ok {
+		return
+	}
+	if !s.isAuthenticated(userEmail) {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	format := strings.ToLower(strings.TrimSpace(r.FormValue("format")))
+	if format == "" {
+		format = "csv"
+	}
+	spec, ok := exportFormats[format]
+	if !ok {
+		http.Error(w, "Invalid format parameter (use csv, beancount, or json)", http.StatusBadRequest)
+		return
+	}
+
+	filter, err := parseTransactionFilter(r)
+	if err != nil {
+		http.Error(w, "Invalid parameter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Count the matches up front, which also surfaces store errors before any output
+	count := filter
+	count.Limit = 1
+	_, total, err := s.transactions.Query(userEmail, count)
+	if err != nil {
+		logger.Printf("Unable to query transactions for %s: %v", userEmail, err)
+		http.Error(w, "Failed to query transactions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", spec.contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="transactions.%s"`, spec.extension))
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+
+	flush := func() {}
+	if flusher, ok := w.(http.Flusher); ok {
+		flush = flusher.Flush
+	}
+	written, err := s.writeTransactionsExport(w, flush, format, userEmail, filter, time.Now())
+	if err != nil {
+		logger.Printf("Export of transactions for %s failed after %d records: %v", userEmail, written, err)
+		return
+	}
+	logger.Printf("Exported %d transactions for %s as %s", written, userEmail, format)
+}
+
+// eachExportRecord calls fn with every record of a user matching filter, oldest
+// first, reading exportPageSize records from the store at a time
+func (s *Server) eachExportRecord(userEmail string, filter TransactionFilter, fn func(record TransactionRecord) error) error {
+	filter.Oldest, filter.Limit = true, exportPageSize
+	for filter.Offset = 0; ; filter.Offset += exportPageSize {
+		page, _, err := s.transactions.Query(userEmail, filter)
+		if err != nil {
+			return err
+		}
+		for _, record := range page {
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+		if len(page) < exportPageSize {
+			return nil
+		}
+	}
+}
+
+// writeTransactionsExport streams a user's records matching filter to out in
+// format, calling flush every exportFlushEvery rows; now dates the Beancount
+// header. Returns the records written.
+func (s *Server) writeTransactionsExport(out io.Writer, flush func(), format, userEmail string, filter TransactionFilter, now time.Time) (int, error) {
+	buffered := bufio.NewWriter(out)
+	var writer exportRowWriter
+	switch format {
+	case "csv":
+		writer = &csvExportWriter{w: csv.NewWriter(buffered)}
+	case "json":
+		writer = &jsonExportWriter{w: buffered}
+	case "beancount":
+		// Open directives come first, so a first pass dates each account's first use
+		accounts := beancountAccountsFromEnv()
+		opened := make(map[string]string)
+		err := s.eachExportRecord(userEmail, filter, func(record TransactionRecord) error {
+			accounts.beancountOpenDates(opened, record)
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+		writer = &beancountExportWriter{w: buffered, accounts: accounts, opened: opened, now: now}
+	default:
+		return 0, fmt.Errorf("unknown export format %q", format)
+	}
+
+	written := 0
+	err := writer.begin()
+	if err == nil {
+		err = s.eachExportRecord(userEmail, filter, func(record TransactionRecord) error {
+			if err := writer.write(record); err != nil {
+				return err
+			}
+			written++
+			if written%exportFlushEvery == 0 {
+				if csvWriter, ok := writer.(*csvExportWriter); ok {
+					csvWriter.w.Flush()
+				}
+				buffered.Flush()
+				flush()
+			}
+			return nil
+		})
+	}
+	if err == nil {
+		err = writer.end()
+	}
+	if err == nil {
+		err = buffered.Flush()
+	}
+	return written, err
+}
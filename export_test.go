@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// exportRecords covers CSV quoting, Beancount accounts, refunds, pending and
+// declined records, and currencies with zero and three decimal places
+func exportRecords() []TransactionRecord {
+	day := time.Date(2025, 11, 10, 12, 30, 0, 0, time.UTC)
+	record := func(ref string, minor int64, currency, merchant, category string, ts time.Time) TransactionRecord {
+		r := storeRecord("user@example.com", ref, minor, merchant, channelCreditCard, ts)
+		r.Currency, r.Category, r.CardNumber, r.Bank, r.Status = currency, category, "XX1234", "HDFC Bank", statusCompleted
+		r.Date, r.Time = ts.Format("02 Jan 2006"), ts.Format("15:04")
+		return r
+	}
+
+	quoted := record("r1", 142400, "INR", `Domino's "Pizza", Andheri`, "food_delivery", day)
+	refund := record("r2", 50000, "INR", "AMAZON", "shopping", day.Add(24*time.Hour))
+	refund.Direction, refund.IsRefund = directionCredit, true
+	separators := record("r3", 9900, "INR", "Corner Store", "_", day.Add(48*time.Hour))
+	pending := record("r4", 150050, "JPY", "Lawson", "groceries", day.Add(72*time.Hour))
+	pending.Status = statusPending
+	threeDecimals := record("r5", 1234, "KWD", "Talabat", "food_delivery", day.Add(96*time.Hour))
+	declined := record("r6", 99900, "INR", "FLIPKART", "shopping", day.Add(120*time.Hour))
+	declined.Status = statusDeclined
+	return []TransactionRecord{quoted, refund, separators, pending, threeDecimals, declined}
+}
+
+func TestTransactionsExportGolden(t *testing.T) {
+	t.Setenv("TZ", "UTC")
+	s := newTestServer(t)
+	for _, record := range exportRecords() {
+		if _, err := s.transactions.Add(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Date(2025, 12, 1, 9, 0, 0, 0, time.UTC)
+	for _, format := range []string{"csv", "beancount", "json"} {
+		t.Run(format, func(t *testing.T) {
+			var got bytes.Buffer
+			written, err := s.writeTransactionsExport(&got, func() {}, format, "user@example.com", TransactionFilter{}, now)
+			if err != nil || written != 6 {
+				t.Fatalf("export wrote %d records (%v), want 6", written, err)
+			}
+
+			golden := filepath.Join("testdata", "export", "transactions."+exportFormats[format].extension)
+			if *update {
+				if err := os.WriteFile(golden, got.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run with -update to create it)", err)
+			}
+			if !bytes.Equal(got.Bytes(), want) {
+				t.Errorf("export differs from %s (run with -update after checking the change):\n%s", golden, got.String())
+			}
+		})
+	}
+}
+
+func TestExpenseAccount(t *testing.T) {
+	accounts := beancountAccounts{categories: map[string]string{"travel": "Expenses:Trips"}, income: "Income:Uncategorized"}
+	tests := []struct {
+		category string
+		want     string
+	}{
+		{"food_delivery", "Expenses:FoodDelivery"},
+		{"", "Expenses:Uncategorized"},
+		{"_", "Expenses:Uncategorized"},
+		{" - ", "Expenses:Uncategorized"},
+		{"Travel", "Expenses:Trips"},
+	}
+	for _, tt := range tests {
+		record := TransactionRecord{CreditCardTransaction: CreditCardTransaction{Category: tt.category, Direction: directionDebit}}
+		if got := accounts.expenseAccount(record); got != tt.want {
+			t.Errorf("expenseAccount(%q) = %q, want %q", tt.category, got, tt.want)
+		}
+	}
+}
+
+func TestFormatMinorUnits(t *testing.T) {
+	tests := []struct {
+		minor    int64
+		currency string
+		want     string
+	}{
+		{142400, "INR", "1424.00"},
+		{-5, "USD", "-0.05"},
+		{150000, "JPY", "1500"},
+		{150050, "jpy", "1501"},
+		{-150049, "JPY", "-1500"},
+		{1234, "KWD", "12.340"},
+	}
+	for _, tt := range tests {
+		if got := formatMinorUnits(tt.minor, tt.currency); got != tt.want {
+			t.Errorf("formatMinorUnits(%d, %s) = %q, want %q", tt.minor, tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestTransactionsExportPagesOldestFirst(t *testing.T) {
+	s := newTestServer(t)
+	authenticate(s, "user@example.com")
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	n := exportPageSize*2 + 3
+	for i := n - 1; i >= 0; i-- {
+		record := storeRecord("user@example.com", "r"+strconv.Itoa(i), 10000, "SHOP", channelCreditCard, start.Add(time.Duration(i)*time.Minute))
+		if _, err := s.transactions.Add(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/transactions/export?userEmail=user@example.com&format=csv", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("X-Total-Count") != strconv.Itoa(n) {
+		t.Fatalf("status %d, X-Total-Count %q, want 200 and %d", rec.Code, rec.Header().Get("X-Total-Count"), n)
+	}
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if len(lines) != n+1 {
+		t.Fatalf("export has %d lines, want a header and %d rows", len(lines), n)
+	}
+	for i, line := range lines[1:] {
+		if !strings.Contains(line, ",r"+strconv.Itoa(i)+",") {
+			t.Fatalf("row %d = %q, want reference r%d", i, line, i)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// forwardedMessage is a message forwarded into the mailbox, either embedded as a
+// message/rfc822 part or quoted inline after a "Forwarded message" marker
+// From, Subject and Date are the original message's raw header values; the
+// zero value means the message has no forwarded content.
+type forwardedMessage struct {
+	Body    string
+	From    string
+	Subject string
+	Date    string
+}
+
+// forwardedDateLayouts are tried against an inline forward's Date line, which
+// mail clients write in their own formats rather than RFC 5322
+var forwardedDateLayouts = []string{
+	"Mon, 2 Jan 2006 at 15:04",
+	"Mon, Jan 2, 2006 at 3:04 PM",
+	"January 2, 2006 at 3:04:05 PM MST",
+	"Monday, January 2, 2006 3:04 PM",
+	"2 January 2006 at 15:04:05 MST",
+}
+
+// inlineForwardPattern matches the line mail clients put before an inline forward
+var inlineForwardPattern = regexp.MustCompile(`(?i)^(?:-+\s*forwarded message\s*-+|begin forwarded message:?)$`)
+
+// Time parses the forwarded message's Date, as RFC 5322 or one of
+// forwardedDateLayouts in TZ
+func (f forwardedMessage) Time() (time.Time, bool) {
+	date := strings.TrimSpace(f.Date)
+	if date == "" {
+		return time.Time{}, false
+	}
+	if t, err := mail.ParseDate(date); err == nil {
+		return t, true
+	}
+	for _, layout := range forwardedDateLayouts {
+		if t, err := time.ParseInLocation(layout, date, transactionLocation()); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// extractForwardedMessage extracts the first embedded message/rfc822 part with its headers
+// Gmail doesn't always populate Parts for embedded messages, so when they are
+// missing the raw message is decoded and walked with net/mail.
+// Returns the zero value if the message has no embedded message.
+func extractForwardedMessage(ctx context.Context, service *gmail.Service, mailbox, msgID string, payload *gmail.MessagePart) forwardedMessage {
+	if payload == nil {
+		return forwardedMessage{}
+	}
+
+	if payload.MimeType == "message/rfc822" {
+		// Parts already parsed by Gmail; extract them like a top-level message
+		if len(payload.Parts) > 0 {
+			inner := *payload
+			inner.MimeType = ""
+			return forwardedMessage{
+				Body:    extractEmailBodyWithFetch(ctx, service, mailbox, msgID, &inner).Best(),
+				From:    embeddedHeader(payload, "From"),
+				Subject: embeddedHeader(payload, "Subject"),
+				Date:    embeddedHeader(payload, "Date"),
+			}
+		}
+
+		raw := rfc822PartData(ctx, service, mailbox, msgID, payload)
+		if raw == nil {
+			return forwardedMessage{}
+		}
+		forwarded, err := parseRFC822Message(raw)
+		if err != nil {
+			log.Printf("Unable to parse forwarded message in %s: %v", msgID, err)
+			return forwardedMessage{}
+		}
+		return forwarded
+	}
+
+	for _, subPart := range payload.Parts {
+		if forwarded := extractForwardedMessage(ctx, service, mailbox, msgID, subPart); forwarded.Body != "" {
+			return forwarded
+		}
+	}
+	return forwardedMessage{}
+}
+
+// embeddedHeader returns a header of a message/rfc822 part Gmail has parsed
+// The embedded message's headers are on the part itself or on its first child.
+func embeddedHeader(part *gmail.MessagePart, name string) string {
+	for _, p := range append([]*gmail.MessagePart{part}, part.Parts[0]) {
+		for _, h := range p.Headers {
+			if strings.EqualFold(h.Name, name) {
+				return h.Value
+			}
+		}
+	}
+	return ""
+}
+
+// parseInlineForward returns the message a plain text body forwards inline:
+// the From, Subject and Date lines after the forward marker, and the text after them.
+// Returns the zero value when the body has no inline forward.
+func parseInlineForward(text string) forwardedMessage {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	i := 0
+	for i < len(lines) && !inlineForwardPattern.MatchString(strings.TrimSpace(lines[i])) {
+		i++
+	}
+	if i == len(lines) {
+		return forwardedMessage{}
+	}
+	// Skip the marker and any blank lines before the header block
+	for i++; i < len(lines) && strings.TrimSpace(lines[i]) == ""; i++ {
+	}
+
+	var forwarded forwardedMessage
+headers:
+	for ; i < len(lines); i++ {
+		// Some clients bold the header names ("*From:* ...")
+		name, value, ok := strings.Cut(strings.ReplaceAll(strings.TrimSpace(lines[i]), "*", ""), ":")
+		if !ok {
+			break
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "from":
+			forwarded.From = value
+		case "subject":
+			forwarded.Subject = value
+		case "date", "sent":
+			forwarded.Date = value
+		case "to", "cc", "reply-to":
+		default:
+			break headers
+		}
+	}
+
+	forwarded.Body, _ = truncateUTF8(strings.TrimSpace(strings.Join(lines[i:], "\n")), maxBodyBytes())
+	if forwarded.Body == "" {
+		return forwardedMessage{}
+	}
+	return forwarded
+}
+
+// rfc822PartData returns the decoded raw bytes of an embedded message part
+func rfc822PartData(ctx context.Context, service *gmail.Service, mailbox, msgID string, part *gmail.MessagePart) []byte {
+	if part.Body == nil {
+		return nil
+	}
+
+	bodyData := part.Body.Data
+	if bodyData == "" && part.Body.AttachmentId != "" && service != nil {
+		bodyData = fetchAttachmentData(ctx, service, mailbox, msgID, part)
+	}
+	if bodyData == "" {
+		return nil
+	}
+
+	data, err := decodeBase64Flexible(bodyData)
+	if err != nil {
+		log.Printf("Unable to decode forwarded message in %s: %v", msgID, err)
+		return nil
+	}
+	return data
+}
+
+// parseRFC822Message parses a raw RFC 822 message into its headers and body,
+// preferring plain text to HTML and truncated to MAX_BODY_BYTES
+func parseRFC822Message(raw []byte) (forwardedMessage, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return forwardedMessage{}, fmt.Errorf("unable to read message: %v", err)
+	}
+
+	var plainTextBody, htmlBody string
+	if err := walkMIMEPart(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body, &plainTextBody, &htmlBody); err != nil {
+		return forwardedMessage{}, err
+	}
+	body := plainTextBody
+	if body == "" {
+		body = htmlBody
+	}
+	body, _ = truncateUTF8(body, maxBodyBytes())
+	return forwardedMessage{
+		Body:    body,
+		From:    msg.Header.Get("From"),
+		Subject: msg.Header.Get("Subject"),
+		Date:    msg.Header.Get("Date"),
+	}, nil
+}
+
+// walkMIMEPart recursively collects the first text/plain and text/html bodies of a MIME entity
+func walkMIMEPart(contentType, transferEncoding string, body io.Reader, plainTextBody, htmlBody *string) error {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		// RFC 2045 default for a missing or malformed Content-Type
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"):
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("unable to read multipart body: %v", err)
+			}
+			if err := walkMIMEPart(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part, plainTextBody, htmlBody); err != nil {
+				return err
+			}
+		}
+	case mediaType == "text/plain" || mediaType == "text/html":
+		data, err := io.ReadAll(io.LimitReader(transferDecoder(transferEncoding, body), maxFetchedBodyBytes))
+		if err != nil {
+			return fmt.Errorf("unable to read %s body: %v", mediaType, err)
+		}
+		content := decodeCharset(data, params["charset"])
+		if mediaType == "text/plain" && *plainTextBody == "" {
+			*plainTextBody = content
+		}
+		if mediaType == "text/html" && *htmlBody == "" {
+			*htmlBody = content
+		}
+	case mediaType == "message/rfc822":
+		msg, err := mail.ReadMessage(transferDecoder(transferEncoding, body))
+		if err != nil {
+			return fmt.Errorf("unable to read nested message: %v", err)
+		}
+		return walkMIMEPart(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body, plainTextBody, htmlBody)
+	}
+	return nil
+}
+
+// transferDecoder wraps a reader to undo the given Content-Transfer-Encoding
+func transferDecoder(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	default:
+		return r
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// forwardedAlert is an HDFC alert without a date of its own, so its timestamp
+// can only come from the forwarded message's Date
+const forwardedAlert = "Rs.424.00 spent on your HDFC Bank credit card XX1234 at AMAZON"
+
+// forwardedRaw is the raw RFC 822 bank alert embedded in forwarded messages
+const forwardedRaw = "From: HDFC Bank InstaAlerts <alerts@hdfcbank.net>\r\n" +
+	"Subject: Alert : Update on your HDFC Bank Credit Card\r\n" +
+	"Date: Tue, 11 Nov 2025 12:38:00 +0530\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	forwardedAlert + "\r\n"
+
+// wantForwarded checks the headers and body of an extracted forwarded message
+func wantForwarded(t *testing.T, got forwardedMessage, from, subject, date string) {
+	t.Helper()
+	if got.From != from || got.Subject != subject || got.Date != date {
+		t.Errorf("headers = %q / %q / %q, want %q / %q / %q", got.From, got.Subject, got.Date, from, subject, date)
+	}
+	if strings.TrimSpace(got.Body) != forwardedAlert {
+		t.Errorf("body = %q, want %q", got.Body, forwardedAlert)
+	}
+}
+
+func TestExtractForwardedMessage(t *testing.T) {
+	const (
+		from    = "HDFC Bank InstaAlerts <alerts@hdfcbank.net>"
+		subject = "Alert : Update on your HDFC Bank Credit Card"
+		date    = "Tue, 11 Nov 2025 12:38:00 +0530"
+	)
+	text := func(body string) *gmail.MessagePart {
+		return &gmail.MessagePart{MimeType: "text/plain", Body: &gmail.MessagePartBody{Data: base64.URLEncoding.EncodeToString([]byte(body))}}
+	}
+
+	t.Run("raw embedded message", func(t *testing.T) {
+		payload := &gmail.MessagePart{MimeType: "multipart/mixed", Parts: []*gmail.MessagePart{
+			text("FYI"),
+			{MimeType: "message/rfc822", Body: &gmail.MessagePartBody{Data: base64.URLEncoding.EncodeToString([]byte(forwardedRaw))}},
+		}}
+		wantForwarded(t, extractForwardedMessage(context.Background(), nil, "me", "msg-1", payload), from, subject, date)
+	})
+
+	t.Run("parts parsed by Gmail", func(t *testing.T) {
+		inner := text(forwardedAlert)
+		inner.Headers = []*gmail.MessagePartHeader{{Name: "From", Value: from}, {Name: "Subject", Value: subject}, {Name: "Date", Value: date}}
+		payload := &gmail.MessagePart{MimeType: "multipart/mixed", Parts: []*gmail.MessagePart{
+			text("FYI"),
+			{MimeType: "message/rfc822", Parts: []*gmail.MessagePart{inner}},
+		}}
+		wantForwarded(t, extractForwardedMessage(context.Background(), nil, "me", "msg-1", payload), from, subject, date)
+	})
+
+	t.Run("no embedded message", func(t *testing.T) {
+		if got := extractForwardedMessage(context.Background(), nil, "me", "msg-1", text(forwardedAlert)); got != (forwardedMessage{}) {
+			t.Errorf("got %+v, want the zero value", got)
+		}
+	})
+}
+
+func TestParseInlineForward(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		from string
+		date string
+	}{
+		{
+			"gmail",
+			"FYI\n\n---------- Forwarded message ---------\nFrom: HDFC Bank InstaAlerts <alerts@hdfcbank.net>\nDate: Tue, 11 Nov 2025 at 12:38\nSubject: Alert : Update on your HDFC Bank Credit Card\nTo: <user@gmail.com>\n\n\n" + forwardedAlert,
+			"HDFC Bank InstaAlerts <alerts@hdfcbank.net>",
+			"Tue, 11 Nov 2025 at 12:38",
+		},
+		{
+			"apple mail",
+			"Begin forwarded message:\r\n\r\n*From:* alerts@hdfcbank.net\r\n*Subject:* Alert : Update on your HDFC Bank Credit Card\r\n*Date:* November 11, 2025 at 12:38:00 PM IST\r\n*To:* user@icloud.com\r\n\r\n" + forwardedAlert,
+			"alerts@hdfcbank.net",
+			"November 11, 2025 at 12:38:00 PM IST",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wantForwarded(t, parseInlineForward(tt.body), tt.from, "Alert : Update on your HDFC Bank Credit Card", tt.date)
+		})
+	}
+
+	if got := parseInlineForward("Thanks!\n\nOn Tue, 11 Nov 2025, Alerts wrote:\n> " + forwardedAlert); got != (forwardedMessage{}) {
+		t.Errorf("reply parsed as a forward: %+v", got)
+	}
+}
+
+func TestAnalyzeEmailUsesForwardedDate(t *testing.T) {
+	t.Setenv("TZ", "Asia/Kolkata")
+	forwarded, err := parseRFC822Message([]byte(forwardedRaw))
+	if err != nil {
+		t.Fatalf("parseRFC822Message: %v", err)
+	}
+
+	headers := map[string]string{"From": "alerts@hdfcbank.net", "Subject": "Fwd: Alert : Update on your HDFC Bank Credit Card"}
+	analysis := analyzeEmail(headers, "FYI", forwarded, "")
+	if !analysis.Forwarded || len(analysis.Transactions) != 1 {
+		t.Fatalf("forwarded %v with %d transactions (%s), want 1 forwarded", analysis.Forwarded, len(analysis.Transactions), analysis.Reason)
+	}
+	want := time.Date(2025, 11, 11, 12, 38, 0, 0, transactionLocation())
+	if txn := analysis.Transactions[0]; !txn.Timestamp.Equal(want) || txn.TimestampSource != timestampSourceForwardedDate {
+		t.Errorf("timestamp %v (%s), want %v from the forwarded Date", txn.Timestamp, txn.TimestampSource, want)
+	}
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
+)
+
+// fakeGmail emulates the Gmail REST endpoints the server calls (profile,
+// messages.list, messages.get, history.list and watch) and Google's token
+// endpoint, for one mailbox
+type fakeGmail struct {
+	server *httptest.Server
+	email  string
+
+	mu        sync.Mutex
+	messages  map[string]*gmail.Message
+	history   []*gmail.History
+	historyID uint64
+	pageSize  int           // History records per history.list page; 0 answers in one page
+	delay     time.Duration // Added before answering each messages.get
+	watches   []gmail.WatchRequest
+	requests  []string       // "METHOD path" of every Gmail call, in order
+	queries   []url.Values   // Query of each call in requests
+	failures  map[string]int // Error status to answer "METHOD resource" calls with, e.g. "GET history"
+}
+
+// newFakeGmail starts a fake Gmail for email, stopped when the test ends
+func newFakeGmail(t *testing.T, email string) *fakeGmail {
+	f := &fakeGmail{email: email, messages: make(map[string]*gmail.Message), historyID: 1000, failures: make(map[string]int)}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// use points s at the fake: Gmail services call it and OAuth codes are
+// exchanged with it
+func (f *fakeGmail) use(s *Server) {
+	s.oauthConfig.Endpoint.TokenURL = f.server.URL + "/token"
+	s.gmailServiceFactory = func(ctx context.Context, token *oauth2.Token) (*gmail.Service, error) {
+		return gmail.NewService(ctx, option.WithEndpoint(f.server.URL+"/"), option.WithHTTPClient(f.server.Client()))
+	}
+}
+
+// addMessage adds a message with a text/plain body, and an HTML alternative
+// when html is set, recording it in the mailbox history
+func (f *fakeGmail) addMessage(id string, headers map[string]string, text, html string, internalDate time.Time) *gmail.Message {
+	payload := &gmail.MessagePart{MimeType: "text/plain", Body: &gmail.MessagePartBody{Data: base64.URLEncoding.EncodeToString([]byte(text)), Size: int64(len(text))}}
+	if html != "" {
+		payload = &gmail.MessagePart{
+			MimeType: "multipart/alternative",
+			Parts: []*gmail.MessagePart{
+				payload,
+				{MimeType: "text/html", Body: &gmail.MessagePartBody{Data: base64.URLEncoding.EncodeToString([]byte(html)), Size: int64(len(html))}},
+			},
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var raw strings.Builder
+	for _, name := range names {
+		payload.Headers = append(payload.Headers, &gmail.MessagePartHeader{Name: name, Value: headers[name]})
+		fmt.Fprintf(&raw, "%s: %s\r\n", name, headers[name])
+	}
+	raw.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n" + text)
+
+	snippet := text
+	if len(snippet) > 100 {
+		snippet = snippet[:100]
+	}
+	msg := &gmail.Message{
+		Id:           id,
+		ThreadId:     id,
+		LabelIds:     []string{"INBOX"},
+		Snippet:      snippet,
+		InternalDate: internalDate.UnixMilli(),
+		Payload:      payload,
+		Raw:          base64.URLEncoding.EncodeToString([]byte(raw.String())),
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.historyID++
+	msg.HistoryId = f.historyID
+	f.messages[id] = msg
+	f.history = append(f.history, &gmail.History{Id: f.historyID, MessagesAdded: []*gmail.HistoryMessageAdded{{Message: &gmail.Message{Id: id, ThreadId: id, LabelIds: msg.LabelIds}}}})
+	return msg
+}
+
+// fail makes calls to resource ("history", "messages", "messages/m1", ...)
+// answer with an error status until the test ends
+func (f *fakeGmail) fail(method, resource string, code int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[method+" "+resource] = code
+}
+
+// query returns the query of the last Gmail call to path
+func (f *fakeGmail) query(method, path string) url.Values {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.requests) - 1; i >= 0; i-- {
+		if f.requests[i] == method+" "+path {
+			return f.queries[i]
+		}
+	}
+	return nil
+}
+
+// calls returns the Gmail requests received so far
+func (f *fakeGmail) calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.requests...)
+}
+
+func (f *fakeGmail) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/token" {
+		writeFakeJSON(w, map[string]interface{}{
+			"access_token":  "fake-access-token",
+			"refresh_token": "fake-refresh-token",
+			"token_type":    "Bearer",
+			"expires_in":    3600,
+		})
+		return
+	}
+
+	rest, ok := strings.CutPrefix(r.URL.Path, "/gmail/v1/users/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	_, resource, _ := strings.Cut(rest, "/")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	f.queries = append(f.queries, r.URL.Query())
+	if code, ok := f.failures[r.Method+" "+resource]; ok {
+		writeFakeError(w, code, "Injected failure")
+		return
+	}
+
+	switch {
+	case resource == "profile":
+		writeFakeJSON(w, &gmail.Profile{EmailAddress: f.email, HistoryId: f.historyID, MessagesTotal: int64(len(f.messages))})
+	case resource == "messages" && r.Method == http.MethodGet:
+		f.listMessages(w, r)
+	case strings.HasPrefix(resource, "messages/") && r.Method == http.MethodGet:
+		time.Sleep(f.delay)
+		msg, ok := f.messages[strings.TrimPrefix(resource, "messages/")]
+		if !ok {
+			writeFakeError(w, http.StatusNotFound, "Requested entity was not found.")
+			return
+		}
+		writeFakeJSON(w, messageInFormat(msg, r.URL.Query().Get("format")))
+	case resource == "history" && r.Method == http.MethodGet:
+		start, err := strconv.ParseUint(r.URL.Query().Get("startHistoryId"), 10, 64)
+		if err != nil {
+			writeFakeError(w, http.StatusBadRequest, "Invalid startHistoryId")
+			return
+		}
+		var history []*gmail.History
+		for _, h := range f.history {
+			if h.Id > start {
+				history = append(history, h)
+			}
+		}
+		// Page tokens are offsets into the matching records
+		offset, _ := strconv.Atoi(r.URL.Query().Get("pageToken"))
+		history = history[min(offset, len(history)):]
+		var next string
+		if f.pageSize > 0 && len(history) > f.pageSize {
+			history = history[:f.pageSize]
+			next = strconv.Itoa(offset + f.pageSize)
+		}
+		writeFakeJSON(w, &gmail.ListHistoryResponse{History: history, HistoryId: f.historyID, NextPageToken: next})
+	case resource == "watch" && r.Method == http.MethodPost:
+		var req gmail.WatchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeFakeError(w, http.StatusBadRequest, "Invalid watch request")
+			return
+		}
+		f.watches = append(f.watches, req)
+		writeFakeJSON(w, &gmail.WatchResponse{HistoryId: f.historyID, Expiration: time.Now().Add(7 * 24 * time.Hour).UnixMilli()})
+	default:
+		writeFakeError(w, http.StatusNotFound, "Unsupported fake Gmail call "+r.Method+" "+r.URL.Path)
+	}
+}
+
+// listMessages answers messages.list newest first, honouring maxResults; the
+// q search is ignored, every message matches
+func (f *fakeGmail) listMessages(w http.ResponseWriter, r *http.Request) {
+	msgs := make([]*gmail.Message, 0, len(f.messages))
+	for _, msg := range f.messages {
+		msgs = append(msgs, msg)
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].InternalDate > msgs[j].InternalDate })
+	if max, err := strconv.Atoi(r.URL.Query().Get("maxResults")); err == nil && max > 0 && len(msgs) > max {
+		msgs = msgs[:max]
+	}
+	refs := make([]*gmail.Message, len(msgs))
+	for i, msg := range msgs {
+		refs[i] = &gmail.Message{Id: msg.Id, ThreadId: msg.ThreadId}
+	}
+	writeFakeJSON(w, &gmail.ListMessagesResponse{Messages: refs, ResultSizeEstimate: int64(len(f.messages))})
+}
+
+// messageInFormat trims a stored message to what Gmail returns for format
+func messageInFormat(msg *gmail.Message, format string) *gmail.Message {
+	out := *msg
+	switch format {
+	case "minimal":
+		out.Payload, out.Raw = nil, ""
+	case "metadata":
+		out.Payload, out.Raw = &gmail.MessagePart{MimeType: msg.Payload.MimeType, Headers: msg.Payload.Headers}, ""
+	case "raw":
+		out.Payload = nil
+	default:
+		out.Raw = ""
+	}
+	return &out
+}
+
+func writeFakeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeFakeError writes an error in the shape Google APIs use
+func writeFakeError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{"code": code, "message": message}})
+}
+
+func TestEmailSummaryWithFakeGmail(t *testing.T) {
+	s := newTestServer(t)
+	fake := newFakeGmail(t, "user@example.com")
+	fake.use(s)
+	authenticate(s, "user@example.com")
+
+	now := time.Now()
+	fake.addMessage("m1", map[string]string{"Subject": "Older", "From": "a@example.com"}, "first", "", now.Add(-2*time.Hour))
+	fake.addMessage("m2", map[string]string{
+		"Subject": "Your statement",
+		"From":    "Bank <alerts@bank.example>",
+		"To":      "user@example.com",
+		"Date":    "Mon, 10 Nov 2025 09:30:00 +0530",
+	}, "Statement is ready", "<p>Statement is <b>ready</b></p>", now.Add(-time.Hour))
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/emails/summary?userEmail=user@example.com", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	var got struct {
+		UserEmail   string `json:"user_email"`
+		Count       int64  `json:"count_last_30_days"`
+		LatestEmail struct {
+			ID       string `json:"id"`
+			Subject  string `json:"subject"`
+			From     string `json:"from"`
+			BodyText string `json:"body_text"`
+			BodyHTML string `json:"body_html"`
+		} `json:"latest_email"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.UserEmail != "user@example.com" || got.Count != 2 {
+		t.Errorf("user %q count %d, want user@example.com with 2 messages", got.UserEmail, got.Count)
+	}
+	latest := got.LatestEmail
+	if latest.ID != "m2" || latest.Subject != "Your statement" || latest.From != "Bank <alerts@bank.example>" {
+		t.Errorf("latest email = %+v, want m2 from the bank", latest)
+	}
+	if latest.BodyText != "Statement is ready" || !strings.Contains(latest.BodyHTML, "<b>ready</b>") {
+		t.Errorf("body text %q, html %q", latest.BodyText, latest.BodyHTML)
+	}
+
+	want := []string{"GET /gmail/v1/users/me/messages", "GET /gmail/v1/users/me/messages/m2"}
+	if calls := fake.calls(); !equalStrings(calls, want) {
+		t.Errorf("Gmail calls = %v, want %v", calls, want)
+	}
+}
+
+func TestEmailSummaryFormat(t *testing.T) {
+	s := newTestServer(t)
+	fake := newFakeGmail(t, "user@example.com")
+	fake.use(s)
+	authenticate(s, "user@example.com")
+	fake.addMessage("m1", map[string]string{"Subject": "Statement", "From": "alerts@bank.example", "Date": "Mon, 10 Nov 2025 09:30:00 +0530"},
+		"Statement is ready", "", time.Now())
+
+	tests := []struct {
+		format  string
+		want    string   // format sent to Gmail
+		headers []string // metadataHeaders sent to Gmail
+		body    bool
+	}{
+		{"", "full", nil, true},
+		{"full", "full", nil, true},
+		{"metadata", "metadata", metadataHeaders, false},
+		{"minimal", "minimal", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run("format="+tt.format, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/emails/summary?userEmail=user@example.com&format="+tt.format, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+
+			query := fake.query(http.MethodGet, "/gmail/v1/users/me/messages/m1")
+			if got := query.Get("format"); got != tt.want {
+				t.Errorf("Gmail format = %q, want %q", got, tt.want)
+			}
+			if got := query["metadataHeaders"]; !equalStrings(got, tt.headers) {
+				t.Errorf("metadataHeaders = %v, want %v", got, tt.headers)
+			}
+
+			var got struct {
+				LatestEmail map[string]interface{} `json:"latest_email"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if _, ok := got.LatestEmail["body_text"]; ok != tt.body {
+				t.Errorf("latest_email = %v, want a body: %v", got.LatestEmail, tt.body)
+			}
+			if tt.format == "metadata" && got.LatestEmail["subject"] != "Statement" {
+				t.Errorf("subject = %v, want the metadata header", got.LatestEmail["subject"])
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/emails/summary?userEmail=user@example.com&format=raw", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("format=raw status = %d, want 400", rec.Code)
+	}
+}
+
+func TestEmailSummaryFormBody(t *testing.T) {
+	s := newTestServer(t)
+	fake := newFakeGmail(t, "user@example.com")
+	fake.use(s)
+	authenticate(s, "user@example.com")
+	fake.addMessage("m1", map[string]string{"Subject": "Statement"}, "Statement is ready", "", time.Now())
+
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		want   int
+		format string // Format sent to Gmail on success
+	}{
+		{"form body", "/emails/summary", "userEmail=user%40example.com", http.StatusOK, "full"},
+		{"form body with options", "/emails/summary", "userEmail=user%40example.com&format=metadata", http.StatusOK, "metadata"},
+		{"query string on POST", "/emails/summary?userEmail=user@example.com&format=minimal", "", http.StatusOK, "minimal"},
+		{"body overrides query", "/emails/summary?format=minimal", "userEmail=user%40example.com&format=metadata", http.StatusOK, "metadata"},
+		{"unknown user", "/emails/summary", "userEmail=other%40example.com", http.StatusUnauthorized, ""},
+		{"missing user", "/emails/summary", "format=full", http.StatusBadRequest, ""},
+		{"malformed body", "/emails/summary", "userEmail=%zz", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			var got struct {
+				UserEmail string `json:"user_email"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil || got.UserEmail != "user@example.com" {
+				t.Errorf("user_email = %q (%v), want user@example.com", got.UserEmail, err)
+			}
+			if format := fake.query(http.MethodGet, "/gmail/v1/users/me/messages/m1").Get("format"); format != tt.format {
+				t.Errorf("Gmail format = %q, want %q", format, tt.format)
+			}
+		})
+	}
+}
+
+func TestDelegatedMailboxUserID(t *testing.T) {
+	tests := []struct {
+		name      string
+		delegated string
+		want      string // Gmail user ID expected in every call's path
+	}{
+		{"oauth user", "", "me"},
+		{"other mailbox delegated", "shared@example.com", "me"},
+		{"delegated", "shared@example.com, user@example.com", "user@example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DELEGATED_MAILBOXES", tt.delegated)
+			s := newTestServer(t)
+			fake := newFakeGmail(t, "user@example.com")
+			fake.use(s)
+			authenticate(s, "user@example.com")
+			fake.addMessage("m1", map[string]string{"Subject": "Hello", "From": "a@example.com"}, "hello", "", time.Now())
+
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/emails/summary?userEmail=user@example.com", nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			calls := fake.calls()
+			if len(calls) == 0 {
+				t.Fatal("no Gmail calls")
+			}
+			for _, call := range calls {
+				if _, path, _ := strings.Cut(call, " "); !strings.HasPrefix(path, "/gmail/v1/users/"+tt.want+"/") {
+					t.Errorf("call %q, want user ID %q", call, tt.want)
+				}
+			}
+		})
+	}
+}
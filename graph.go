@@ -0,0 +1,584 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/microsoft"
+)
+
+// graphAPIBase is the Microsoft Graph endpoint; tests can point it at a fake server
+var graphAPIBase = "https://graph.microsoft.com/v1.0"
+
+// graphSubscriptionLifetime is how long a Graph mail subscription is requested
+// for; Graph caps mail subscriptions at just under seven days
+const graphSubscriptionLifetime = 3 * 24 * time.Hour
+
+// graphRenewBefore is how long before expiry the janitor renews a Graph subscription
+const graphRenewBefore = 24 * time.Hour
+
+// graphSubscription is the user and secret behind a Graph subscription created by /watch/start
+type graphSubscription struct {
+	ID          string    `json:"id"`
+	UserEmail   string    `json:"user_email"`
+	ClientState string    `json:"client_state"`
+	Expiration  time.Time `json:"expiration"`
+}
+
+// graphSubscriptionsPath returns the file Graph subscriptions are saved to, from GRAPH_SUBSCRIPTIONS_PATH
+func graphSubscriptionsPath() string {
+	if value := strings.TrimSpace(os.Getenv("GRAPH_SUBSCRIPTIONS_PATH")); value != "" {
+		return value
+	}
+	return "graph_subscriptions.json"
+}
+
+// Outlook is available when OUTLOOK_CLIENT_ID is set
+func init() {
+	registerProvider(providerOutlook, func(s *Server) MailProvider {
+		config := outlookConfig(s.oauthConfig.RedirectURL)
+		if config == nil {
+			return nil
+		}
+		return &graphProvider{s: s, config: config}
+	})
+}
+
+// outlookConfig builds the Azure AD OAuth configuration from OUTLOOK_CLIENT_ID,
+// OUTLOOK_CLIENT_SECRET, OUTLOOK_TENANT (default "common") and OUTLOOK_REDIRECT_URL
+// (default the Google redirect URL, since both share /oauth2/callback).
+// Returns nil when OUTLOOK_CLIENT_ID is unset.
+func outlookConfig(defaultRedirect string) *oauth2.Config {
+	clientID := strings.TrimSpace(os.Getenv("OUTLOOK_CLIENT_ID"))
+	if clientID == "" {
+		return nil
+	}
+	tenant := strings.TrimSpace(os.Getenv("OUTLOOK_TENANT"))
+	if tenant == "" {
+		tenant = "common"
+	}
+	redirect := strings.TrimSpace(os.Getenv("OUTLOOK_REDIRECT_URL"))
+	if redirect == "" {
+		redirect = defaultRedirect
+	}
+	return &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: os.Getenv("OUTLOOK_CLIENT_SECRET"),
+		Endpoint:     microsoft.AzureADEndpoint(tenant),
+		RedirectURL:  redirect,
+		// offline_access is what makes Azure AD issue a refresh token
+		Scopes: []string{"offline_access", "User.Read", "Mail.Read"},
+	}
+}
+
+// graphProvider reads Outlook and Microsoft 365 mail through Microsoft Graph
+type graphProvider struct {
+	s      *Server
+	config *oauth2.Config
+}
+
+// graphRecipient is Graph's JSON shape for a sender or recipient
+type graphRecipient struct {
+	EmailAddress struct {
+		Name    string `json:"name"`
+		Address string `json:"address"`
+	} `json:"emailAddress"`
+}
+
+// String renders the recipient as a header value, e.g. "HDFC Bank <alerts@hdfcbank.net>"
+func (r graphRecipient) String() string {
+	if r.EmailAddress.Name == "" || r.EmailAddress.Name == r.EmailAddress.Address {
+		return r.EmailAddress.Address
+	}
+	return fmt.Sprintf("%s <%s>", r.EmailAddress.Name, r.EmailAddress.Address)
+}
+
+// graphMessage is the subset of Graph's message resource the server reads
+type graphMessage struct {
+	ID               string           `json:"id"`
+	Subject          string           `json:"subject"`
+	BodyPreview      string           `json:"bodyPreview"`
+	ReceivedDateTime time.Time        `json:"receivedDateTime"`
+	From             *graphRecipient  `json:"from"`
+	ToRecipients     []graphRecipient `json:"toRecipients"`
+	CcRecipients     []graphRecipient `json:"ccRecipients"`
+	HasAttachments   bool             `json:"hasAttachments"`
+	Body             struct {
+		ContentType string `json:"contentType"` // "text" or "html"
+		Content     string `json:"content"`
+	} `json:"body"`
+	InternetMessageHeaders []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"internetMessageHeaders"`
+}
+
+// Name returns "outlook"
+func (p *graphProvider) Name() string { return providerOutlook }
+
+// AuthCodeURL returns the Azure AD consent URL
+func (p *graphProvider) AuthCodeURL(state string, opts ...oauth2.AuthCodeOption) string {
+	return p.config.AuthCodeURL(state, opts...)
+}
+
+// Exchange trades an authorization code for an Azure AD token
+func (p *graphProvider) Exchange(ctx context.Context, code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	return p.config.Exchange(ctx, code, opts...)
+}
+
+// call sends a Graph request and decodes the JSON response into out, if non-nil
+func (p *graphProvider) call(ctx context.Context, token *oauth2.Token, method, path string, query url.Values, header http.Header, body interface{}, out interface{}) error {
+	target := graphAPIBase + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("unable to encode Graph request: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return fmt.Errorf("unable to build Graph request: %v", err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	// The client refreshes the access token as needed, like the Gmail service
+	resp, err := p.config.Client(context.Background(), token).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("graph %s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(detail)))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("unable to decode Graph response: %v", err)
+	}
+	return nil
+}
+
+// UserEmail returns the mailbox address of the account token belongs to,
+// falling back to the sign-in name for accounts without a mailbox address
+func (p *graphProvider) UserEmail(ctx context.Context, token *oauth2.Token) (string, error) {
+	var me struct {
+		Mail              string `json:"mail"`
+		UserPrincipalName string `json:"userPrincipalName"`
+	}
+	if err := p.call(ctx, token, http.MethodGet, "/me", url.Values{"$select": {"mail,userPrincipalName"}}, nil, nil, &me); err != nil {
+		return "", fmt.Errorf("unable to get user profile: %v", err)
+	}
+	if me.Mail != "" {
+		return me.Mail, nil
+	}
+	return me.UserPrincipalName, nil
+}
+
+// ListMessages returns the user's messages, newest first
+// Unfiltered listings use $filter and report Graph's total count; $filter can't
+// match a sender domain or To-or-Cc, so filtered ones use a KQL $search instead,
+// which has no count.
+func (p *graphProvider) ListMessages(ctx context.Context, userEmail string, token *oauth2.Token, query mailQuery) ([]string, int64, error) {
+	since := time.Now().AddDate(0, 0, -query.Days)
+	params := url.Values{
+		"$top":    {strconv.Itoa(query.Limit)},
+		"$select": {"id"},
+	}
+	header := http.Header{}
+	if query.From == "" && query.Recipient == "" {
+		params.Set("$filter", "receivedDateTime ge "+since.UTC().Format(time.RFC3339))
+		params.Set("$orderby", "receivedDateTime desc")
+		params.Set("$count", "true")
+		header.Set("ConsistencyLevel", "eventual")
+	} else {
+		terms := []string{"received>=" + since.Format("2006-01-02")}
+		if query.From != "" {
+			terms = append(terms, "from:"+query.From)
+		}
+		if query.Recipient != "" {
+			recipient := strings.NewReplacer(`"`, "", `\`, "").Replace(query.Recipient)
+			terms = append(terms, "(to:"+recipient+" OR cc:"+recipient+")")
+		}
+		params.Set("$search", `"`+strings.Join(terms, " AND ")+`"`)
+	}
+
+	var res struct {
+		Count *int64 `json:"@odata.count"`
+		Value []struct {
+			ID string `json:"id"`
+		} `json:"value"`
+	}
+	if err := p.call(ctx, token, http.MethodGet, "/me/messages", params, header, nil, &res); err != nil {
+		return nil, 0, err
+	}
+	ids := make([]string, 0, len(res.Value))
+	for _, m := range res.Value {
+		ids = append(ids, m.ID)
+	}
+	estimate := int64(len(ids))
+	if res.Count != nil && *res.Count > estimate {
+		estimate = *res.Count
+	}
+	return ids, estimate, nil
+}
+
+// FetchMessage gets a message, selecting the fields that match the Gmail format
+// Forwarded-as-attachment messages are not read, so Forwarded stays empty.
+func (p *graphProvider) FetchMessage(ctx context.Context, userEmail string, token *oauth2.Token, msgID, format string) (*mailMessage, error) {
+	fields := "id,bodyPreview,receivedDateTime"
+	if format != "minimal" {
+		fields += ",subject,from,toRecipients,ccRecipients,internetMessageHeaders"
+	}
+	if format == "full" {
+		fields += ",body,hasAttachments"
+	}
+	var msg graphMessage
+	path := "/me/messages/" + url.PathEscape(msgID)
+	if err := p.call(ctx, token, http.MethodGet, path, url.Values{"$select": {fields}}, nil, nil, &msg); err != nil {
+		return nil, err
+	}
+
+	m := &mailMessage{
+		ID:           msg.ID,
+		Headers:      graphHeaders(&msg, format),
+		Snippet:      msg.BodyPreview,
+		InternalDate: msg.ReceivedDateTime.UnixMilli(),
+	}
+	if format != "full" {
+		return m, nil
+	}
+
+	content, truncated := truncateUTF8(msg.Body.Content, maxBodyBytes())
+	m.Body = EmailBody{Attachments: []AttachmentMeta{}, Truncated: truncated}
+	if strings.EqualFold(msg.Body.ContentType, "html") {
+		m.Body.HTML = content
+	} else {
+		m.Body.PlainText = content
+	}
+	if msg.HasAttachments {
+		var res struct {
+			Value []struct {
+				ID          string `json:"id"`
+				Name        string `json:"name"`
+				ContentType string `json:"contentType"`
+				Size        int64  `json:"size"`
+			} `json:"value"`
+		}
+		params := url.Values{"$select": {"id,name,contentType,size"}}
+		if err := p.call(ctx, token, http.MethodGet, path+"/attachments", params, nil, nil, &res); err != nil {
+			return nil, fmt.Errorf("unable to list attachments: %v", err)
+		}
+		for _, a := range res.Value {
+			m.Body.Attachments = append(m.Body.Attachments, AttachmentMeta{Filename: a.Name, MimeType: a.ContentType, Size: a.Size, AttachmentID: a.ID})
+		}
+	}
+	return m, nil
+}
+
+// graphHeaders returns a message's original internet headers, filling in
+// Subject, From, To, Cc and Date from Graph's own fields where missing
+// (Graph only has internet headers for mail received over SMTP)
+func graphHeaders(msg *graphMessage, format string) map[string]string {
+	headers := make(map[string]string)
+	if format == "minimal" {
+		return headers
+	}
+	for _, h := range msg.InternetMessageHeaders {
+		headers[h.Name] = h.Value
+	}
+	join := func(recipients []graphRecipient) string {
+		values := make([]string, 0, len(recipients))
+		for _, r := range recipients {
+			values = append(values, r.String())
+		}
+		return strings.Join(values, ", ")
+	}
+	fallback := map[string]string{
+		"Subject": msg.Subject,
+		"To":      join(msg.ToRecipients),
+		"Cc":      join(msg.CcRecipients),
+	}
+	if msg.From != nil {
+		fallback["From"] = msg.From.String()
+	}
+	if !msg.ReceivedDateTime.IsZero() {
+		fallback["Date"] = msg.ReceivedDateTime.In(transactionLocation()).Format(time.RFC1123Z)
+	}
+	for name, value := range fallback {
+		if _, ok := headers[name]; !ok && value != "" {
+			headers[name] = value
+		}
+	}
+	return headers
+}
+
+// Watch subscribes GRAPH_NOTIFICATION_URL (the public URL of /graph/push) to
+// new messages in the user's inbox, replacing the user's previous subscription
+func (p *graphProvider) Watch(ctx context.Context, userEmail string, token *oauth2.Token) (time.Time, error) {
+	notificationURL := strings.TrimSpace(os.Getenv("GRAPH_NOTIFICATION_URL"))
+	if notificationURL == "" {
+		return time.Time{}, &watchConfigError{what: "Graph notification URL",
+			err: errors.New("GRAPH_NOTIFICATION_URL is not set; set it to the public https URL of /graph/push")}
+	}
+
+	// Each subscription gets its own secret, which Graph echoes in every notification
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return time.Time{}, fmt.Errorf("unable to generate client state: %v", err)
+	}
+	clientState := hex.EncodeToString(b[:])
+
+	request := map[string]string{
+		"changeType":         "created",
+		"notificationUrl":    notificationURL,
+		"resource":           "me/mailFolders('Inbox')/messages",
+		"expirationDateTime": time.Now().Add(graphSubscriptionLifetime).UTC().Format(time.RFC3339),
+		"clientState":        clientState,
+	}
+	var res struct {
+		ID                 string    `json:"id"`
+		ExpirationDateTime time.Time `json:"expirationDateTime"`
+	}
+	if err := p.call(ctx, token, http.MethodPost, "/subscriptions", nil, nil, request, &res); err != nil {
+		return time.Time{}, err
+	}
+
+	// Notifications for the user's older subscription are dropped from now on
+	p.s.graphSubscriptions.Lock()
+	p.s.loadGraphSubscriptionsLocked()
+	for id, sub := range p.s.graphSubscriptions.subscriptions {
+		if sub.UserEmail == userEmail {
+			delete(p.s.graphSubscriptions.subscriptions, id)
+		}
+	}
+	p.s.graphSubscriptions.subscriptions[res.ID] = &graphSubscription{ID: res.ID, UserEmail: userEmail, ClientState: clientState, Expiration: res.ExpirationDateTime}
+	p.s.saveGraphSubscriptionsLocked()
+	p.s.graphSubscriptions.Unlock()
+
+	p.s.watchStore.Lock()
+	p.s.watchStore.expirations[accountKey{providerOutlook, userEmail}] = res.ExpirationDateTime
+	p.s.watchStore.Unlock()
+	return res.ExpirationDateTime, nil
+}
+
+// renew extends a subscription by graphSubscriptionLifetime from now, returning its new expiry
+func (p *graphProvider) renew(ctx context.Context, token *oauth2.Token, id string) (time.Time, error) {
+	request := map[string]string{
+		"expirationDateTime": time.Now().Add(graphSubscriptionLifetime).UTC().Format(time.RFC3339),
+	}
+	var res struct {
+		ExpirationDateTime time.Time `json:"expirationDateTime"`
+	}
+	if err := p.call(ctx, token, http.MethodPatch, "/subscriptions/"+url.PathEscape(id), nil, nil, request, &res); err != nil {
+		return time.Time{}, err
+	}
+	return res.ExpirationDateTime, nil
+}
+
+// renewGraphSubscriptions renews the subscriptions expiring within
+// graphRenewBefore of now, and forgets those that have already expired
+// Subscriptions whose user has no Outlook token are left to lapse.
+// Returns how many were renewed.
+func (s *Server) renewGraphSubscriptions(now time.Time) int {
+	s.graphSubscriptions.Lock()
+	s.loadGraphSubscriptionsLocked()
+	var due []graphSubscription
+	expired := 0
+	for id, sub := range s.graphSubscriptions.subscriptions {
+		switch {
+		case !sub.Expiration.After(now):
+			delete(s.graphSubscriptions.subscriptions, id)
+			expired++
+		case sub.Expiration.Sub(now) <= graphRenewBefore:
+			due = append(due, *sub)
+		}
+	}
+	if expired > 0 {
+		s.saveGraphSubscriptionsLocked()
+	}
+	s.graphSubscriptions.Unlock()
+
+	provider, configured := s.provider(providerOutlook)
+	if !configured || len(due) == 0 {
+		return 0
+	}
+	p := provider.(*graphProvider)
+
+	renewed := 0
+	for _, sub := range due {
+		token, ok := s.userToken(providerOutlook, sub.UserEmail)
+		if !ok {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), gmailTimeout())
+		expiration, err := p.renew(ctx, token, sub.ID)
+		cancel()
+		if err != nil {
+			log.Printf("Unable to renew Graph subscription %s for %s: %v", sub.ID, sub.UserEmail, err)
+			addCounter("graph_subscription_renewals_total", 1, "result", "error")
+			continue
+		}
+		addCounter("graph_subscription_renewals_total", 1, "result", "ok")
+		renewed++
+
+		// The user may have started a new watch while the request was in flight
+		s.graphSubscriptions.Lock()
+		if current, ok := s.graphSubscriptions.subscriptions[sub.ID]; ok {
+			current.Expiration = expiration
+			s.saveGraphSubscriptionsLocked()
+		}
+		s.graphSubscriptions.Unlock()
+
+		s.watchStore.Lock()
+		s.watchStore.expirations[accountKey{providerOutlook, sub.UserEmail}] = expiration
+		s.watchStore.Unlock()
+	}
+	return renewed
+}
+
+// loadGraphSubscriptionsLocked reads the saved subscriptions from disk once;
+// s.graphSubscriptions must be locked
+func (s *Server) loadGraphSubscriptionsLocked() {
+	if s.graphSubscriptions.loaded {
+		return
+	}
+	s.graphSubscriptions.loaded = true
+
+	data, err := os.ReadFile(graphSubscriptionsPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Unable to read Graph subscriptions: %v", err)
+		}
+		return
+	}
+	var subscriptions []*graphSubscription
+	if err := json.Unmarshal(data, &subscriptions); err != nil {
+		log.Printf("Unable to parse Graph subscriptions: %v", err)
+		return
+	}
+	for _, sub := range subscriptions {
+		s.graphSubscriptions.subscriptions[sub.ID] = sub
+	}
+}
+
+// saveGraphSubscriptionsLocked writes the subscriptions to disk, by user;
+// s.graphSubscriptions must be locked
+func (s *Server) saveGraphSubscriptionsLocked() {
+	subscriptions := make([]*graphSubscription, 0, len(s.graphSubscriptions.subscriptions))
+	for _, sub := range s.graphSubscriptions.subscriptions {
+		subscriptions = append(subscriptions, sub)
+	}
+	sort.Slice(subscriptions, func(i, j int) bool { return subscriptions[i].UserEmail < subscriptions[j].UserEmail })
+	data, err := json.MarshalIndent(subscriptions, "", "  ")
+	if err != nil {
+		log.Printf("Unable to encode Graph subscriptions: %v", err)
+		return
+	}
+	if err := os.WriteFile(graphSubscriptionsPath(), data, 0600); err != nil {
+		log.Printf("Unable to write Graph subscriptions: %v", err)
+	}
+}
+
+// graphNotification is one change notification in a /graph/push request
+type graphNotification struct {
+	SubscriptionID string `json:"subscriptionId"`
+	ClientState    string `json:"clientState"`
+	ChangeType     string `json:"changeType"`
+	ResourceData   struct {
+		ID string `json:"id"`
+	} `json:"resourceData"`
+}
+
+// graphPushHandler receives Microsoft Graph change notifications for the
+// subscriptions created by /watch/start
+func (s *Server) graphPushHandler(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r.Context())
+
+	// Graph validates a new subscription's URL by expecting its token echoed back as plain text
+	if token := r.URL.Query().Get("validationToken"); token != "" {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, token)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var payload struct {
+		Value []graphNotification `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		logger.Printf("Unable to parse Graph notification: %v", err)
+		http.Error(w, "Failed to parse request", http.StatusBadRequest)
+		return
+	}
+
+	for _, n := range payload.Value {
+		s.graphSubscriptions.Lock()
+		s.loadGraphSubscriptionsLocked()
+		sub, ok := s.graphSubscriptions.subscriptions[n.SubscriptionID]
+		s.graphSubscriptions.Unlock()
+		if !ok || subtle.ConstantTimeCompare([]byte(n.ClientState), []byte(sub.ClientState)) != 1 {
+			logger.Printf("DROPPED Graph notification for unknown subscription %s", n.SubscriptionID)
+			continue
+		}
+		if n.ResourceData.ID == "" {
+			continue
+		}
+		logger.Printf("Received Graph notification for user: %s, message: %s", sub.UserEmail, n.ResourceData.ID)
+
+		// Graph retries notifications not answered within three seconds, so
+		// messages are fetched after responding
+		go s.processGraphMessage(logger, sub.UserEmail, n.ResourceData.ID)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// processGraphMessage fetches a message announced by a Graph notification and
+// runs it through the same classification and detectors as Gmail pushes
+func (s *Server) processGraphMessage(logger *log.Logger, emailAddress, msgID string) string {
+	token, exists := s.userToken(providerOutlook, emailAddress)
+	p, configured := s.provider(providerOutlook)
+	if !exists || !configured {
+		logger.Printf("DROPPED Graph notification for unknown user %s", emailAddress)
+		return outcomeSkipped
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), gmailTimeout())
+	defer cancel()
+	msg, err := p.FetchMessage(ctx, emailAddress, token, msgID, "full")
+	if err != nil {
+		logger.Printf("Unable to get message %s: %v", msgID, err)
+		return outcomeFailed
+	}
+	return s.processFetchedMessage(ctx, logger, emailAddress, msg)
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// fakeGraph emulates the Microsoft Graph endpoints the server calls (message
+// get, attachment list, and subscription create and renew) for one mailbox
+type fakeGraph struct {
+	server *httptest.Server
+
+	mu            sync.Mutex
+	messages      map[string]map[string]interface{} // Graph message resources by ID
+	attachments   map[string][]map[string]interface{}
+	subscriptions map[string]time.Time // Expiry of each subscription by ID
+	created       []map[string]string  // Bodies of POST /subscriptions, in order
+	requests      []string             // "METHOD path" of every Graph call, in order
+	queries       []url.Values         // Query of each call in requests
+}
+
+// newFakeGraph starts a fake Graph, stopped when the test ends
+func newFakeGraph(t *testing.T) *fakeGraph {
+	f := &fakeGraph{
+		messages:      make(map[string]map[string]interface{}),
+		attachments:   make(map[string][]map[string]interface{}),
+		subscriptions: make(map[string]time.Time),
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// newGraphTestServer creates a test Server with Outlook configured and its
+// Graph calls sent to f
+func newGraphTestServer(t *testing.T, f *fakeGraph) *Server {
+	t.Helper()
+	t.Setenv("OUTLOOK_CLIENT_ID", "outlook-client")
+	previous := graphAPIBase
+	graphAPIBase = f.server.URL
+	t.Cleanup(func() { graphAPIBase = previous })
+	return newTestServer(t)
+}
+
+// graphProviderFor returns s's Outlook provider
+func graphProviderFor(t *testing.T, s *Server) *graphProvider {
+	t.Helper()
+	p, ok := s.provider(providerOutlook)
+	if !ok {
+		t.Fatal("Outlook provider not configured")
+	}
+	return p.(*graphProvider)
+}
+
+// calls returns the Graph requests received so far
+func (f *fakeGraph) calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.requests...)
+}
+
+// query returns the query of the last Graph call to path
+func (f *fakeGraph) query(method, path string) url.Values {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.requests) - 1; i >= 0; i-- {
+		if f.requests[i] == method+" "+path {
+			return f.queries[i]
+		}
+	}
+	return nil
+}
+
+func (f *fakeGraph) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	f.queries = append(f.queries, r.URL.Query())
+
+	switch rest, _ := strings.CutPrefix(r.URL.Path, "/"); {
+	case strings.HasPrefix(rest, "me/messages/") && strings.HasSuffix(rest, "/attachments") && r.Method == http.MethodGet:
+		id := strings.TrimSuffix(strings.TrimPrefix(rest, "me/messages/"), "/attachments")
+		writeFakeJSON(w, map[string]interface{}{"value": f.attachments[id]})
+	case strings.HasPrefix(rest, "me/messages/") && r.Method == http.MethodGet:
+		msg, ok := f.messages[strings.TrimPrefix(rest, "me/messages/")]
+		if !ok {
+			writeFakeGraphError(w, http.StatusNotFound, "ErrorItemNotFound")
+			return
+		}
+		// Only the $select fields are returned, as Graph does
+		selected := make(map[string]interface{})
+		for _, field := range strings.Split(r.URL.Query().Get("$select"), ",") {
+			if value, ok := msg[field]; ok {
+				selected[field] = value
+			}
+		}
+		writeFakeJSON(w, selected)
+	case rest == "subscriptions" && r.Method == http.MethodPost:
+		var req map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeFakeGraphError(w, http.StatusBadRequest, "InvalidRequest")
+			return
+		}
+		expiration, err := time.Parse(time.RFC3339, req["expirationDateTime"])
+		if err != nil {
+			writeFakeGraphError(w, http.StatusBadRequest, "InvalidRequest")
+			return
+		}
+		f.created = append(f.created, req)
+		id := fmt.Sprintf("sub-%d", len(f.created))
+		f.subscriptions[id] = expiration
+		writeFakeJSON(w, map[string]interface{}{"id": id, "expirationDateTime": expiration})
+	case strings.HasPrefix(rest, "subscriptions/") && r.Method == http.MethodPatch:
+		id := strings.TrimPrefix(rest, "subscriptions/")
+		if _, ok := f.subscriptions[id]; !ok {
+			writeFakeGraphError(w, http.StatusNotFound, "ResourceNotFound")
+			return
+		}
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+		expiration, err := time.Parse(time.RFC3339, req["expirationDateTime"])
+		if err != nil {
+			writeFakeGraphError(w, http.StatusBadRequest, "InvalidRequest")
+			return
+		}
+		f.subscriptions[id] = expiration
+		writeFakeJSON(w, map[string]interface{}{"id": id, "expirationDateTime": expiration})
+	default:
+		writeFakeGraphError(w, http.StatusNotFound, "Unsupported fake Graph call "+r.Method+" "+r.URL.Path)
+	}
+}
+
+// writeFakeGraphError writes an error in the shape Graph uses
+func writeFakeGraphError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{"code": message, "message": message}})
+}
+
+// graphToken is the token tests sign users in to Outlook with; without an
+// expiry it is never refreshed
+var graphToken = &oauth2.Token{AccessToken: "graph-access", RefreshToken: "graph-refresh"}
+
+func TestGraphFetchMessage(t *testing.T) {
+	t.Setenv("TZ", "Asia/Kolkata")
+	fake := newFakeGraph(t)
+	s := newGraphTestServer(t, fake)
+	p := graphProviderFor(t, s)
+
+	received := time.Date(2024, 3, 5, 10, 30, 0, 0, time.UTC)
+	fake.messages["AAMk=1"] = map[string]interface{}{
+		"id":               "AAMk=1",
+		"subject":          "Transaction alert",
+		"bodyPreview":      "Rs 1,250.00 spent on your card",
+		"receivedDateTime": received,
+		"from":             map[string]interface{}{"emailAddress": map[string]string{"name": "HDFC Bank", "address": "alerts@hdfcbank.net"}},
+		"toRecipients": []interface{}{
+			map[string]interface{}{"emailAddress": map[string]string{"name": "user@example.com", "address": "user@example.com"}},
+		},
+		"ccRecipients": []interface{}{
+			map[string]interface{}{"emailAddress": map[string]string{"name": "Joint Holder", "address": "joint@example.com"}},
+			map[string]interface{}{"emailAddress": map[string]string{"address": "audit@example.com"}},
+		},
+		"internetMessageHeaders": []interface{}{
+			map[string]string{"name": "Message-ID", "value": "<txn-1@hdfcbank.net>"},
+			map[string]string{"name": "Subject", "value": "=?UTF-8?Q?Transaction_alert?="},
+		},
+		"hasAttachments": true,
+		"body":           map[string]string{"contentType": "html", "content": "<p>Rs 1,250.00 spent</p>"},
+	}
+	fake.attachments["AAMk=1"] = []map[string]interface{}{
+		{"id": "att-1", "name": "statement.pdf", "contentType": "application/pdf", "size": 2048},
+	}
+
+	msg, err := p.FetchMessage(context.Background(), "user@example.com", graphToken, "AAMk=1", "full")
+	if err != nil {
+		t.Fatalf("FetchMessage: %v", err)
+	}
+	wantHeaders := map[string]string{
+		"Message-ID": "<txn-1@hdfcbank.net>",
+		"Subject":    "=?UTF-8?Q?Transaction_alert?=", // The internet header wins over Graph's subject
+		"From":       "HDFC Bank <alerts@hdfcbank.net>",
+		"To":         "user@example.com",
+		"Cc":         "Joint Holder <joint@example.com>, audit@example.com",
+		"Date":       "Tue, 05 Mar 2024 16:00:00 +0530",
+	}
+	if len(msg.Headers) != len(wantHeaders) {
+		t.Errorf("headers = %v, want %v", msg.Headers, wantHeaders)
+	}
+	for name, want := range wantHeaders {
+		if got := msg.Headers[name]; got != want {
+			t.Errorf("header %s = %q, want %q", name, got, want)
+		}
+	}
+	if msg.ID != "AAMk=1" || msg.Snippet != "Rs 1,250.00 spent on your card" || msg.InternalDate != received.UnixMilli() {
+		t.Errorf("message = %+v", msg)
+	}
+	if msg.Body.HTML != "<p>Rs 1,250.00 spent</p>" || msg.Body.PlainText != "" || msg.Body.Truncated {
+		t.Errorf("body = %+v", msg.Body)
+	}
+	if len(msg.Body.Attachments) != 1 || msg.Body.Attachments[0] != (AttachmentMeta{Filename: "statement.pdf", MimeType: "application/pdf", Size: 2048, AttachmentID: "att-1"}) {
+		t.Errorf("attachments = %+v", msg.Body.Attachments)
+	}
+	if got := fake.query(http.MethodGet, "/me/messages/AAMk=1").Get("$select"); got != "id,bodyPreview,receivedDateTime,subject,from,toRecipients,ccRecipients,internetMessageHeaders,body,hasAttachments" {
+		t.Errorf("$select = %q", got)
+	}
+
+	// Minimal messages select no headers or body, and list no attachments
+	before := len(fake.calls())
+	msg, err = p.FetchMessage(context.Background(), "user@example.com", graphToken, "AAMk=1", "minimal")
+	if err != nil {
+		t.Fatalf("FetchMessage minimal: %v", err)
+	}
+	if len(msg.Headers) != 0 || msg.Body.HTML != "" || msg.Body.Attachments != nil || msg.Snippet == "" {
+		t.Errorf("minimal message = %+v", msg)
+	}
+	if calls := fake.calls()[before:]; len(calls) != 1 {
+		t.Errorf("minimal fetch made calls %v, want one", calls)
+	}
+
+	// Plain-text bodies land in PlainText
+	fake.messages["AAMk=2"] = map[string]interface{}{
+		"id":   "AAMk=2",
+		"body": map[string]string{"contentType": "text", "content": "Rs 99.00 spent"},
+	}
+	msg, err = p.FetchMessage(context.Background(), "user@example.com", graphToken, "AAMk=2", "full")
+	if err != nil {
+		t.Fatalf("FetchMessage text: %v", err)
+	}
+	if msg.Body.PlainText != "Rs 99.00 spent" || msg.Body.HTML != "" {
+		t.Errorf("text body = %+v", msg.Body)
+	}
+
+	if _, err := p.FetchMessage(context.Background(), "user@example.com", graphToken, "missing", "full"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("missing message error = %v, want a 404", err)
+	}
+}
+
+func TestGraphPushValidationToken(t *testing.T) {
+	s := newGraphTestServer(t, newFakeGraph(t))
+
+	// Graph sends the validation request as a POST; the token must come back verbatim
+	for _, method := range []string{http.MethodPost, http.MethodGet} {
+		rec := httptest.NewRecorder()
+		token := "Validation: Testing client application reachability for subscription Request-Id: 1a2b"
+		s.Handler().ServeHTTP(rec, httptest.NewRequest(method, "/graph/push?validationToken="+url.QueryEscape(token), nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != token {
+			t.Errorf("%s: got %d %q, want 200 with the token", method, rec.Code, rec.Body)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "text/plain" {
+			t.Errorf("%s: Content-Type = %q, want text/plain", method, ct)
+		}
+	}
+}
+
+func TestGraphPushClientState(t *testing.T) {
+	fake := newFakeGraph(t)
+	s := newGraphTestServer(t, fake)
+	s.storeToken(providerOutlook, "user@example.com", graphToken)
+	s.graphSubscriptions.subscriptions["sub-1"] = &graphSubscription{ID: "sub-1", UserEmail: "user@example.com", ClientState: "secret", Expiration: time.Now().Add(time.Hour)}
+	logs := captureLog(t)
+
+	// None of these carry the subscription's secret
+	body := `{"value": [
+		{"subscriptionId": "sub-1", "clientState": "guess", "changeType": "created", "resourceData": {"id": "forged"}},
+		{"subscriptionId": "sub-1", "changeType": "created", "resourceData": {"id": "stateless"}},
+		{"subscriptionId": "sub-9", "clientState": "secret", "changeType": "created", "resourceData": {"id": "unknown"}}
+	]}`
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/graph/push", strings.NewReader(body)))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if got := strings.Count(logs.String(), "DROPPED Graph notification for unknown subscription"); got != 3 {
+		t.Errorf("logged %d dropped notifications, want 3:\n%s", got, logs)
+	}
+
+	body = `{"value": [{"subscriptionId": "sub-1", "clientState": "secret", "changeType": "created", "resourceData": {"id": "genuine"}}]}`
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/graph/push", strings.NewReader(body)))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	waitForGraphCall(t, fake, "GET /me/messages/genuine")
+	if calls := fake.calls(); len(calls) != 1 {
+		t.Errorf("Graph calls = %v, want only the genuine message fetched", calls)
+	}
+}
+
+// waitForGraphCall waits for the fake to receive call, made by a notification
+// processed after its response
+func waitForGraphCall(t *testing.T, f *fakeGraph, call string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		for _, c := range f.calls() {
+			if c == call {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Graph call %s was not made", call)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestGraphWatchPersistsSubscription(t *testing.T) {
+	t.Setenv("GRAPH_NOTIFICATION_URL", "https://example.com/graph/push")
+	fake := newFakeGraph(t)
+	s := newGraphTestServer(t, fake)
+	p := graphProviderFor(t, s)
+
+	first, err := p.Watch(context.Background(), "user@example.com", graphToken)
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+	if time.Until(first) < graphSubscriptionLifetime-time.Minute {
+		t.Errorf("expiration = %v, want about %v from now", first, graphSubscriptionLifetime)
+	}
+	// Watching again replaces the user's subscription
+	if _, err := p.Watch(context.Background(), "user@example.com", graphToken); err != nil {
+		t.Fatalf("second Watch: %v", err)
+	}
+
+	data, err := os.ReadFile(graphSubscriptionsPath())
+	if err != nil {
+		t.Fatalf("read subscriptions: %v", err)
+	}
+	var saved []graphSubscription
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("parse subscriptions: %v", err)
+	}
+	if len(saved) != 1 || saved[0].ID != "sub-2" || saved[0].UserEmail != "user@example.com" || saved[0].ClientState != fake.created[1]["clientState"] {
+		t.Fatalf("saved = %+v", saved)
+	}
+
+	// A restarted server accepts notifications for the saved subscription only
+	restarted := NewServer(s.oauthConfig)
+	restarted.storeToken(providerOutlook, "user@example.com", graphToken)
+	for _, tt := range []struct {
+		id, state string
+		want      bool
+	}{
+		{"sub-1", fake.created[0]["clientState"], false},
+		{"sub-2", fake.created[1]["clientState"], true},
+	} {
+		body := fmt.Sprintf(`{"value": [{"subscriptionId": %q, "clientState": %q, "resourceData": {"id": "m-%s"}}]}`, tt.id, tt.state, tt.id)
+		restarted.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/graph/push", strings.NewReader(body)))
+		restarted.graphSubscriptions.Lock()
+		sub, ok := restarted.graphSubscriptions.subscriptions[tt.id]
+		restarted.graphSubscriptions.Unlock()
+		if ok != tt.want || (ok && sub.ClientState != tt.state) {
+			t.Errorf("%s after restart = %+v, %v; want loaded %v", tt.id, sub, ok, tt.want)
+		}
+		if tt.want {
+			waitForGraphCall(t, fake, "GET /me/messages/m-"+tt.id)
+		}
+	}
+}
+
+func TestRenewGraphSubscriptions(t *testing.T) {
+	fake := newFakeGraph(t)
+	s := newGraphTestServer(t, fake)
+	s.storeToken(providerOutlook, "due@example.com", graphToken)
+	s.storeToken(providerOutlook, "later@example.com", graphToken)
+
+	now := time.Now()
+	subscriptions := []*graphSubscription{
+		{ID: "due", UserEmail: "due@example.com", ClientState: "a", Expiration: now.Add(2 * time.Hour)},
+		{ID: "later", UserEmail: "later@example.com", ClientState: "b", Expiration: now.Add(2 * 24 * time.Hour)},
+		{ID: "expired", UserEmail: "gone@example.com", ClientState: "c", Expiration: now.Add(-time.Minute)},
+		{ID: "signed-out", UserEmail: "signedout@example.com", ClientState: "d", Expiration: now.Add(time.Hour)},
+	}
+	s.graphSubscriptions.Lock()
+	for _, sub := range subscriptions {
+		s.graphSubscriptions.subscriptions[sub.ID] = sub
+		fake.subscriptions[sub.ID] = sub.Expiration
+	}
+	s.graphSubscriptions.loaded = true
+	s.graphSubscriptions.Unlock()
+
+	if renewed := s.renewGraphSubscriptions(now); renewed != 1 {
+		t.Errorf("renewed = %d, want 1", renewed)
+	}
+	if calls := fake.calls(); len(calls) != 1 || calls[0] != "PATCH /subscriptions/due" {
+		t.Errorf("Graph calls = %v, want only the due subscription renewed", calls)
+	}
+
+	s.graphSubscriptions.Lock()
+	due := s.graphSubscriptions.subscriptions["due"].Expiration
+	_, expiredKept := s.graphSubscriptions.subscriptions["expired"]
+	remaining := len(s.graphSubscriptions.subscriptions)
+	s.graphSubscriptions.Unlock()
+	if !due.Equal(fake.subscriptions["due"]) || due.Sub(now) < graphSubscriptionLifetime-time.Minute {
+		t.Errorf("renewed expiration = %v, want about %v from now", due, graphSubscriptionLifetime)
+	}
+	if expiredKept || remaining != 3 {
+		t.Errorf("%d subscriptions remain (expired kept: %v), want 3", remaining, expiredKept)
+	}
+	s.watchStore.RLock()
+	watch := s.watchStore.expirations[accountKey{providerOutlook, "due@example.com"}]
+	s.watchStore.RUnlock()
+	if !watch.Equal(due) {
+		t.Errorf("watch expiration = %v, want %v", watch, due)
+	}
+
+	// The renewal is saved
+	data, err := os.ReadFile(graphSubscriptionsPath())
+	if err != nil {
+		t.Fatalf("read subscriptions: %v", err)
+	}
+	var saved []graphSubscription
+	if err := json.Unmarshal(data, &saved); err != nil || len(saved) != 3 || saved[0].ID != "due" || !saved[0].Expiration.Equal(due) {
+		t.Errorf("saved = %s (%v)", data, err)
+	}
+
+	// A subscription Graph no longer knows is left to lapse
+	fake.mu.Lock()
+	delete(fake.subscriptions, "due")
+	fake.mu.Unlock()
+	logs := captureLog(t)
+	if renewed := s.renewGraphSubscriptions(due.Add(-time.Hour)); renewed != 0 {
+		t.Errorf("renewed = %d after Graph dropped the subscription, want 0", renewed)
+	}
+	if !strings.Contains(logs.String(), "Unable to renew Graph subscription due for due@example.com") {
+		t.Errorf("log = %q", logs)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// Types of historyEvent
+const (
+	historyEventDeleted       = "deleted"
+	historyEventLabelsAdded   = "labels_added"
+	historyEventLabelsRemoved = "labels_removed"
+)
+
+// historyEvent is a Gmail history change other than a new message
+type historyEvent struct {
+	Type      string
+	MessageID string
+	LabelIDs  []string
+}
+
+// processHistory runs the push pipeline over the messages added in history records
+// Deletions and label changes are logged and passed to Server.onHistoryEvent.
+// Messages trashed or marked as spam within the same batch are skipped unless
+// SKIP_DISCARDED_MESSAGES=false.
+func (s *Server) processHistory(ctx context.Context, logger *log.Logger, srv *gmail.Service, emailAddress string, records []*gmail.History) {
+	discarded := make(map[string]bool)
+	for _, record := range records {
+		for _, deleted := range record.MessagesDeleted {
+			if deleted.Message == nil {
+				continue
+			}
+			discarded[deleted.Message.Id] = true
+			s.recordHistoryEvent(logger, emailAddress, historyEvent{Type: historyEventDeleted, MessageID: deleted.Message.Id})
+		}
+		for _, added := range record.LabelsAdded {
+			if added.Message == nil {
+				continue
+			}
+			if containsString(added.LabelIds, "TRASH") || containsString(added.LabelIds, "SPAM") {
+				discarded[added.Message.Id] = true
+			}
+			s.recordHistoryEvent(logger, emailAddress, historyEvent{Type: historyEventLabelsAdded, MessageID: added.Message.Id, LabelIDs: added.LabelIds})
+		}
+		for _, removed := range record.LabelsRemoved {
+			if removed.Message == nil {
+				continue
+			}
+			s.recordHistoryEvent(logger, emailAddress, historyEvent{Type: historyEventLabelsRemoved, MessageID: removed.Message.Id, LabelIDs: removed.LabelIds})
+		}
+	}
+
+	skipDiscarded := !strings.EqualFold(os.Getenv("SKIP_DISCARDED_MESSAGES"), "false")
+	for _, record := range records {
+		for _, added := range record.MessagesAdded {
+			if added.Message == nil {
+				continue
+			}
+			if skipDiscarded && discarded[added.Message.Id] {
+				logger.Printf("Skipped message %s: deleted, trashed, or marked as spam", added.Message.Id)
+				continue
+			}
+			s.processPushedMessage(ctx, logger, srv, emailAddress, added.Message.Id)
+		}
+	}
+}
+
+// recordHistoryEvent logs a history event and hands it to the Server's hook, if any
+func (s *Server) recordHistoryEvent(logger *log.Logger, emailAddress string, event historyEvent) {
+	if len(event.LabelIDs) > 0 {
+		logger.Printf("History event for %s: %s message %s labels %v", emailAddress, event.Type, event.MessageID, event.LabelIDs)
+	} else {
+		logger.Printf("History event for %s: %s message %s", emailAddress, event.Type, event.MessageID)
+	}
+	if s.onHistoryEvent != nil {
+		s.onHistoryEvent(emailAddress, event)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+func TestProcessHistoryEvents(t *testing.T) {
+	added := func(id string) *gmail.History {
+		return &gmail.History{MessagesAdded: []*gmail.HistoryMessageAdded{{Message: &gmail.Message{Id: id}}}}
+	}
+	deleted := func(id string) *gmail.History {
+		return &gmail.History{MessagesDeleted: []*gmail.HistoryMessageDeleted{{Message: &gmail.Message{Id: id}}}}
+	}
+	labelled := func(id string, labels ...string) *gmail.History {
+		return &gmail.History{LabelsAdded: []*gmail.HistoryLabelAdded{{Message: &gmail.Message{Id: id}, LabelIds: labels}}}
+	}
+
+	tests := []struct {
+		name          string
+		keepDiscarded string // SKIP_DISCARDED_MESSAGES
+		records       []*gmail.History
+		recorded      int
+		events        []string // "type message" handed to onHistoryEvent, in order
+	}{
+		{"deleted only", "", []*gmail.History{deleted("old")}, 0, []string{"deleted old"}},
+		{"deleted without message", "", []*gmail.History{{MessagesDeleted: []*gmail.HistoryMessageDeleted{{}}}, {LabelsRemoved: []*gmail.HistoryLabelRemoved{{LabelIds: []string{"INBOX"}}}}}, 0, nil},
+		{"added then deleted", "", []*gmail.History{added("m1"), deleted("m1")}, 0, []string{"deleted m1"}},
+		{"added then spam", "", []*gmail.History{added("m1"), labelled("m1", "SPAM")}, 0, []string{"labels_added m1"}},
+		{"added then starred", "", []*gmail.History{added("m1"), labelled("m1", "STARRED")}, 1, []string{"labels_added m1"}},
+		{"discarded kept when disabled", "false", []*gmail.History{added("m1"), labelled("m1", "TRASH")}, 1, []string{"labels_added m1"}},
+		{"label removed", "", []*gmail.History{added("m1"), {LabelsRemoved: []*gmail.HistoryLabelRemoved{{Message: &gmail.Message{Id: "m1"}, LabelIds: []string{"UNREAD"}}}}}, 1, []string{"labels_removed m1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SKIP_DISCARDED_MESSAGES", tt.keepDiscarded)
+			s := newTestServer(t)
+			fake := newFakeGmail(t, "user@example.com")
+			fake.use(s)
+			fake.addMessage("m1", map[string]string{"Subject": "Transaction alert", "From": "alerts@examplebank.com"},
+				"Rs.424.00 spent on your credit card XX1234 at AMAZON on 11 Nov, 2025", "", time.Now())
+			var events []string
+			s.onHistoryEvent = func(emailAddress string, event historyEvent) {
+				events = append(events, event.Type+" "+event.MessageID)
+			}
+
+			srv, err := s.gmailServiceFactory(context.Background(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.processHistory(context.Background(), discardLogger(), srv, "user@example.com", tt.records)
+
+			records, _, err := s.transactions.Query("user@example.com", TransactionFilter{})
+			if err != nil || len(records) != tt.recorded {
+				t.Errorf("recorded %d transactions (%v), want %d", len(records), err, tt.recorded)
+			}
+			if !equalStrings(events, tt.events) {
+				t.Errorf("events = %q, want %q", events, tt.events)
+			}
+		})
+	}
+}
+
+func TestPushWithMessagesDeleted(t *testing.T) {
+	s := newTestServer(t)
+	fake := newFakeGmail(t, "user@example.com")
+	fake.use(s)
+	authenticate(s, "user@example.com")
+	s.historyStore.history[accountKey{providerGmail, "user@example.com"}] = 1000
+	msg := fake.addMessage("m1", map[string]string{"Subject": "Transaction alert", "From": "alerts@examplebank.com"},
+		"Rs.424.00 spent on your credit card XX1234 at AMAZON on 11 Nov, 2025", "", time.Now())
+	fake.mu.Lock()
+	fake.historyID++
+	fake.history = append(fake.history, &gmail.History{Id: fake.historyID, MessagesDeleted: []*gmail.HistoryMessageDeleted{{Message: &gmail.Message{Id: "m0"}}}})
+	fake.mu.Unlock()
+
+	logs := captureLog(t)
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, pushRequest(base64.StdEncoding, "user@example.com", msg.HistoryId+1))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if !strings.Contains(logs.String(), "deleted message m0") {
+		t.Errorf("log lacks the deletion:\n%s", logs.String())
+	}
+	if records, _, err := s.transactions.Query("user@example.com", TransactionFilter{}); err != nil || len(records) != 1 {
+		t.Errorf("recorded %v (%v), want the added message's transaction", records, err)
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// restHookMaxGone is the number of consecutive 410 Gone deliveries after which
+// a hook is removed, as REST hook consumers such as Zapier expect
+const restHookMaxGone = 3
+
+// restHookEvents are the events a hook may subscribe to
+var restHookEvents = []string{webhookEventTransaction, webhookEventBillReminder, webhookEventAlert}
+
+// restHook is a subscription registered through POST /hooks
+type restHook struct {
+	ID        string    `json:"id"`
+	UserEmail string    `json:"user_email"`
+	TargetURL string    `json:"target_url"`
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Gone      int       `json:"gone_responses,omitempty"` // Consecutive 410 responses
+}
+
+// restHooksPath returns the file hook subscriptions are saved to, from HOOKS_PATH
+func restHooksPath() string {
+	if value := strings.TrimSpace(os.Getenv("HOOKS_PATH")); value != "" {
+		return value
+	}
+	return "hooks.json"
+}
+
+// REST hooks are always available; the sink does nothing until hooks are registered
+func init() {
+	registerNotifier("hooks", func(s *Server) Notifier { return restHookNotifier{s} })
+}
+
+// restHookNotifier delivers events to the user's registered hooks
+type restHookNotifier struct {
+	s *Server
+}
+
+// Name identifies the sink in logs and metrics
+func (n restHookNotifier) Name() string { return "hooks" }
+
+// NotifyTransaction delivers a transaction event to the user's hooks
+func (n restHookNotifier) NotifyTransaction(ctx context.Context, userEmail string, txn *CreditCardTransaction) error {
+	return n.s.deliverRestHooks(ctx, webhookPayload{Event: webhookEventTransaction, UserEmail: userEmail, MessageID: notificationFrom(ctx).MessageID, Transaction: txn})
+}
+
+// NotifyBill delivers a bill reminder event to the user's hooks
+func (n restHookNotifier) NotifyBill(ctx context.Context, userEmail string, bill *BillReminder) error {
+	return n.s.deliverRestHooks(ctx, webhookPayload{Event: webhookEventBillReminder, UserEmail: userEmail, MessageID: notificationFrom(ctx).MessageID, BillReminder: bill})
+}
+
+// NotifyAlert delivers a triggered spend alert event to the user's hooks
+func (n restHookNotifier) NotifyAlert(ctx context.Context, userEmail string, alert *spendAlert) error {
+	return n.s.deliverRestHooks(ctx, webhookPayload{Event: webhookEventAlert, UserEmail: userEmail, MessageID: notificationFrom(ctx).MessageID, Alert: alert})
+}
+
+// deliverRestHooks sends payload to each hook subscribed to its user and event,
+// concurrently, with the webhook retries and signature
+// Hooks answering 410 Gone restHookMaxGone times in a row are removed; other
+// failures go to the dead-letter store.
+func (s *Server) deliverRestHooks(ctx context.Context, payload webhookPayload) error {
+	hooks := s.restHooksFor(payload.UserEmail, payload.Event)
+	if len(hooks) == 0 {
+		return nil
+	}
+	payload.SentAt = time.Now()
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("unable to encode %s hook: %v", payload.Event, err)
+	}
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs []error
+	)
+	for _, hook := range hooks {
+		wg.Add(1)
+		go func(hook restHook) {
+			defer wg.Done()
+			attempts, err := deliverWebhook(ctx, hook.TargetURL, payload.Event, body)
+			var statusErr *webhookStatusError
+			gone := errors.As(err, &statusErr) && statusErr.code == http.StatusGone
+			s.recordRestHookResult(hook.ID, gone)
+			if err == nil || gone {
+				return
+			}
+			s.addDeadLetter(deadLetter{Target: hook.TargetURL, Event: payload.Event, Payload: body, Attempts: attempts, LastError: err.Error()})
+			mu.Lock()
+			errs = append(errs, fmt.Errorf("hook %s failed after %d attempts: %v", hook.ID, attempts, err))
+			mu.Unlock()
+		}(hook)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// restHooksFor returns copies of the hooks subscribed to the user's event
+func (s *Server) restHooksFor(userEmail, event string) []restHook {
+	s.restHooks.Lock()
+	defer s.restHooks.Unlock()
+	s.loadRestHooksLocked()
+	var hooks []restHook
+	for _, hook := range s.restHooks.hooks {
+		if hook.UserEmail == userEmail && hook.Event == event {
+			hooks = append(hooks, *hook)
+		}
+	}
+	return hooks
+}
+
+// recordRestHookResult counts a hook's consecutive 410 responses, removing the
+// hook once it reaches restHookMaxGone; any other result resets the count
+func (s *Server) recordRestHookResult(id string, gone bool) {
+	s.restHooks.Lock()
+	defer s.restHooks.Unlock()
+	hook, ok := s.restHooks.hooks[id]
+	if !ok || (!gone && hook.Gone == 0) {
+		return
+	}
+	if gone {
+		hook.Gone++
+	} else {
+		hook.Gone = 0
+	}
+	if hook.Gone >= restHookMaxGone {
+		log.Printf("Removing hook %s for %s after %d 410 Gone responses", id, hook.UserEmail, hook.Gone)
+		delete(s.restHooks.hooks, id)
+	}
+	s.saveRestHooksLocked()
+}
+
+// loadRestHooksLocked reads the saved hooks from disk once; s.restHooks must be locked
+func (s *Server) loadRestHooksLocked() {
+	if s.restHooks.loaded {
+		return
+	}
+	s.restHooks.loaded = true
+
+	data, err := os.ReadFile(restHooksPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Unable to read hooks: %v", err)
+		}
+		return
+	}
+	var hooks []*restHook
+	if err := json.Unmarshal(data, &hooks); err != nil {
+		log.Printf("Unable to parse hooks: %v", err)
+		return
+	}
+	for _, hook := range hooks {
+		s.restHooks.hooks[hook.ID] = hook
+	}
+}
+
+// saveRestHooksLocked writes the hooks to disk, oldest first; s.restHooks must be locked
+func (s *Server) saveRestHooksLocked() {
+	hooks := make([]*restHook, 0, len(s.restHooks.hooks))
+	for _, hook := range s.restHooks.hooks {
+		hooks = append(hooks, hook)
+	}
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].CreatedAt.Before(hooks[j].CreatedAt) })
+	data, err := json.MarshalIndent(hooks, "", "  ")
+	if err != nil {
+		log.Printf("Unable to encode hooks: %v", err)
+		return
+	}
+	if err := os.WriteFile(restHooksPath(), data, 0600); err != nil {
+		log.Printf("Unable to write hooks: %v", err)
+	}
+}
+
+// validHookTarget checks a hook's target URL: absolute, and HTTPS unless it
+// points at this machine
+func validHookTarget(target string) error {
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return errors.New("must be an absolute URL")
+	}
+	switch {
+	case u.Scheme == "https":
+	case u.Scheme == "http" && isLoopbackHost(u.Hostname()):
+	default:
+		return errors.New("must use https")
+	}
+	return nil
+}
+
+// sampleTransaction is the transaction sent by /webhook/test and /hooks/sample
+func sampleTransaction() *CreditCardTransaction {
+	txn := parseCreditCardTransaction("Transaction alert", "Rs.424.00 spent on your credit card XX1234 at AMAZON on 11 Nov, 2025 at 12:38 PM")
+	txn.Category = categorizeMerchant(txn.Merchant)
+	return txn
+}
+
+// hooksHandler serves the REST hook endpoints used by Zapier and Make:
+// POST /hooks subscribes, DELETE /hooks/{id} unsubscribes, and GET
+// /hooks/sample returns example payloads for field mapping
+func (s *Server) hooksHandler(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r.Context())
+
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/hooks"), "/")
+	switch {
+	case id == "" && r.Method == http.MethodPost:
+	case id == "sample" && r.Method == http.MethodGet:
+		restHookSampleHandler(w, r)
+		return
+	case id != "" && id != "sample" && r.Method == http.MethodDelete:
+		s.restHooks.Lock()
+		s.loadRestHooksLocked()
+		hook, ok := s.restHooks.hooks[id]
+		if ok {
+			delete(s.restHooks.hooks, id)
+			s.saveRestHooksLocked()
+		}
+		s.restHooks.Unlock()
+		if !ok {
+			http.Error(w, "Hook not found", http.StatusNotFound)
+			return
+		}
+		logger.Printf("Removed hook %s for %s", id, hook.UserEmail)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		UserEmail string `json:"user_email"`
+		TargetURL string `json:"target_url"`
+		Event     string `json:"event"`
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	hook := &restHook{
+		UserEmail: strings.TrimSpace(req.UserEmail),
+		TargetURL: strings.TrimSpace(req.TargetURL),
+		Event:     strings.TrimSpace(req.Event),
+		CreatedAt: time.Now(),
+	}
+	if hook.UserEmail == "" {
+		http.Error(w, "Missing user_email", http.StatusBadRequest)
+		return
+	}
+	if !s.isAuthenticated(hook.UserEmail) {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+	if err := validHookTarget(hook.TargetURL); err != nil {
+		http.Error(w, "Invalid target_url: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !containsString(restHookEvents, hook.Event) {
+		http.Error(w, "Invalid event (use "+strings.Join(restHookEvents, " or ")+")", http.StatusBadRequest)
+		return
+	}
+
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		logger.Printf("Unable to generate hook ID: %v", err)
+		http.Error(w, "Failed to register hook", http.StatusInternalServerError)
+		return
+	}
+	hook.ID = hex.EncodeToString(b[:])
+
+	s.restHooks.Lock()
+	s.loadRestHooksLocked()
+	s.restHooks.hooks[hook.ID] = hook
+	s.saveRestHooksLocked()
+	s.restHooks.Unlock()
+	logger.Printf("Registered hook %s for %s (%s)", hook.ID, hook.UserEmail, hook.Event)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(hook)
+}
+
+// restHookSampleHandler returns a one-element list holding a sample payload for
+// the event parameter (transaction.created by default), the shape Zapier's
+// sample step expects
+func restHookSampleHandler(w http.ResponseWriter, r *http.Request) {
+	payload := webhookPayload{
+		Event:     r.URL.Query().Get("event"),
+		UserEmail: "user@example.com",
+		MessageID: "sample",
+		SentAt:    time.Now(),
+	}
+	switch payload.Event {
+	case "", webhookEventTransaction:
+		payload.Event = webhookEventTransaction
+		payload.Transaction = sampleTransaction()
+	case webhookEventBillReminder:
+		payload.BillReminder = parseBillReminder("Your HDFC Bank Credit Card statement", "Total amount due: Rs.12,345.00. Minimum amount due: Rs.620.00. Payment due date: 05 Dec 2025 for card ending 1234.")
+	case webhookEventAlert:
+		txn := sampleTransaction()
+		payload.Alert = &spendAlert{
+			Rule:             alertRule{ID: "1", Type: alertSingleTransaction, MaxAmount: 40000},
+			AmountMinorUnits: txn.AmountMinorUnits,
+			Currency:         txn.Currency,
+			Transaction:      txn,
+		}
+	default:
+		http.Error(w, "Invalid event (use "+strings.Join(restHookEvents, " or ")+")", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode([]webhookPayload{payload})
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// hookRequest sends an admin request to the hooks endpoints
+func hookRequest(s *Server, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("X-Admin-Token", "admin-secret")
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHooksHandler(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+	s := newTestServer(t)
+	authenticate(s, "user@example.com")
+
+	tests := []struct {
+		name string
+		body string
+		code int
+		want string
+	}{
+		{"camelCase keys", `{"userEmail":"user@example.com","targetUrl":"https://hooks.example.com/a","event":"transaction.created"}`, http.StatusBadRequest, "unknown field"},
+		{"missing user", `{"target_url":"https://hooks.example.com/a","event":"transaction.created"}`, http.StatusBadRequest, "Missing user_email"},
+		{"unknown user", `{"user_email":"other@example.com","target_url":"https://hooks.example.com/a","event":"transaction.created"}`, http.StatusUnauthorized, "not authenticated"},
+		{"plain http target", `{"user_email":"user@example.com","target_url":"http://hooks.example.com/a","event":"transaction.created"}`, http.StatusBadRequest, "Invalid target_url"},
+		{"unknown event", `{"user_email":"user@example.com","target_url":"https://hooks.example.com/a","event":"email.received"}`, http.StatusBadRequest, "Invalid event"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := hookRequest(s, http.MethodPost, "/hooks", tt.body)
+			if rec.Code != tt.code || !strings.Contains(rec.Body.String(), tt.want) {
+				t.Errorf("got %d %q, want %d containing %q", rec.Code, rec.Body, tt.code, tt.want)
+			}
+		})
+	}
+
+	rec := hookRequest(s, http.MethodPost, "/hooks", `{"user_email":"user@example.com","target_url":"https://hooks.example.com/a","event":"transaction.created"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("subscribe status = %d: %s", rec.Code, rec.Body)
+	}
+	var hook map[string]interface{}
+	if err := json.NewDecoder(rec.Body).Decode(&hook); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	for _, key := range []string{"id", "user_email", "target_url", "event", "created_at"} {
+		if _, ok := hook[key]; !ok {
+			t.Errorf("hook response lacks %q: %v", key, hook)
+		}
+	}
+	id, _ := hook["id"].(string)
+
+	saved, err := os.ReadFile(restHooksPath())
+	if err != nil || !strings.Contains(string(saved), `"target_url": "https://hooks.example.com/a"`) {
+		t.Errorf("saved hooks = %s (%v), want the new hook", saved, err)
+	}
+
+	if rec := hookRequest(s, http.MethodDelete, "/hooks/"+id, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("delete status = %d, want 204", rec.Code)
+	}
+	if rec := hookRequest(s, http.MethodDelete, "/hooks/"+id, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("second delete status = %d, want 404", rec.Code)
+	}
+}
+
+func TestHooksSample(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+	s := newTestServer(t)
+
+	for _, event := range []string{webhookEventTransaction, webhookEventBillReminder} {
+		rec := hookRequest(s, http.MethodGet, "/hooks/sample?event="+event, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s sample status = %d: %s", event, rec.Code, rec.Body)
+		}
+		var samples []webhookPayload
+		if err := json.NewDecoder(rec.Body).Decode(&samples); err != nil || len(samples) != 1 {
+			t.Fatalf("%s sample = %v (%v), want one payload", event, samples, err)
+		}
+		if samples[0].Event != event || (samples[0].Transaction == nil && samples[0].BillReminder == nil) {
+			t.Errorf("%s sample = %+v", event, samples[0])
+		}
+	}
+	if rec := hookRequest(s, http.MethodGet, "/hooks/sample?event=email.received", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown event sample status = %d, want 400", rec.Code)
+	}
+}
+
+func TestRestHookRemovedAfterGone(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+	s := newTestServer(t)
+	authenticate(s, "user@example.com")
+
+	var (
+		mu       sync.Mutex
+		received []webhookPayload
+	)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload webhookPayload
+		json.NewDecoder(r.Body).Decode(&payload)
+		mu.Lock()
+		received = append(received, payload)
+		mu.Unlock()
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer target.Close()
+
+	rec := hookRequest(s, http.MethodPost, "/hooks", `{"user_email":"user@example.com","target_url":"`+target.URL+`","event":"transaction.created"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("subscribe status = %d: %s", rec.Code, rec.Body)
+	}
+
+	notifier := restHookNotifier{s}
+	for i := 1; i <= restHookMaxGone; i++ {
+		if err := notifier.NotifyTransaction(context.Background(), "user@example.com", sampleTransaction()); err != nil {
+			t.Fatalf("delivery %d: %v", i, err)
+		}
+		registered := len(s.restHooksFor("user@example.com", webhookEventTransaction)) == 1
+		if want := i < restHookMaxGone; registered != want {
+			t.Fatalf("after %d 410 responses registered = %v, want %v", i, registered, want)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != restHookMaxGone {
+		t.Fatalf("target received %d deliveries, want %d", len(received), restHookMaxGone)
+	}
+	if received[0].UserEmail != "user@example.com" || received[0].Transaction == nil {
+		t.Errorf("delivered payload = %+v", received[0])
+	}
+	s.deadLetters.Lock()
+	defer s.deadLetters.Unlock()
+	if len(s.deadLetters.entries) != 0 {
+		t.Errorf("410 responses were dead-lettered: %v", s.deadLetters.entries)
+	}
+}
@@ -0,0 +1,781 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/mail"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	xhtml "golang.org/x/net/html"
+	"golang.org/x/oauth2"
+)
+
+// TLS modes for IMAP accounts
+const (
+	imapTLS      = "tls"      // Implicit TLS, port 993 by default
+	imapStartTLS = "starttls" // STARTTLS upgrade, port 143 by default
+	imapNoTLS    = "none"     // Plain text, only to local bridges such as Proton Mail Bridge
+)
+
+const (
+	// defaultIMAPPollInterval is used when IMAP_POLL_INTERVAL is unset
+	defaultIMAPPollInterval = time.Minute
+
+	// imapIdleTimeout is how long one IDLE lasts; RFC 2177 asks clients to
+	// re-issue it at least every 29 minutes
+	imapIdleTimeout = 25 * time.Minute
+
+	// imapSnippetRunes is the length of snippets built from IMAP bodies, as Gmail's
+	imapSnippetRunes = 200
+)
+
+// imapAccount is a registered IMAP mailbox
+// Password is the app password sealed with IMAP_SECRET_KEY; see sealIMAPPassword.
+type imapAccount struct {
+	UserEmail string `json:"user_email"`
+	Host      string `json:"host"`
+	Port      int    `json:"port"`
+	TLS       string `json:"tls"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+}
+
+// imapMark is an inbox's UID high-water mark, the IMAP counterpart of a Gmail history ID
+// UIDs only compare within one UIDVALIDITY; a new one means the mailbox was rebuilt.
+type imapMark struct {
+	UIDValidity uint32
+	LastUID     uint32
+}
+
+// imapPollStatus reports one account's polling health for /imap/status
+type imapPollStatus struct {
+	UserEmail   string     `json:"user_email"`
+	Host        string     `json:"host"`
+	Mode        string     `json:"mode"` // "idle" or "poll"; empty until the first connection
+	Connected   bool       `json:"connected"`
+	LastPoll    *time.Time `json:"last_poll"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+	UIDValidity uint32     `json:"uid_validity"`
+	LastUID     uint32     `json:"last_uid"`
+	Processed   int        `json:"messages_processed"`
+}
+
+// IMAP needs no configuration beyond its accounts
+func init() {
+	registerProvider(providerIMAP, func(s *Server) MailProvider { return imapProvider{s} })
+}
+
+// imapPollInterval returns how often mailboxes without IDLE are checked, and
+// how long to wait before reconnecting after an error, from IMAP_POLL_INTERVAL
+func imapPollInterval() time.Duration {
+	value := strings.TrimSpace(os.Getenv("IMAP_POLL_INTERVAL"))
+	if value == "" {
+		return defaultIMAPPollInterval
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < time.Second {
+		log.Printf("Invalid IMAP_POLL_INTERVAL %q, using %v", value, defaultIMAPPollInterval)
+		return defaultIMAPPollInterval
+	}
+	return interval
+}
+
+// imapAccountsPath returns the file IMAP accounts are saved to, from IMAP_ACCOUNTS_PATH
+func imapAccountsPath() string {
+	if value := strings.TrimSpace(os.Getenv("IMAP_ACCOUNTS_PATH")); value != "" {
+		return value
+	}
+	return "imap_accounts.json"
+}
+
+// imapSecretKey returns the AES-256 key for IMAP passwords from IMAP_SECRET_KEY,
+// 32 bytes encoded as hex or base64
+func imapSecretKey() ([]byte, error) {
+	value := strings.TrimSpace(os.Getenv("IMAP_SECRET_KEY"))
+	if value == "" {
+		return nil, errors.New("IMAP_SECRET_KEY is not set")
+	}
+	if key, err := hex.DecodeString(value); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(value); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, errors.New("IMAP_SECRET_KEY must be 32 bytes, hex or base64 encoded")
+}
+
+// imapCipher returns the AES-GCM cipher for IMAP passwords
+func imapCipher() (cipher.AEAD, error) {
+	key, err := imapSecretKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealIMAPPassword encrypts a password with AES-GCM, bound to the user so a
+// sealed password can't be moved to another account, and returns nonce and
+// ciphertext as base64
+func sealIMAPPassword(userEmail, password string) (string, error) {
+	aead, err := imapCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("unable to generate nonce: %v", err)
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(password), []byte(userEmail))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openIMAPPassword decrypts a password sealed by sealIMAPPassword
+func openIMAPPassword(userEmail, sealed string) (string, error) {
+	aead, err := imapCipher()
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < aead.NonceSize() {
+		return "", errors.New("malformed sealed password")
+	}
+	password, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(userEmail))
+	if err != nil {
+		return "", errors.New("unable to decrypt password; was IMAP_SECRET_KEY changed?")
+	}
+	return string(password), nil
+}
+
+// isLoopbackHost reports whether host names this machine
+func isLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// imapProvider reads mailboxes registered through /imap/accounts over IMAP
+type imapProvider struct {
+	s *Server
+}
+
+// Name returns "imap"
+func (p imapProvider) Name() string { return providerIMAP }
+
+// open connects to the user's server and examines the inbox
+func (p imapProvider) open(ctx context.Context, userEmail string) (*imapClient, imapMailbox, error) {
+	p.s.imapAccounts.RLock()
+	account, ok := p.s.imapAccounts.accounts[userEmail]
+	p.s.imapAccounts.RUnlock()
+	if !ok {
+		return nil, imapMailbox{}, fmt.Errorf("no IMAP account for %s", userEmail)
+	}
+	password, err := openIMAPPassword(userEmail, account.Password)
+	if err != nil {
+		return nil, imapMailbox{}, err
+	}
+	c, err := dialIMAP(ctx, account, password, gmailTimeout())
+	if err != nil {
+		return nil, imapMailbox{}, err
+	}
+	box, err := c.examine("INBOX")
+	if err != nil {
+		c.logout()
+		return nil, imapMailbox{}, err
+	}
+	return c, box, nil
+}
+
+// ListMessages searches the inbox, newest (highest UID) first
+// IMAP search has no notion of a total beyond the matches, so the estimate is exact.
+func (p imapProvider) ListMessages(ctx context.Context, userEmail string, token *oauth2.Token, query mailQuery) ([]string, int64, error) {
+	c, _, err := p.open(ctx, userEmail)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer c.logout()
+
+	criteria := "SINCE " + time.Now().AddDate(0, 0, -query.Days).Format("2-Jan-2006")
+	if query.From != "" {
+		from, err := imapQuote(query.From)
+		if err != nil {
+			return nil, 0, err
+		}
+		criteria += " FROM " + from
+	}
+	if query.Recipient != "" {
+		recipient, err := imapQuote(query.Recipient)
+		if err != nil {
+			return nil, 0, err
+		}
+		criteria += " OR TO " + recipient + " CC " + recipient
+	}
+	uids, err := c.uidSearch(criteria)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sort.Slice(uids, func(i, j int) bool { return uids[i] > uids[j] })
+	ids := make([]string, 0, min(len(uids), query.Limit))
+	for _, uid := range uids[:min(len(uids), query.Limit)] {
+		ids = append(ids, strconv.FormatUint(uint64(uid), 10))
+	}
+	return ids, int64(len(uids)), nil
+}
+
+// FetchMessage fetches a message's source by UID and parses it
+// The whole source is fetched for every format, so snippets are always present.
+func (p imapProvider) FetchMessage(ctx context.Context, userEmail string, token *oauth2.Token, msgID, format string) (*mailMessage, error) {
+	uid, err := strconv.ParseUint(msgID, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IMAP message ID %q", msgID)
+	}
+	c, _, err := p.open(ctx, userEmail)
+	if err != nil {
+		return nil, err
+	}
+	defer c.logout()
+
+	m, err := fetchIMAPMessage(c, uint32(uid))
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case "minimal":
+		m.Headers = make(map[string]string)
+		fallthrough
+	case "metadata":
+		m.Body, m.Forwarded = EmailBody{}, forwardedMessage{}
+	}
+	return m, nil
+}
+
+// Watch (re)starts polling the user's inbox; polls don't expire, so the expiration is zero
+func (p imapProvider) Watch(ctx context.Context, userEmail string, token *oauth2.Token) (time.Time, error) {
+	p.s.imapAccounts.RLock()
+	_, ok := p.s.imapAccounts.accounts[userEmail]
+	p.s.imapAccounts.RUnlock()
+	if !ok {
+		return time.Time{}, fmt.Errorf("no IMAP account for %s", userEmail)
+	}
+	p.s.startIMAPPoller(userEmail)
+	return time.Time{}, nil
+}
+
+// fetchIMAPMessage fetches and parses one message, up to MAX_RAW_BYTES of it
+func fetchIMAPMessage(c *imapClient, uid uint32) (*mailMessage, error) {
+	limit := maxRawBytes()
+	fetched, err := c.uidFetch(uid, limit)
+	if err != nil {
+		return nil, err
+	}
+	m, err := parseIMAPMessage(strconv.FormatUint(uint64(uid), 10), fetched.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(fetched.Body) >= limit {
+		m.Body.Truncated = true
+	}
+	if !fetched.InternalDate.IsZero() {
+		m.InternalDate = fetched.InternalDate.UnixMilli()
+	}
+	return m, nil
+}
+
+// parseIMAPMessage parses a message's source into the shared message shape:
+// the first plain text and HTML bodies, attachments, and the first forwarded message
+func parseIMAPMessage(id string, raw []byte) (*mailMessage, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read message %s: %v", id, err)
+	}
+
+	m := &mailMessage{ID: id, Headers: make(map[string]string), Body: EmailBody{Attachments: []AttachmentMeta{}}}
+	// Gmail returns header values with encoded words already decoded
+	for name, values := range msg.Header {
+		if len(values) > 0 {
+			m.Headers[name] = decodeHeader(values[0])
+		}
+	}
+	if date, err := msg.Header.Date(); err == nil {
+		m.InternalDate = date.UnixMilli()
+	}
+
+	// A partial fetch cuts multipart bodies short; keep whatever was read
+	if err := walkIMAPPart(msg.Header.Get, msg.Body, m); err != nil {
+		debugf("Incomplete MIME structure in message %s: %v", id, err)
+	}
+
+	limit := maxBodyBytes()
+	var plainCut, htmlCut bool
+	m.Body.PlainText, plainCut = truncateUTF8(m.Body.PlainText, limit)
+	m.Body.HTML, htmlCut = truncateUTF8(m.Body.HTML, limit)
+	m.Body.Truncated = plainCut || htmlCut
+	m.Snippet = imapSnippet(m.Body)
+	return m, nil
+}
+
+// walkIMAPPart collects bodies, attachments and the forwarded message from a MIME entity
+func walkIMAPPart(header func(string) string, body io.Reader, m *mailMessage) error {
+	mediaType, params, err := mime.ParseMediaType(header("Content-Type"))
+	if err != nil {
+		// RFC 2045 default for a missing or malformed Content-Type
+		mediaType, params = "text/plain", map[string]string{}
+	}
+	disposition, dispositionParams, _ := mime.ParseMediaType(header("Content-Disposition"))
+	filename := dispositionParams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	filename = decodeHeader(filename)
+	content := transferDecoder(header("Content-Transfer-Encoding"), body)
+
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"):
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("unable to read multipart body: %v", err)
+			}
+			if err := walkIMAPPart(part.Header.Get, part, m); err != nil {
+				return err
+			}
+		}
+	case mediaType == "message/rfc822":
+		data, err := io.ReadAll(io.LimitReader(content, maxFetchedBodyBytes))
+		if err != nil {
+			return fmt.Errorf("unable to read nested message: %v", err)
+		}
+		if filename != "" {
+			m.Body.Attachments = append(m.Body.Attachments, AttachmentMeta{Filename: filename, MimeType: mediaType, Size: int64(len(data))})
+		}
+		if m.Forwarded.Body == "" {
+			if forwarded, err := parseRFC822Message(data); err == nil {
+				m.Forwarded = forwarded
+			}
+		}
+	case (mediaType == "text/plain" || mediaType == "text/html") && disposition != "attachment":
+		data, err := io.ReadAll(io.LimitReader(content, maxFetchedBodyBytes))
+		if err != nil {
+			return fmt.Errorf("unable to read %s body: %v", mediaType, err)
+		}
+		text := decodeCharset(data, params["charset"])
+		if mediaType == "text/plain" && m.Body.PlainText == "" {
+			m.Body.PlainText = text
+		}
+		if mediaType == "text/html" && m.Body.HTML == "" {
+			m.Body.HTML = text
+		}
+	case filename != "":
+		size, err := io.Copy(io.Discard, content)
+		if err != nil {
+			return fmt.Errorf("unable to read attachment %s: %v", filename, err)
+		}
+		m.Body.Attachments = append(m.Body.Attachments, AttachmentMeta{Filename: filename, MimeType: mediaType, Size: size})
+	}
+	return nil
+}
+
+// imapSnippet builds a Gmail-style snippet: the start of the body text with
+// whitespace collapsed, taken from the HTML when there is no plain text
+func imapSnippet(body EmailBody) string {
+	text := body.PlainText
+	if text == "" && body.HTML != "" {
+		var b strings.Builder
+		tokenizer := xhtml.NewTokenizer(strings.NewReader(body.HTML))
+		skip := 0
+		for tt := tokenizer.Next(); tt != xhtml.ErrorToken; tt = tokenizer.Next() {
+			name, _ := tokenizer.TagName()
+			switch {
+			case tt == xhtml.StartTagToken && (string(name) == "script" || string(name) == "style"):
+				skip++
+			case tt == xhtml.EndTagToken && (string(name) == "script" || string(name) == "style") && skip > 0:
+				skip--
+			case tt == xhtml.TextToken && skip == 0:
+				b.Write(tokenizer.Text())
+				b.WriteByte(' ')
+			}
+		}
+		text = b.String()
+	}
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) > imapSnippetRunes {
+		text = string([]rune(text)[:imapSnippetRunes])
+	}
+	return text
+}
+
+// startIMAPPoller starts polling the user's inbox, replacing any running poller
+func (s *Server) startIMAPPoller(userEmail string) {
+	s.imapAccounts.Lock()
+	defer s.imapAccounts.Unlock()
+	account, ok := s.imapAccounts.accounts[userEmail]
+	if !ok {
+		return
+	}
+	if stop, running := s.imapAccounts.pollers[userEmail]; running {
+		close(stop)
+	}
+	stop := make(chan struct{})
+	s.imapAccounts.pollers[userEmail] = stop
+	s.imapAccounts.status[userEmail] = &imapPollStatus{UserEmail: userEmail, Host: account.Host}
+	go s.pollIMAP(userEmail, stop)
+}
+
+// runIMAPPollers loads the saved IMAP accounts, polls each until stop is
+// closed, then stops every poller
+func (s *Server) runIMAPPollers(stop <-chan struct{}) {
+	if err := s.loadIMAPAccounts(); err != nil {
+		log.Printf("Unable to load IMAP accounts: %v", err)
+	}
+	s.imapAccounts.RLock()
+	users := make([]string, 0, len(s.imapAccounts.accounts))
+	for userEmail := range s.imapAccounts.accounts {
+		users = append(users, userEmail)
+	}
+	s.imapAccounts.RUnlock()
+	for _, userEmail := range users {
+		s.startIMAPPoller(userEmail)
+	}
+	if len(users) > 0 {
+		log.Printf("Polling %d IMAP accounts", len(users))
+	}
+
+	<-stop
+	s.imapAccounts.Lock()
+	for userEmail, poller := range s.imapAccounts.pollers {
+		close(poller)
+		delete(s.imapAccounts.pollers, userEmail)
+	}
+	s.imapAccounts.Unlock()
+}
+
+// updateIMAPStatus applies update to the user's poll status
+func (s *Server) updateIMAPStatus(userEmail string, update func(status *imapPollStatus)) {
+	s.imapAccounts.Lock()
+	if status, ok := s.imapAccounts.status[userEmail]; ok {
+		update(status)
+	}
+	s.imapAccounts.Unlock()
+}
+
+// pollIMAP keeps a session open to the user's server until stop is closed,
+// reconnecting after IMAP_POLL_INTERVAL when it fails
+func (s *Server) pollIMAP(userEmail string, stop <-chan struct{}) {
+	for {
+		err := s.pollIMAPSession(userEmail, stop)
+		now := time.Now()
+		s.updateIMAPStatus(userEmail, func(status *imapPollStatus) {
+			status.Connected = false
+			if err != nil {
+				status.LastError, status.LastErrorAt = err.Error(), &now
+			}
+		})
+		if err != nil {
+			log.Printf("IMAP polling for %s failed: %v", userEmail, err)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(imapPollInterval()):
+		}
+	}
+}
+
+// pollIMAPSession processes new inbox mail, then waits for more with IDLE when
+// the server supports it, or for IMAP_POLL_INTERVAL otherwise, until stop or an error
+func (s *Server) pollIMAPSession(userEmail string, stop <-chan struct{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), gmailTimeout())
+	c, box, err := imapProvider{s}.open(ctx, userEmail)
+	cancel()
+	if err != nil {
+		return err
+	}
+	defer c.logout()
+
+	idle := c.caps["IDLE"]
+	s.updateIMAPStatus(userEmail, func(status *imapPollStatus) {
+		status.Connected, status.Mode = true, "poll"
+		if idle {
+			status.Mode = "idle"
+		}
+	})
+
+	for {
+		if err := s.processNewIMAPMessages(c, box, userEmail); err != nil {
+			return err
+		}
+		if idle {
+			if _, err := c.idle(imapIdleTimeout, stop); err != nil {
+				return err
+			}
+		} else {
+			select {
+			case <-stop:
+			case <-time.After(imapPollInterval()):
+			}
+		}
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
+		// Re-examining picks up the new UIDNEXT and any UIDVALIDITY change
+		if box, err = c.examine("INBOX"); err != nil {
+			return err
+		}
+	}
+}
+
+// processNewIMAPMessages runs the messages above the user's UID mark through
+// the detectors, advancing the mark after each one
+// The first poll, and the first after UIDVALIDITY changes, only sets the mark,
+// just as a new Gmail watch starts from the current history ID.
+func (s *Server) processNewIMAPMessages(c *imapClient, box imapMailbox, userEmail string) error {
+	s.uidStore.Lock()
+	mark, ok := s.uidStore.marks[userEmail]
+	if !ok || mark.UIDValidity != box.UIDValidity {
+		mark = imapMark{UIDValidity: box.UIDValidity}
+		if box.UIDNext > 0 {
+			mark.LastUID = box.UIDNext - 1
+		}
+		s.uidStore.marks[userEmail] = mark
+	}
+	s.uidStore.Unlock()
+
+	var uids []uint32
+	if ok && mark.UIDValidity == box.UIDValidity && (box.UIDNext == 0 || box.UIDNext > mark.LastUID+1) {
+		found, err := c.uidSearch(fmt.Sprintf("UID %d:*", mark.LastUID+1))
+		if err != nil {
+			return err
+		}
+		// "n:*" also matches the highest UID when it is below n
+		for _, uid := range found {
+			if uid > mark.LastUID {
+				uids = append(uids, uid)
+			}
+		}
+		sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	}
+
+	logger := log.Default()
+	for _, uid := range uids {
+		msg, err := fetchIMAPMessage(c, uid)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), gmailTimeout())
+		outcome := s.processFetchedMessage(ctx, logger, userEmail, msg)
+		cancel()
+		logger.Printf("Processed IMAP message %d for %s: %s", uid, userEmail, outcome)
+
+		mark.LastUID = uid
+		s.uidStore.Lock()
+		s.uidStore.marks[userEmail] = mark
+		s.uidStore.Unlock()
+		s.updateIMAPStatus(userEmail, func(status *imapPollStatus) { status.Processed++ })
+	}
+
+	now := time.Now()
+	s.updateIMAPStatus(userEmail, func(status *imapPollStatus) {
+		status.LastPoll, status.UIDValidity, status.LastUID = &now, mark.UIDValidity, mark.LastUID
+	})
+	return nil
+}
+
+// loadIMAPAccounts reads the saved accounts and marks their users as IMAP users
+func (s *Server) loadIMAPAccounts() error {
+	data, err := os.ReadFile(imapAccountsPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var accounts []*imapAccount
+	if err := json.Unmarshal(data, &accounts); err != nil {
+		return fmt.Errorf("unable to parse %s: %v", imapAccountsPath(), err)
+	}
+	s.imapAccounts.Lock()
+	for _, account := range accounts {
+		s.imapAccounts.accounts[account.UserEmail] = account
+	}
+	s.imapAccounts.Unlock()
+	for _, account := range accounts {
+		s.storeIMAPUser(account.UserEmail)
+	}
+	return nil
+}
+
+// saveIMAPAccountsLocked writes the accounts, passwords still sealed; s.imapAccounts must be locked
+func (s *Server) saveIMAPAccountsLocked() error {
+	accounts := make([]*imapAccount, 0, len(s.imapAccounts.accounts))
+	for _, account := range s.imapAccounts.accounts {
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].UserEmail < accounts[j].UserEmail })
+	data, err := json.MarshalIndent(accounts, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(imapAccountsPath(), data, 0600)
+}
+
+// storeIMAPUser records the user as an IMAP user
+// IMAP users get an empty token, so the endpoints that look users up by token
+// treat them like OAuth users.
+func (s *Server) storeIMAPUser(userEmail string) {
+	s.storeToken(providerIMAP, userEmail, &oauth2.Token{})
+}
+
+// imapAccountsHandler registers an IMAP mailbox from a JSON body of
+// user_email, host, port, tls (tls, starttls or none), username and password
+// The credentials are checked by logging in before the account is saved and polled.
+func (s *Server) imapAccountsHandler(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r.Context())
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		UserEmail string `json:"user_email"`
+		Host      string `json:"host"`
+		Port      int    `json:"port"`
+		TLS       string `json:"tls"`
+		Username  string `json:"username"`
+		Password  string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Failed to parse request", http.StatusBadRequest)
+		return
+	}
+
+	account := &imapAccount{
+		UserEmail: strings.TrimSpace(req.UserEmail),
+		Host:      strings.TrimSpace(req.Host),
+		Port:      req.Port,
+		TLS:       strings.ToLower(strings.TrimSpace(req.TLS)),
+		Username:  strings.TrimSpace(req.Username),
+	}
+	if account.TLS == "" {
+		account.TLS = imapTLS
+	}
+	if account.Username == "" {
+		account.Username = account.UserEmail
+	}
+	if account.Port == 0 {
+		account.Port = 143
+		if account.TLS == imapTLS {
+			account.Port = 993
+		}
+	}
+	switch {
+	case account.UserEmail == "":
+		http.Error(w, "Missing user_email", http.StatusBadRequest)
+		return
+	case account.Host == "" || req.Password == "":
+		http.Error(w, "Missing host or password", http.StatusBadRequest)
+		return
+	case account.Port < 1 || account.Port > 65535:
+		http.Error(w, "Invalid port", http.StatusBadRequest)
+		return
+	case account.TLS != imapTLS && account.TLS != imapStartTLS && account.TLS != imapNoTLS:
+		http.Error(w, "Invalid tls parameter (use tls, starttls, or none)", http.StatusBadRequest)
+		return
+	case account.TLS == imapNoTLS && !isLoopbackHost(account.Host):
+		http.Error(w, "Plain-text IMAP is only allowed to localhost", http.StatusBadRequest)
+		return
+	}
+
+	sealed, err := sealIMAPPassword(account.UserEmail, req.Password)
+	if err != nil {
+		logger.Printf("Unable to encrypt IMAP password: %v", err)
+		http.Error(w, "Failed to store IMAP account", http.StatusInternalServerError)
+		return
+	}
+	account.Password = sealed
+
+	ctx, cancel := gmailContext(r)
+	defer cancel()
+	c, err := dialIMAP(ctx, account, req.Password, gmailTimeout())
+	if err != nil {
+		logger.Printf("Unable to log in to IMAP server %s for %s: %v", account.Host, account.UserEmail, err)
+		http.Error(w, fmt.Sprintf("Failed to log in to IMAP server: %v", err), http.StatusBadGateway)
+		return
+	}
+	c.logout()
+
+	s.imapAccounts.Lock()
+	s.imapAccounts.accounts[account.UserEmail] = account
+	err = s.saveIMAPAccountsLocked()
+	s.imapAccounts.Unlock()
+	if err != nil {
+		logger.Printf("Unable to save IMAP accounts: %v", err)
+		http.Error(w, "Failed to store IMAP account", http.StatusInternalServerError)
+		return
+	}
+	s.storeIMAPUser(account.UserEmail)
+	s.startIMAPPoller(account.UserEmail)
+	logger.Printf("Registered IMAP account %s on %s:%d (%s)", account.UserEmail, account.Host, account.Port, account.TLS)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":     "polling",
+		"user_email": account.UserEmail,
+		"host":       account.Host,
+		"port":       account.Port,
+		"tls":        account.TLS,
+		"username":   account.Username,
+	})
+}
+
+// imapStatusHandler reports each IMAP account's polling health
+func (s *Server) imapStatusHandler(w http.ResponseWriter, r *http.Request) {
+	s.imapAccounts.RLock()
+	accounts := make([]imapPollStatus, 0, len(s.imapAccounts.status))
+	for _, status := range s.imapAccounts.status {
+		accounts = append(accounts, *status)
+	}
+	s.imapAccounts.RUnlock()
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].UserEmail < accounts[j].UserEmail })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"count":    len(accounts),
+		"accounts": accounts,
+	})
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// imapMaxLiteral caps a single literal read from the server, above the partial
+// fetch size so a whole message always fits
+const imapMaxLiteral = 64 << 20
+
+// imapLiteralPattern matches the {n} length that ends a line followed by a literal
+var imapLiteralPattern = regexp.MustCompile(`\{(\d+)\}$`)
+
+// imapInternalDatePattern matches a FETCH response's INTERNALDATE
+var imapInternalDatePattern = regexp.MustCompile(`INTERNALDATE "([^"]+)"`)
+
+// imapCodePattern matches a numeric response code such as [UIDVALIDITY 3857529045]
+var imapCodePattern = regexp.MustCompile(`\[(UIDVALIDITY|UIDNEXT) (\d+)\]`)
+
+// imapClient is a minimal IMAP4rev1 client (RFC 3501) covering what the poller
+// needs: LOGIN, EXAMINE, UID SEARCH, UID FETCH and IDLE (RFC 2177)
+type imapClient struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	tag     int
+	timeout time.Duration // Deadline for each command
+	caps    map[string]bool
+	pending string // Partial line left by a read deadline during IDLE
+}
+
+// imapResponse is one server response; literals are cut out of Text, which
+// keeps their {n} markers, and returned in order in Literals
+type imapResponse struct {
+	Text     string
+	Literals [][]byte
+}
+
+// imapMailbox is the state EXAMINE reports for a mailbox
+type imapMailbox struct {
+	UIDValidity uint32
+	UIDNext     uint32
+}
+
+// imapFetched is a message returned by UID FETCH
+type imapFetched struct {
+	UID          uint32
+	InternalDate time.Time
+	Body         []byte
+}
+
+// dialIMAP connects and logs in to the account's server
+// ctx bounds the connection and login; later commands use timeout each.
+func dialIMAP(ctx context.Context, account *imapAccount, password string, timeout time.Duration) (*imapClient, error) {
+	addr := net.JoinHostPort(account.Host, strconv.Itoa(account.Port))
+	tlsConfig := &tls.Config{ServerName: account.Host}
+	dialer := &net.Dialer{}
+
+	var conn net.Conn
+	var err error
+	if account.TLS == imapTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to %s: %v", addr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c := &imapClient{conn: conn, r: bufio.NewReader(conn), timeout: timeout}
+	if err := c.login(account, password, tlsConfig); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// login reads the greeting, upgrades with STARTTLS when configured, and logs in
+func (c *imapClient) login(account *imapAccount, password string, tlsConfig *tls.Config) error {
+	greeting, err := c.readResponse()
+	if err != nil {
+		return fmt.Errorf("unable to read IMAP greeting: %v", err)
+	}
+	if !strings.HasPrefix(greeting.Text, "* OK") {
+		return fmt.Errorf("unexpected IMAP greeting: %s", greeting.Text)
+	}
+	if err := c.capability(); err != nil {
+		return err
+	}
+
+	if account.TLS == imapStartTLS {
+		if !c.caps["STARTTLS"] {
+			return errors.New("server does not offer STARTTLS")
+		}
+		if _, err := c.run("STARTTLS"); err != nil {
+			return err
+		}
+		tlsConn := tls.Client(c.conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			return fmt.Errorf("STARTTLS handshake failed: %v", err)
+		}
+		c.conn, c.r = tlsConn, bufio.NewReader(tlsConn)
+		// Capabilities sent before STARTTLS can't be trusted (RFC 3501 section 6.2.1)
+		if err := c.capability(); err != nil {
+			return err
+		}
+	}
+
+	if c.caps["LOGINDISABLED"] {
+		return errors.New("server does not allow LOGIN on this connection")
+	}
+	username, err := imapQuote(account.Username)
+	if err != nil {
+		return fmt.Errorf("invalid username: %v", err)
+	}
+	quotedPassword, err := imapQuote(password)
+	if err != nil {
+		return fmt.Errorf("invalid password: %v", err)
+	}
+	if _, err := c.run("LOGIN " + username + " " + quotedPassword); err != nil {
+		return err
+	}
+	// Servers commonly advertise more, such as IDLE, once logged in
+	return c.capability()
+}
+
+// imapQuote renders s as an IMAP quoted string
+func imapQuote(s string) (string, error) {
+	if strings.ContainsAny(s, "\r\n\x00") {
+		return "", errors.New("must not contain line breaks")
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`, nil
+}
+
+// readLine reads one CRLF-terminated line, keeping a partial line for the next
+// call when a read deadline interrupts it
+func (c *imapClient) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	line = c.pending + line
+	c.pending = ""
+	if err != nil {
+		c.pending = line
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readResponse reads one response, including any literals it carries
+func (c *imapClient) readResponse() (imapResponse, error) {
+	var resp imapResponse
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return resp, err
+		}
+		resp.Text += line
+		m := imapLiteralPattern.FindStringSubmatch(line)
+		if m == nil {
+			return resp, nil
+		}
+		n, err := strconv.Atoi(m[1])
+		if err != nil || n > imapMaxLiteral {
+			return resp, fmt.Errorf("IMAP literal of %s bytes is too large", m[1])
+		}
+		literal := make([]byte, n)
+		if _, err := io.ReadFull(c.r, literal); err != nil {
+			return resp, err
+		}
+		resp.Literals = append(resp.Literals, literal)
+	}
+}
+
+// send writes a tagged command and returns its tag
+func (c *imapClient) send(command string) (string, error) {
+	c.tag++
+	tag := fmt.Sprintf("A%04d", c.tag)
+	if c.timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+	_, err := io.WriteString(c.conn, tag+" "+command+"\r\n")
+	return tag, err
+}
+
+// run sends a command and returns its untagged responses once it completes
+// Errors name only the command's verb, so LOGIN failures don't echo the password.
+func (c *imapClient) run(command string) ([]imapResponse, error) {
+	verb, _, _ := strings.Cut(command, " ")
+	if verb == "UID" {
+		verb = strings.Join(strings.Fields(command)[:2], " ")
+	}
+	tag, err := c.send(command)
+	if err != nil {
+		return nil, fmt.Errorf("IMAP %s: %v", verb, err)
+	}
+	return c.await(tag, verb)
+}
+
+// await reads responses until the tagged completion of a command
+func (c *imapClient) await(tag, verb string) ([]imapResponse, error) {
+	var untagged []imapResponse
+	for {
+		resp, err := c.readResponse()
+		if err != nil {
+			return untagged, fmt.Errorf("IMAP %s: %v", verb, err)
+		}
+		if status, ok := strings.CutPrefix(resp.Text, tag+" "); ok {
+			if strings.HasPrefix(status, "OK") {
+				return untagged, nil
+			}
+			return untagged, fmt.Errorf("IMAP %s: %s", verb, status)
+		}
+		untagged = append(untagged, resp)
+	}
+}
+
+// capability refreshes the server's capabilities
+func (c *imapClient) capability() error {
+	responses, err := c.run("CAPABILITY")
+	if err != nil {
+		return err
+	}
+	c.caps = make(map[string]bool)
+	for _, resp := range responses {
+		if fields, ok := strings.CutPrefix(resp.Text, "* CAPABILITY "); ok {
+			for _, capability := range strings.Fields(fields) {
+				c.caps[strings.ToUpper(capability)] = true
+			}
+		}
+	}
+	return nil
+}
+
+// examine opens a mailbox read-only, so fetching never marks mail as read
+func (c *imapClient) examine(mailbox string) (imapMailbox, error) {
+	name, err := imapQuote(mailbox)
+	if err != nil {
+		return imapMailbox{}, err
+	}
+	responses, err := c.run("EXAMINE " + name)
+	if err != nil {
+		return imapMailbox{}, err
+	}
+	var box imapMailbox
+	for _, resp := range responses {
+		for _, m := range imapCodePattern.FindAllStringSubmatch(resp.Text, -1) {
+			n, _ := strconv.ParseUint(m[2], 10, 32)
+			if m[1] == "UIDVALIDITY" {
+				box.UIDValidity = uint32(n)
+			} else {
+				box.UIDNext = uint32(n)
+			}
+		}
+	}
+	return box, nil
+}
+
+// uidSearch returns the UIDs matching criteria, in the server's order
+func (c *imapClient) uidSearch(criteria string) ([]uint32, error) {
+	responses, err := c.run("UID SEARCH " + criteria)
+	if err != nil {
+		return nil, err
+	}
+	var uids []uint32
+	for _, resp := range responses {
+		fields, ok := strings.CutPrefix(resp.Text, "* SEARCH")
+		if !ok {
+			continue
+		}
+		for _, field := range strings.Fields(fields) {
+			if n, err := strconv.ParseUint(field, 10, 32); err == nil {
+				uids = append(uids, uint32(n))
+			}
+		}
+	}
+	return uids, nil
+}
+
+// uidFetch fetches up to limit bytes of one message's source without setting \Seen
+func (c *imapClient) uidFetch(uid uint32, limit int) (*imapFetched, error) {
+	responses, err := c.run(fmt.Sprintf("UID FETCH %d (UID INTERNALDATE BODY.PEEK[]<0.%d>)", uid, limit))
+	if err != nil {
+		return nil, err
+	}
+	for _, resp := range responses {
+		if !strings.Contains(resp.Text, " FETCH ") || len(resp.Literals) == 0 {
+			continue
+		}
+		fetched := &imapFetched{UID: uid, Body: resp.Literals[len(resp.Literals)-1]}
+		if m := imapInternalDatePattern.FindStringSubmatch(resp.Text); m != nil {
+			fetched.InternalDate, _ = time.Parse("_2-Jan-2006 15:04:05 -0700", m[1])
+		}
+		return fetched, nil
+	}
+	return nil, fmt.Errorf("message %d not found", uid)
+}
+
+// idle waits up to wait for the server to announce new mail (RFC 2177), or
+// until stop is closed; newMail reports whether an EXISTS arrived
+func (c *imapClient) idle(wait time.Duration, stop <-chan struct{}) (newMail bool, err error) {
+	tag, err := c.send("IDLE")
+	if err != nil {
+		return false, fmt.Errorf("IMAP IDLE: %v", err)
+	}
+	resp, err := c.readResponse()
+	if err != nil {
+		return false, fmt.Errorf("IMAP IDLE: %v", err)
+	}
+	if !strings.HasPrefix(resp.Text, "+") {
+		return false, fmt.Errorf("IMAP IDLE: %s", resp.Text)
+	}
+
+	// A read deadline ends the wait; stopping moves it to now
+	c.conn.SetDeadline(time.Now().Add(wait))
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-stop:
+			c.conn.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	for {
+		resp, err := c.readResponse()
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			break
+		}
+		if err != nil {
+			close(done)
+			return false, fmt.Errorf("IMAP IDLE: %v", err)
+		}
+		if strings.HasPrefix(resp.Text, "* ") && strings.HasSuffix(resp.Text, " EXISTS") {
+			newMail = true
+			break
+		}
+	}
+	close(done)
+
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	if _, err := io.WriteString(c.conn, "DONE\r\n"); err != nil {
+		return newMail, fmt.Errorf("IMAP IDLE: %v", err)
+	}
+	_, err = c.await(tag, "IDLE")
+	return newMail, err
+}
+
+// logout ends the session and closes the connection
+func (c *imapClient) logout() {
+	c.run("LOGOUT")
+	c.conn.Close()
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIMAPAccountsHandlerValidation(t *testing.T) {
+	s := newTestServer(t)
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"missing user", `{"host": "imap.example.com", "password": "secret"}`, "Missing user_email\n"},
+		{"camelCase user ignored", `{"userEmail": "user@example.com", "host": "imap.example.com", "password": "secret"}`, "Missing user_email\n"},
+		{"missing password", `{"user_email": "user@example.com", "host": "imap.example.com"}`, "Missing host or password\n"},
+		{"invalid port", `{"user_email": "user@example.com", "host": "imap.example.com", "port": 70000, "password": "secret"}`, "Invalid port\n"},
+		{"invalid tls", `{"user_email": "user@example.com", "host": "imap.example.com", "tls": "ssl", "password": "secret"}`, "Invalid tls parameter (use tls, starttls, or none)\n"},
+		{"plain text to a remote host", `{"user_email": "user@example.com", "host": "imap.example.com", "tls": "none", "password": "secret"}`, "Plain-text IMAP is only allowed to localhost\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.imapAccountsHandler(rec, httptest.NewRequest(http.MethodPost, "/imap/accounts", strings.NewReader(tt.body)))
+			if rec.Code != http.StatusBadRequest || rec.Body.String() != tt.want {
+				t.Errorf("got %d %q, want 400 %q", rec.Code, rec.Body, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// defaultJanitorInterval is used when JANITOR_INTERVAL is unset
+const defaultJanitorInterval = 5 * time.Minute
+
+// janitorInterval returns how often runJanitor sweeps, from JANITOR_INTERVAL
+// Accepts Go durations ("30s") or plain seconds ("30").
+func janitorInterval() time.Duration {
+	value := strings.TrimSpace(os.Getenv("JANITOR_INTERVAL"))
+	if value == "" {
+		return defaultJanitorInterval
+	}
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return d
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	log.Printf("Invalid JANITOR_INTERVAL %q, using %v", value, defaultJanitorInterval)
+	return defaultJanitorInterval
+}
+
+// sweepResult counts what one sweep removed
+type sweepResult struct {
+	loginStates   int
+	services      int
+	events        int
+	watchWarnings int
+	sheetsKeys    int
+}
+
+// runJanitor sweeps expired state and renews expiring Graph subscriptions
+// every interval until stop is closed
+func (s *Server) runJanitor(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			result := s.sweep(time.Now())
+			if result != (sweepResult{}) {
+				log.Printf("Janitor removed %d OAuth states, %d Gmail services, %d buffered events, %d watch warnings, %d spreadsheet index entries",
+					result.loginStates, result.services, result.events, result.watchWarnings, result.sheetsKeys)
+			}
+			if renewed := s.renewGraphSubscriptions(time.Now()); renewed > 0 {
+				log.Printf("Janitor renewed %d Graph subscriptions", renewed)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// sweep removes expired OAuth state, cached services whose token was deleted
+// or replaced, events past the replay window, warnings for watches no longer
+// tracked, and spreadsheet index entries past sheetsIndexRetention
+func (s *Server) sweep(now time.Time) sweepResult {
+	var result sweepResult
+
+	s.loginStates.Lock()
+	for key, pending := range s.loginStates.pending {
+		if now.Sub(pending.created) > loginStateTTL {
+			delete(s.loginStates.pending, key)
+			result.loginStates++
+		}
+	}
+	s.loginStates.Unlock()
+
+	// Cached services are Gmail clients, built from the users' Gmail tokens
+	s.tokenStore.RLock()
+	tokens := make(map[string]*oauth2.Token, len(s.tokenStore.tokens))
+	for key, token := range s.tokenStore.tokens {
+		if key.Provider == providerGmail {
+			tokens[key.Email] = token
+		}
+	}
+	s.tokenStore.RUnlock()
+
+	s.serviceCache.Lock()
+	for email, cached := range s.serviceCache.entries {
+		if token, ok := tokens[email]; !ok || token != cached.token {
+			delete(s.serviceCache.entries, email)
+			result.services++
+		}
+	}
+	s.serviceCache.Unlock()
+
+	s.events.Lock()
+	before := len(s.events.recent)
+	s.events.pruneLocked(now)
+	result.events = before - len(s.events.recent)
+	s.events.Unlock()
+
+	s.watchStore.Lock()
+	for key := range s.watchStore.warned {
+		if _, ok := s.watchStore.expirations[key]; !ok {
+			delete(s.watchStore.warned, key)
+			result.watchWarnings++
+		}
+	}
+	s.watchStore.Unlock()
+
+	// The index is read on first use; until then there is nothing to prune
+	s.sheets.Lock()
+	if s.sheets.loaded {
+		result.sheetsKeys = s.pruneSheetsIndexLocked(now.Add(-sheetsIndexRetention))
+		if result.sheetsKeys > 0 {
+			s.saveSheetsIndexLocked()
+		}
+	}
+	s.sheets.Unlock()
+
+	return result
+}
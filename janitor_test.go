@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSweepRemovesExpiredState(t *testing.T) {
+	s := newTestServer(t)
+	now := time.Now()
+
+	s.loginStates.pending["expired"] = pendingLogin{created: now.Add(-loginStateTTL - time.Minute)}
+	s.loginStates.pending["fresh"] = pendingLogin{created: now}
+
+	s.sheets.Lock()
+	s.loadSheetsIndexLocked()
+	s.markSheetsAppendedLocked(sheetsKey("user@example.com", "old"), now.Add(-sheetsIndexRetention-time.Hour))
+	s.markSheetsAppendedLocked(sheetsKey("user@example.com", "new"), now.Add(-time.Hour))
+	s.sheets.Unlock()
+
+	result := s.sweep(now)
+	if result.loginStates != 1 || result.sheetsKeys != 1 {
+		t.Fatalf("sweep = %+v, want one OAuth state and one spreadsheet entry removed", result)
+	}
+	if _, ok := s.loginStates.pending["fresh"]; !ok || len(s.loginStates.pending) != 1 {
+		t.Errorf("login states = %v, want only the fresh one", s.loginStates.pending)
+	}
+	if _, ok := s.sheets.appended[sheetsKey("user@example.com", "new")]; !ok || len(s.sheets.appended) != 1 {
+		t.Errorf("spreadsheet index = %v, want only the new message", s.sheets.appended)
+	}
+
+	// The pruned index is saved
+	data, err := os.ReadFile(sheetsIndexPath())
+	if err != nil {
+		t.Fatalf("read index: %v", err)
+	}
+	var saved []sheetsIndexEntry
+	if err := json.Unmarshal(data, &saved); err != nil || len(saved) != 1 || saved[0].Key != sheetsKey("user@example.com", "new") {
+		t.Errorf("saved index = %s (%v), want only the new message", data, err)
+	}
+}
+
+func TestRunJanitor(t *testing.T) {
+	s := newTestServer(t)
+	s.loginStates.Lock()
+	s.loginStates.pending["expired"] = pendingLogin{created: time.Now().Add(-loginStateTTL - time.Minute)}
+	s.loginStates.Unlock()
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.runJanitor(10*time.Millisecond, stop)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		s.loginStates.Lock()
+		remaining := len(s.loginStates.pending)
+		s.loginStates.Unlock()
+		if remaining == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expired OAuth state was not swept")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("runJanitor did not stop")
+	}
+}
+
+func TestLoadSheetsIndexWithoutTimes(t *testing.T) {
+	s := newTestServer(t)
+	if err := os.WriteFile(sheetsIndexPath(), []byte(`["user@example.com/m1","user@example.com/m2"]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s.sheets.Lock()
+	s.loadSheetsIndexLocked()
+	s.sheets.Unlock()
+	if len(s.sheets.order) != 2 || s.sheets.appended["user@example.com/m1"].IsZero() {
+		t.Errorf("index = %v, want both keys kept from now", s.sheets.appended)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// gmailMaxResults is the largest page Gmail's list calls return
+const gmailMaxResults = 500
+
+// maxResultLimit returns the largest accepted limit parameter, read from
+// MAX_RESULT_LIMIT and never above gmailMaxResults
+func maxResultLimit() int {
+	value := strings.TrimSpace(os.Getenv("MAX_RESULT_LIMIT"))
+	if value == "" {
+		return gmailMaxResults
+	}
+	if n, err := strconv.Atoi(value); err == nil && n > 0 {
+		return min(n, gmailMaxResults)
+	}
+	log.Printf("Invalid MAX_RESULT_LIMIT %q, using %d", value, gmailMaxResults)
+	return gmailMaxResults
+}
+
+// defaultResultLimit returns the page size used without a limit parameter, read
+// from DEFAULT_RESULT_LIMIT and capped at maxResultLimit
+func defaultResultLimit() int {
+	limit := maxResultLimit()
+	value := strings.TrimSpace(os.Getenv("DEFAULT_RESULT_LIMIT"))
+	if value == "" {
+		return limit
+	}
+	if n, err := strconv.Atoi(value); err == nil && n > 0 {
+		return min(n, limit)
+	}
+	log.Printf("Invalid DEFAULT_RESULT_LIMIT %q, using %d", value, limit)
+	return limit
+}
+
+// resultLimit reads the limit parameter of a list request
+// Missing limits use defaultResultLimit; non-positive or over-cap values are an error.
+func resultLimit(r *http.Request) (int, error) {
+	value := strings.TrimSpace(r.FormValue("limit"))
+	if value == "" {
+		return defaultResultLimit(), nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("limit must be a positive integer")
+	}
+	if maxLimit := maxResultLimit(); n > maxLimit {
+		return 0, fmt.Errorf("limit must be at most %d", maxLimit)
+	}
+	return n, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResultLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		defaultLim string // DEFAULT_RESULT_LIMIT
+		maxLim     string // MAX_RESULT_LIMIT
+		limit      string
+		want       int
+		wantErr    bool
+	}{
+		{"default", "", "", "", 500, false},
+		{"configured default", "50", "", "", 50, false},
+		{"default capped at max", "200", "100", "", 100, false},
+		{"invalid default", "lots", "", "", 500, false},
+		{"custom", "", "", "20", 20, false},
+		{"at cap", "", "100", "100", 100, false},
+		{"over cap", "", "100", "101", 0, true},
+		{"max never above gmail", "", "10000", "501", 0, true},
+		{"absurd", "", "", "1000000000", 0, true},
+		{"zero", "", "", "0", 0, true},
+		{"negative", "", "", "-5", 0, true},
+		{"not a number", "", "", "ten", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DEFAULT_RESULT_LIMIT", tt.defaultLim)
+			t.Setenv("MAX_RESULT_LIMIT", tt.maxLim)
+			captureLog(t)
+			got, err := resultLimit(httptest.NewRequest(http.MethodGet, "/emails/summary?limit="+tt.limit, nil))
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("resultLimit = %d, %v; want %d (error %v)", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSummaryLimit(t *testing.T) {
+	t.Setenv("DEFAULT_RESULT_LIMIT", "")
+	t.Setenv("MAX_RESULT_LIMIT", "100")
+	s := newTestServer(t)
+	fake := newFakeGmail(t, "user@example.com")
+	fake.use(s)
+	authenticate(s, "user@example.com")
+
+	tests := []struct {
+		name       string
+		query      string
+		want       int
+		maxResults string // maxResults sent to messages.list
+	}{
+		{"default", "", http.StatusOK, "100"},
+		{"custom", "&limit=25", http.StatusOK, "25"},
+		{"over cap", "&limit=101", http.StatusBadRequest, ""},
+		{"negative", "&limit=-1", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(fake.calls())
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/emails/summary?userEmail=user@example.com"+tt.query, nil))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			if tt.want != http.StatusOK {
+				if calls := fake.calls(); len(calls) != before {
+					t.Errorf("Gmail calls %v after a rejected limit", calls[before:])
+				}
+				return
+			}
+			if got := fake.query(http.MethodGet, "/gmail/v1/users/me/messages").Get("maxResults"); got != tt.maxResults {
+				t.Errorf("maxResults = %q, want %q", got, tt.maxResults)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/transactions?userEmail=user@example.com&limit=101", nil))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "at most 100") {
+		t.Errorf("/transactions over-cap = %d %q, want 400", rec.Code, rec.Body)
+	}
+}
@@ -1,60 +1,101 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
 	"log"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"regexp"
 	"strconv"
 	"strings"
-	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
 
 	"github.com/joho/godotenv"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
 	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
-)
-
-// Global in-memory stores
-var (
-	tokenStore = struct {
-		sync.RWMutex
-		tokens map[string]*oauth2.Token
-	}{tokens: make(map[string]*oauth2.Token)}
-
-	historyStore = struct {
-		sync.RWMutex
-		history map[string]uint64
-	}{history: make(map[string]uint64)}
-
-	oauthConfig *oauth2.Config
+	"google.golang.org/api/sheets/v4"
 )
 
 func main() {
 	// Load .env file if it exists (ignore error if file doesn't exist)
 	_ = godotenv.Load()
 
-	var err error
-	oauthConfig, err = loadConfig()
+	config, err := loadConfig()
 	if err != nil {
 		log.Fatalf("Unable to load OAuth config: %v", err)
 	}
 
-	http.HandleFunc("/auth-url", authURLHandler)
-	http.HandleFunc("/oauth2/callback", oauth2CallbackHandler)
-	http.HandleFunc("/emails/summary", emailSummaryHandler)
-	http.HandleFunc("/watch/start", watchStartHandler)
-	http.HandleFunc("/gmail/push", gmailPushHandler)
+	// Optional user-defined parser rules; an invalid file is fatal at startup
+	if parserRulesFilePath() != "" {
+		count, categories, err := reloadParserRules()
+		if err != nil {
+			log.Fatalf("Unable to load parser rules: %v", err)
+		}
+		log.Printf("Loaded %d parser rule groups and %d merchant categories from %s", count, categories, parserRulesFilePath())
+	}
+
+	server := NewServer(config)
+	store, err := transactionStoreFromEnv()
+	if err != nil {
+		log.Fatalf("Unable to open transaction store: %v", err)
+	}
+	server.transactions = store
+
+	// Background loops run until shutdown: watch expiry warnings, the janitor and IMAP polling
+	stop := make(chan struct{})
+	go server.monitorWatchExpirations(stop)
+	go server.runJanitor(janitorInterval(), stop)
+	go server.runIMAPPollers(stop)
+	if minutes, ok := digestClock(); ok {
+		go server.runDigests(minutes, stop)
+	}
+
+	if debugEndpointsEnabled() {
+		log.Println("Debug endpoints enabled")
+	}
+
+	httpServer := &http.Server{Addr: ":8080", Handler: server.Handler()}
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		sig := <-signals
+		log.Printf("Received %v, shutting down", sig)
+		close(stop)
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := httpServer.Shutdown(ctx); err != nil {
+			log.Printf("Shutdown did not finish cleanly: %v", err)
+		}
+	}()
 
 	log.Println("Server started at :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	log.Println("Server stopped")
 }
 
+// shutdownTimeout bounds how long in-flight requests get to finish on shutdown
+const shutdownTimeout = 10 * time.Second
+
 // loadConfig reads credentials.json and builds oauth2.Config
 func loadConfig() (*oauth2.Config, error) {
 	b, err := os.ReadFile("credentials.json")
@@ -66,7 +107,12 @@ func loadConfig() (*oauth2.Config, error) {
 	// - Reading full email messages (including body)
 	// - Using query parameters (q parameter)
 	// - Watch functionality for push notifications
-	config, err := google.ConfigFromJSON(b, gmail.GmailReadonlyScope)
+	// The spreadsheets scope is added only when a spreadsheet is configured.
+	scopes := []string{gmail.GmailReadonlyScope}
+	if sheetsEnabled() {
+		scopes = append(scopes, sheets.SpreadsheetsScope)
+	}
+	config, err := google.ConfigFromJSON(b, scopes...)
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse client secret file to config: %v", err)
 	}
@@ -76,8 +122,46 @@ func loadConfig() (*oauth2.Config, error) {
 }
 
 // getGmailService creates an authenticated Gmail service client
-func getGmailService(ctx context.Context, token *oauth2.Token) (*gmail.Service, error) {
-	client := oauthConfig.Client(ctx, token)
+func (s *Server) getGmailService(ctx context.Context, token *oauth2.Token) (*gmail.Service, error) {
+	return s.gmailServiceFactory(ctx, token)
+}
+
+// cachedGmailService is a Gmail service and the token it was built with
+type cachedGmailService struct {
+	token   *oauth2.Token
+	service *gmail.Service
+}
+
+// getUserGmailService returns the cached Gmail service for a user, building a
+// new one when none is cached or the stored token has been replaced
+// Per-call deadlines come from .Context(ctx) on each Gmail call, so the
+// service itself is built with a background context that outlives requests.
+func (s *Server) getUserGmailService(userEmail string, token *oauth2.Token) (*gmail.Service, error) {
+	s.serviceCache.Lock()
+	defer s.serviceCache.Unlock()
+
+	if cached, ok := s.serviceCache.entries[userEmail]; ok && cached.token == token {
+		return cached.service, nil
+	}
+
+	srv, err := s.getGmailService(context.Background(), token)
+	if err != nil {
+		return nil, err
+	}
+	s.serviceCache.entries[userEmail] = cachedGmailService{token: token, service: srv}
+	return srv, nil
+}
+
+// invalidateGmailService drops a user's cached Gmail service
+func (s *Server) invalidateGmailService(userEmail string) {
+	s.serviceCache.Lock()
+	delete(s.serviceCache.entries, userEmail)
+	s.serviceCache.Unlock()
+}
+
+// newGmailService creates a Gmail service client backed by the real Gmail API
+func (s *Server) newGmailService(ctx context.Context, token *oauth2.Token) (*gmail.Service, error) {
+	client := s.oauthConfig.Client(ctx, token)
 	srv, err := gmail.NewService(ctx, option.WithHTTPClient(client))
 	if err != nil {
 		return nil, fmt.Errorf("unable to retrieve Gmail client: %v", err)
@@ -85,32 +169,154 @@ func getGmailService(ctx context.Context, token *oauth2.Token) (*gmail.Service,
 	return srv, nil
 }
 
+// defaultGmailTimeout bounds each request's Gmail calls when GMAIL_TIMEOUT is not set
+const defaultGmailTimeout = 15 * time.Second
+
+// gmailTimeout returns the per-request Gmail deadline from GMAIL_TIMEOUT
+// Accepts Go durations ("30s") or plain seconds ("30").
+func gmailTimeout() time.Duration {
+	value := strings.TrimSpace(os.Getenv("GMAIL_TIMEOUT"))
+	if value == "" {
+		return defaultGmailTimeout
+	}
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return d
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	log.Printf("Invalid GMAIL_TIMEOUT %q, using %v", value, defaultGmailTimeout)
+	return defaultGmailTimeout
+}
+
+// gmailContext derives a context for a request's Gmail calls bounded by gmailTimeout
+func gmailContext(r *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), gmailTimeout())
+}
+
+// gmailErrorStatus maps a Gmail call error to an HTTP status,
+// returning 504 when the request deadline was exceeded
+func gmailErrorStatus(err error) int {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
 // getUserEmail retrieves the user's email address from Gmail profile
-func getUserEmail(service *gmail.Service) (string, error) {
-	userProfile, err := service.Users.GetProfile("me").Do()
+func getUserEmail(ctx context.Context, service *gmail.Service) (string, error) {
+	userProfile, err := service.Users.GetProfile("me").Context(ctx).Do()
 	if err != nil {
 		return "", fmt.Errorf("unable to get user profile: %v", err)
 	}
 	return userProfile.EmailAddress, nil
 }
 
-// extractEmailBody extracts the email body text from a Gmail message payload
+// userID returns the Gmail user ID used for a user's API calls
+// OAuth users are addressed as "me"; addresses listed in DELEGATED_MAILBOXES
+// (comma-separated) are addressed explicitly, as required for domain-wide delegation.
+func userID(email string) string {
+	for _, delegated := range strings.Split(os.Getenv("DELEGATED_MAILBOXES"), ",") {
+		if delegated = strings.TrimSpace(delegated); delegated != "" && strings.EqualFold(delegated, email) {
+			return delegated
+		}
+	}
+	return "me"
+}
+
+// maxFetchedBodyBytes caps the size of body parts fetched via AttachmentId
+const maxFetchedBodyBytes = 1 << 20
+
+// defaultMaxBodyBytes is the decoded body cap used when MAX_BODY_BYTES is unset
+const defaultMaxBodyBytes = 512 << 10
+
+// maxBodyBytes returns the cap on each decoded body returned by extractEmailBody,
+// read from MAX_BODY_BYTES; larger bodies are truncated and flagged
+func maxBodyBytes() int {
+	value := strings.TrimSpace(os.Getenv("MAX_BODY_BYTES"))
+	if value == "" {
+		return defaultMaxBodyBytes
+	}
+	if n, err := strconv.Atoi(value); err == nil && n > 0 {
+		return n
+	}
+	log.Printf("Invalid MAX_BODY_BYTES %q, using %d", value, defaultMaxBodyBytes)
+	return defaultMaxBodyBytes
+}
+
+// EmailBody holds the decoded content of a message
+type EmailBody struct {
+	PlainText   string           `json:"body_text"`
+	HTML        string           `json:"body_html"`
+	Attachments []AttachmentMeta `json:"attachments"`
+	Truncated   bool             `json:"truncated"`
+}
+
+// AttachmentMeta describes an attachment without its content
+type AttachmentMeta struct {
+	Filename     string `json:"filename"`
+	MimeType     string `json:"mime_type"`
+	Size         int64  `json:"size"`
+	AttachmentID string `json:"attachment_id"`
+}
+
+// Best returns the plain text body if present, otherwise the HTML body
+func (b EmailBody) Best() string {
+	if b.PlainText != "" {
+		return b.PlainText
+	}
+	return b.HTML
+}
+
+// extractEmailBody extracts the email body from a Gmail message payload
 // Handles both simple and multipart messages (including nested multipart)
-func extractEmailBody(payload *gmail.MessagePart) string {
+func extractEmailBody(payload *gmail.MessagePart) EmailBody {
+	return extractEmailBodyWithFetch(context.Background(), nil, "me", "", payload)
+}
+
+// extractEmailBodyWithFetch extracts the email body like extractEmailBody, and
+// additionally fetches text parts that Gmail stores out of line (Body.AttachmentId)
+// If service is nil, out-of-line parts are skipped.
+func extractEmailBodyWithFetch(ctx context.Context, service *gmail.Service, mailbox, msgID string, payload *gmail.MessagePart) EmailBody {
 	var plainTextBody, htmlBody string
+	attachments := []AttachmentMeta{}
 
 	// Helper function to recursively extract body from parts
 	var extractFromPart func(part *gmail.MessagePart)
 	extractFromPart = func(part *gmail.MessagePart) {
-		if part == nil {
+		// Embedded messages are extracted separately by extractForwardedMessage
+		if part == nil || part.MimeType == "message/rfc822" {
+			return
+		}
+
+		// Parts with a filename are attachments, not body text
+		if part.Filename != "" {
+			meta := AttachmentMeta{Filename: part.Filename, MimeType: part.MimeType}
+			if part.Body != nil {
+				meta.Size = part.Body.Size
+				meta.AttachmentID = part.Body.AttachmentId
+			}
+			attachments = append(attachments, meta)
 			return
 		}
 
+		// Long bodies are not inlined; fetch them by attachment ID
+		bodyData := ""
+		if part.Body != nil {
+			bodyData = part.Body.Data
+			if bodyData == "" && part.Body.AttachmentId != "" && service != nil {
+				bodyData = fetchBodyPart(ctx, service, mailbox, msgID, part, plainTextBody, htmlBody)
+			}
+		}
+
 		// If this part has a body, extract it
-		if part.Body != nil && part.Body.Data != "" {
-			data, err := base64.URLEncoding.DecodeString(part.Body.Data)
-			if err == nil {
-				content := string(data)
+		if bodyData != "" {
+			data, err := decodeBase64Flexible(bodyData)
+			if err != nil {
+				// Leave this part out so another MIME alternative can be used
+				log.Printf("Unable to decode %s part of message %s: %v", part.MimeType, msgID, err)
+			} else {
+				content := decodeCharset(data, partCharset(part))
 				switch part.MimeType {
 				case "text/plain":
 					if plainTextBody == "" {
@@ -135,228 +341,611 @@ func extractEmailBody(payload *gmail.MessagePart) string {
 	// Start extraction from the root payload
 	extractFromPart(payload)
 
-	// Prefer plain text over HTML
-	if plainTextBody != "" {
-		return plainTextBody
+	body := EmailBody{Attachments: attachments}
+	var plainTruncated, htmlTruncated bool
+	limit := maxBodyBytes()
+	body.PlainText, plainTruncated = truncateUTF8(plainTextBody, limit)
+	body.HTML, htmlTruncated = truncateUTF8(htmlBody, limit)
+	body.Truncated = plainTruncated || htmlTruncated
+	return body
+}
+
+// truncateUTF8 cuts s to at most limit bytes without splitting a UTF-8 sequence
+// Returns the result and whether anything was removed.
+func truncateUTF8(s string, limit int) (string, bool) {
+	if limit <= 0 || len(s) <= limit {
+		return s, false
+	}
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut], true
+}
+
+// base64Encodings lists the base64 variants tried by decodeBase64Flexible, in order
+var base64Encodings = []struct {
+	name string
+	enc  *base64.Encoding
+}{
+	{"url", base64.URLEncoding},
+	{"raw url", base64.RawURLEncoding},
+	{"std", base64.StdEncoding},
+	{"raw std", base64.RawStdEncoding},
+}
+
+// decodeBase64Flexible decodes base64 in the URL or standard alphabet, with or
+// without padding. Gmail body data and push payloads should be padded base64url
+// and base64 respectively, but some providers deviate.
+func decodeBase64Flexible(data string) ([]byte, error) {
+	var firstErr error
+	for i, e := range base64Encodings {
+		decoded, err := e.enc.DecodeString(data)
+		if err == nil {
+			if i > 0 {
+				debugf("Decoded data using %s base64 fallback", e.name)
+			}
+			return decoded, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, fmt.Errorf("unable to decode base64 data: %v", firstErr)
+}
+
+// fetchBodyPart downloads an out-of-line text body part and returns its base64url data
+// Parts that are not text, already covered, or larger than maxFetchedBodyBytes are skipped.
+func fetchBodyPart(ctx context.Context, service *gmail.Service, mailbox, msgID string, part *gmail.MessagePart, plainTextBody, htmlBody string) string {
+	switch {
+	case part.MimeType == "text/plain" && plainTextBody == "":
+	case part.MimeType == "text/html" && htmlBody == "":
+	default:
+		return ""
+	}
+
+	return fetchAttachmentData(ctx, service, mailbox, msgID, part)
+}
+
+// fetchAttachmentData downloads the base64url data of an out-of-line part
+// Parts larger than maxFetchedBodyBytes are skipped.
+func fetchAttachmentData(ctx context.Context, service *gmail.Service, mailbox, msgID string, part *gmail.MessagePart) string {
+	if part.Body.Size > maxFetchedBodyBytes {
+		log.Printf("Skipping body part of message %s: %d bytes exceeds limit", msgID, part.Body.Size)
+		return ""
+	}
+
+	attachment, err := service.Users.Messages.Attachments.Get(mailbox, msgID, part.Body.AttachmentId).Context(ctx).Do()
+	if err != nil {
+		log.Printf("Unable to fetch body part of message %s: %v", msgID, err)
+		return ""
 	}
-	return htmlBody
+	return attachment.Data
 }
 
-// authURLHandler generates and returns the Google OAuth consent URL
-func authURLHandler(w http.ResponseWriter, r *http.Request) {
-	authURL := oauthConfig.AuthCodeURL("state-token", oauth2.AccessTypeOffline, oauth2.ApprovalForce)
-	log.Printf("Visit the URL for the auth dialog: %v", authURL)
+// partCharset returns the charset parameter from a part's Content-Type header, if any
+func partCharset(part *gmail.MessagePart) string {
+	for _, h := range part.Headers {
+		if !strings.EqualFold(h.Name, "Content-Type") {
+			continue
+		}
+		_, params, err := mime.ParseMediaType(h.Value)
+		if err != nil {
+			return ""
+		}
+		return params["charset"]
+	}
+	return ""
+}
+
+// decodeCharset converts body bytes in the given charset to a UTF-8 string
+// UTF-16 bodies with a byte order mark are detected regardless of the declared charset.
+// Unknown charsets fall back to the raw bytes. Invalid sequences in the result
+// are replaced with U+FFFD, so bodies always encode cleanly as JSON.
+func decodeCharset(data []byte, charset string) string {
+	if bytes.HasPrefix(data, []byte{0xFF, 0xFE}) || bytes.HasPrefix(data, []byte{0xFE, 0xFF}) {
+		decoder := unicode.BOMOverride(unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewDecoder())
+		if decoded, _, err := transform.Bytes(decoder, data); err == nil {
+			return validUTF8(string(decoded))
+		}
+	}
+
+	charset = strings.TrimSpace(charset)
+	if charset == "" || strings.EqualFold(charset, "utf-8") || strings.EqualFold(charset, "us-ascii") {
+		return validUTF8(string(data))
+	}
+
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		log.Printf("Unknown charset %q, using raw body", charset)
+		return validUTF8(string(data))
+	}
+	decoded, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		log.Printf("Unable to decode body from charset %q: %v", charset, err)
+		return validUTF8(string(data))
+	}
+	return validUTF8(string(decoded))
+}
+
+// validUTF8 replaces each run of invalid UTF-8 in s with U+FFFD
+func validUTF8(s string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+	return strings.ToValidUTF8(s, "\uFFFD")
+}
+
+// encodeRedirectState appends a redirect target to the OAuth state value
+func encodeRedirectState(state, redirect string) string {
+	return state + ":" + base64.RawURLEncoding.EncodeToString([]byte(redirect))
+}
+
+// decodeRedirectState extracts the redirect target carried in the OAuth state, if any
+func decodeRedirectState(state string) string {
+	parts := strings.SplitN(state, ":", 2)
+	if len(parts) != 2 {
+		return ""
+	}
+	redirect, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+	return string(redirect)
+}
+
+// postAuthRedirect returns the validated redirect target for a completed login
+// A redirect carried in the state takes precedence over postAuthRedirectURL.
+// Returns empty string when no redirect is configured or the target is not allowed.
+func postAuthRedirect(state string) string {
+	redirect := decodeRedirectState(state)
+	if redirect == "" {
+		redirect = postAuthRedirectURL()
+	}
+	if redirect == "" {
+		return ""
+	}
+	if !isAllowedRedirect(redirect) {
+		log.Printf("Rejected post-auth redirect to %s: not in allowlist", redirect)
+		return ""
+	}
+	return redirect
+}
+
+// postAuthRedirectURL returns the default frontend URL from POST_AUTH_REDIRECT_URL,
+// or its shorter alias POST_AUTH_REDIRECT
+func postAuthRedirectURL() string {
+	if redirect := os.Getenv("POST_AUTH_REDIRECT_URL"); redirect != "" {
+		return redirect
+	}
+	return os.Getenv("POST_AUTH_REDIRECT")
+}
+
+// isAllowedRedirect checks a redirect target against the allowlisted hosts
+// The host of postAuthRedirectURL is always allowed; additional hosts come
+// from the comma-separated POST_AUTH_REDIRECT_ALLOWLIST.
+func isAllowedRedirect(target string) bool {
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return false
+	}
+
+	allowed := strings.Split(os.Getenv("POST_AUTH_REDIRECT_ALLOWLIST"), ",")
+	if configured, err := url.Parse(postAuthRedirectURL()); err == nil && configured.Host != "" {
+		allowed = append(allowed, configured.Host)
+	}
+	for _, host := range allowed {
+		host = strings.TrimSpace(host)
+		if host != "" && strings.EqualFold(host, u.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+// appendQueryParams adds query parameters to a URL
+func appendQueryParams(rawURL string, params url.Values) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %q: %v", rawURL, err)
+	}
+	q := u.Query()
+	for key, values := range params {
+		q[key] = values
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// authURLHandler generates and returns the OAuth consent URL for ?provider=
+// (gmail, the default, or outlook when configured)
+func (s *Server) authURLHandler(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r.Context())
+
+	name := r.URL.Query().Get("provider")
+	if name == "" {
+		name = providerGmail
+	}
+	provider, ok := s.provider(name)
+	oauth, signsIn := provider.(oauthProvider)
+	if !ok || !signsIn {
+		http.Error(w, "Unknown or unconfigured provider", http.StatusBadRequest)
+		return
+	}
+
+	// Each login gets its own state, checked by the callback
+	state, err := newLoginState(provider.Name())
+	if err != nil {
+		logger.Printf("%v", err)
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	// Optional post-auth redirect is carried through the OAuth state
+	if redirect := r.URL.Query().Get("redirect"); redirect != "" {
+		if !isAllowedRedirect(redirect) {
+			http.Error(w, "Redirect target not allowed", http.StatusBadRequest)
+			return
+		}
+		state = encodeRedirectState(state, redirect)
+	}
+
+	authURL := oauth.AuthCodeURL(state, s.beginLogin(state)...)
+	logger.Printf("Visit the URL for the auth dialog: %v", authURL)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"auth_url": authURL})
 }
 
-// oauth2CallbackHandler handles Google redirect after user approves access
-func oauth2CallbackHandler(w http.ResponseWriter, r *http.Request) {
+// oauth2CallbackHandler handles the provider's redirect after user approves access
+// The provider is the one named in the state by authURLHandler.
+func (s *Server) oauth2CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r.Context())
+
 	code := r.URL.Query().Get("code")
 	if code == "" {
-		http.Error(w, "Missing authorization code", http.StatusBadRequest)
+		// The provider reports a declined consent screen as ?error=access_denied
+		reason := "missing_code"
+		if providerErr := r.URL.Query().Get("error"); providerErr != "" {
+			reason = "provider_error"
+			if oauthErrorPattern.MatchString(providerErr) {
+				reason = providerErr
+			}
+		}
+		authFailed(w, r, reason, "Missing authorization code", http.StatusBadRequest)
 		return
 	}
 
-	ctx := context.Background()
-	token, err := oauthConfig.Exchange(ctx, code)
-	if err != nil {
-		log.Printf("Unable to retrieve token: %v", err)
-		http.Error(w, "Failed to exchange token", http.StatusInternalServerError)
+	// Only states issued by /auth-url are accepted, each once, so forged
+	// callbacks are turned away before any token or session is issued
+	login, ok := s.takeLogin(r.URL.Query().Get("state"))
+	if !ok {
+		authFailed(w, r, "invalid_state", "Invalid or expired OAuth state", http.StatusBadRequest)
+		return
+	}
+	var opts []oauth2.AuthCodeOption
+	if login.verifier != "" {
+		opts = append(opts, oauth2.VerifierOption(login.verifier))
+	}
+
+	provider, ok := s.provider(stateProvider(r.URL.Query().Get("state")))
+	oauth, signsIn := provider.(oauthProvider)
+	if !ok || !signsIn {
+		authFailed(w, r, "invalid_state", "Invalid or expired OAuth state", http.StatusBadRequest)
 		return
 	}
 
-	// Get Gmail service to retrieve user email
-	srv, err := getGmailService(ctx, token)
+	ctx, cancel := gmailContext(r)
+	defer cancel()
+	token, err := oauth.Exchange(ctx, code, opts...)
 	if err != nil {
-		log.Printf("Unable to create Gmail service: %v", err)
-		http.Error(w, "Failed to create Gmail service", http.StatusInternalServerError)
+		logger.Printf("Unable to retrieve token: %v", err)
+		authFailed(w, r, "exchange_failed", "Failed to exchange token", gmailErrorStatus(err))
 		return
 	}
 
-	userEmail, err := getUserEmail(srv)
+	userEmail, err := oauth.UserEmail(ctx, token)
 	if err != nil {
-		log.Printf("Unable to get user email: %v", err)
-		http.Error(w, "Failed to get user email", http.StatusInternalServerError)
+		logger.Printf("Unable to get user email: %v", err)
+		authFailed(w, r, "profile_failed", "Failed to get user email", gmailErrorStatus(err))
 		return
 	}
 
-	// Store tokens keyed by email
-	tokenStore.Lock()
-	tokenStore.tokens[userEmail] = token
-	tokenStore.Unlock()
+	// Store tokens keyed by the provider that issued them and the email
+	s.storeToken(provider.Name(), userEmail, token)
+	if provider.Name() == providerGmail {
+		s.invalidateGmailService(userEmail)
+	}
+	// Browser clients are signed in, so later requests needn't name the user
+	setSessionCookie(w, userEmail)
 
 	// Log authentication details
-	log.Printf("User authenticated: %s", userEmail)
-	log.Printf("Access token: %s...", token.AccessToken[:min(20, len(token.AccessToken))])
+	logger.Printf("User authenticated: %s (%s)", userEmail, provider.Name())
+	logger.Printf("Access token: %s...", token.AccessToken[:min(20, len(token.AccessToken))])
 	if token.RefreshToken != "" {
-		log.Printf("Refresh token: present")
+		logger.Printf("Refresh token: present")
 	} else {
-		log.Printf("Refresh token: not present")
+		logger.Printf("Refresh token: not present")
 	}
 	if token.Expiry.IsZero() {
-		log.Printf("Token expiry: not set")
+		logger.Printf("Token expiry: not set")
 	} else {
-		log.Printf("Token expiry: %v", token.Expiry)
+		logger.Printf("Token expiry: %v", token.Expiry)
 	}
 
-	w.Header().Set("Content-Type", "text/html")
-	fmt.Fprintf(w, "<html><body><h1>Authentication complete</h1><p>User: %s</p><p>You can return to the backend logs.</p></body></html>", userEmail)
-}
-
-// emailSummaryHandler returns count of emails and latest email from last 30 days
-func emailSummaryHandler(w http.ResponseWriter, r *http.Request) {
-	userEmail := r.URL.Query().Get("userEmail")
-	if userEmail == "" {
-		http.Error(w, "Missing userEmail parameter", http.StatusBadRequest)
-		return
+	// With AUTO_WATCH=true, push notifications start without a separate /watch/start
+	var watch map[string]interface{}
+	if autoWatchEnabled() {
+		watch = s.autoWatch(ctx, logger, provider, userEmail, token)
 	}
 
-	// Retrieve tokens
-	tokenStore.RLock()
-	token, exists := tokenStore.tokens[userEmail]
-	tokenStore.RUnlock()
-	if !exists {
-		http.Error(w, "User not authenticated", http.StatusUnauthorized)
-		return
-	}
-
-	ctx := context.Background()
-	srv, err := getGmailService(ctx, token)
-	if err != nil {
-		log.Printf("Unable to create Gmail service: %v", err)
-		http.Error(w, "Failed to create Gmail service", http.StatusInternalServerError)
+	// API clients can ask for JSON instead of the browser-facing HTML page
+	if wantsJSON(r) {
+		response := map[string]interface{}{
+			"user_email":        userEmail,
+			"provider":          provider.Name(),
+			"has_refresh_token": token.RefreshToken != "",
+		}
+		if watch != nil {
+			response["watch"] = watch
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
 		return
 	}
 
-	// Query emails from last 30 days
-	query := "newer_than:30d"
-	msgs, err := srv.Users.Messages.List("me").Q(query).MaxResults(500).Do()
-	if err != nil {
-		log.Printf("Unable to list messages: %v", err)
-		http.Error(w, "Failed to list messages", http.StatusInternalServerError)
-		return
+	// Send the browser back to the frontend when a redirect is configured; a
+	// signed-in browser has the session, so the email isn't put in the URL
+	if redirect := postAuthRedirect(r.URL.Query().Get("state")); redirect != "" {
+		params := url.Values{"status": {"success"}}
+		if sessionSecret() == "" {
+			params.Set("user_email", userEmail)
+		}
+		if watch != nil {
+			params.Set("watch", watch["status"].(string))
+		}
+		target, err := appendQueryParams(redirect, params)
+		if err != nil {
+			logger.Printf("Unable to build post-auth redirect: %v", err)
+		} else {
+			http.Redirect(w, r, target, http.StatusFound)
+			return
+		}
 	}
 
-	// Count emails (use ResultSizeEstimate if available, otherwise count actual results)
-	count := int64(0)
-	if msgs.ResultSizeEstimate > 0 {
-		count = msgs.ResultSizeEstimate
-	} else {
-		count = int64(len(msgs.Messages))
+	var watchStatus string
+	switch {
+	case watch == nil:
+	case watch["status"] == "watch_started":
+		watchStatus = "<p>Push notifications started.</p>"
+	default:
+		watchStatus = "<p>Push notifications could not be started: " + html.EscapeString(watch["error"].(string)) + "</p>"
 	}
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprintf(w, "<html><body><h1>Authentication complete</h1><p>User: %s</p>%s<p>You can return to the backend logs.</p></body></html>", userEmail, watchStatus)
+}
 
-	var latestEmail map[string]interface{}
-	if len(msgs.Messages) > 0 {
-		// Get the first (latest) message with full format to read email body
-		msgID := msgs.Messages[0].Id
-		msg, err := srv.Users.Messages.Get("me", msgID).Format("full").Do()
-		if err != nil {
-			log.Printf("Unable to get message: %v", err)
-			http.Error(w, "Failed to get message", http.StatusInternalServerError)
+// oauthErrorPattern matches the error codes OAuth providers return (RFC 6749
+// section 4.1.2.1), which are passed on to the frontend as the reason
+var oauthErrorPattern = regexp.MustCompile(`^[a-z_]{1,64}$`)
+
+// authFailed ends a failed OAuth callback: browsers are sent back to the
+// post-auth redirect with status=error and a short reason code, while API
+// clients and setups without a redirect get message with status as before
+func authFailed(w http.ResponseWriter, r *http.Request, reason, message string, status int) {
+	if redirect := postAuthRedirect(r.URL.Query().Get("state")); redirect != "" && !wantsJSON(r) {
+		target, err := appendQueryParams(redirect, url.Values{"status": {"error"}, "reason": {reason}})
+		if err == nil {
+			http.Redirect(w, r, target, http.StatusFound)
 			return
 		}
+		requestLogger(r.Context()).Printf("Unable to build post-auth redirect: %v", err)
+	}
+	http.Error(w, message, status)
+}
 
-		// Extract headers
-		headers := make(map[string]string)
-		for _, h := range msg.Payload.Headers {
-			headers[h.Name] = h.Value
+// wantsJSON reports whether the client asked for a JSON response,
+// either via ?format=json or an Accept header preferring application/json
+func wantsJSON(r *http.Request) bool {
+	if strings.EqualFold(r.URL.Query().Get("format"), "json") {
+		return true
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType := strings.TrimSpace(strings.SplitN(accept, ";", 2)[0])
+		if strings.EqualFold(mediaType, "application/json") {
+			return true
 		}
+	}
+	return false
+}
 
-		// Extract email body
-		body := extractEmailBody(msg.Payload)
+// emailSummaryHandler returns count of emails and latest email from last 30 days
+func (s *Server) emailSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r.Context())
 
-		latestEmail = map[string]interface{}{
-			"id":      msg.Id,
-			"subject": headers["Subject"],
-			"from":    headers["From"],
-			"date":    headers["Date"],
-			"snippet": msg.Snippet,
-			"body":    body,
-		}
+	// Parameters may come from the query string or a form-encoded body
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form body", http.StatusBadRequest)
+		return
 	}
 
-	response := map[string]interface{}{
-		"user_email":         userEmail,
-		"count_last_30_days": count,
-		"latest_email":       latestEmail,
+	userEmail, ok := s.requestUser(w, r, r.FormValue("userEmail"))
+	if !ok {
+		return
+	}
+	if !s.isAuthenticated(userEmail) {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	opts, serr := parseSummaryOptions(r)
+	if serr != nil {
+		http.Error(w, serr.message, serr.status)
+		return
 	}
 
-	// Log the summary
-	log.Printf("Email summary for %s: count=%d", userEmail, count)
-	if latestEmail != nil {
-		log.Printf("Latest email: subject=%s, from=%s", latestEmail["subject"], latestEmail["from"])
+	ctx, cancel := gmailContext(r)
+	defer cancel()
+	response, serr := s.emailSummary(ctx, logger, userEmail, opts)
+	if serr != nil {
+		http.Error(w, serr.message, serr.status)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
-// watchStartHandler sets up Gmail watch for push notifications
-func watchStartHandler(w http.ResponseWriter, r *http.Request) {
-	userEmail := r.URL.Query().Get("userEmail")
-	if userEmail == "" {
-		http.Error(w, "Missing userEmail parameter", http.StatusBadRequest)
+// metadataHeaders are the headers requested when fetching messages in metadata format
+var metadataHeaders = []string{"Subject", "From", "To", "Cc", "Date"}
+
+// isValidMessageFormat reports whether format is a Gmail message format we support
+func isValidMessageFormat(format string) bool {
+	switch format {
+	case "minimal", "metadata", "full":
+		return true
+	}
+	return false
+}
+
+// getMessage fetches a message in the given Gmail format, limiting metadata
+// requests to metadataHeaders
+func getMessage(ctx context.Context, service *gmail.Service, mailbox, msgID, format string) (*gmail.Message, error) {
+	call := service.Users.Messages.Get(mailbox, msgID).Format(format).Context(ctx)
+	if format == "metadata" {
+		call = call.MetadataHeaders(metadataHeaders...)
+	}
+	return call.Do()
+}
+
+// watchStartHandler sets up push notifications for the user's inbox: a Gmail
+// watch, or a Graph subscription for Outlook users
+func (s *Server) watchStartHandler(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r.Context())
+
+	// Parameters may come from the query string or a form-encoded body
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form body", http.StatusBadRequest)
+		return
+	}
+
+	userEmail, ok := s.requestUser(w, r, r.FormValue("userEmail"))
+	if !ok {
+		return
+	}
+	provider, ok := s.requestProvider(w, r, userEmail)
+	if !ok {
 		return
 	}
 
 	// Retrieve tokens
-	tokenStore.RLock()
-	token, exists := tokenStore.tokens[userEmail]
-	tokenStore.RUnlock()
+	token, exists := s.userToken(provider.Name(), userEmail)
 	if !exists {
 		http.Error(w, "User not authenticated", http.StatusUnauthorized)
 		return
 	}
 
-	ctx := context.Background()
-	srv, err := getGmailService(ctx, token)
+	ctx, cancel := gmailContext(r)
+	defer cancel()
+	expiration, err := provider.Watch(ctx, userEmail, token)
+	var configErr *watchConfigError
+	if errors.As(err, &configErr) {
+		// Fail fast on missing configuration rather than letting the provider reject the watch
+		logger.Printf("Invalid %s configuration: %v", configErr.what, configErr.err)
+		http.Error(w, fmt.Sprintf("Invalid %s configuration: %v", configErr.what, configErr.err), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
-		log.Printf("Unable to create Gmail service: %v", err)
-		http.Error(w, "Failed to create Gmail service", http.StatusInternalServerError)
+		logger.Printf("Unable to start watch: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to start watch: %v", err), gmailErrorStatus(err))
 		return
 	}
 
-	// Get project ID from environment or use placeholder
-	projectID := os.Getenv("GOOGLE_CLOUD_PROJECT")
-	if projectID == "" {
-		projectID = "YOUR_PROJECT_ID" // User must set this
-		log.Printf("Warning: GOOGLE_CLOUD_PROJECT not set, using placeholder")
+	// Expirations are reported in milliseconds since the epoch, as Gmail does;
+	// IMAP polling doesn't expire
+	response := map[string]interface{}{
+		"status":   "watch_started",
+		"provider": provider.Name(),
 	}
-
-	topicName := fmt.Sprintf("projects/%s/topics/gmail-notifications", projectID)
-	req := &gmail.WatchRequest{
-		TopicName: topicName,
-		LabelIds:  []string{"INBOX"},
+	if !expiration.IsZero() {
+		response["expiration"] = expiration.UnixMilli()
 	}
-
-	res, err := srv.Users.Watch("me", req).Do()
-	if err != nil {
-		log.Printf("Unable to start watch: %v", err)
-		http.Error(w, fmt.Sprintf("Failed to start watch: %v", err), http.StatusInternalServerError)
-		return
+	if provider.Name() == providerGmail {
+		s.historyStore.RLock()
+		response["history_id"] = s.historyStore.history[accountKey{providerGmail, userEmail}]
+		s.historyStore.RUnlock()
 	}
+	logger.Printf("Watch started for user %s (%s): expiration=%v", userEmail, provider.Name(), expiration)
 
-	// Store history ID
-	historyStore.Lock()
-	historyStore.history[userEmail] = res.HistoryId
-	historyStore.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
 
-	log.Printf("Watch started for user %s: topic=%s, historyId=%d, expiration=%v", userEmail, topicName, res.HistoryId, res.Expiration)
+// placeholderProjectID is the project ID shipped in example configs
+const placeholderProjectID = "YOUR_PROJECT_ID"
 
-	response := map[string]interface{}{
-		"status":     "watch_started",
-		"history_id": res.HistoryId,
-		"expiration": res.Expiration,
+// errProjectNotConfigured is returned when GOOGLE_CLOUD_PROJECT is empty or still the placeholder
+var errProjectNotConfigured = errors.New("GOOGLE_CLOUD_PROJECT is not set; set it to the Google Cloud project ID that owns the Pub/Sub topic")
+
+// projectIDPattern matches the Google Cloud project ID format
+var projectIDPattern = regexp.MustCompile(`^[a-z][a-z0-9-]{4,28}[a-z0-9]$`)
+
+// topicNamePattern matches fully qualified Pub/Sub topic names
+var topicNamePattern = regexp.MustCompile(`^projects/[^/]+/topics/[^/]+$`)
+
+// pubsubTopicName builds the Pub/Sub topic for Gmail watch notifications
+// PUBSUB_TOPIC may be a full "projects/*/topics/*" name or a short topic name
+// combined with GOOGLE_CLOUD_PROJECT (default "gmail-notifications").
+func pubsubTopicName() (string, error) {
+	topic := strings.TrimSpace(os.Getenv("PUBSUB_TOPIC"))
+	if topic == "" {
+		topic = "gmail-notifications"
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	topicName := topic
+	if !strings.HasPrefix(topic, "projects/") {
+		projectID := strings.TrimSpace(os.Getenv("GOOGLE_CLOUD_PROJECT"))
+		if projectID == "" || projectID == placeholderProjectID {
+			return "", errProjectNotConfigured
+		}
+		if !projectIDPattern.MatchString(projectID) {
+			return "", fmt.Errorf("GOOGLE_CLOUD_PROJECT %q is not a valid project ID", projectID)
+		}
+		topicName = fmt.Sprintf("projects/%s/topics/%s", projectID, topic)
+	}
+
+	if !topicNamePattern.MatchString(topicName) {
+		return "", fmt.Errorf("topic %q does not match projects/*/topics/*", topicName)
+	}
+	if strings.HasPrefix(topicName, "projects/"+placeholderProjectID+"/") {
+		return "", errProjectNotConfigured
+	}
+	return topicName, nil
+}
+
+// subscriptionAllowed reports whether a push came from a subscription listed in
+// PUBSUB_SUBSCRIPTIONS (comma-separated full "projects/*/subscriptions/*" names
+// or short names). An empty list allows every subscription.
+func subscriptionAllowed(subscription string) bool {
+	configured := false
+	short := subscription[strings.LastIndex(subscription, "/")+1:]
+	for _, allowed := range strings.Split(os.Getenv("PUBSUB_SUBSCRIPTIONS"), ",") {
+		allowed = strings.TrimSpace(allowed)
+		if allowed == "" {
+			continue
+		}
+		configured = true
+		if allowed == subscription || (!strings.Contains(allowed, "/") && allowed == short) {
+			return true
+		}
+	}
+	return !configured
 }
 
 // gmailPushHandler receives Gmail push notifications via Pub/Sub
-func gmailPushHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) gmailPushHandler(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r.Context())
+
 	// Pub/Sub sends POST requests with JSON body
 	var notification struct {
 		Message struct {
@@ -367,28 +956,32 @@ func gmailPushHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&notification); err != nil {
-		log.Printf("Unable to parse push notification: %v", err)
+		logger.Printf("Unable to parse push notification: %v", err)
 		http.Error(w, "Failed to parse request", http.StatusBadRequest)
 		return
 	}
 
-	// Decode base64 data
-	data, err := base64.StdEncoding.DecodeString(notification.Message.Data)
+	// Pushes from other environments' subscriptions are dropped; they are still
+	// acknowledged, since any error status makes Pub/Sub redeliver them forever
+	if !subscriptionAllowed(notification.Subscription) {
+		logger.Printf("Rejected push %s from subscription %q not in PUBSUB_SUBSCRIPTIONS", notification.Message.MessageID, notification.Subscription)
+		acknowledgePush(w, "rejected_subscription")
+		return
+	}
+
+	// Decode base64 data, tolerating either alphabet and missing padding
+	data, err := decodeBase64Flexible(notification.Message.Data)
 	if err != nil {
-		// Try URL encoding if standard fails
-		data, err = base64.URLEncoding.DecodeString(notification.Message.Data)
-		if err != nil {
-			log.Printf("Unable to decode message data: %v", err)
-			http.Error(w, "Failed to decode message data", http.StatusBadRequest)
-			return
-		}
+		logger.Printf("Unable to decode message data: %v", err)
+		http.Error(w, "Failed to decode message data", http.StatusBadRequest)
+		return
 	}
 
 	// Parse Gmail push notification data
 	// Note: Gmail sends historyId as either a number or string in JSON
 	var pushDataRaw map[string]interface{}
 	if err := json.Unmarshal(data, &pushDataRaw); err != nil {
-		log.Printf("Unable to parse push data: %v", err)
+		logger.Printf("Unable to parse push data: %v", err)
 		http.Error(w, "Failed to parse push data", http.StatusBadRequest)
 		return
 	}
@@ -396,7 +989,7 @@ func gmailPushHandler(w http.ResponseWriter, r *http.Request) {
 	// Extract email address
 	emailAddress, ok := pushDataRaw["emailAddress"].(string)
 	if !ok {
-		log.Printf("Unable to extract emailAddress from push data")
+		logger.Printf("Unable to extract emailAddress from push data")
 		http.Error(w, "Failed to extract emailAddress", http.StatusBadRequest)
 		return
 	}
@@ -412,213 +1005,154 @@ func gmailPushHandler(w http.ResponseWriter, r *http.Request) {
 		var err error
 		historyId, err = strconv.ParseUint(v, 10, 64)
 		if err != nil {
-			log.Printf("Unable to parse historyId string: %v", err)
+			logger.Printf("Unable to parse historyId string: %v", err)
 			http.Error(w, "Failed to parse historyId", http.StatusBadRequest)
 			return
 		}
 	default:
-		log.Printf("Unexpected historyId type: %T", v)
+		logger.Printf("Unexpected historyId type: %T", v)
 		http.Error(w, "Invalid historyId format", http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("Received push notification for user: %s, historyId: %d", emailAddress, historyId)
+	logger.Printf("Received push notification for user: %s, historyId: %d, subscription: %s", emailAddress, historyId, notification.Subscription)
 
-	// Retrieve tokens for this user
-	tokenStore.RLock()
-	token, exists := tokenStore.tokens[emailAddress]
-	tokenStore.RUnlock()
+	// Retrieve the Gmail token for this user
+	key := accountKey{providerGmail, emailAddress}
+	token, exists := s.userToken(providerGmail, emailAddress)
 	if !exists {
-		log.Printf("User %s not authenticated", emailAddress)
-		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		// Acknowledge so Pub/Sub doesn't redeliver for a user we'll never have tokens for
+		logger.Printf("DROPPED push notification for unknown user %s (historyId: %d)", emailAddress, historyId)
+		acknowledgePush(w, "dropped")
 		return
 	}
 
 	// Get stored history ID
-	historyStore.RLock()
-	lastHistoryId, hasHistory := historyStore.history[emailAddress]
-	historyStore.RUnlock()
+	s.historyStore.RLock()
+	lastHistoryId, hasHistory := s.historyStore.history[key]
+	s.historyStore.RUnlock()
 
 	if !hasHistory {
-		log.Printf("No stored history ID for user %s, using current historyId", emailAddress)
+		logger.Printf("No stored history ID for user %s, using current historyId", emailAddress)
 		lastHistoryId = historyId
 	}
 
-	ctx := context.Background()
-	srv, err := getGmailService(ctx, token)
+	ctx, cancel := gmailContext(r)
+	defer cancel()
+	srv, err := s.getUserGmailService(emailAddress, token)
 	if err != nil {
-		log.Printf("Unable to create Gmail service: %v", err)
+		logger.Printf("Unable to create Gmail service: %v", err)
 		http.Error(w, "Failed to create Gmail service", http.StatusInternalServerError)
 		return
 	}
 
-	// Get history changes
-	history, err := srv.Users.History.List("me").StartHistoryId(lastHistoryId).Do()
-	if err != nil {
-		log.Printf("Unable to get history: %v", err)
-		http.Error(w, "Failed to get history", http.StatusInternalServerError)
-		return
-	}
-
-	// Process new messages
-	for _, historyRecord := range history.History {
-		for _, messageAdded := range historyRecord.MessagesAdded {
-			msgID := messageAdded.Message.Id
-
-			// Get message details with full format to read email body
-			msg, err := srv.Users.Messages.Get("me", msgID).Format("full").Do()
-			if err != nil {
-				log.Printf("Unable to get message %s: %v", msgID, err)
-				continue
-			}
-
-			// Extract headers
-			headers := make(map[string]string)
-			for _, h := range msg.Payload.Headers {
-				headers[h.Name] = h.Value
-			}
-
-			// Extract email body
-			body := extractEmailBody(msg.Payload)
-			subject := headers["Subject"]
-
-			// Check if this is a credit card transaction email
-			if isCreditCardTransactionEmail(subject, body) {
-				// Parse credit card transaction details
-				txn := parseCreditCardTransaction(subject, body)
-
-				log.Printf("=== CREDIT CARD TRANSACTION DETECTED ===")
-				log.Printf("New email received for %s:", emailAddress)
-				log.Printf("  Message ID: %s", msg.Id)
-				log.Printf("  Subject: %s", subject)
-				log.Printf("  From: %s", headers["From"])
-				log.Printf("  Date: %s", headers["Date"])
-				log.Printf("--- Transaction Details ---")
-				log.Printf("  Amount: %s", txn.Amount)
-				log.Printf("  Card Number: %s", txn.CardNumber)
-				log.Printf("  Merchant: %s", txn.Merchant)
-				log.Printf("  Date: %s", txn.Date)
-				log.Printf("  Time: %s", txn.Time)
-				log.Printf("================================")
-			} else {
-				// Non-credit card email
-				log.Printf("=== NON CREDIT CARD INFO EMAIL ===")
-				log.Printf("New email received for %s:", emailAddress)
-				log.Printf("  Message ID: %s", msg.Id)
-				log.Printf("  Subject: %s", subject)
-				log.Printf("  From: %s", headers["From"])
-				log.Printf("  Date: %s", headers["Date"])
-				log.Printf("  Snippet: %s", msg.Snippet)
-				log.Printf("================================")
-			}
+	// Get history changes; an expired start ID falls back to a recent re-sync
+	history, err := srv.Users.History.List(userID(emailAddress)).StartHistoryId(lastHistoryId).Context(ctx).Do()
+	if isHistoryExpired(err) {
+		logger.Printf("History ID %d for %s has expired, re-syncing recent messages", lastHistoryId, emailAddress)
+		if err := s.resyncRecentMessages(ctx, logger, srv, emailAddress); err != nil {
+			logger.Printf("Unable to re-sync messages: %v", err)
+			http.Error(w, "Failed to re-sync messages", gmailErrorStatus(err))
+			return
 		}
+	} else if err != nil {
+		logger.Printf("Unable to get history: %v", err)
+		http.Error(w, "Failed to get history", gmailErrorStatus(err))
+		return
+	} else {
+		// Process new messages, deletions, and label changes
+		s.processHistory(ctx, logger, srv, emailAddress, history.History)
 	}
 
 	// Update stored history ID
-	historyStore.Lock()
-	historyStore.history[emailAddress] = historyId
-	historyStore.Unlock()
+	s.historyStore.Lock()
+	s.historyStore.history[key] = historyId
+	s.historyStore.Unlock()
 
 	// Return 200 OK to acknowledge receipt
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
-}
-
-// CreditCardTransaction represents parsed credit card transaction details
-type CreditCardTransaction struct {
-	Amount     string
-	CardNumber string
-	Merchant   string
-	Date       string
-	Time       string
-}
-
-// isCreditCardTransactionEmail checks if an email is a credit card transaction notification
-func isCreditCardTransactionEmail(subject, body string) bool {
-	// Check for common credit card transaction keywords
-	keywords := []string{
-		"credit card",
-		"debit.*card",
-		"card.*ending",
-		"card.*\\*\\*",
-		"debited.*card",
-		"transaction.*card",
-	}
-
-	combined := strings.ToLower(subject + " " + body)
-	for _, keyword := range keywords {
-		matched, _ := regexp.MatchString(keyword, combined)
-		if matched {
-			return true
-		}
-	}
-	return false
+	acknowledgePush(w, "ok")
 }
 
-// parseCreditCardTransaction extracts transaction details from email subject and body
-func parseCreditCardTransaction(subject, body string) *CreditCardTransaction {
-	txn := &CreditCardTransaction{}
+// resyncMessageLimit caps how many recent messages are processed after the history ID expires
+const resyncMessageLimit = 50
 
-	// Combine subject and body for parsing
-	combined := subject + " " + body
+// isHistoryExpired reports whether History.List failed because the start history ID is too old
+func isHistoryExpired(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
 
-	// Extract amount - patterns like "Rs.424.00", "₹424.00", "$424.00", "INR 424.00"
-	amountPattern := regexp.MustCompile(`(?i)(?:Rs\.|₹|INR|USD|\$)\s*([\d,]+\.?\d*)`)
-	if matches := amountPattern.FindStringSubmatch(combined); len(matches) > 1 {
-		txn.Amount = strings.TrimSpace(matches[1])
+// resyncRecentMessages processes the most recent inbox messages when history can't be listed
+// The caller then stores the notification's history ID, so later pushes resume normally.
+func (s *Server) resyncRecentMessages(ctx context.Context, logger *log.Logger, srv *gmail.Service, emailAddress string) error {
+	res, err := srv.Users.Messages.List(userID(emailAddress)).LabelIds("INBOX").Q("newer_than:1d").MaxResults(resyncMessageLimit).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("unable to list recent messages: %w", err)
 	}
 
-	// Extract card number - patterns like "ending 0000", "**0000", "card ending in 0000"
-	cardPatterns := []*regexp.Regexp{
-		regexp.MustCompile(`(?i)(?:ending|ending in|card ending)\s+(\d{4})`),
-		regexp.MustCompile(`(?i)\*\*(\d{4})`),
-		regexp.MustCompile(`(?i)card\s+(\d{4})`),
-	}
-	for _, pattern := range cardPatterns {
-		if matches := pattern.FindStringSubmatch(combined); len(matches) > 1 {
-			txn.CardNumber = matches[1]
-			break
-		}
+	logger.Printf("Re-syncing %d recent messages for %s", len(res.Messages), emailAddress)
+	for _, m := range res.Messages {
+		s.processPushedMessage(ctx, logger, srv, emailAddress, m.Id)
 	}
+	return nil
+}
 
-	// Extract merchant - patterns like "towards Swiggy Limited", "at Swiggy", "from Swiggy"
-	merchantPatterns := []*regexp.Regexp{
-		regexp.MustCompile(`(?i)(?:towards|at|from|with)\s+([A-Za-z][A-Za-z\s&]+?)(?:\s+on|\s+at|\.|$)`),
-		regexp.MustCompile(`(?i)(?:merchant|vendor):\s*([A-Za-z][A-Za-z\s&]+?)(?:\s+on|\s+at|\.|$)`),
-	}
-	for _, pattern := range merchantPatterns {
-		if matches := pattern.FindStringSubmatch(combined); len(matches) > 1 {
-			merchant := strings.TrimSpace(matches[1])
-			// Clean up common suffixes
-			merchant = regexp.MustCompile(`(?i)\s+(limited|ltd|inc|corp|corporation)\.?$`).ReplaceAllString(merchant, "")
-			txn.Merchant = strings.TrimSpace(merchant)
-			if txn.Merchant != "" {
-				break
-			}
-		}
-	}
+// Outcomes of processPushedMessage
+const (
+	outcomeTransaction    = "transaction"
+	outcomeBillReminder   = "bill_reminder"
+	outcomeOTP            = "otp"
+	outcomeSecurityAlert  = "security_alert"
+	outcomeNonTransaction = "non_transaction"
+	outcomeSkipped        = "skipped"
+	outcomeFailed         = "failed"
+)
 
-	// Extract date - patterns like "11 Nov, 2025", "11-Nov-2025", "2025-11-11"
-	datePatterns := []*regexp.Regexp{
-		regexp.MustCompile(`(\d{1,2}\s+(?:Jan|Feb|Mar|Apr|May|Jun|Jul|Aug|Sep|Oct|Nov|Dec)[a-z]*\s*,?\s*\d{4})`),
-		regexp.MustCompile(`(\d{1,2}[-/]\d{1,2}[-/]\d{4})`),
-		regexp.MustCompile(`(\d{4}[-/]\d{1,2}[-/]\d{1,2})`),
+// processPushedMessage fetches a message announced by a push notification,
+// classifies it, and hands it to the registered detectors. Returns the
+// outcome of the first matching detector, one of the outcome* constants for
+// the built-in ones.
+func (s *Server) processPushedMessage(ctx context.Context, logger *log.Logger, srv *gmail.Service, emailAddress, msgID string) string {
+	// Get message details with full format to read email body
+	msg, err := srv.Users.Messages.Get(userID(emailAddress), msgID).Format("full").Context(ctx).Do()
+	if err != nil {
+		logger.Printf("Unable to get message %s: %v", msgID, err)
+		return outcomeFailed
 	}
-	for _, pattern := range datePatterns {
-		if matches := pattern.FindStringSubmatch(combined); len(matches) > 1 {
-			txn.Date = strings.TrimSpace(matches[1])
-			break
-		}
+
+	// Extract headers
+	headers := make(map[string]string)
+	for _, h := range msg.Payload.Headers {
+		headers[h.Name] = h.Value
 	}
 
-	// Extract time - patterns like "12:38:53", "12:38 PM", "12:38"
-	timePattern := regexp.MustCompile(`(\d{1,2}:\d{2}(?::\d{2})?(?:\s*(?:AM|PM))?)`)
-	if matches := timePattern.FindStringSubmatch(combined); len(matches) > 1 {
-		txn.Time = strings.TrimSpace(matches[1])
+	// Extract email body, plus the body of any forwarded message
+	fetched := &mailMessage{
+		ID:           msg.Id,
+		Headers:      headers,
+		Snippet:      msg.Snippet,
+		InternalDate: msg.InternalDate,
+		Body:         extractEmailBodyWithFetch(ctx, srv, userID(emailAddress), msg.Id, msg.Payload),
+		Forwarded:    extractForwardedMessage(ctx, srv, userID(emailAddress), msg.Id, msg.Payload),
 	}
 
-	return txn
+	// Skip ignored senders, then classify and parse exactly as /parser/test does
+	return s.processFetchedMessage(ctx, logger, emailAddress, fetched)
+}
+
+// acknowledgePush answers a Pub/Sub push with 200 so the message isn't redelivered
+// Only retryable failures should return 5xx instead.
+func acknowledgePush(w http.ResponseWriter, status string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": status})
+}
+
+// debugf logs only when LOG_LEVEL=debug
+func debugf(format string, args ...interface{}) {
+	if strings.EqualFold(os.Getenv("LOG_LEVEL"), "debug") {
+		log.Printf("[debug] "+format, args...)
+	}
 }
 
 // Helper function for min
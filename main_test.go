@@ -0,0 +1,857 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
+)
+
+// callback completes a login against the fake Gmail: it takes a fresh state
+// from /auth-url and calls the callback with it, adding query and accept
+func callback(t *testing.T, handler http.Handler, query, accept string) *httptest.ResponseRecorder {
+	t.Helper()
+	target := "/oauth2/callback?code=abc&state=" + url.QueryEscape(issuedState(t, handler))
+	if query != "" {
+		target += "&" + query
+	}
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestOAuthCallbackResponseFormat(t *testing.T) {
+	s := newTestServer(t)
+	newFakeGmail(t, "user@example.com").use(s)
+	handler := s.Handler()
+
+	tests := []struct {
+		name   string
+		query  string
+		accept string
+		json   bool
+	}{
+		{"browser", "", "text/html,application/xhtml+xml,*/*;q=0.8", false},
+		{"accept header", "", "application/json", true},
+		{"accept list", "", "text/plain, application/json;q=0.9", true},
+		{"format parameter", "format=json", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := callback(t, handler, tt.query, tt.accept)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+
+			if !tt.json {
+				if ct := rec.Header().Get("Content-Type"); ct != "text/html" || !strings.Contains(rec.Body.String(), "<p>User: user@example.com</p>") {
+					t.Errorf("got %s %q, want the HTML page", ct, rec.Body)
+				}
+				return
+			}
+			var got map[string]interface{}
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if got["user_email"] != "user@example.com" || got["has_refresh_token"] != true || got["provider"] != providerGmail {
+				t.Errorf("got %v", got)
+			}
+		})
+	}
+}
+
+// textPart builds a text/plain part holding data, labelled with contentType
+func textPart(contentType string, data []byte) *gmail.MessagePart {
+	part := &gmail.MessagePart{MimeType: "text/plain", Body: &gmail.MessagePartBody{Data: base64.URLEncoding.EncodeToString(data)}}
+	if contentType != "" {
+		part.Headers = []*gmail.MessagePartHeader{{Name: "Content-Type", Value: contentType}}
+	}
+	return part
+}
+
+func TestExtractEmailBodyCharsets(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		data        []byte
+		want        string
+	}{
+		{"utf-8", "text/plain; charset=UTF-8", []byte("Spent \u20b91,234.00"), "Spent \u20b91,234.00"},
+		{"no charset", "", []byte("Spent Rs.1,234.00"), "Spent Rs.1,234.00"},
+		{"iso-8859-1", "text/plain; charset=ISO-8859-1", []byte("Spent \xa312.50 at Caf\xe9"), "Spent \u00a312.50 at Caf\u00e9"},
+		{"quoted charset", `text/plain; charset="iso-8859-1"`, []byte("Caf\xe9"), "Caf\u00e9"},
+		{"windows-1252", "text/plain; charset=windows-1252", []byte("Spent \x8012.50 \x93today\x94"), "Spent \u20ac12.50 \u201ctoday\u201d"},
+		{"utf-16le bom", "text/plain; charset=UTF-8", []byte{0xFF, 0xFE, 0xB9, 0x20, '5', 0, '.', 0, '0', 0, '0', 0}, "\u20b95.00"},
+		{"utf-16be bom", "", []byte{0xFE, 0xFF, 0x20, 0xB9, 0, '5'}, "\u20b95"},
+		{"unknown charset", "text/plain; charset=x-unknown", []byte("Spent Rs.10.00"), "Spent Rs.10.00"},
+		{"invalid utf-8", "text/plain; charset=UTF-8", []byte("Spent \xff10"), "Spent \ufffd10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractEmailBody(textPart(tt.contentType, tt.data)).PlainText; got != tt.want {
+				t.Errorf("body = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLatin1Amount(t *testing.T) {
+	body := extractEmailBody(textPart("text/plain; charset=windows-1252", []byte("INR 2,499.00 spent on your credit card XX1234 at CAF\xc9 COFFEE on 11 Nov, 2025"))).Best()
+	txn := parseCreditCardTransaction("Transaction alert", body)
+	if !strings.Contains(body, "CAF\u00c9") || txn == nil || txn.AmountMinorUnits != 249900 {
+		t.Errorf("parsed %+v from %q", txn, body)
+	}
+}
+
+func TestPostAuthRedirect(t *testing.T) {
+	t.Setenv("POST_AUTH_REDIRECT_URL", "https://app.example.com/done")
+	t.Setenv("POST_AUTH_REDIRECT_ALLOWLIST", "staging.example.com")
+	s := newTestServer(t)
+	newFakeGmail(t, "user@example.com").use(s)
+	handler := s.Handler()
+
+	t.Run("configured default", func(t *testing.T) {
+		rec := callback(t, handler, "", "")
+		if rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://app.example.com/done?status=success&user_email=user%40example.com" {
+			t.Errorf("got %d to %q", rec.Code, rec.Header().Get("Location"))
+		}
+	})
+
+	t.Run("redirect carried in state", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth-url?redirect="+url.QueryEscape("https://staging.example.com/cb?tab=1"), nil))
+		var body struct {
+			AuthURL string `json:"auth_url"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatalf("decode /auth-url: %v", err)
+		}
+		u, _ := url.Parse(body.AuthURL)
+
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/oauth2/callback?code=abc&state="+url.QueryEscape(u.Query().Get("state")), nil))
+		if rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://staging.example.com/cb?status=success&tab=1&user_email=user%40example.com" {
+			t.Errorf("got %d to %q", rec.Code, rec.Header().Get("Location"))
+		}
+	})
+
+	t.Run("declined consent", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/oauth2/callback?error=access_denied&state="+url.QueryEscape(issuedState(t, handler)), nil))
+		if rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://app.example.com/done?reason=access_denied&status=error" {
+			t.Errorf("got %d to %q", rec.Code, rec.Header().Get("Location"))
+		}
+	})
+
+	for _, target := range []string{"https://evil.example.com/", "javascript:alert(1)", "//app.example.com.evil.example/", "ftp://app.example.com/"} {
+		t.Run("rejects "+target, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth-url?redirect="+url.QueryEscape(target), nil))
+			if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "Redirect target not allowed") {
+				t.Errorf("got %d %q, want 400", rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestPostAuthRedirectOmitsEmailWithSessions(t *testing.T) {
+	t.Setenv("POST_AUTH_REDIRECT_URL", "https://app.example.com/done")
+	t.Setenv("SESSION_SECRET", "test-secret")
+	s := newTestServer(t)
+	newFakeGmail(t, "user@example.com").use(s)
+
+	rec := callback(t, s.Handler(), "", "")
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://app.example.com/done?status=success" {
+		t.Errorf("got %d to %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if cookies := rec.Result().Cookies(); len(cookies) != 1 || cookies[0].Name != sessionCookieName {
+		t.Errorf("cookies = %v, want the session", cookies)
+	}
+}
+
+func TestDecodeBase64Flexible(t *testing.T) {
+	// The text encodes with '+' (or '-' in the URL alphabet) and needs padding
+	text := "Spent Rs.1,234.00 ~~>??"
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"url", base64.URLEncoding.EncodeToString([]byte(text))},
+		{"raw url", base64.RawURLEncoding.EncodeToString([]byte(text))},
+		{"std", base64.StdEncoding.EncodeToString([]byte(text))},
+		{"raw std", base64.RawStdEncoding.EncodeToString([]byte(text))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeBase64Flexible(tt.data)
+			if err != nil || string(got) != text {
+				t.Errorf("decoded %q (%v), want %q", got, err, text)
+			}
+		})
+	}
+	if _, err := decodeBase64Flexible("not*base64!"); err == nil {
+		t.Error("corrupt data decoded without error")
+	}
+}
+
+func TestCorruptPartFallsBackToAlternative(t *testing.T) {
+	payload := &gmail.MessagePart{
+		MimeType: "multipart/alternative",
+		Parts: []*gmail.MessagePart{
+			{MimeType: "text/plain", Body: &gmail.MessagePartBody{Data: "not*base64!"}},
+			{MimeType: "text/html", Body: &gmail.MessagePartBody{Data: base64.RawStdEncoding.EncodeToString([]byte("<p>Spent Rs.10.00</p>"))}},
+		},
+	}
+	body := extractEmailBody(payload)
+	if body.PlainText != "" || body.Best() != "<p>Spent Rs.10.00</p>" {
+		t.Errorf("body = %+v, want the HTML alternative", body)
+	}
+}
+
+func TestPubsubTopicName(t *testing.T) {
+	tests := []struct {
+		name    string
+		project string
+		topic   string
+		want    string
+		wantErr string
+	}{
+		{"default topic", "my-project", "", "projects/my-project/topics/gmail-notifications", ""},
+		{"short topic", "my-project", "mail-push", "projects/my-project/topics/mail-push", ""},
+		{"full topic", "", "projects/other-project/topics/mail-push", "projects/other-project/topics/mail-push", ""},
+		{"placeholder project", placeholderProjectID, "", "", "GOOGLE_CLOUD_PROJECT is not set"},
+		{"placeholder in full topic", "", "projects/YOUR_PROJECT_ID/topics/gmail-notifications", "", "GOOGLE_CLOUD_PROJECT is not set"},
+		{"invalid project", "My_Project", "", "", "not a valid project ID"},
+		{"malformed full topic", "", "projects/my-project/subscriptions/mail-push", "", "does not match projects/*/topics/*"},
+		{"topic with slash", "my-project", "mail/push", "", "does not match projects/*/topics/*"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GOOGLE_CLOUD_PROJECT", tt.project)
+			t.Setenv("PUBSUB_TOPIC", tt.topic)
+			got, err := pubsubTopicName()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("got %q, %v; want error containing %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("got %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatchStartRequiresProject(t *testing.T) {
+	for _, project := range []string{"", placeholderProjectID} {
+		t.Run("project="+project, func(t *testing.T) {
+			t.Setenv("GOOGLE_CLOUD_PROJECT", project)
+			t.Setenv("PUBSUB_TOPIC", "")
+			s := newTestServer(t)
+			fake := newFakeGmail(t, "user@example.com")
+			fake.use(s)
+			authenticate(s, "user@example.com")
+
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/watch/start?userEmail=user@example.com", nil))
+			if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "set it to the Google Cloud project ID") {
+				t.Errorf("got %d %q, want 400 asking for GOOGLE_CLOUD_PROJECT", rec.Code, rec.Body)
+			}
+			if calls := fake.calls(); len(calls) != 0 {
+				t.Errorf("Gmail was called: %v", calls)
+			}
+		})
+	}
+}
+
+func TestExtractEmailBodyStdBase64(t *testing.T) {
+	text := "Spent \u20b9999.00 at SHOP?>"
+	data := base64.StdEncoding.EncodeToString([]byte(text))
+	if !strings.ContainsAny(data, "+/") {
+		t.Fatalf("%q uses no standard-only characters", data)
+	}
+	part := &gmail.MessagePart{MimeType: "text/plain", Body: &gmail.MessagePartBody{Data: data}}
+	if got := extractEmailBody(part).PlainText; got != text {
+		t.Errorf("body = %q, want %q", got, text)
+	}
+}
+
+func TestGmailTimeout(t *testing.T) {
+	t.Setenv("GMAIL_TIMEOUT", "50ms")
+	s := newTestServer(t)
+	authenticate(s, "user@example.com")
+
+	// Gmail that answers nothing until the caller gives up
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer slow.Close()
+	s.gmailServiceFactory = func(ctx context.Context, token *oauth2.Token) (*gmail.Service, error) {
+		return gmail.NewService(ctx, option.WithEndpoint(slow.URL+"/"), option.WithHTTPClient(slow.Client()))
+	}
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/emails/summary?userEmail=user@example.com", nil))
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("status = %d: %s, want 504", rec.Code, rec.Body)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("request took %v, want it cut off near GMAIL_TIMEOUT", elapsed)
+	}
+}
+
+func TestGmailTimeoutConfig(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", defaultGmailTimeout},
+		{"30s", 30 * time.Second},
+		{"1m30s", 90 * time.Second},
+		{"20", 20 * time.Second},
+		{"0", defaultGmailTimeout},
+		{"-5s", defaultGmailTimeout},
+		{"soon", defaultGmailTimeout},
+	}
+	for _, tt := range tests {
+		t.Setenv("GMAIL_TIMEOUT", tt.value)
+		if got := gmailTimeout(); got != tt.want {
+			t.Errorf("GMAIL_TIMEOUT=%q: got %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestGmailServiceCache(t *testing.T) {
+	s := newTestServer(t)
+	fake := newFakeGmail(t, "user@example.com")
+	fake.use(s)
+	fake.addMessage("m1", map[string]string{"Subject": "Hello"}, "hi", "", time.Now())
+	authenticate(s, "user@example.com")
+
+	built := 0
+	factory := s.gmailServiceFactory
+	s.gmailServiceFactory = func(ctx context.Context, token *oauth2.Token) (*gmail.Service, error) {
+		built++
+		return factory(ctx, token)
+	}
+	summary := func() {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/emails/summary?userEmail=user@example.com", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("summary status = %d: %s", rec.Code, rec.Body)
+		}
+	}
+
+	summary()
+	summary()
+	if built != 1 {
+		t.Errorf("built %d services for two requests, want 1", built)
+	}
+
+	s.tokenStore.RLock()
+	token := s.tokenStore.tokens[accountKey{providerGmail, "user@example.com"}]
+	s.tokenStore.RUnlock()
+	first, _ := s.getUserGmailService("user@example.com", token)
+
+	// A refreshed token replaces the cached service
+	s.storeToken(providerGmail, "user@example.com", &oauth2.Token{AccessToken: "refreshed", RefreshToken: "refresh"})
+	summary()
+	if built != 2 {
+		t.Errorf("built %d services after a token update, want 2", built)
+	}
+	s.tokenStore.RLock()
+	token = s.tokenStore.tokens[accountKey{providerGmail, "user@example.com"}]
+	s.tokenStore.RUnlock()
+	if second, _ := s.getUserGmailService("user@example.com", token); second == first {
+		t.Error("the service built for the old token is still in use")
+	}
+
+	s.invalidateGmailService("user@example.com")
+	summary()
+	if built != 3 {
+		t.Errorf("built %d services after invalidation, want 3", built)
+	}
+}
+
+// pushRequest builds a Pub/Sub push for a Gmail notification, with the
+// message data encoded by enc
+func pushRequest(enc *base64.Encoding, emailAddress string, historyID uint64) *http.Request {
+	data, _ := json.Marshal(map[string]interface{}{"emailAddress": emailAddress, "historyId": historyID})
+	body, _ := json.Marshal(map[string]interface{}{
+		"message":      map[string]string{"data": enc.EncodeToString(data), "messageId": "push-1"},
+		"subscription": "projects/my-project/subscriptions/gmail-push",
+	})
+	return httptest.NewRequest(http.MethodPost, "/gmail/push", bytes.NewReader(body))
+}
+
+func TestPushDataEncodings(t *testing.T) {
+	// The 52-byte notification encodes with "==" padding, which the unpadded forms leave off
+	tests := []struct {
+		name string
+		enc  *base64.Encoding
+	}{
+		{"padded std", base64.StdEncoding},
+		{"unpadded std", base64.RawStdEncoding},
+		{"padded url", base64.URLEncoding},
+		{"unpadded url", base64.RawURLEncoding},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			fake := newFakeGmail(t, "user@example.com")
+			fake.use(s)
+			authenticate(s, "user@example.com")
+			s.historyStore.history[accountKey{providerGmail, "user@example.com"}] = 1000
+			msg := fake.addMessage("m1", map[string]string{"Subject": "Transaction alert", "From": "alerts@examplebank.com"},
+				"Rs.424.00 spent on your credit card XX1234 at AMAZON on 11 Nov, 2025", "", time.Now())
+
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, pushRequest(tt.enc, "user@example.com", msg.HistoryId))
+			if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"ok"`) {
+				t.Fatalf("got %d %s, want the push acknowledged", rec.Code, rec.Body)
+			}
+			records, _, err := s.transactions.Query("user@example.com", TransactionFilter{})
+			if err != nil || len(records) != 1 || records[0].AmountMinorUnits != 42400 {
+				t.Errorf("recorded %v (%v), want the Rs.424.00 transaction", records, err)
+			}
+		})
+	}
+}
+
+func TestSubscriptionAllowed(t *testing.T) {
+	tests := []struct {
+		allowed      string
+		subscription string
+		want         bool
+	}{
+		{"", "projects/my-project/subscriptions/gmail-push", true},
+		{"projects/my-project/subscriptions/gmail-push", "projects/my-project/subscriptions/gmail-push", true},
+		{"projects/staging/subscriptions/gmail-push, projects/my-project/subscriptions/gmail-push", "projects/my-project/subscriptions/gmail-push", true},
+		{"gmail-push", "projects/my-project/subscriptions/gmail-push", true},
+		{"projects/staging/subscriptions/gmail-push", "projects/my-project/subscriptions/gmail-push", false},
+		{"gmail-push-staging", "projects/my-project/subscriptions/gmail-push", false},
+		{"gmail-push", "", false},
+	}
+	for _, tt := range tests {
+		t.Setenv("PUBSUB_SUBSCRIPTIONS", tt.allowed)
+		if got := subscriptionAllowed(tt.subscription); got != tt.want {
+			t.Errorf("PUBSUB_SUBSCRIPTIONS=%q: subscriptionAllowed(%q) = %v, want %v", tt.allowed, tt.subscription, got, tt.want)
+		}
+	}
+}
+
+func TestPushSubscriptionAllowlist(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed string
+		status  string
+	}{
+		{"allowed", "projects/staging/subscriptions/gmail-push,projects/my-project/subscriptions/gmail-push", "ok"},
+		{"disallowed", "projects/staging/subscriptions/gmail-push", "rejected_subscription"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PUBSUB_SUBSCRIPTIONS", tt.allowed)
+			logs := captureLog(t)
+			s := newTestServer(t)
+			fake := newFakeGmail(t, "user@example.com")
+			fake.use(s)
+			authenticate(s, "user@example.com")
+			s.historyStore.history[accountKey{providerGmail, "user@example.com"}] = 1000
+			msg := fake.addMessage("m1", map[string]string{"Subject": "Transaction alert", "From": "alerts@examplebank.com"},
+				"Rs.424.00 spent on your credit card XX1234 at AMAZON on 11 Nov, 2025", "", time.Now())
+
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, pushRequest(base64.StdEncoding, "user@example.com", msg.HistoryId))
+			// Rejected pushes are still acknowledged, so Pub/Sub doesn't redeliver them
+			if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"`+tt.status+`"`) {
+				t.Fatalf("got %d %s, want 200 %q", rec.Code, rec.Body, tt.status)
+			}
+			records, _, _ := s.transactions.Query("user@example.com", TransactionFilter{})
+			if tt.status == "ok" {
+				if len(records) != 1 {
+					t.Errorf("recorded %d transactions, want 1", len(records))
+				}
+				return
+			}
+			if len(records) != 0 || len(fake.calls()) != 0 {
+				t.Errorf("rejected push recorded %v and called Gmail %v", records, fake.calls())
+			}
+			if !strings.Contains(logs.String(), `subscription "projects/my-project/subscriptions/gmail-push" not in PUBSUB_SUBSCRIPTIONS`) {
+				t.Errorf("log lacks the rejected subscription:\n%s", logs)
+			}
+		})
+	}
+}
+
+func TestPushStatusCodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		user     string // Mailbox the push is for; only user@example.com is authenticated
+		failure  int    // Status the fake History.List answers with, 0 for success
+		want     int
+		status   string // Acknowledgement status on 200
+		advanced bool   // Whether the stored history ID moves to the push's
+	}{
+		{"processed", "user@example.com", 0, http.StatusOK, "ok", true},
+		{"unknown user is dropped", "stranger@example.com", 0, http.StatusOK, "dropped", false},
+		{"gmail server error is retried", "user@example.com", http.StatusInternalServerError, http.StatusInternalServerError, "", false},
+		{"gmail unavailable is retried", "user@example.com", http.StatusServiceUnavailable, http.StatusInternalServerError, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			fake := newFakeGmail(t, "user@example.com")
+			fake.use(s)
+			authenticate(s, "user@example.com")
+			s.historyStore.history[accountKey{providerGmail, "user@example.com"}] = 1000
+			if tt.failure != 0 {
+				fake.fail(http.MethodGet, "history", tt.failure)
+			}
+			msg := fake.addMessage("m1", map[string]string{"Subject": "Hello"}, "hi", "", time.Now())
+
+			logs := captureLog(t)
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, pushRequest(base64.StdEncoding, tt.user, msg.HistoryId))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			if tt.status != "" && !strings.Contains(rec.Body.String(), `"status":"`+tt.status+`"`) {
+				t.Errorf("body = %s, want status %q", rec.Body, tt.status)
+			}
+			if tt.status == "dropped" && !strings.Contains(logs.String(), "DROPPED push notification for unknown user stranger@example.com") {
+				t.Errorf("log = %q, want the dropped notification", logs)
+			}
+			if got := s.historyStore.history[accountKey{providerGmail, "user@example.com"}]; (got == msg.HistoryId) != tt.advanced {
+				t.Errorf("stored history ID = %d, advanced = %v, want %v", got, got == msg.HistoryId, tt.advanced)
+			}
+		})
+	}
+}
+
+func TestPushExpiredHistoryResyncs(t *testing.T) {
+	s := newTestServer(t)
+	fake := newFakeGmail(t, "user@example.com")
+	fake.use(s)
+	authenticate(s, "user@example.com")
+	s.historyStore.history[accountKey{providerGmail, "user@example.com"}] = 12
+	fake.fail(http.MethodGet, "history", http.StatusNotFound)
+	msg := fake.addMessage("m1", map[string]string{"Subject": "Transaction alert", "From": "alerts@examplebank.com"},
+		"Rs.424.00 spent on your credit card XX1234 at AMAZON on 11 Nov, 2025", "", time.Now())
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, pushRequest(base64.StdEncoding, "user@example.com", msg.HistoryId))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	want := []string{
+		"GET /gmail/v1/users/me/history",
+		"GET /gmail/v1/users/me/messages",
+		"GET /gmail/v1/users/me/messages/m1",
+	}
+	if calls := fake.calls(); !equalStrings(calls, want) {
+		t.Errorf("Gmail calls = %v, want %v", calls, want)
+	}
+	if q := fake.query(http.MethodGet, "/gmail/v1/users/me/messages").Get("q"); q != "newer_than:1d" {
+		t.Errorf("re-sync query = %q, want newer_than:1d", q)
+	}
+	if got := s.historyStore.history[accountKey{providerGmail, "user@example.com"}]; got != msg.HistoryId {
+		t.Errorf("stored history ID = %d, want it reset to %d", got, msg.HistoryId)
+	}
+	records, _, err := s.transactions.Query("user@example.com", TransactionFilter{})
+	if err != nil || len(records) != 1 {
+		t.Errorf("recorded %d transactions (%v), want the re-synced one", len(records), err)
+	}
+
+	// A failed re-sync is retried, keeping the old history ID
+	fake.fail(http.MethodGet, "messages", http.StatusInternalServerError)
+	s.historyStore.history[accountKey{providerGmail, "user@example.com"}] = 12
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, pushRequest(base64.StdEncoding, "user@example.com", msg.HistoryId))
+	if rec.Code != http.StatusInternalServerError || s.historyStore.history[accountKey{providerGmail, "user@example.com"}] != 12 {
+		t.Errorf("failed re-sync: status %d, history ID %d; want 500 and 12", rec.Code, s.historyStore.history[accountKey{providerGmail, "user@example.com"}])
+	}
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	t.Setenv("MAX_BODY_BYTES", "16")
+	tests := []struct {
+		name      string
+		data      string
+		want      string
+		truncated bool
+	}{
+		{"under the cap", "Spent Rs.424", "Spent Rs.424", false},
+		{"at the cap", "Spent Rs.424.00!", "Spent Rs.424.00!", false},
+		{"over the cap", "Spent Rs.424.00 at AMAZON", "Spent Rs.424.00 ", true},
+		// The cap falls inside the three-byte rupee sign, which is dropped whole
+		{"multibyte boundary", "Spent in full \u20b9424.00", "Spent in full ", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := extractEmailBody(textPart("text/plain; charset=UTF-8", []byte(tt.data)))
+			if body.PlainText != tt.want || body.Truncated != tt.truncated {
+				t.Errorf("got %q truncated=%v, want %q truncated=%v", body.PlainText, body.Truncated, tt.want, tt.truncated)
+			}
+		})
+	}
+}
+
+func TestOversizedBody(t *testing.T) {
+	t.Setenv("MAX_BODY_BYTES", "1024")
+	s := newTestServer(t)
+	fake := newFakeGmail(t, "user@example.com")
+	fake.use(s)
+	authenticate(s, "user@example.com")
+	s.historyStore.history[accountKey{providerGmail, "user@example.com"}] = 1000
+	text := "Rs.424.00 spent on your credit card XX1234 at AMAZON on 11 Nov, 2025. " + strings.Repeat("Terms and conditions apply. ", 40000)
+	msg := fake.addMessage("m1", map[string]string{"Subject": "Transaction alert", "From": "alerts@examplebank.com"},
+		text, "<p>"+text+"</p>", time.Now())
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/emails/summary?userEmail=user@example.com", nil))
+	var got struct {
+		LatestEmail struct {
+			BodyText  string `json:"body_text"`
+			BodyHTML  string `json:"body_html"`
+			Truncated bool   `json:"truncated"`
+		} `json:"latest_email"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !got.LatestEmail.Truncated || len(got.LatestEmail.BodyText) != 1024 || len(got.LatestEmail.BodyHTML) != 1024 {
+		t.Errorf("truncated = %v with %d text and %d HTML bytes, want both cut to 1024",
+			got.LatestEmail.Truncated, len(got.LatestEmail.BodyText), len(got.LatestEmail.BodyHTML))
+	}
+
+	// Detection still runs on the truncated content
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, pushRequest(base64.StdEncoding, "user@example.com", msg.HistoryId))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("push status = %d: %s", rec.Code, rec.Body)
+	}
+	records, _, err := s.transactions.Query("user@example.com", TransactionFilter{})
+	if err != nil || len(records) != 1 || records[0].AmountMinorUnits != 42400 {
+		t.Errorf("recorded %v (%v), want the Rs.424.00 transaction", records, err)
+	}
+}
+
+func TestPushRecordsEachTransaction(t *testing.T) {
+	s := newTestServer(t)
+	fake := newFakeGmail(t, "user@example.com")
+	fake.use(s)
+	authenticate(s, "user@example.com")
+	s.historyStore.history[accountKey{providerGmail, "user@example.com"}] = 1000
+	msg := fake.addMessage("m1", map[string]string{"Subject": "Your recent credit card transactions", "From": "alerts@examplebank.com"},
+		"Recent transactions on your credit card ending 1234:\nRs.424.00 spent at AMAZON on 11 Nov, 2025\nRs.1,299.00 spent at SWIGGY on 12 Nov, 2025\nTotal due Rs.1,723.00", "", time.Now())
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, pushRequest(base64.StdEncoding, "user@example.com", msg.HistoryId))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	records, _, err := s.transactions.Query("user@example.com", TransactionFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]int64{}
+	for _, record := range records {
+		got[record.Merchant] = record.AmountMinorUnits
+	}
+	if len(records) != 2 || got["AMAZON"] != 42400 || got["SWIGGY"] != 129900 {
+		t.Errorf("recorded %v, want AMAZON 42400 and SWIGGY 129900", got)
+	}
+}
+
+func TestPushedMessageOutcomes(t *testing.T) {
+	s := newTestServer(t)
+	fake := newFakeGmail(t, "user@example.com")
+	fake.use(s)
+	srv, err := s.gmailServiceFactory(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		subject string
+		body    string
+		want    string
+	}{
+		{"Transaction alert", "Rs.424.00 spent on your credit card XX1234 at AMAZON on 11 Nov, 2025", outcomeTransaction},
+		{"Your OTP", "123456 is your OTP to log in. Do not share it with anyone.", outcomeOTP},
+		{"New sign-in to your account", "We noticed a new sign-in from Chrome on Windows.", outcomeSecurityAlert},
+		{"Weekly deals", "Save 20% on your next order.", outcomeNonTransaction},
+	}
+	for i, tt := range tests {
+		id := "m" + string(rune('1'+i))
+		fake.addMessage(id, map[string]string{"Subject": tt.subject, "From": "alerts@examplebank.com"}, tt.body, "", time.Now())
+		if got := s.processPushedMessage(context.Background(), discardLogger(), srv, "user@example.com", id); got != tt.want {
+			t.Errorf("%s: outcome = %q, want %q", tt.subject, got, tt.want)
+		}
+	}
+	if records, _, err := s.transactions.Query("user@example.com", TransactionFilter{}); err != nil || len(records) != 1 {
+		t.Errorf("recorded %v (%v), want only the transaction", records, err)
+	}
+}
+
+func TestPushParsesSnippetWhenBodyEmpty(t *testing.T) {
+	s := newTestServer(t)
+	fake := newFakeGmail(t, "user@example.com")
+	fake.use(s)
+	authenticate(s, "user@example.com")
+	s.historyStore.history[accountKey{providerGmail, "user@example.com"}] = 1000
+	msg := fake.addMessage("m1", map[string]string{"Subject": "Transaction alert", "From": "alerts@examplebank.com"}, "", "", time.Now())
+	msg.Snippet = "Rs.424.00 spent on your credit card XX1234 at Domino&#39;s on 11 Nov, 2025"
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, pushRequest(base64.StdEncoding, "user@example.com", msg.HistoryId))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	records, _, err := s.transactions.Query("user@example.com", TransactionFilter{})
+	if err != nil || len(records) != 1 || records[0].AmountMinorUnits != 42400 || records[0].Merchant != "Domino's" {
+		t.Errorf("recorded %v (%v), want the Rs.424.00 Domino's transaction from the snippet", records, err)
+	}
+}
+func TestValidUTF8(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"Spent \u20b9100", "Spent \u20b9100"},
+		{"Spent \xff100", "Spent \ufffd100"},
+		{"Caf\xe9 \xe9\xe9", "Caf\ufffd \ufffd"},
+		{"truncated \xe2\x82", "truncated \ufffd"},
+		{"surrogate \xed\xa0\x80", "surrogate \ufffd"},
+		{"overlong \xc0\xaf", "overlong \ufffd"},
+	}
+	for _, tt := range tests {
+		if got := validUTF8(tt.in); got != tt.want {
+			t.Errorf("validUTF8(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSummaryEncodesInvalidUTF8(t *testing.T) {
+	s := newTestServer(t)
+	fake := newFakeGmail(t, "user@example.com")
+	fake.use(s)
+	authenticate(s, "user@example.com")
+	fake.addMessage("m1", map[string]string{"Subject": "Statement", "From": "alerts@bank.example"},
+		"Spent Rs.424 at CAF\xc9 \xff\xfe\xfd", "<p>Spent at CAF\xc9</p>\xe2\x82", time.Now())
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/emails/summary?userEmail=user@example.com", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if !utf8.Valid(rec.Body.Bytes()) || !json.Valid(rec.Body.Bytes()) {
+		t.Fatalf("response is not valid UTF-8 JSON: %q", rec.Body)
+	}
+
+	var got struct {
+		LatestEmail struct {
+			BodyText string `json:"body_text"`
+			BodyHTML string `json:"body_html"`
+		} `json:"latest_email"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if want := "Spent Rs.424 at CAF\ufffd \ufffd"; got.LatestEmail.BodyText != want {
+		t.Errorf("body_text = %q, want %q", got.LatestEmail.BodyText, want)
+	}
+	if want := "<p>Spent at CAF\ufffd</p>\ufffd"; got.LatestEmail.BodyHTML != want {
+		t.Errorf("body_html = %q, want %q", got.LatestEmail.BodyHTML, want)
+	}
+}
+
+func TestOAuthCallbackAutoWatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		autoWatch string
+		project   string
+		status    string // watch status in the response, empty when no watch is attempted
+	}{
+		{"disabled", "", "my-project", ""},
+		{"enabled", "true", "my-project", "watch_started"},
+		{"enabled without a project", "true", "", "watch_failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AUTO_WATCH", tt.autoWatch)
+			t.Setenv("GOOGLE_CLOUD_PROJECT", tt.project)
+			t.Setenv("PUBSUB_TOPIC", "")
+			s := newTestServer(t)
+			fake := newFakeGmail(t, "user@example.com")
+			fake.use(s)
+			fake.addMessage("m1", map[string]string{"Subject": "Hello"}, "hi", "", time.Now())
+
+			// A failed watch is reported without failing the sign-in
+			rec := callback(t, s.Handler(), "format=json", "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			var got struct {
+				UserEmail string                 `json:"user_email"`
+				Watch     map[string]interface{} `json:"watch"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if got.UserEmail != "user@example.com" {
+				t.Errorf("user_email = %q", got.UserEmail)
+			}
+
+			fake.mu.Lock()
+			watches := fake.watches
+			fake.mu.Unlock()
+			switch tt.status {
+			case "":
+				if got.Watch != nil || len(watches) != 0 {
+					t.Errorf("watch = %v with %d watch calls, want none", got.Watch, len(watches))
+				}
+			case "watch_started":
+				if len(watches) != 1 || watches[0].TopicName != "projects/my-project/topics/gmail-notifications" {
+					t.Fatalf("watch calls = %+v, want one on the project's topic", watches)
+				}
+				if got.Watch["status"] != "watch_started" || got.Watch["history_id"] != float64(1001) || got.Watch["expiration"] == nil {
+					t.Errorf("watch = %v, want started with history 1001", got.Watch)
+				}
+				s.historyStore.RLock()
+				stored := s.historyStore.history[accountKey{providerGmail, "user@example.com"}]
+				s.historyStore.RUnlock()
+				if stored != 1001 {
+					t.Errorf("stored history ID = %d, want 1001", stored)
+				}
+			default:
+				if got.Watch["status"] != tt.status || got.Watch["error"] == "" || len(watches) != 0 {
+					t.Errorf("watch = %v with %d watch calls, want %s", got.Watch, len(watches), tt.status)
+				}
+			}
+		})
+	}
+}
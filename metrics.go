@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// metrics holds process-wide counters served at /metrics
+// Keys are full series names, e.g. `outbound_publish_failures_total{reason="api"}`.
+var metrics = struct {
+	sync.Mutex
+	counters map[string]int64
+}{counters: make(map[string]int64)}
+
+// addCounter adds delta to a counter; labels are name/value pairs
+func addCounter(name string, delta int64, labels ...string) {
+	series := name
+	if len(labels) > 0 {
+		var pairs []string
+		for i := 0; i+1 < len(labels); i += 2 {
+			pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+		}
+		series += "{" + strings.Join(pairs, ",") + "}"
+	}
+
+	metrics.Lock()
+	metrics.counters[series] += delta
+	metrics.Unlock()
+}
+
+// metricsHandler writes every counter in the Prometheus text format
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	metrics.Lock()
+	series := make([]string, 0, len(metrics.counters))
+	for name := range metrics.counters {
+		series = append(series, name)
+	}
+	sort.Strings(series)
+	values := make([]int64, len(series))
+	for i, name := range series {
+		values[i] = metrics.counters[name]
+	}
+	metrics.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	typed := make(map[string]bool)
+	for i, name := range series {
+		base, _, _ := strings.Cut(name, "{")
+		if !typed[base] {
+			typed[base] = true
+			fmt.Fprintf(w, "# TYPE %s counter\n", base)
+		}
+		fmt.Fprintf(w, "%s %d\n", name, values[i])
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// requestIDKey is the context key for the request correlation ID
+type requestIDKey struct{}
+
+// requestIDMiddleware reads X-Request-ID (or generates one), stores it in the
+// request context, and echoes it back in the response header
+func requestIDMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := strings.TrimSpace(r.Header.Get("X-Request-ID"))
+		if requestID == "" || len(requestID) > 128 {
+			requestID = newRequestID()
+		}
+
+		w.Header().Set("X-Request-ID", requestID)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, requestID)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// requestIDFromContext returns the request ID stored by requestIDMiddleware, if any
+func requestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// requestLogger returns a logger whose lines carry the request ID from ctx
+func requestLogger(ctx context.Context) *log.Logger {
+	prefix := ""
+	if requestID := requestIDFromContext(ctx); requestID != "" {
+		prefix = "[request_id=" + requestID + "] "
+	}
+	return log.New(log.Writer(), prefix, log.Flags()|log.Lmsgprefix)
+}
+
+// newRequestID generates a random UUID (version 4)
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		log.Printf("Unable to generate request ID: %v", err)
+		return "unknown"
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	h := hex.EncodeToString(b[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+// corsMiddleware adds CORS headers for origins listed in ALLOWED_ORIGINS
+// (comma-separated, "*" allows any origin) and answers preflight requests.
+// Requests without an Origin header pass through untouched.
+func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next(w, r)
+			return
+		}
+
+		allowOrigin := corsAllowOrigin(origin)
+		switch allowOrigin {
+		case "":
+		case "*":
+			// Browsers refuse credentials with a wildcard, and echoing the origin
+			// instead would hand any site the user's session
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		default:
+			w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+			w.Header().Add("Vary", "Origin")
+		}
+
+		// Preflight requests are answered here and never reach the handler
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if allowOrigin == "" {
+				http.Error(w, "Origin not allowed", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+			}
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// corsAllowOrigin returns the Access-Control-Allow-Origin value for origin:
+// the origin itself when listed in ALLOWED_ORIGINS, "*" when only the
+// wildcard allows it, or empty when it isn't allowed
+func corsAllowOrigin(origin string) string {
+	wildcard := false
+	for _, allowed := range strings.Split(os.Getenv("ALLOWED_ORIGINS"), ",") {
+		allowed = strings.TrimSpace(allowed)
+		if allowed == "*" {
+			wildcard = true
+		} else if allowed != "" && strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return origin
+		}
+	}
+	if wildcard {
+		return "*"
+	}
+	return ""
+}
+
+// adminMiddleware restricts a handler to requests carrying ADMIN_TOKEN, either as
+// "Authorization: Bearer <token>" or X-Admin-Token. Without ADMIN_TOKEN set,
+// admin endpoints are disabled.
+func adminMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if os.Getenv("ADMIN_TOKEN") == "" {
+			http.Error(w, "Admin endpoints are disabled", http.StatusForbidden)
+			return
+		}
+
+		if !hasAdminToken(r) {
+			http.Error(w, "Invalid admin token", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// hasAdminToken reports whether the request carries ADMIN_TOKEN, as
+// adminMiddleware requires; always false when ADMIN_TOKEN is unset
+func hasAdminToken(r *http.Request) bool {
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	if adminToken == "" {
+		return false
+	}
+	provided := r.Header.Get("X-Admin-Token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		provided = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(adminToken)) == 1
+}
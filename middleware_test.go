@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	tests := []struct {
+		name           string
+		allowedOrigins string
+		method         string
+		origin         string
+		preflight      bool
+		wantStatus     int
+		wantOrigin     string
+		wantCreds      string
+	}{
+		{"listed origin", "https://app.example.com", http.MethodGet, "https://app.example.com", false, http.StatusOK, "https://app.example.com", "true"},
+		{"listed with trailing slash", "https://app.example.com/", http.MethodGet, "https://app.example.com", false, http.StatusOK, "https://app.example.com", "true"},
+		{"preflight", "https://app.example.com", http.MethodOptions, "https://app.example.com", true, http.StatusNoContent, "https://app.example.com", "true"},
+		{"disallowed origin", "https://app.example.com", http.MethodGet, "https://evil.example.com", false, http.StatusOK, "", ""},
+		{"disallowed preflight", "https://app.example.com", http.MethodOptions, "https://evil.example.com", true, http.StatusForbidden, "", ""},
+		{"wildcard", "*", http.MethodGet, "https://any.example.com", false, http.StatusOK, "*", ""},
+		{"wildcard preflight", "*", http.MethodOptions, "https://any.example.com", true, http.StatusNoContent, "*", ""},
+		{"listed beside wildcard", "*, https://app.example.com", http.MethodGet, "https://app.example.com", false, http.StatusOK, "https://app.example.com", "true"},
+		{"no origin", "https://app.example.com", http.MethodGet, "", false, http.StatusOK, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ALLOWED_ORIGINS", tt.allowedOrigins)
+			reached := false
+			handler := corsMiddleware(func(w http.ResponseWriter, r *http.Request) { reached = true })
+
+			req := httptest.NewRequest(tt.method, "/emails/summary", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight {
+				req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+				req.Header.Set("Access-Control-Request-Headers", "Content-Type")
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != tt.wantCreds {
+				t.Errorf("Allow-Credentials = %q, want %q", got, tt.wantCreds)
+			}
+			if reached == tt.preflight {
+				t.Errorf("handler reached = %t, want %t", reached, !tt.preflight)
+			}
+			if tt.preflight && tt.wantStatus == http.StatusNoContent && rec.Header().Get("Access-Control-Allow-Headers") != "Content-Type" {
+				t.Errorf("Allow-Headers = %q, want the requested headers", rec.Header().Get("Access-Control-Allow-Headers"))
+			}
+		})
+	}
+}
+
+// captureLog sends the standard logger's output to a buffer until the test ends
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(previous) })
+	return &buf
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	uuidPattern := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	tests := []struct {
+		name     string
+		incoming string
+		echoed   bool // Whether the incoming ID is kept
+	}{
+		{"incoming", "trace-123", true},
+		{"generated", "", false},
+		{"too long", strings.Repeat("x", 129), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLog(t)
+			handler := requestIDMiddleware(func(w http.ResponseWriter, r *http.Request) {
+				requestLogger(r.Context()).Printf("handled")
+			})
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.incoming != "" {
+				req.Header.Set("X-Request-ID", tt.incoming)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			got := rec.Header().Get("X-Request-ID")
+			if tt.echoed && got != tt.incoming {
+				t.Errorf("X-Request-ID = %q, want %q echoed", got, tt.incoming)
+			}
+			if !tt.echoed && !uuidPattern.MatchString(got) {
+				t.Errorf("X-Request-ID = %q, want a generated UUID", got)
+			}
+			if !strings.Contains(logs.String(), "[request_id="+got+"] handled") {
+				t.Errorf("log %q lacks the request ID", logs)
+			}
+		})
+	}
+}
+
+func TestPushHandlerLogsRequestID(t *testing.T) {
+	s := newTestServer(t)
+	logs := captureLog(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/gmail/push", strings.NewReader("not json"))
+	req.Header.Set("X-Request-ID", "push-42")
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	if rec.Header().Get("X-Request-ID") != "push-42" {
+		t.Errorf("X-Request-ID = %q, want push-42", rec.Header().Get("X-Request-ID"))
+	}
+	if !strings.Contains(logs.String(), "[request_id=push-42] Unable to parse push notification") {
+		t.Errorf("log %q lacks the request ID", logs)
+	}
+}
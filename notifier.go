@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// defaultNotifierTimeout bounds one sink's delivery, including its retries
+const defaultNotifierTimeout = 60 * time.Second
+
+// Notifier is a destination for detected transactions, bill reminders and spend alerts
+// Sinks deliver synchronously and return the final error; the dispatcher runs
+// them concurrently and applies the timeout.
+type Notifier interface {
+	Name() string
+	NotifyTransaction(ctx context.Context, userEmail string, txn *CreditCardTransaction) error
+	NotifyBill(ctx context.Context, userEmail string, bill *BillReminder) error
+	NotifyAlert(ctx context.Context, userEmail string, alert *spendAlert) error
+}
+
+// digestNotifier is implemented by sinks that can deliver the daily digest
+type digestNotifier interface {
+	NotifyDigest(ctx context.Context, userEmail string, digest *dailyDigest) error
+}
+
+// notifierFactories builds each sink for a Server, returning nil when the sink is not configured
+var notifierFactories = map[string]func(s *Server) Notifier{}
+
+// registerNotifier adds a sink factory; called from the sinks' init functions
+func registerNotifier(name string, factory func(s *Server) Notifier) {
+	notifierFactories[name] = factory
+}
+
+// notificationKey is the context key for the message a notification comes from
+type notificationKey struct{}
+
+// notification carries the source message and request logger to the sinks
+type notification struct {
+	Logger    *log.Logger
+	MessageID string
+	Subject   string
+}
+
+// notificationFrom returns the notification details in ctx, with the default logger if unset
+func notificationFrom(ctx context.Context) notification {
+	n, _ := ctx.Value(notificationKey{}).(notification)
+	if n.Logger == nil {
+		n.Logger = log.Default()
+	}
+	return n
+}
+
+// notifierTimeout returns the per-sink deadline from NOTIFIER_TIMEOUT (e.g. "30s")
+func notifierTimeout() time.Duration {
+	value := os.Getenv("NOTIFIER_TIMEOUT")
+	if value == "" {
+		return defaultNotifierTimeout
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		log.Printf("Invalid NOTIFIER_TIMEOUT %q, using %v", value, defaultNotifierTimeout)
+		return defaultNotifierTimeout
+	}
+	return timeout
+}
+
+// notifierDispatcher fans notifications out to every configured sink
+type notifierDispatcher struct {
+	notifiers []Notifier
+	timeout   time.Duration
+}
+
+// newNotifierDispatcher builds the configured sinks for s, in name order
+func newNotifierDispatcher(s *Server) *notifierDispatcher {
+	names := make([]string, 0, len(notifierFactories))
+	for name := range notifierFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	d := &notifierDispatcher{timeout: notifierTimeout()}
+	for _, name := range names {
+		if n := notifierFactories[name](s); n != nil {
+			d.notifiers = append(d.notifiers, n)
+		}
+	}
+	return d
+}
+
+// transaction sends txn to every sink without blocking the caller
+func (d *notifierDispatcher) transaction(logger *log.Logger, userEmail, msgID, subject string, txn *CreditCardTransaction) {
+	d.dispatch(d.notifiers, notification{Logger: logger, MessageID: msgID, Subject: subject}, func(ctx context.Context, n Notifier) error {
+		return n.NotifyTransaction(ctx, userEmail, txn)
+	})
+}
+
+// bill sends a bill reminder to every sink without blocking the caller
+func (d *notifierDispatcher) bill(logger *log.Logger, userEmail, msgID, subject string, bill *BillReminder) {
+	d.dispatch(d.notifiers, notification{Logger: logger, MessageID: msgID, Subject: subject}, func(ctx context.Context, n Notifier) error {
+		return n.NotifyBill(ctx, userEmail, bill)
+	})
+}
+
+// alert sends a triggered spend alert to every sink without blocking the caller
+func (d *notifierDispatcher) alert(logger *log.Logger, userEmail, msgID, subject string, alert *spendAlert) {
+	d.dispatch(d.notifiers, notification{Logger: logger, MessageID: msgID, Subject: subject}, func(ctx context.Context, n Notifier) error {
+		return n.NotifyAlert(ctx, userEmail, alert)
+	})
+}
+
+// digest sends a daily digest to the sinks that support one, without blocking the caller
+func (d *notifierDispatcher) digest(logger *log.Logger, userEmail string, digest *dailyDigest) {
+	var notifiers []Notifier
+	for _, n := range d.notifiers {
+		if _, ok := n.(digestNotifier); ok {
+			notifiers = append(notifiers, n)
+		}
+	}
+	d.dispatch(notifiers, notification{Logger: logger, MessageID: "digest-" + digest.Date}, func(ctx context.Context, n Notifier) error {
+		return n.(digestNotifier).NotifyDigest(ctx, userEmail, digest)
+	})
+}
+
+// dispatch runs send for each of notifiers in its own goroutine under its own timeout,
+// so a slow sink delays neither the others nor the Gmail ack. Results are
+// counted in notifier_deliveries_total{sink,result}.
+func (d *notifierDispatcher) dispatch(notifiers []Notifier, meta notification, send func(ctx context.Context, n Notifier) error) {
+	for _, n := range notifiers {
+		go func(n Notifier) {
+			ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), notificationKey{}, meta), d.timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := send(ctx, n)
+			switch {
+			case err == nil:
+				addCounter("notifier_deliveries_total", 1, "sink", n.Name(), "result", "ok")
+				return
+			case errors.Is(err, context.DeadlineExceeded) || ctx.Err() != nil:
+				addCounter("notifier_deliveries_total", 1, "sink", n.Name(), "result", "timeout")
+			default:
+				addCounter("notifier_deliveries_total", 1, "sink", n.Name(), "result", "error")
+			}
+			meta.Logger.Printf("Notifier %s failed for message %s after %v: %v", n.Name(), meta.MessageID, time.Since(start).Round(time.Millisecond), err)
+		}(n)
+	}
+}
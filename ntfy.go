@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ntfyMaxAttempts bounds posts per notification before it is dropped
+const ntfyMaxAttempts = 3
+
+// ntfyTopicFor returns the full topic URL for a user: NTFY_URL (default
+// https://ntfy.sh) joined with their NTFY_TOPIC_MAP entry
+// ("alice@example.com=alice-spend,...") or NTFY_TOPIC; "" disables ntfy
+func ntfyTopicFor(userEmail string) string {
+	topic := strings.TrimSpace(os.Getenv("NTFY_TOPIC"))
+	for _, entry := range strings.Split(os.Getenv("NTFY_TOPIC_MAP"), ",") {
+		user, userTopic, ok := strings.Cut(entry, "=")
+		if ok && strings.EqualFold(strings.TrimSpace(user), userEmail) {
+			topic = strings.TrimSpace(userTopic)
+			break
+		}
+	}
+	if topic == "" {
+		return ""
+	}
+
+	server := strings.TrimRight(strings.TrimSpace(os.Getenv("NTFY_URL")), "/")
+	if server == "" {
+		server = "https://ntfy.sh"
+	}
+	return server + "/" + strings.Trim(topic, "/")
+}
+
+// ntfyPriority returns "high" for transactions of at least NTFY_PRIORITY_AMOUNT
+// (major units) and "default" otherwise
+func ntfyPriority(txn *CreditCardTransaction) string {
+	value := strings.TrimSpace(os.Getenv("NTFY_PRIORITY_AMOUNT"))
+	if value == "" {
+		return "default"
+	}
+	threshold, err := parseAmountMinorUnitsIn(value, pointDecimalFormat)
+	if err != nil {
+		log.Printf("Invalid NTFY_PRIORITY_AMOUNT %q", value)
+		return "default"
+	}
+	if txn.AmountMinorUnits >= threshold {
+		return "high"
+	}
+	return "default"
+}
+
+// ntfyMessage renders the notification body for a transaction
+func ntfyMessage(txn *CreditCardTransaction) string {
+	verb := "spent at"
+	if txn.Direction == directionCredit {
+		verb = "credited from"
+	}
+	merchant := txn.Merchant
+	if merchant == "" {
+		merchant = "unknown merchant"
+	}
+	message := fmt.Sprintf("%s %s %s", formatAmount(txn.AmountMinorUnits, txn.Currency), verb, merchant)
+	if txn.CardNumber != "" {
+		message += " with card XX" + txn.CardNumber
+	} else if txn.AccountLast4 != "" {
+		message += " from account XX" + txn.AccountLast4
+	}
+	if !txn.Timestamp.IsZero() {
+		message += " on " + txn.Timestamp.In(transactionLocation()).Format("2 Jan 15:04")
+	}
+	return message
+}
+
+// The ntfy sink is registered when NTFY_TOPIC or NTFY_TOPIC_MAP is set
+func init() {
+	registerNotifier("ntfy", func(s *Server) Notifier {
+		if strings.TrimSpace(os.Getenv("NTFY_TOPIC")) == "" && strings.TrimSpace(os.Getenv("NTFY_TOPIC_MAP")) == "" {
+			return nil
+		}
+		return ntfyNotifier{}
+	})
+}
+
+// ntfyNotifier publishes transactions and spend alerts to the users' ntfy topics
+type ntfyNotifier struct{}
+
+// Name identifies the sink in logs and metrics
+func (ntfyNotifier) Name() string { return "ntfy" }
+
+// NotifyTransaction publishes a transaction to the user's ntfy topic
+func (ntfyNotifier) NotifyTransaction(ctx context.Context, userEmail string, txn *CreditCardTransaction) error {
+	target := ntfyTopicFor(userEmail)
+	if target == "" {
+		return nil
+	}
+	title := txn.Merchant
+	if title == "" {
+		title = "Transaction"
+	}
+	return publishNtfy(ctx, target, title, ntfyMessage(txn), ntfyPriority(txn), "credit_card")
+}
+
+// NotifyBill does nothing; ntfy only receives transactions and alerts
+func (ntfyNotifier) NotifyBill(ctx context.Context, userEmail string, bill *BillReminder) error {
+	return nil
+}
+
+// NotifyAlert publishes a triggered spend alert to the user's ntfy topic at high priority
+func (ntfyNotifier) NotifyAlert(ctx context.Context, userEmail string, alert *spendAlert) error {
+	target := ntfyTopicFor(userEmail)
+	if target == "" {
+		return nil
+	}
+	return publishNtfy(ctx, target, "Spend alert", alertText(alert), "high", "warning")
+}
+
+// publishNtfy posts a notification, retrying a few failed attempts before giving up
+func publishNtfy(ctx context.Context, target, title, message, priority, tags string) error {
+	backoff := webhookBaseBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := postNtfy(ctx, target, title, message, priority, tags)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt == ntfyMaxAttempts {
+			return fmt.Errorf("failed after %d attempts: %v", attempt, err)
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return fmt.Errorf("%v (retry cancelled: %v)", err, ctx.Err())
+		}
+		backoff *= 2
+	}
+}
+
+// postNtfy sends one notification and reports whether a failure is worth retrying
+func postNtfy(ctx context.Context, target, title, message, priority, tags string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, webhookClient.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, strings.NewReader(message))
+	if err != nil {
+		return false, fmt.Errorf("invalid ntfy URL: %v", err)
+	}
+	// Headers must be ASCII; ntfy decodes RFC 2047 encoded words
+	req.Header.Set("Title", mime.QEncoding.Encode("utf-8", title))
+	req.Header.Set("Priority", priority)
+	req.Header.Set("Tags", tags)
+	if token := strings.TrimSpace(os.Getenv("NTFY_TOKEN")); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("request failed: %v", errors.Unwrap(err))
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retry, fmt.Errorf("ntfy returned %s", resp.Status)
+	}
+	return false, nil
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// apiParam describes one request parameter
+// In is "query" unless set; path parameters appear in the route as {name}.
+type apiParam struct {
+	Name        string
+	In          string
+	Type        string // OpenAPI primitive type; "string" when empty
+	Required    bool
+	Description string
+}
+
+// apiObject describes a JSON object response built from a map in the handler;
+// each value is a zero value whose Go type gives the property's schema
+type apiObject map[string]interface{}
+
+// apiRoute describes one method of one endpoint for /openapi.json
+type apiRoute struct {
+	Path        string
+	Method      string
+	Summary     string
+	Admin       bool
+	Params      []apiParam
+	Body        interface{} // JSON request body, described like Response
+	Response    interface{} // apiObject, a Go value whose type is described, or nil
+	ContentType string      // Response media type; "application/json" when empty
+}
+
+// userEmailParam is the parameter most user endpoints require
+var userEmailParam = apiParam{Name: "userEmail", Required: true, Description: "Authenticated Gmail or Outlook address"}
+
+// sessionUserParam names the user on endpoints that default to the signed-in user
+var sessionUserParam = apiParam{Name: "userEmail", Description: "Authenticated address; defaults to the session's user, and needs the admin token when SESSION_SECRET is set"}
+
+// providerParam picks the mailbox of a user signed in with several providers
+var providerParam = apiParam{Name: "provider", Description: "gmail, outlook, or imap; defaults to the provider the user signed in with, and is required when there are several"}
+
+// telegramUserParam is the user in a /users/{email}/telegram path
+var telegramUserParam = apiParam{Name: "email", In: "path", Required: true, Description: "Authenticated address; must be the session's user when SESSION_SECRET is set, unless the admin token is sent"}
+
+// apiRoutes lists the documented endpoints; keep it in step with Server.Handler
+var apiRoutes = []apiRoute{
+	{Path: "/auth-url", Method: "get", Summary: "Get the Google or Microsoft OAuth consent URL",
+		Params: []apiParam{
+			{Name: "provider", Description: "gmail (default) or outlook"},
+			{Name: "redirect", Description: "Frontend URL to return to after consent"},
+		},
+		Response: apiObject{"auth_url": ""}},
+	{Path: "/oauth2/callback", Method: "get", Summary: "Complete OAuth and store the user's token",
+		Params: []apiParam{
+			{Name: "code", Required: true, Description: "Authorization code from Google or Microsoft"},
+			{Name: "state", Description: "OAuth state from /auth-url"},
+			{Name: "format", Description: `"json" for a JSON response instead of HTML`},
+		},
+		Response: apiObject{"user_email": "", "provider": "", "has_refresh_token": false, "watch": map[string]interface{}{}}},
+	{Path: "/logout", Method: "post", Summary: "Clear the session cookie"},
+	{Path: "/emails/summary", Method: "get", Summary: "Count the last 30 days of mail and return the latest email",
+		Params: []apiParam{
+			sessionUserParam,
+			providerParam,
+			{Name: "format", Description: "minimal, metadata, or full"},
+			{Name: "limit", Type: "integer", Description: "Messages to list"},
+			{Name: "recipientFilter", Description: "Only count mail sent to this address"},
+			{Name: "from", Description: "Only count mail from this address or domain"},
+			{Name: "sanitize", Type: "boolean", Description: "Sanitize the HTML body"},
+			{Name: "snippetLen", Type: "integer", Description: "Truncate the snippet to this many characters"},
+			{Name: "fullSnippet", Type: "boolean", Description: "Also return the untruncated snippet"},
+		},
+		Response: apiObject{"user_email": "", "count_last_30_days": 0, "latest_email": map[string]interface{}{}, "limit": 0, "recipient_filter": "", "from": ""}},
+	{Path: "/emails/raw", Method: "get", Summary: "Download a Gmail message as RFC 822",
+		Params:      []apiParam{sessionUserParam, {Name: "messageId", Required: true}},
+		ContentType: "message/rfc822"},
+	{Path: "/token/status", Method: "get", Summary: "Show whether the user's token is stored and when it expires",
+		Params:   []apiParam{sessionUserParam, providerParam},
+		Response: apiObject{"authenticated": false, "provider": "", "expires_at": (*time.Time)(nil), "has_refresh_token": false, "expired": false}},
+	{Path: "/watch/start", Method: "post", Summary: "Start Gmail or Graph push notifications for a user",
+		Params:   []apiParam{sessionUserParam, providerParam},
+		Response: apiObject{"status": "", "provider": "", "history_id": uint64(0), "expiration": int64(0)}},
+	{Path: "/transactions", Method: "get", Summary: "List stored transactions",
+		Params: []apiParam{
+			sessionUserParam,
+			{Name: "from", Description: "Earliest timestamp, RFC 3339 or YYYY-MM-DD"},
+			{Name: "to", Description: "Latest timestamp, RFC 3339 or YYYY-MM-DD"},
+			{Name: "merchant", Description: "Case-insensitive merchant substring"},
+			{Name: "minAmount", Type: "number"},
+			{Name: "channel"},
+			{Name: "limit", Type: "integer"},
+			{Name: "offset", Type: "integer"},
+		},
+		Response: apiObject{"user_email": "", "total": 0, "limit": 0, "offset": 0, "transactions": []TransactionRecord{}}},
+	{Path: "/transactions/export", Method: "get", Summary: "Stream stored transactions as CSV, Beancount, or JSON",
+		Params: []apiParam{
+			sessionUserParam,
+			{Name: "format", Description: "csv (default), beancount, or json"},
+			{Name: "from", Description: "Earliest timestamp, RFC 3339 or YYYY-MM-DD"},
+			{Name: "to", Description: "Latest timestamp, RFC 3339 or YYYY-MM-DD"},
+			{Name: "merchant"},
+			{Name: "minAmount", Type: "number"},
+			{Name: "channel"},
+		},
+		ContentType: "text/csv"},
+	{Path: "/transactions/subscriptions", Method: "get", Summary: "Detect recurring charges",
+		Params:   []apiParam{sessionUserParam},
+		Response: apiObject{"user_email": "", "subscriptions": []subscription{}}},
+	{Path: "/transactions/summary", Method: "get", Summary: "Aggregate spend by merchant, category, month, bank, or card",
+		Params: []apiParam{
+			sessionUserParam,
+			{Name: "groupBy", Required: true},
+			{Name: "top", Type: "integer", Description: "Only the largest groups"},
+		},
+		Response: apiObject{"user_email": "", "group_by": "", "primary_currency": "", "groups": []spendGroup{}}},
+	{Path: "/alerts", Method: "get", Summary: "List spend alert rules",
+		Params:   []apiParam{sessionUserParam},
+		Response: apiObject{"user_email": "", "rules": []alertRule{}}},
+	{Path: "/alerts", Method: "put", Summary: "Replace spend alert rules",
+		Params:   []apiParam{sessionUserParam},
+		Body:     []alertRule{},
+		Response: apiObject{"user_email": "", "rules": []alertRule{}}},
+	{Path: "/alerts", Method: "delete", Summary: "Delete one or all spend alert rules",
+		Params:   []apiParam{sessionUserParam, {Name: "id"}},
+		Response: apiObject{"user_email": "", "rules": []alertRule{}}},
+	{Path: "/users/{email}/telegram", Method: "get", Summary: "Show the user's Telegram chat",
+		Params:   []apiParam{telegramUserParam},
+		Response: telegramChatResponse},
+	{Path: "/users/{email}/telegram", Method: "put", Summary: "Register the user's Telegram chat",
+		Params:   []apiParam{telegramUserParam},
+		Body:     apiObject{"chat_id": ""},
+		Response: telegramChatResponse},
+	{Path: "/users/{email}/telegram", Method: "delete", Summary: "Remove the user's Telegram chat",
+		Params:   []apiParam{telegramUserParam},
+		Response: telegramChatResponse},
+	{Path: "/events", Method: "get", Summary: "Stream new transactions and bill reminders as Server-Sent Events",
+		Params:      []apiParam{sessionUserParam, {Name: "lastEventId", Type: "integer", Description: "Replay events after this ID"}},
+		ContentType: "text/event-stream"},
+	{Path: "/bills/calendar.ics", Method: "get", Summary: "iCalendar feed of the user's bill due dates",
+		Params:      []apiParam{userEmailParam, {Name: "token", Required: true, Description: "Feed secret from /bills/calendar-token"}},
+		ContentType: "text/calendar"},
+	{Path: "/bills/calendar-token", Method: "get", Summary: "Get the user's calendar feed token and URL",
+		Params:   []apiParam{sessionUserParam},
+		Response: apiObject{"user_email": "", "token": "", "url": ""}},
+	{Path: "/bills/calendar-token", Method: "post", Summary: "Issue a new calendar feed token, revoking the old URL",
+		Params:   []apiParam{sessionUserParam},
+		Response: apiObject{"user_email": "", "token": "", "url": ""}},
+	{Path: "/accounts", Method: "get", Summary: "List authenticated users with token and watch state", Admin: true,
+		Response: apiObject{"count": 0, "accounts": []accountStatus{}}},
+	{Path: "/emails/summary/bulk", Method: "post", Summary: "Summarize several users' mail, keyed by email", Admin: true,
+		Params: []apiParam{
+			providerParam,
+			{Name: "format", Description: "minimal, metadata, or full"},
+			{Name: "limit", Type: "integer", Description: "Messages to list per user"},
+			{Name: "recipientFilter", Description: "Only count mail sent to this address"},
+			{Name: "from", Description: "Only count mail from this address or domain"},
+			{Name: "sanitize", Type: "boolean", Description: "Sanitize the HTML body"},
+			{Name: "snippetLen", Type: "integer", Description: "Truncate the snippet to this many characters"},
+			{Name: "fullSnippet", Type: "boolean", Description: "Also return the untruncated snippet"},
+		},
+		Body:     apiObject{"user_emails": []string{}},
+		Response: apiObject{"count": 0, "failed": 0, "summaries": map[string]interface{}{}}},
+	{Path: "/admin/reprocess", Method: "post", Summary: "Replay a user's history range through the pipeline", Admin: true,
+		Params: []apiParam{
+			userEmailParam,
+			{Name: "startHistoryId", Type: "integer", Required: true},
+			{Name: "endHistoryId", Type: "integer"},
+		},
+		Response: apiObject{"user_email": "", "start_history_id": uint64(0), "end_history_id": uint64(0), "messages": 0, "outcomes": map[string]int{}}},
+	{Path: "/admin/deadletter", Method: "get", Summary: "List failed outbound deliveries", Admin: true,
+		Response: apiObject{"count": 0, "dead_letters": []deadLetter{}}},
+	{Path: "/admin/deadletter", Method: "post", Summary: "Requeue or delete a dead letter", Admin: true,
+		Params:   []apiParam{{Name: "id", Type: "integer", Required: true}, {Name: "action", Required: true, Description: "requeue or delete"}},
+		Response: apiObject{"id": int64(0), "status": ""}},
+	{Path: "/imap/accounts", Method: "post", Summary: "Register an IMAP mailbox and start polling it", Admin: true,
+		Body:     apiObject{"user_email": "", "host": "", "port": 0, "tls": "", "username": "", "password": ""},
+		Response: apiObject{"status": "", "user_email": "", "host": "", "port": 0, "tls": "", "username": ""}},
+	{Path: "/imap/status", Method: "get", Summary: "Show each IMAP account's polling health", Admin: true,
+		Response: apiObject{"count": 0, "accounts": []imapPollStatus{}}},
+	{Path: "/hooks", Method: "post", Summary: "Subscribe a REST hook to a user's transaction, bill reminder or alert events", Admin: true,
+		Body:     apiObject{"user_email": "", "target_url": "", "event": ""},
+		Response: restHook{}},
+	{Path: "/hooks/{id}", Method: "delete", Summary: "Unsubscribe a REST hook", Admin: true,
+		Params: []apiParam{{Name: "id", In: "path", Required: true}}},
+	{Path: "/hooks/sample", Method: "get", Summary: "Sample hook payloads for field mapping", Admin: true,
+		Params:   []apiParam{{Name: "event", Description: "transaction.created (default), bill_reminder.created or alert.triggered"}},
+		Response: []webhookPayload{}},
+	{Path: "/webhook/test", Method: "post", Summary: "Send a test event to WEBHOOK_URL", Admin: true,
+		Response: apiObject{"status": "", "attempts": 0, "error": ""}},
+	{Path: "/parser/reload", Method: "post", Summary: "Reload PARSER_RULES_FILE", Admin: true,
+		Response: apiObject{"status": "", "groups": 0, "categories": 0}},
+	{Path: "/parser/test", Method: "post", Summary: "Run the parsing pipeline on a pasted email",
+		Body: apiObject{"subject": "", "body": "", "from": "", "to": "", "cc": "", "snippet": ""},
+		Response: apiObject{"kind": EmailKind(""), "is_transaction": false, "reason": "", "from_snippet": false,
+			"transaction": (*CreditCardTransaction)(nil), "transactions": []*CreditCardTransaction{},
+			"bill_reminder": (*BillReminder)(nil), "matched_patterns": []string{}}},
+	{Path: "/metrics", Method: "get", Summary: "Counters in the Prometheus text format",
+		ContentType: "text/plain"},
+	{Path: "/openapi.json", Method: "get", Summary: "This document",
+		Response: map[string]interface{}{}},
+}
+
+// telegramChatResponse is the body returned by every /users/{email}/telegram method
+var telegramChatResponse = apiObject{"user_email": "", "registered": false, "chat_id": "", "enabled": false}
+
+// openAPISchemas collects named struct schemas into components while describing routes
+type openAPISchemas map[string]interface{}
+
+// schemaFor describes a Go type, registering named structs as components
+func (schemas openAPISchemas) schemaFor(t reflect.Type) map[string]interface{} {
+	if t == nil {
+		return map[string]interface{}{}
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == reflect.TypeOf(json.RawMessage{}):
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": schemas.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemas.schemaFor(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return schemas.structSchema(t)
+		}
+		name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+		if _, ok := schemas[name]; !ok {
+			// Register first so self-referencing types terminate
+			schemas[name] = map[string]interface{}{}
+			schemas[name] = schemas.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]interface{}{}
+}
+
+// structSchema describes a struct's JSON fields, flattening embedded structs
+func (schemas openAPISchemas) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	schemas.addFields(t, properties)
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+// addFields adds the JSON properties of t's exported fields to properties
+func (schemas openAPISchemas) addFields(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			schemas.addFields(field.Type, properties)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = schemas.schemaFor(field.Type)
+	}
+}
+
+// schemaForValue describes an apiObject or the type of any other value
+func (schemas openAPISchemas) schemaForValue(value interface{}) map[string]interface{} {
+	object, ok := value.(apiObject)
+	if !ok {
+		return schemas.schemaFor(reflect.TypeOf(value))
+	}
+	properties := map[string]interface{}{}
+	for name, example := range object {
+		properties[name] = schemas.schemaForValue(example)
+	}
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+// buildOpenAPISpec generates the OpenAPI 3 document for apiRoutes
+func buildOpenAPISpec() map[string]interface{} {
+	schemas := openAPISchemas{}
+	paths := map[string]map[string]interface{}{}
+
+	for _, route := range apiRoutes {
+		parameters := []interface{}{}
+		for _, param := range route.Params {
+			in, typ := param.In, param.Type
+			if in == "" {
+				in = "query"
+			}
+			if typ == "" {
+				typ = "string"
+			}
+			spec := map[string]interface{}{
+				"name":     param.Name,
+				"in":       in,
+				"required": param.Required,
+				"schema":   map[string]interface{}{"type": typ},
+			}
+			if param.Description != "" {
+				spec["description"] = param.Description
+			}
+			parameters = append(parameters, spec)
+		}
+
+		contentType := route.ContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		media := map[string]interface{}{}
+		if route.Response != nil {
+			media["schema"] = schemas.schemaForValue(route.Response)
+		}
+		responses := map[string]interface{}{
+			"200": map[string]interface{}{
+				"description": "OK",
+				"content":     map[string]interface{}{contentType: media},
+			},
+		}
+		if len(route.Params) > 0 || route.Body != nil {
+			responses["400"] = map[string]interface{}{"description": "Invalid or missing parameters"}
+		}
+		if route.Admin {
+			responses["401"] = map[string]interface{}{"description": "Missing or invalid admin token"}
+		}
+		operation := map[string]interface{}{
+			"summary":    route.Summary,
+			"parameters": parameters,
+			"responses":  responses,
+		}
+		if route.Body != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": schemas.schemaForValue(route.Body)},
+				},
+			}
+		}
+		if route.Admin {
+			operation["security"] = []interface{}{map[string]interface{}{"adminToken": []string{}}}
+		}
+
+		if paths[route.Path] == nil {
+			paths[route.Path] = map[string]interface{}{}
+		}
+		paths[route.Path][route.Method] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "read-emails API",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"adminToken": map[string]interface{}{"type": "http", "scheme": "bearer", "description": "ADMIN_TOKEN"},
+			},
+		},
+	}
+}
+
+// openAPIHandler serves the generated OpenAPI document
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildOpenAPISpec())
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// openAPIDocument is the part of an OpenAPI 3 document the test checks
+type openAPIDocument struct {
+	OpenAPI string `json:"openapi"`
+	Info    struct {
+		Title   string `json:"title"`
+		Version string `json:"version"`
+	} `json:"info"`
+	Paths map[string]map[string]struct {
+		Summary    string `json:"summary"`
+		Parameters []struct {
+			Name        string                 `json:"name"`
+			In          string                 `json:"in"`
+			Required    bool                   `json:"required"`
+			Description string                 `json:"description"`
+			Schema      map[string]interface{} `json:"schema"`
+		} `json:"parameters"`
+		RequestBody *struct {
+			Required bool `json:"required"`
+			Content  map[string]struct {
+				Schema map[string]interface{} `json:"schema"`
+			} `json:"content"`
+		} `json:"requestBody"`
+		Responses map[string]struct {
+			Description string `json:"description"`
+			Content     map[string]struct {
+				Schema map[string]interface{} `json:"schema"`
+			} `json:"content"`
+		} `json:"responses"`
+		Security []map[string][]string `json:"security"`
+	} `json:"paths"`
+	Components struct {
+		Schemas         map[string]map[string]interface{} `json:"schemas"`
+		SecuritySchemes map[string]interface{}            `json:"securitySchemes"`
+	} `json:"components"`
+}
+
+var (
+	openAPIMethods     = map[string]bool{"get": true, "put": true, "post": true, "delete": true, "options": true, "head": true, "patch": true, "trace": true}
+	openAPITypes       = map[string]bool{"string": true, "number": true, "integer": true, "boolean": true, "array": true, "object": true}
+	openAPIPathParam   = regexp.MustCompile(`\{([^}]+)\}`)
+	openAPIResponseKey = regexp.MustCompile(`^(default|[1-5](\d\d|XX))$`)
+)
+
+func TestOpenAPIDocument(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newTestServer(t).Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+
+	decoder := json.NewDecoder(rec.Body)
+	decoder.DisallowUnknownFields()
+	var doc openAPIDocument
+	if err := decoder.Decode(&doc); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") || doc.Info.Title == "" || doc.Info.Version == "" {
+		t.Errorf("openapi %q, info %+v: want a 3.x version, title and version", doc.OpenAPI, doc.Info)
+	}
+	if len(doc.Paths) == 0 {
+		t.Fatal("no paths")
+	}
+
+	for path, operations := range doc.Paths {
+		if !strings.HasPrefix(path, "/") {
+			t.Errorf("path %q does not start with /", path)
+		}
+		templated := map[string]bool{}
+		for _, match := range openAPIPathParam.FindAllStringSubmatch(path, -1) {
+			templated[match[1]] = true
+		}
+
+		for method, op := range operations {
+			where := strings.ToUpper(method) + " " + path
+			if !openAPIMethods[method] {
+				t.Errorf("%s: invalid method", where)
+			}
+			if op.Summary == "" {
+				t.Errorf("%s: no summary", where)
+			}
+			for _, param := range op.Parameters {
+				switch {
+				case param.Name == "":
+					t.Errorf("%s: unnamed parameter", where)
+				case param.In != "query" && param.In != "path" && param.In != "header" && param.In != "cookie":
+					t.Errorf("%s: parameter %s in %q", where, param.Name, param.In)
+				case param.In == "path" && (!param.Required || !templated[param.Name]):
+					t.Errorf("%s: path parameter %s must be required and appear in the path", where, param.Name)
+				}
+				checkOpenAPISchema(t, &doc, where+" parameter "+param.Name, param.Schema)
+			}
+			if op.RequestBody != nil {
+				for _, media := range op.RequestBody.Content {
+					checkOpenAPISchema(t, &doc, where+" request body", media.Schema)
+				}
+			}
+			if len(op.Responses) == 0 {
+				t.Errorf("%s: no responses", where)
+			}
+			for status, response := range op.Responses {
+				if !openAPIResponseKey.MatchString(status) || response.Description == "" {
+					t.Errorf("%s: response %q needs a valid status and description", where, status)
+				}
+				for _, media := range response.Content {
+					checkOpenAPISchema(t, &doc, where+" response "+status, media.Schema)
+				}
+			}
+			for _, requirement := range op.Security {
+				for scheme := range requirement {
+					if doc.Components.SecuritySchemes[scheme] == nil {
+						t.Errorf("%s: undefined security scheme %q", where, scheme)
+					}
+				}
+			}
+		}
+	}
+
+	for name, schema := range doc.Components.Schemas {
+		checkOpenAPISchema(t, &doc, "component "+name, schema)
+	}
+	transaction := doc.Components.Schemas["CreditCardTransaction"]
+	properties, _ := transaction["properties"].(map[string]interface{})
+	for _, field := range []string{"amount_minor_units", "merchant", "bank", "currency"} {
+		if properties[field] == nil {
+			t.Errorf("CreditCardTransaction schema lacks %q", field)
+		}
+	}
+}
+
+// checkOpenAPISchema reports invalid types and $refs that don't resolve in a
+// schema and its nested schemas
+func checkOpenAPISchema(t *testing.T, doc *openAPIDocument, where string, schema map[string]interface{}) {
+	t.Helper()
+	if ref, ok := schema["$ref"].(string); ok {
+		name, found := strings.CutPrefix(ref, "#/components/schemas/")
+		if !found || doc.Components.Schemas[name] == nil {
+			t.Errorf("%s: unresolved $ref %q", where, ref)
+		}
+		return
+	}
+	if typ, ok := schema["type"]; ok && !openAPITypes[typ.(string)] {
+		t.Errorf("%s: invalid type %v", where, typ)
+	}
+	if schema["type"] == "array" && schema["items"] == nil {
+		t.Errorf("%s: array without items", where)
+	}
+	for key, value := range schema {
+		switch key {
+		case "items", "additionalProperties":
+			if nested, ok := value.(map[string]interface{}); ok {
+				checkOpenAPISchema(t, doc, where+"."+key, nested)
+			}
+		case "properties":
+			for name, property := range value.(map[string]interface{}) {
+				checkOpenAPISchema(t, doc, where+"."+name, property.(map[string]interface{}))
+			}
+		}
+	}
+}
+
+func TestOpenAPIRoutesAreServed(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+	handler := newTestServer(t).Handler()
+	for _, route := range apiRoutes {
+		path := openAPIPathParam.ReplaceAllString(route.Path, "x")
+		req := httptest.NewRequest(strings.ToUpper(route.Method), path, nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		// ServeMux's own 404, as opposed to a handler reporting a missing resource
+		if rec.Code == http.StatusNotFound && rec.Body.String() == "404 page not found\n" {
+			t.Errorf("%s %s is documented but not routed", strings.ToUpper(route.Method), route.Path)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/pubsub/v1"
+)
+
+const (
+	// outboundBatchSize is the most messages sent in one publish request
+	outboundBatchSize = 100
+
+	// outboundBatchDelay is how long messages wait to be batched before publishing
+	outboundBatchDelay = time.Second
+
+	// outboundPublishTimeout bounds each publish request
+	outboundPublishTimeout = 30 * time.Second
+)
+
+// outboundEvent is the JSON data of each message published to OUTBOUND_TOPIC
+type outboundEvent struct {
+	Event        string                 `json:"event"` // "transaction", "bill_reminder", "alert" or "email"
+	UserEmail    string                 `json:"user_email"`
+	MessageID    string                 `json:"message_id"`
+	Transaction  *CreditCardTransaction `json:"transaction,omitempty"`
+	BillReminder *BillReminder          `json:"bill_reminder,omitempty"`
+	Alert        *spendAlert            `json:"alert,omitempty"`
+	Subject      string                 `json:"subject,omitempty"` // Email metadata only; bodies are never published
+	From         string                 `json:"from,omitempty"`
+	Kind         EmailKind              `json:"kind,omitempty"`
+	PublishedAt  time.Time              `json:"published_at"`
+}
+
+// outboundPublisher batches messages for OUTBOUND_TOPIC and publishes them in
+// the background; failures are counted in /metrics and never block processing
+// It uses the Pub/Sub REST client from google.golang.org/api, already a
+// dependency for Gmail and Sheets, rather than cloud.google.com/go/pubsub and
+// its gRPC stack; batching is done here instead.
+type outboundPublisher struct {
+	sync.Mutex
+	pending   []*pubsub.PubsubMessage
+	scheduled bool
+	service   *pubsub.Service
+}
+
+// outboundTopicName returns the topic from OUTBOUND_TOPIC, qualified with
+// GOOGLE_CLOUD_PROJECT when short, or "" when publishing is off
+func outboundTopicName() (string, error) {
+	topic := strings.TrimSpace(os.Getenv("OUTBOUND_TOPIC"))
+	if topic == "" || strings.HasPrefix(topic, "projects/") {
+		if topic != "" && !topicNamePattern.MatchString(topic) {
+			return "", fmt.Errorf("OUTBOUND_TOPIC %q does not match projects/*/topics/*", topic)
+		}
+		return topic, nil
+	}
+
+	projectID := strings.TrimSpace(os.Getenv("GOOGLE_CLOUD_PROJECT"))
+	if projectID == "" || projectID == placeholderProjectID {
+		return "", errProjectNotConfigured
+	}
+	return fmt.Sprintf("projects/%s/topics/%s", projectID, topic), nil
+}
+
+// outboundMetadataEnabled reports whether non-transaction email metadata is
+// published as well, from OUTBOUND_PUBLISH_METADATA
+func outboundMetadataEnabled() bool {
+	return strings.EqualFold(os.Getenv("OUTBOUND_PUBLISH_METADATA"), "true")
+}
+
+// The outbound sink is registered when OUTBOUND_TOPIC is set
+func init() {
+	registerNotifier("outbound", func(s *Server) Notifier {
+		if strings.TrimSpace(os.Getenv("OUTBOUND_TOPIC")) == "" {
+			return nil
+		}
+		return &s.outbound
+	})
+}
+
+// Name identifies the sink in logs and metrics
+func (p *outboundPublisher) Name() string { return "outbound" }
+
+// NotifyTransaction queues a transaction for the next batch
+func (p *outboundPublisher) NotifyTransaction(ctx context.Context, userEmail string, txn *CreditCardTransaction) error {
+	meta := notificationFrom(ctx)
+	p.publishTransaction(meta.Logger, userEmail, meta.MessageID, txn)
+	return nil
+}
+
+// NotifyBill queues a bill reminder for the next batch
+func (p *outboundPublisher) NotifyBill(ctx context.Context, userEmail string, bill *BillReminder) error {
+	meta := notificationFrom(ctx)
+	p.enqueue(meta.Logger, outboundEvent{Event: "bill_reminder", UserEmail: userEmail, MessageID: meta.MessageID, BillReminder: bill}, map[string]string{
+		"event":      "bill_reminder",
+		"user_email": userEmail,
+	})
+	return nil
+}
+
+// NotifyAlert queues a triggered spend alert for the next batch
+func (p *outboundPublisher) NotifyAlert(ctx context.Context, userEmail string, alert *spendAlert) error {
+	meta := notificationFrom(ctx)
+	p.enqueue(meta.Logger, outboundEvent{Event: "alert", UserEmail: userEmail, MessageID: meta.MessageID, Alert: alert}, map[string]string{
+		"event":      "alert",
+		"user_email": userEmail,
+	})
+	return nil
+}
+
+// publishTransaction queues a parsed transaction, with attributes subscribers can filter on
+func (p *outboundPublisher) publishTransaction(logger *log.Logger, userEmail, msgID string, txn *CreditCardTransaction) {
+	p.enqueue(logger, outboundEvent{Event: "transaction", UserEmail: userEmail, MessageID: msgID, Transaction: txn}, map[string]string{
+		"event":      "transaction",
+		"user_email": userEmail,
+		"channel":    txn.Channel,
+		"direction":  txn.Direction,
+	})
+}
+
+// publishEmailMetadata queues the subject and sender of a non-transaction email
+// when OUTBOUND_PUBLISH_METADATA=true
+func (p *outboundPublisher) publishEmailMetadata(logger *log.Logger, userEmail, msgID, subject, from string, kind EmailKind) {
+	if !outboundMetadataEnabled() {
+		return
+	}
+	p.enqueue(logger, outboundEvent{Event: "email", UserEmail: userEmail, MessageID: msgID, Subject: subject, From: from, Kind: kind}, map[string]string{
+		"event":      "email",
+		"user_email": userEmail,
+		"kind":       string(kind),
+	})
+}
+
+// enqueue adds a message to the pending batch, publishing at once when the batch is full
+func (p *outboundPublisher) enqueue(logger *log.Logger, event outboundEvent, attributes map[string]string) {
+	topic, err := outboundTopicName()
+	if err != nil {
+		logger.Printf("Not publishing %s event: %v", event.Event, err)
+		addCounter("outbound_publish_failures_total", 1, "reason", "config")
+		return
+	}
+	if topic == "" {
+		return
+	}
+
+	event.PublishedAt = time.Now()
+	data, err := json.Marshal(event)
+	if err != nil {
+		logger.Printf("Unable to encode %s event: %v", event.Event, err)
+		addCounter("outbound_publish_failures_total", 1, "reason", "encode")
+		return
+	}
+	message := &pubsub.PubsubMessage{Data: base64.StdEncoding.EncodeToString(data), Attributes: attributes}
+
+	p.Lock()
+	defer p.Unlock()
+	p.pending = append(p.pending, message)
+	if len(p.pending) >= outboundBatchSize {
+		batch := p.pending
+		p.pending = nil
+		go p.publish(topic, batch)
+		return
+	}
+	if !p.scheduled {
+		p.scheduled = true
+		time.AfterFunc(outboundBatchDelay, func() { p.flush(topic) })
+	}
+}
+
+// flush publishes whatever is pending once outboundBatchDelay has passed
+func (p *outboundPublisher) flush(topic string) {
+	p.Lock()
+	batch := p.pending
+	p.pending = nil
+	p.scheduled = false
+	p.Unlock()
+
+	if len(batch) > 0 {
+		p.publish(topic, batch)
+	}
+}
+
+// publish sends one batch using application default credentials
+func (p *outboundPublisher) publish(topic string, batch []*pubsub.PubsubMessage) {
+	ctx, cancel := context.WithTimeout(context.Background(), outboundPublishTimeout)
+	defer cancel()
+
+	p.Lock()
+	if p.service == nil {
+		service, err := pubsub.NewService(context.Background())
+		if err != nil {
+			p.Unlock()
+			log.Printf("Unable to create Pub/Sub client for %s: %v", topic, err)
+			addCounter("outbound_publish_failures_total", int64(len(batch)), "reason", "client")
+			return
+		}
+		p.service = service
+	}
+	service := p.service
+	p.Unlock()
+
+	_, err := service.Projects.Topics.Publish(topic, &pubsub.PublishRequest{Messages: batch}).Context(ctx).Do()
+	if err != nil {
+		log.Printf("Unable to publish %d messages to %s: %v", len(batch), topic, err)
+		addCounter("outbound_publish_failures_total", int64(len(batch)), "reason", "api")
+		return
+	}
+	addCounter("outbound_published_total", int64(len(batch)))
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"google.golang.org/api/option"
+	"google.golang.org/api/pubsub/v1"
+)
+
+func TestOutboundPublishesTransactionsAndBills(t *testing.T) {
+	const topic = "projects/test-project/topics/transactions"
+	t.Setenv("OUTBOUND_TOPIC", topic)
+
+	var (
+		mu        sync.Mutex
+		paths     []string
+		published []*pubsub.PubsubMessage
+	)
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req pubsub.PublishRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		published = append(published, req.Messages...)
+		mu.Unlock()
+		json.NewEncoder(w).Encode(pubsub.PublishResponse{MessageIds: make([]string, len(req.Messages))})
+	}))
+	defer fake.Close()
+
+	service, err := pubsub.NewService(context.Background(), option.WithEndpoint(fake.URL+"/"), option.WithHTTPClient(fake.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &outboundPublisher{service: service}
+
+	txn := parseCreditCardTransaction("Alert", "Rs.250.00 debited from A/c XX1234 via UPI to VPA swiggy@icici")
+	bill := &BillReminder{Issuer: "HDFC Bank", TotalDue: "12,345.00", Currency: "INR", DueDate: "05 Dec 2025"}
+	p.NotifyTransaction(context.Background(), "user@example.com", txn)
+	p.NotifyBill(context.Background(), "user@example.com", bill)
+	p.flush(topic)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(paths) != 1 || paths[0] != "/v1/"+topic+":publish" {
+		t.Fatalf("publish requests = %v, want one batch to %s", paths, topic)
+	}
+	if len(published) != 2 {
+		t.Fatalf("published %d messages, want 2", len(published))
+	}
+
+	wantAttributes := []map[string]string{
+		{"event": "transaction", "user_email": "user@example.com", "channel": channelUPI, "direction": directionDebit},
+		{"event": "bill_reminder", "user_email": "user@example.com"},
+	}
+	for i, msg := range published {
+		for key, want := range wantAttributes[i] {
+			if got := msg.Attributes[key]; got != want {
+				t.Errorf("message %d attribute %s = %q, want %q", i, key, got, want)
+			}
+		}
+	}
+
+	data, err := base64.StdEncoding.DecodeString(published[1].Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var event outboundEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.BillReminder == nil || event.BillReminder.TotalDue != "12,345.00" || event.Transaction != nil {
+		t.Errorf("bill event = %s, want the bill reminder only", data)
+	}
+}
@@ -0,0 +1,895 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// CreditCardTransaction represents parsed credit card transaction details
+type CreditCardTransaction struct {
+	Amount           string    `json:"amount"`             // Matched amount digits, e.g. "1,424.00"
+	RawAmount        string    `json:"raw_amount"`         // Full matched amount text including the currency, e.g. "Rs.1,424.00"
+	AmountMinorUnits int64     `json:"amount_minor_units"` // Amount in minor units (paise, cents)
+	AmountValue      float64   `json:"amount_value"`       // Amount in major units, for display and thresholds
+	Currency         string    `json:"currency"`           // ISO 4217 code, e.g. "INR"
+	CardNumber       string    `json:"card_number"`
+	Merchant         string    `json:"merchant"`
+	Category         string    `json:"category"` // From the merchant category mappings, categoryUncategorized if none match
+	Date             string    `json:"date"`
+	Time             string    `json:"time"`
+	Channel          string    `json:"channel"`          // Payment channel, one of the channel* constants
+	VPA              string    `json:"vpa"`              // UPI virtual payment address of the counterparty
+	UPIRef           string    `json:"upi_ref"`          // UPI transaction reference number
+	AccountLast4     string    `json:"account_last4"`    // Last digits of the bank account for UPI/netbanking debits
+	Direction        string    `json:"direction"`        // directionDebit, directionCredit, or directionUnknown
+	IsRefund         bool      `json:"is_refund"`        // True when the alert describes a refund or reversal
+	IsRecurring      bool      `json:"is_recurring"`     // True when the charge matches a detected subscription
+	LowValue         bool      `json:"low_value"`        // True when AmountValue is below MIN_TRANSACTION_AMOUNT
+	Timestamp        time.Time `json:"timestamp"`        // Date and Time combined, in the configured TZ
+	TimestampSource  string    `json:"timestamp_source"` // timestampSourceBody, timestampSourceForwardedDate or timestampSourceInternalDate
+	ReferenceID      string    `json:"reference_id"`     // Bank reference, UTR, auth code, or transaction ID
+	Segment          int       `json:"segment"`          // 1-based line of a multi-transaction alert, 0 for single alerts
+	Status           string    `json:"status"`           // statusCompleted, statusDeclined, statusPending, or statusUnknown
+	Network          string    `json:"network"`          // Card network, e.g. "Visa" or "RuPay"
+	Issuer           string    `json:"issuer"`           // Issuing bank, from the text or the sender's bank parser
+	Bank             string    `json:"bank"`             // Sending bank, from the From domain via bankNameFor
+	FundingSource    string    `json:"funding_source"`   // How a wallet/BNPL payment was funded, e.g. "UPI linked to HDFC"
+
+	Confidence        float64  `json:"confidence"`         // 0-1 trust in the parse, see scoreTransaction
+	ConfidenceSignals []string `json:"confidence_signals"` // Signals that raised or lowered Confidence
+
+	AvailableBalance           string  `json:"available_balance"`             // Matched available limit/balance digits
+	AvailableBalanceMinorUnits int64   `json:"available_balance_minor_units"` // Available limit/balance in minor units
+	AvailableBalanceValue      float64 `json:"available_balance_value"`       // Available limit/balance in major units
+}
+
+// DedupKey identifies a transaction across repeated or re-delivered alerts
+// The reference ID is preferred; otherwise card, amount, timestamp, merchant
+// (case and spacing ignored), and the line of a multi-transaction alert are
+// combined, so identical lines of one digest stay distinct while a re-delivered
+// digest still dedups. Without a reference ID, two single alerts of the same
+// amount at the same merchant and time on one card collide, and only the first
+// is stored.
+func (t *CreditCardTransaction) DedupKey() string {
+	if t.ReferenceID != "" {
+		return "ref:" + t.ReferenceID
+	}
+	card := t.CardNumber
+	if card == "" {
+		card = t.AccountLast4
+	}
+	ts := ""
+	if !t.Timestamp.IsZero() {
+		ts = t.Timestamp.UTC().Format(time.RFC3339)
+	}
+	merchant := strings.ToLower(strings.Join(strings.Fields(t.Merchant), " "))
+	key := fmt.Sprintf("txn:%s:%d:%s:%s:%s", card, t.AmountMinorUnits, t.Currency, ts, merchant)
+	if t.Segment > 0 {
+		key += fmt.Sprintf("#%d", t.Segment)
+	}
+	return key
+}
+
+// CountsTowardSpend reports whether the transaction should be included in spend totals
+// Declined transactions never moved money, so they are excluded.
+func (t *CreditCardTransaction) CountsTowardSpend() bool {
+	return t.Direction == directionDebit && t.Status != statusDeclined
+}
+
+// Money movement directions reported in CreditCardTransaction.Direction
+const (
+	directionDebit   = "debit"
+	directionCredit  = "credit"
+	directionUnknown = "unknown"
+)
+
+// Transaction outcomes reported in CreditCardTransaction.Status
+const (
+	statusCompleted = "completed"
+	statusDeclined  = "declined"
+	statusPending   = "pending"
+	statusUnknown   = "unknown"
+)
+
+// Payment channels reported in CreditCardTransaction.Channel
+const (
+	channelCreditCard = "credit_card"
+	channelDebitCard  = "debit_card"
+	channelUPI        = "upi"
+	channelNetbanking = "netbanking"
+	channelWallet     = "wallet"
+	channelBNPL       = "bnpl"
+)
+
+// namedPattern pairs a display name with the pattern that detects it
+type namedPattern struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+// cardNetworks maps card network names to the patterns that mention them
+var cardNetworks = []namedPattern{
+	{"Visa", regexp.MustCompile(`(?i)\bvisa\b`)},
+	{"Mastercard", regexp.MustCompile(`(?i)\bmaster\s?card\b`)},
+	{"American Express", regexp.MustCompile(`(?i)\bamex\b|\bamerican express\b`)},
+	{"RuPay", regexp.MustCompile(`(?i)\brupay\b`)},
+	{"Diners Club", regexp.MustCompile(`(?i)\bdiners\b`)},
+}
+
+// cardIssuers maps issuing bank names to the patterns that mention them
+var cardIssuers = []namedPattern{
+	{"HDFC Bank", regexp.MustCompile(`(?i)\bhdfc\b`)},
+	{"ICICI Bank", regexp.MustCompile(`(?i)\bicici\b`)},
+	{"SBI Card", regexp.MustCompile(`(?i)\bsbi\b|\bstate bank\b`)},
+	{"Axis Bank", regexp.MustCompile(`(?i)\baxis bank\b`)},
+	{"Kotak Mahindra Bank", regexp.MustCompile(`(?i)\bkotak\b`)},
+	{"IDFC First Bank", regexp.MustCompile(`(?i)\bidfc\b`)},
+	{"IndusInd Bank", regexp.MustCompile(`(?i)\bindusind\b`)},
+	{"Yes Bank", regexp.MustCompile(`(?i)\byes bank\b`)},
+	{"RBL Bank", regexp.MustCompile(`(?i)\brbl\b`)},
+	{"AU Small Finance Bank", regexp.MustCompile(`(?i)\bau (?:small finance )?bank\b`)},
+	{"HSBC", regexp.MustCompile(`(?i)\bhsbc\b`)},
+	{"Standard Chartered", regexp.MustCompile(`(?i)\bstandard chartered\b`)},
+	{"Citibank", regexp.MustCompile(`(?i)\bciti(?:bank)?\b`)},
+	{"American Express", regexp.MustCompile(`(?i)\bamerican express\b|\bamex\b`)},
+}
+
+// handlePattern matches email addresses and UPI handles like "swiggy@icici",
+// whose domain names the payee's bank rather than the card's
+var handlePattern = regexp.MustCompile(`\S+@\S+`)
+
+// detectCardNetworkAndIssuer returns the card network and issuing bank mentioned in text
+func detectCardNetworkAndIssuer(text string) (string, string) {
+	text = handlePattern.ReplaceAllString(text, " ")
+	return earliestMatch(cardNetworks, text), earliestMatch(cardIssuers, text)
+}
+
+// earliestMatch returns the name of the candidate mentioned first in text
+func earliestMatch(candidates []namedPattern, text string) string {
+	name, first := "", -1
+	for _, c := range candidates {
+		if loc := c.pattern.FindStringIndex(text); loc != nil && (first < 0 || loc[0] < first) {
+			name, first = c.name, loc[0]
+		}
+	}
+	return name
+}
+
+// merchantTerminator ends a merchant name capture: a following date, time,
+// amount or balance, the verb of a clause like "FLIPKART has been credited",
+// or the end of the sentence
+const merchantTerminator = `(?:\s+on\b|\s+at\b|\s+avl\b|\s+info:|\s+(?:is|was|has|have|will)\b|\s+(?:rs\.?|inr|usd|₹|\$)\s*\d|\.(?:\s|$)|[,;(]|\s*$)`
+
+// Transaction patterns are compiled once; they run for every message in every push notification
+var (
+	// transactionKeywordPattern matches common credit card transaction keywords in lowercased text
+	transactionKeywordPattern = regexp.MustCompile(`credit card|debit.*card|card.*ending|card.*\*\*|debited.*card|transaction.*card|credited.*card|refund.*card|card.*refund|revers.*card`)
+
+	// otpPattern matches one-time password emails in lowercased text
+	otpPattern = regexp.MustCompile(`\botp\b|one[- ]time password|verification code`)
+
+	// securityAlertPattern matches sign-in and account security notices in lowercased text
+	securityAlertPattern = regexp.MustCompile(`new sign[- ]?in|new login|suspicious (?:sign[- ]?in|login|activity)|unusual (?:sign[- ]?in|login|activity)|login (?:alert|attempt)|sign[- ]?in (?:alert|attempt)|new device|password (?:was )?(?:changed|reset)|security alert`)
+
+	// promoPattern matches marketing markers in lowercased text
+	promoPattern = regexp.MustCompile(`unsubscribe|apply now|pre-?approved|lifetime[- ]free|limited[- ]time offer|exclusive offer|upgrade your card`)
+
+	// debitVerbPattern and creditVerbPattern match the verbs that give a transaction its direction
+	debitVerbPattern  = regexp.MustCompile(`(?i)\b(?:debited|spent|charged|paid|purchase[ds]?|withdrawn|deducted)\b`)
+	creditVerbPattern = regexp.MustCompile(`(?i)\b(?:credited|refunded|refund|reversed|reversal|cashback)\b`)
+
+	// refundPattern matches reversal language that marks a credit as a refund
+	refundPattern = regexp.MustCompile(`(?i)\b(?:refund(?:ed)?|revers(?:al|ed)|chargeback)\b`)
+
+	// declinedPattern matches alerts for transactions that did not go through
+	declinedPattern = regexp.MustCompile(`(?i)\b(?:declined|failed|unsuccessful|could not be (?:processed|completed)|was not (?:processed|completed)|rejected)\b`)
+
+	// pendingPattern matches authorization alerts for transactions not yet settled
+	pendingPattern = regexp.MustCompile(`(?i)\b(?:being processed|is pending|pending (?:authori[sz]ation|confirmation)|authori[sz]ation hold|on hold)\b`)
+
+	// upiKeywordPattern matches UPI alerts in lowercased text
+	upiKeywordPattern = regexp.MustCompile(`\bupi\b|\bvpa\b`)
+
+	// netbankingKeywordPattern matches netbanking transfers in lowercased text
+	netbankingKeywordPattern = regexp.MustCompile(`net\s*banking|\bneft\b|\bimps\b|\brtgs\b`)
+
+	// walletKeywordPattern matches wallet payments (Paytm, PhonePe, Amazon Pay) in lowercased text
+	walletKeywordPattern = regexp.MustCompile(`\bwallet\b|\bpaytm\b|\bphonepe\b|\bamazon pay\b|\bmobikwik\b|\bfreecharge\b`)
+
+	// bnplKeywordPattern matches buy-now-pay-later payments (Simpl, LazyPay, Pay Later) in lowercased text
+	bnplKeywordPattern = regexp.MustCompile(`\bsimpl\b|\blazypay\b|\bpay ?later\b|\bpostpaid\b|\bzestmoney\b`)
+
+	// accountDebitPattern matches account debit/credit verbs that accompany UPI and netbanking alerts
+	accountDebitPattern = regexp.MustCompile(`\b(?:debited|credited|paid|sent|received)\b`)
+
+	// amountPattern matches amounts like "Rs.424.00", "₹424.00", "$424.00", "INR 424.00"
+	// capturing the currency marker and the number with either separator, read per
+	// LOCALE by parseAmountMinorUnits; bestAmountMatch picks the
+	// transaction amount among its matches. Some banks space out the marker's dot
+	// or the decimal point ("Rs . 500", "INR 1,234 . 56", "1,234. 56"); the spaced
+	// form only takes a two-digit fraction after a whole number, so "Rs 1,234.56 . 25"
+	// stops at 1,234.56, and normalizeAmount removes the spaces.
+	amountPattern = regexp.MustCompile(`(?i)(\bRs[ \t]*\.?|₹|\bINR|\bUSD|\bUS\$|\bEUR|€|\bGBP|£|\$)\s*(\d(?:[\d,]*\d)?[ \t]*\.[ \t]*\d{2}\b|\d(?:[\d,.]*\d)?)`)
+
+	// bareAmountPattern matches amounts without a currency marker, e.g. SBI's "debited by 250.00"
+	bareAmountPattern = regexp.MustCompile(`(?i)\b(?:debited|credited)\s+(?:by|for|with)\s+(\d[\d,]*\.\d{2})\b`)
+
+	// vpaPattern matches UPI handles like "VPA swiggy@icici" or "to merchant@okaxis"
+	vpaPattern = regexp.MustCompile(`(?i)(?:VPA|UPI ID|to|from)\s*:?\s*([a-z0-9._-]+@[a-z]+)(?:[^a-z.@]|\.\s|\.$|$)`)
+
+	// upiRefPatterns match UPI reference numbers like "UPI Ref 432912345678", "UPI:432912345678", "Refno 432912345678"
+	upiRefPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)\bUPI\b[^0-9]{0,40}?(\d{12})\b`),
+		regexp.MustCompile(`(?i)\bRef\s*(?:no|number)?\.?\s*[:#]?\s*(\d{12})\b`),
+	}
+
+	// upiNarrationPattern matches bank statement narrations carrying the
+	// reference and payee, e.g. "UPI/P2M/629012345678/BLINKIT", "UPI/DR/629012345678/Ramesh K/YESB"
+	upiNarrationPattern = regexp.MustCompile(`(?i)\bUPI/(?:P2[AMP]|DR|CR)/(\d{12})/([A-Za-z0-9][^/\n]*?)\s*(?:/|\s+on\b|[.,;](?:\s|$)|$)`)
+
+	// accountPattern matches account numbers like "A/c XX1234", "Acct XX123", "account **1234"
+	accountPattern = regexp.MustCompile(`(?i)\b(?:A/c|Acct|Account)\s*(?:No\.?)?\s*[Xx*.]*(\d{3,4})\b`)
+
+	// upiMerchantPatterns match payees in UPI alerts, e.g. "VPA swiggy@icici SWIGGY on", "; SWIGGY credited", "trf to SWIGGY"
+	upiMerchantPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)VPA\s+\S+@\S+\s+([A-Za-z][A-Za-z0-9 &.]*?)\s+on\b`),
+		regexp.MustCompile(`;\s*([A-Za-z][A-Za-z0-9 &.]*?)\s+credited\b`),
+		regexp.MustCompile(`(?i)\btrf to\s+([A-Za-z][A-Za-z0-9 &.]*?)(?:\s+Ref|\s+on\b|\.|$)`),
+	}
+
+	// walletMerchantPatterns match payees like "paid ₹180 to Blinkit using Paytm Wallet"
+	walletMerchantPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)\b(?:paid|sent|spent)\s+(?:\S+\s+)?(?:to|at|on)\s+([A-Za-z0-9][A-Za-z0-9 &.'-]*?)(?:\s+(?:using|via|through|from|on)\b|[.,]\s|[.,]?$)`),
+		regexp.MustCompile(`(?i)\bto\s+([A-Za-z0-9][A-Za-z0-9 &.'-]*?)\s+(?:using|via|through)\b`),
+	}
+
+	// fundingSourcePattern matches how a wallet/BNPL payment was funded, e.g.
+	// "via UPI linked to HDFC", "using Paytm Wallet", "from Amazon Pay balance"
+	fundingSourcePattern = regexp.MustCompile(`(?i)\b(?:via|using|through|from)\s+((?:UPI|[A-Za-z]+(?: [A-Za-z]+)? (?:Wallet|Balance|Pay ?Later|Postpaid)|Simpl|LazyPay)(?:\s+(?:linked to|of)\s+[A-Za-z][A-Za-z ]*?)?)(?:\s+(?:on|at|for|to|with|and)\b|[.,;]|$)`)
+
+	// referencePattern matches reference IDs like "Ref No. 432912345678", "UTR: N123456789",
+	// "Auth Code 012345", "Txn ID: TX98765"
+	referencePattern = regexp.MustCompile(`(?i)\b(?:Ref(?:erence)?\.?\s*(?:No\.?|Number|#)?|UTR(?:\s*No\.?)?|Auth(?:orization)?\s*Code|Approval\s*Code|Txn\.?\s*ID|Transaction\s*ID)\s*(?:is\s*)?[:#-]?\s*([A-Z0-9]{4,})\b`)
+
+	// statementTotalPattern matches the summary labels that close a list of
+	// transactions: "Total due", "Total amount payable", "Minimum amount due"
+	statementTotalPattern = regexp.MustCompile(`(?i)\b(?:total|minimum|min\.?)\s+(?:amount\s+)?(?:due|outstanding|payable)\b`)
+
+	// availableBalancePattern matches "Avl Lmt: Rs 45,000", "Available limit: INR 45,000.00", "Avl Bal Rs.1,234"
+	availableBalancePattern = regexp.MustCompile(`(?i)\b(?:Avl|Avbl|Available)\.?\s*(?:Lmt|Limit|Bal|Balance|Credit Limit)\.?\s*(?:is\s*)?:?\s*(?:Rs\.?|₹|INR|USD|\$)?\s*(\d[\d,]*(?:\.\d+)?)`)
+
+	// cardPatterns match card numbers like "ending 0000", "**0000", "card ending in 0000"
+	cardPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)(?:ending|ending in|card ending)\s+(\d{4})`),
+		regexp.MustCompile(`(?i)\*\*(\d{4})`),
+		regexp.MustCompile(`(?i)card\s+(\d{4})`),
+	}
+
+	// merchantPatterns match merchants like "towards Swiggy Limited", "at AMAZON.IN", "from PAYTM-MOVIES"
+	// Names may contain digits, dots, hyphens, apostrophes, and asterisks; they end at
+	// "on <date>", "at <time>", "Avl Limit", "Info:", or the end of the sentence.
+	merchantPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)\b(?:towards|at|from|with)\s+([A-Za-z0-9][A-Za-z0-9 &.'*/-]*?)` + merchantTerminator),
+		regexp.MustCompile(`(?i)\b(?:merchant|vendor)(?: name)?:\s*([A-Za-z0-9][A-Za-z0-9 &.'*/-]*?)` + merchantTerminator),
+	}
+
+	// merchantSuffixPattern matches the company suffixes stripped from merchant names, e.g. " PVT LTD"
+	merchantSuffixPattern = regexp.MustCompile(`(?i)(?:\s+(?:limited|ltd|inc|corp|corporation|pvt|private)\.?)+$`)
+
+	// merchantPrefixPattern matches card-processor prefixes like "POS " and "ECOM "
+	merchantPrefixPattern = regexp.MustCompile(`(?i)^(?:pos|ecom|ecomm|vps|nfc|pg)[\s*-]+`)
+
+	// merchantLocationPattern matches trailing city and country codes like " BANGALORE IN"
+	merchantLocationPattern = regexp.MustCompile(`(?i)(?:\s+(?:bangalore|bengaluru|mumbai|new delhi|delhi|gurgaon|gurugram|noida|hyderabad|chennai|pune|kolkata|ahmedabad))?(?:\s+(?:in|ind|us|usa))?$`)
+
+	// notMerchantPattern matches captured "merchants" that are really pronouns,
+	// e.g. "anyone" in "do not share it with anyone"
+	notMerchantPattern = regexp.MustCompile(`(?i)^(?:anyone|anybody|someone|us|you|your|them|it)$`)
+
+	// timeLikePattern matches captured "merchants" that are really times, e.g. "12 PM"
+	timeLikePattern = regexp.MustCompile(`(?i)^\d{1,2}(?::\d{2})*\s*(?:am|pm)?$`)
+
+	// datePatterns match dates like "11 Nov, 2025", "11 Dez 2025", "11-11-2025", "2025-11-11"
+	// Month names come from localeMonthNames.
+	datePatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(` + localeDateExpr + `)`),
+		regexp.MustCompile(`(\d{1,2}[-/]\d{1,2}[-/]\d{4})`),
+		regexp.MustCompile(`(\d{4}[-/]\d{1,2}[-/]\d{1,2})`),
+		// Two-digit years, common in UPI alerts: "15-11-25", "15-Nov-25"
+		regexp.MustCompile(`\b(\d{1,2}[-/](?:\d{1,2}|[A-Za-z]{3})[-/]\d{2})\b`),
+	}
+
+	// timePattern matches times like "12:38:53", "12:38 PM", "12:38"
+	timePattern = regexp.MustCompile(`(\d{1,2}:\d{2}(?::\d{2})?(?:\s*(?:AM|PM))?)`)
+)
+
+// isCreditCardTransactionEmail checks if an email is a credit card transaction notification
+// UPI and netbanking debit/credit alerts are included as well.
+func isCreditCardTransactionEmail(subject, body string) bool {
+	isTransaction, _ := classifyTransactionEmail(subject, body)
+	return isTransaction
+}
+
+// classifyTransactionEmail decides whether an email is a transaction alert and
+// returns a short reason explaining the decision for logs
+func classifyTransactionEmail(subject, body string) (bool, string) {
+	combined := strings.ToLower(subject + " " + body)
+
+	matched := ""
+	switch {
+	case transactionKeywordPattern.MatchString(combined):
+		matched = "card transaction keywords"
+	case (upiKeywordPattern.MatchString(combined) || netbankingKeywordPattern.MatchString(combined)) &&
+		accountDebitPattern.MatchString(combined):
+		matched = "account debit/credit keywords"
+	case (walletKeywordPattern.MatchString(combined) || bnplKeywordPattern.MatchString(combined)) &&
+		accountDebitPattern.MatchString(combined):
+		matched = "wallet/BNPL payment keywords"
+	default:
+		return false, "no transaction keywords"
+	}
+
+	// OTP emails mention cards and transactions but don't describe a completed one
+	if otpPattern.MatchString(combined) {
+		txn := parseTransactionText(subject + " " + body)
+		if txn.Amount == "" || txn.Merchant == "" {
+			return false, "excluded: OTP email without amount and merchant"
+		}
+	}
+
+	// Card marketing never says money moved
+	if promoPattern.MatchString(combined) && !debitVerbPattern.MatchString(combined) {
+		return false, "excluded: promotional email without debit verb"
+	}
+
+	return true, "matched " + matched
+}
+
+// EmailKind is the broad category of an email, see classifyEmail
+type EmailKind string
+
+// Kinds returned by classifyEmail
+const (
+	emailKindTransaction   EmailKind = "transaction"
+	emailKindBillReminder  EmailKind = "bill_reminder"
+	emailKindOTP           EmailKind = "otp"
+	emailKindSecurityAlert EmailKind = "security_alert"
+	emailKindOther         EmailKind = "other"
+)
+
+// classifyEmail sorts an email into transaction, otp, security_alert, or other
+// OTPs that describe a transaction with an amount and merchant stay transactions,
+// matching classifyTransactionEmail.
+func classifyEmail(subject, body string) EmailKind {
+	if ok, _ := classifyTransactionEmail(subject, body); ok {
+		return emailKindTransaction
+	}
+	return classifyNonTransactionEmail(subject, body)
+}
+
+// classifyNonTransactionEmail sorts an email already known not to be a transaction
+// into otp, security_alert, or other
+func classifyNonTransactionEmail(subject, body string) EmailKind {
+	combined := strings.ToLower(subject + " " + body)
+	switch {
+	case otpPattern.MatchString(combined):
+		return emailKindOTP
+	case securityAlertPattern.MatchString(combined):
+		return emailKindSecurityAlert
+	}
+	return emailKindOther
+}
+
+// detectChannel infers the payment channel of a transaction alert
+// Wallet and BNPL providers win over UPI ("paid via PhonePe using UPI") unless
+// the alert is about a card.
+func detectChannel(text string) string {
+	lower := strings.ToLower(text)
+	cardAlert := transactionKeywordPattern.MatchString(lower)
+	switch {
+	case !cardAlert && bnplKeywordPattern.MatchString(lower):
+		return channelBNPL
+	case !cardAlert && walletKeywordPattern.MatchString(lower):
+		return channelWallet
+	case upiKeywordPattern.MatchString(lower):
+		return channelUPI
+	case netbankingKeywordPattern.MatchString(lower):
+		return channelNetbanking
+	case strings.Contains(lower, "debit card"):
+		return channelDebitCard
+	default:
+		return channelCreditCard
+	}
+}
+
+// parseCreditCardTransaction extracts transaction details from email subject and body
+// For emails listing several transactions, the first one is returned.
+func parseCreditCardTransaction(subject, body string) *CreditCardTransaction {
+	return parseCreditCardTransactions(subject, body)[0]
+}
+
+// parseCreditCardTransactions extracts every transaction from an email
+// The body is split into segments (lines, table rows, or sentences) that each
+// carry a transaction amount; when there is more than one, each segment is
+// parsed as its own transaction, inheriting card and date from the whole email
+// when the segment doesn't state them. Otherwise the whole email is parsed as a
+// single transaction. Always returns at least one result.
+func parseCreditCardTransactions(subject, body string) []*CreditCardTransaction {
+	whole := parseTransactionText(subject + " " + body)
+
+	segments := transactionSegments(body)
+	if len(segments) <= 1 {
+		return []*CreditCardTransaction{whole}
+	}
+
+	txns := make([]*CreditCardTransaction, 0, len(segments))
+	for _, segment := range segments {
+		if statementTotalPattern.MatchString(segment) && !debitVerbPattern.MatchString(segment) {
+			// A statement's total sums the transactions listed above it
+			continue
+		}
+		txn := parseTransactionText(segment)
+		if txn.Amount == "" {
+			// Out of context, the segment's amount wasn't plausible (e.g. a footer year)
+			continue
+		}
+		if txn.CardNumber == "" {
+			txn.CardNumber = whole.CardNumber
+		}
+		if txn.Date == "" && whole.Date != "" {
+			// The timestamp was computed without a date; recompute it with the inherited one
+			txn.Date = whole.Date
+			applyTimestamp(txn)
+		}
+		txns = append(txns, txn)
+	}
+	if len(txns) <= 1 {
+		return []*CreditCardTransaction{whole}
+	}
+	return txns
+}
+
+// segmentBreakPattern matches HTML elements that end a table row, paragraph, or line
+var segmentBreakPattern = regexp.MustCompile(`(?i)</tr>|</p>|</li>|</div>|<br\s*/?>`)
+
+// htmlTagPattern matches any remaining HTML tag
+var htmlTagPattern = regexp.MustCompile(`<[^>]+>`)
+
+// transactionSegments splits a body into pieces that each carry one transaction amount
+// HTML rows and paragraphs become lines; a line with amounts in several sentences
+// is cut between those sentences. Segments without a plausible transaction amount
+// (see transactionAmountSpans) are discarded.
+func transactionSegments(body string) []string {
+	if strings.Contains(body, "</") {
+		body = segmentBreakPattern.ReplaceAllString(body, "\n")
+		body = htmlTagPattern.ReplaceAllString(body, " ")
+	}
+
+	var segments []string
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		spans := transactionAmountSpans(line)
+		if len(spans) == 0 {
+			continue
+		}
+
+		// Cut at the last sentence boundary between consecutive amounts; amounts in
+		// the same sentence ("USD 30.50 (INR 2,540.00)") stay together
+		segmentStart := 0
+		for i := 1; i < len(spans); i++ {
+			cut := -1
+			for _, loc := range sentenceBoundaryPattern.FindAllStringIndex(line[spans[i-1][1]:spans[i][0]], -1) {
+				cut = spans[i-1][1] + loc[1]
+			}
+			if cut < 0 {
+				continue
+			}
+			segments = append(segments, strings.TrimSpace(line[segmentStart:cut]))
+			segmentStart = cut
+		}
+		segments = append(segments, strings.TrimSpace(line[segmentStart:]))
+	}
+	return segments
+}
+
+// transactionAmountSpans returns the spans of plausible transaction amounts in text
+// When some amounts sit next to a debit/credit verb, only those are returned.
+func transactionAmountSpans(text string) [][]int {
+	candidates := amountCandidates(text)
+	anyNearVerb := false
+	for _, candidate := range candidates {
+		anyNearVerb = anyNearVerb || candidate.nearVerb()
+	}
+
+	var spans [][]int
+	for _, candidate := range candidates {
+		if !anyNearVerb || candidate.nearVerb() {
+			spans = append(spans, candidate.loc[:2])
+		}
+	}
+	return spans
+}
+
+// amountVerbWindow is how close (in bytes) an amount must be to a debit/credit
+// verb in the same sentence to count as adjacent to it
+const amountVerbWindow = 40
+
+// amountCandidate is an amountPattern match that plausibly is a transaction amount
+type amountCandidate struct {
+	loc         []int // amountPattern submatch indices
+	twoDecimals bool  // Written with exactly two decimal places
+	verbGap     int   // Bytes to the nearest debit/credit verb, -1 without any verb
+	adjacent    bool  // A debit/credit verb is within amountVerbWindow in the same sentence
+}
+
+// nearVerb reports whether the candidate is adjacent to a debit/credit verb
+func (c amountCandidate) nearVerb() bool {
+	return c.adjacent
+}
+
+// amountCandidates returns the amountPattern matches in text that can be a transaction
+// amount, skipping available limits/balances, matches inside URLs or IDs, card
+// numbers, and bare years ("INR 2025" in a footer) that aren't next to a debit/credit verb
+func amountCandidates(text string) []amountCandidate {
+	balances := availableBalancePattern.FindAllStringIndex(text, -1)
+	format := defaultAmountFormat()
+	verbs := append(debitVerbPattern.FindAllStringIndex(text, -1), creditVerbPattern.FindAllStringIndex(text, -1)...)
+
+	var candidates []amountCandidate
+	for _, loc := range amountPattern.FindAllStringSubmatchIndex(text, -1) {
+		if spanContains(balances, loc[0]) || insideURLOrID(text, loc) {
+			continue
+		}
+
+		amount := normalizeAmount(text[loc[4]:loc[5]])
+		if cardLikeNumberPattern.MatchString(text[loc[4]:]) {
+			continue
+		}
+		candidate := amountCandidate{loc: loc, verbGap: -1}
+		candidate.twoDecimals = format.hasTwoDecimals(amount)
+		for _, verb := range verbs {
+			gap, between := 0, ""
+			if verb[1] <= loc[0] {
+				gap, between = loc[0]-verb[1], text[verb[1]:loc[0]]
+			} else if verb[0] >= loc[1] {
+				gap, between = verb[0]-loc[1], text[loc[1]:verb[0]]
+			}
+			if candidate.verbGap < 0 || gap < candidate.verbGap {
+				candidate.verbGap = gap
+			}
+			// "Get $4 off! USD 12.50 was charged": the verb belongs to the other amount
+			if gap <= amountVerbWindow && !sentenceBoundaryPattern.MatchString(between) {
+				candidate.adjacent = true
+			}
+		}
+
+		if yearLikePattern.MatchString(amount) && !candidate.nearVerb() {
+			continue
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates
+}
+
+// bestAmountMatch returns the amountPattern submatch indices of the most likely
+// transaction amount, or nil. Amounts next to a debit/credit verb win, then
+// amounts with two decimal places, then the one closest to a verb, then the first.
+func bestAmountMatch(text string) []int {
+	var best *amountCandidate
+	candidates := amountCandidates(text)
+	for i := range candidates {
+		c := &candidates[i]
+		switch {
+		case best == nil:
+			best = c
+		case c.nearVerb() != best.nearVerb():
+			if c.nearVerb() {
+				best = c
+			}
+		case c.twoDecimals != best.twoDecimals:
+			if c.twoDecimals {
+				best = c
+			}
+		case c.verbGap >= 0 && c.verbGap < best.verbGap:
+			best = c
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	return best.loc
+}
+
+// yearLikePattern matches bare four-digit years that currency markers sometimes precede
+var yearLikePattern = regexp.MustCompile(`^(?:19|20)\d{2}$`)
+
+// cardLikeNumberPattern matches a 12-16 digit card or account number, run
+// together or in groups of four, at the start of an amount match
+var cardLikeNumberPattern = regexp.MustCompile(`^\d{4}(?:[ -]?\d{4}){2,3}\b`)
+
+// normalizeAmount removes the spaces amountPattern allows around the decimal point
+func normalizeAmount(amount string) string {
+	return strings.Join(strings.Fields(amount), "")
+}
+
+// spanContains reports whether offset falls inside any of spans
+func spanContains(spans [][]int, offset int) bool {
+	for _, span := range spans {
+		if offset >= span[0] && offset < span[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// insideURLOrID reports whether an amount match is part of a URL ("?price=$4")
+// or is a whole number running straight into letters like an order ID ("INR12345AB")
+func insideURLOrID(text string, loc []int) bool {
+	tokenStart := strings.LastIndexAny(text[:loc[0]], " \t\r\n") + 1
+	token := text[tokenStart:loc[0]]
+	if strings.Contains(token, "://") || strings.ContainsAny(token, "=?&/") {
+		return true
+	}
+	if loc[1] < len(text) && !strings.Contains(text[loc[4]:loc[5]], ".") {
+		next, _ := utf8.DecodeRuneInString(text[loc[1]:])
+		if unicode.IsLetter(next) || unicode.IsDigit(next) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTransactionText extracts the first transaction's details from a block of text
+func parseTransactionText(combined string) *CreditCardTransaction {
+	txn := &CreditCardTransaction{Channel: detectChannel(combined)}
+	txn.Direction, txn.IsRefund = detectDirection(combined)
+	txn.Status = detectStatus(combined, txn.Direction)
+	txn.Network, txn.Issuer = detectCardNetworkAndIssuer(combined)
+	accountBased := txn.Channel == channelUPI || txn.Channel == channelNetbanking
+
+	var amountSpan []int
+	if loc := bestAmountMatch(combined); loc != nil {
+		amountSpan = loc[:2]
+		txn.RawAmount = strings.TrimSpace(combined[loc[0]:loc[1]])
+		txn.Amount = normalizeAmount(combined[loc[4]:loc[5]])
+		txn.Currency = currencyCode(combined[loc[2]:loc[3]])
+	} else if loc := bareAmountPattern.FindStringSubmatchIndex(combined); loc != nil && accountBased {
+		// UPI and netbanking alerts without a currency marker are INR
+		amountSpan = loc[:2]
+		txn.RawAmount = strings.TrimSpace(combined[loc[0]:loc[1]])
+		txn.Amount = strings.TrimSpace(combined[loc[2]:loc[3]])
+		txn.Currency = "INR"
+	}
+	if txn.Amount != "" {
+		if minor, err := parseAmountMinorUnits(txn.Amount); err == nil {
+			txn.AmountMinorUnits = minor
+			txn.AmountValue = float64(minor) / 100
+		}
+	}
+
+	if accountBased {
+		if matches := accountPattern.FindStringSubmatch(combined); len(matches) > 1 {
+			txn.AccountLast4 = matches[1]
+		}
+		if matches := vpaPattern.FindStringSubmatch(combined); len(matches) > 1 {
+			txn.VPA = strings.ToLower(matches[1])
+		}
+		if matches := upiNarrationPattern.FindStringSubmatch(combined); len(matches) > 2 {
+			txn.UPIRef, txn.Merchant = matches[1], strings.TrimSpace(matches[2])
+		}
+		for _, pattern := range upiRefPatterns {
+			if txn.UPIRef != "" {
+				break
+			}
+			if matches := pattern.FindStringSubmatch(combined); len(matches) > 1 {
+				txn.UPIRef = matches[1]
+				break
+			}
+		}
+		for _, pattern := range upiMerchantPatterns {
+			if txn.Merchant != "" {
+				break
+			}
+			if matches := pattern.FindStringSubmatch(combined); len(matches) > 1 {
+				txn.Merchant = strings.TrimSpace(matches[1])
+				break
+			}
+		}
+	}
+
+	if txn.Channel == channelWallet || txn.Channel == channelBNPL {
+		for _, pattern := range walletMerchantPatterns {
+			if matches := pattern.FindStringSubmatch(combined); len(matches) > 1 {
+				txn.Merchant = normalizeMerchant(matches[1])
+				break
+			}
+		}
+		applyFundingSource(txn, combined)
+	}
+
+	for _, pattern := range cardPatterns {
+		if matches := pattern.FindStringSubmatch(combined); len(matches) > 1 {
+			txn.CardNumber = matches[1]
+			break
+		}
+	}
+
+	// Account-based alerts reference an account, not a card
+	if accountBased && txn.CardNumber != "" {
+		if txn.AccountLast4 == "" {
+			txn.AccountLast4 = txn.CardNumber
+		}
+		txn.CardNumber = ""
+	}
+
+	for _, pattern := range merchantPatterns {
+		if txn.Merchant != "" {
+			break
+		}
+		for _, matches := range pattern.FindAllStringSubmatch(combined, -1) {
+			if merchant := normalizeMerchant(matches[1]); merchant != "" {
+				txn.Merchant = merchant
+				break
+			}
+		}
+	}
+
+	for _, pattern := range datePatterns {
+		if matches := pattern.FindStringSubmatch(combined); len(matches) > 1 {
+			txn.Date = strings.TrimSpace(matches[1])
+			break
+		}
+	}
+
+	// Only trust a time in the same sentence as the amount, so unrelated
+	// timestamps ("offer valid till 10:00 PM") aren't picked up
+	timeText := combined
+	if amountSpan != nil {
+		timeText = sentenceAround(combined, amountSpan[0], amountSpan[1])
+	}
+	if matches := timePattern.FindStringSubmatch(timeText); len(matches) > 1 {
+		txn.Time = strings.TrimSpace(matches[1])
+	}
+
+	applyReferenceAndBalance(txn, combined)
+	applyTimestamp(txn)
+	return txn
+}
+
+// applyReferenceAndBalance extracts the reference ID and available limit/balance from text
+// UPI reference numbers double as the reference ID when nothing else is found.
+func applyReferenceAndBalance(txn *CreditCardTransaction, text string) {
+	for _, matches := range referencePattern.FindAllStringSubmatch(text, -1) {
+		if strings.ContainsAny(matches[1], "0123456789") {
+			txn.ReferenceID = matches[1]
+			break
+		}
+	}
+	if txn.ReferenceID == "" {
+		txn.ReferenceID = txn.UPIRef
+	}
+
+	if matches := availableBalancePattern.FindStringSubmatch(text); len(matches) > 1 {
+		txn.AvailableBalance = matches[1]
+		if minor, err := parseAmountMinorUnits(matches[1]); err == nil {
+			txn.AvailableBalanceMinorUnits = minor
+			txn.AvailableBalanceValue = float64(minor) / 100
+		}
+	}
+}
+
+// normalizeMerchant cleans a captured merchant name: it strips card-processor
+// prefixes, trailing city/country codes, and company suffixes
+// Returns empty string when the capture doesn't look like a merchant.
+func normalizeMerchant(raw string) string {
+	merchant := strings.Join(strings.Fields(raw), " ")
+	merchant = strings.TrimRight(merchant, ".-* ")
+	merchant = merchantPrefixPattern.ReplaceAllString(merchant, "")
+	merchant = merchantSuffixPattern.ReplaceAllString(merchant, "")
+	if stripped := strings.TrimSpace(merchantLocationPattern.ReplaceAllString(merchant, "")); stripped != "" {
+		merchant = stripped
+	}
+	merchant = strings.TrimSpace(merchantSuffixPattern.ReplaceAllString(merchant, ""))
+
+	if merchant == "" || timeLikePattern.MatchString(merchant) || notMerchantPattern.MatchString(merchant) || !strings.ContainsAny(strings.ToLower(merchant), "abcdefghijklmnopqrstuvwxyz") {
+		return ""
+	}
+	return merchant
+}
+
+// detectDirection infers whether a transaction debits or credits the account
+// When both debit and credit verbs appear, the earliest one wins (e.g. "debited
+// ...; merchant credited"). Without either, the direction is unknown.
+func detectDirection(text string) (string, bool) {
+	isRefund := refundPattern.MatchString(text)
+
+	debit := debitVerbPattern.FindStringIndex(text)
+	credit := creditVerbPattern.FindStringIndex(text)
+	switch {
+	case debit == nil && credit == nil:
+		return directionUnknown, isRefund
+	case credit == nil:
+		return directionDebit, isRefund
+	case debit == nil:
+		return directionCredit, isRefund
+	case credit[0] < debit[0]:
+		return directionCredit, isRefund
+	default:
+		return directionDebit, isRefund
+	}
+}
+
+// applyFundingSource records how a wallet or BNPL payment was funded
+func applyFundingSource(txn *CreditCardTransaction, text string) {
+	if matches := fundingSourcePattern.FindStringSubmatch(text); len(matches) > 1 {
+		txn.FundingSource = strings.TrimSpace(matches[1])
+	}
+}
+
+// detectStatus infers whether a transaction completed, was declined, or is pending
+// Alerts without outcome language are completed when they state a direction.
+func detectStatus(text, direction string) string {
+	switch {
+	case declinedPattern.MatchString(text):
+		return statusDeclined
+	case pendingPattern.MatchString(text):
+		return statusPending
+	case direction != directionUnknown:
+		return statusCompleted
+	}
+	return statusUnknown
+}
+
+// currencyCode maps a matched currency marker to its ISO 4217 code
+// A bare "$" maps to DEFAULT_DOLLAR_CURRENCY (USD when unset).
+func currencyCode(marker string) string {
+	switch strings.ToUpper(strings.TrimSuffix(strings.Join(strings.Fields(marker), ""), ".")) {
+	case "RS", "₹", "INR":
+		return "INR"
+	case "USD", "US$":
+		return "USD"
+	case "EUR", "€":
+		return "EUR"
+	case "GBP", "£":
+		return "GBP"
+	case "$":
+		if code := strings.TrimSpace(os.Getenv("DEFAULT_DOLLAR_CURRENCY")); code != "" {
+			return strings.ToUpper(code)
+		}
+		return "USD"
+	}
+	return ""
+}
+
+// parseAmountMinorUnits converts an amount like "1,23,456.78" to minor units (12345678)
+// using the separators configured with LOCALE
+func parseAmountMinorUnits(amount string) (int64, error) {
+	return parseAmountMinorUnitsIn(amount, defaultAmountFormat())
+}
+
+// parseAmountMinorUnitsIn converts an amount written with format's separators to minor units
+// Any digit grouping is accepted; fractions beyond two digits are truncated.
+func parseAmountMinorUnitsIn(amount string, format amountFormat) (int64, error) {
+	cleaned := strings.ReplaceAll(strings.TrimSpace(amount), format.group, "")
+	whole, fraction, _ := strings.Cut(cleaned, format.decimal)
+	if whole == "" {
+		whole = "0"
+	}
+	fraction = (fraction + "00")[:2]
+
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q: %v", amount, err)
+	}
+	cents, err := strconv.ParseInt(fraction, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q: %v", amount, err)
+	}
+	return units*100 + cents, nil
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSpacedAmounts(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want int64 // Minor units; 0 when no amount should be found
+	}{
+		{"no spaces", "INR 1,234.56 spent on your credit card XX1234 at AMAZON", 123456},
+		{"spaces around the point", "INR 1,234 . 56 spent on your credit card XX1234 at AMAZON", 123456},
+		{"space before the point", "INR 1,234 .56 spent on your credit card XX1234 at AMAZON", 123456},
+		{"space after the point", "INR 1,234. 56 spent on your credit card XX1234 at AMAZON", 123456},
+		{"tab after the point", "INR 1,234.\t56 spent on your credit card XX1234 at AMAZON", 123456},
+		{"spaced marker", "Rs . 500 spent on your credit card XX1234 at AMAZON", 50000},
+		{"spaced marker and point", "Rs . 500 . 25 spent on your credit card XX1234 at AMAZON", 50025},
+		{"rupee sign", "₹ 2,500. 00 debited from your card XX1234 at SWIGGY", 250000},
+		{"no trailing fraction after decimals", "Rs.1,234.56 . 25 spent on your credit card XX1234 at AMAZON", 123456},
+		{"three-digit fraction not joined", "INR 1,234 . 567 spent on your credit card XX1234 at AMAZON", 123400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			txn := parseCreditCardTransaction("Transaction alert", tt.body)
+			if txn.AmountMinorUnits != tt.want {
+				t.Errorf("amount = %d (raw %q), want %d", txn.AmountMinorUnits, txn.RawAmount, tt.want)
+			}
+		})
+	}
+}
+
+func TestCardLikeNumbersAreNotAmounts(t *testing.T) {
+	tests := []string{
+		"Card INR 4111111111111111 was used",
+		"Card INR 4111 1111 1111 1111 was used",
+		"Card INR 4111-1111-1111-1111 was used",
+	}
+	for _, body := range tests {
+		if txn := parseCreditCardTransaction("Transaction alert", body); txn.AmountMinorUnits != 0 {
+			t.Errorf("%q: amount = %d (raw %q), want none", body, txn.AmountMinorUnits, txn.RawAmount)
+		}
+	}
+}
+
+func TestParseMultipleTransactions(t *testing.T) {
+	type want struct {
+		amount   int64
+		merchant string
+		date     string
+	}
+	tests := []struct {
+		name string
+		body string
+		want []want
+	}{
+		{"one per line",
+			"Recent transactions on your credit card ending 1234:\nRs.424.00 spent at AMAZON on 11 Nov, 2025\nRs.1,299.00 spent at SWIGGY on 12 Nov, 2025\nTotal due Rs.1,723.00",
+			[]want{{42400, "AMAZON", "11 Nov, 2025"}, {129900, "SWIGGY", "12 Nov, 2025"}}},
+		{"sentences on one line",
+			"Rs.424.00 spent on your credit card at AMAZON on 11 Nov, 2025. Rs.1,299.00 spent on your credit card at SWIGGY on 12 Nov, 2025.",
+			[]want{{42400, "AMAZON", "11 Nov, 2025"}, {129900, "SWIGGY", "12 Nov, 2025"}}},
+		{"html table rows",
+			"<p>Card ending 1234, statement dated 15 Nov, 2025</p><table><tr><td>Spent at AMAZON</td><td>Rs.424.00</td></tr><tr><td>Spent at SWIGGY</td><td>Rs.1,299.00</td></tr><tr><td>Minimum amount due</td><td>Rs.200.00</td></tr></table>",
+			[]want{{42400, "AMAZON", "15 Nov, 2025"}, {129900, "SWIGGY", "15 Nov, 2025"}}},
+		{"single transaction with a limit",
+			"Rs.424.00 spent on your credit card at AMAZON on 11 Nov, 2025. Available limit Rs.45,000.00.",
+			[]want{{42400, "AMAZON", "11 Nov, 2025"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			txns := parseCreditCardTransactions("Your recent transactions", tt.body)
+			if len(txns) != len(tt.want) {
+				for _, txn := range txns {
+					t.Logf("parsed %s at %q", txn.Amount, txn.Merchant)
+				}
+				t.Fatalf("parsed %d transactions, want %d", len(txns), len(tt.want))
+			}
+			for i, w := range tt.want {
+				txn := txns[i]
+				if txn.AmountMinorUnits != w.amount || txn.Merchant != w.merchant || txn.Date != w.date {
+					t.Errorf("transaction %d = %d %q %q, want %d %q %q", i, txn.AmountMinorUnits, txn.Merchant, txn.Date, w.amount, w.merchant, w.date)
+				}
+			}
+			if first := parseCreditCardTransaction("Your recent transactions", tt.body); first.AmountMinorUnits != tt.want[0].amount {
+				t.Errorf("parseCreditCardTransaction = %d, want the first transaction", first.AmountMinorUnits)
+			}
+		})
+	}
+}
+
+func TestParseMultipleTransactionsInheritedDate(t *testing.T) {
+	t.Setenv("TZ", "Asia/Kolkata")
+	body := "Transactions on your credit card ending 1234 on 11 Nov 2025:\nRs.450.00 spent at SWIGGY\nRs.450.00 spent at ZOMATO"
+
+	txns := parseCreditCardTransactions("Your recent transactions", body)
+	if len(txns) != 2 {
+		t.Fatalf("parsed %d transactions, want 2", len(txns))
+	}
+	want := time.Date(2025, 11, 11, 0, 0, 0, 0, transactionLocation())
+	for i, txn := range txns {
+		if txn.Date != "11 Nov 2025" || !txn.Timestamp.Equal(want) || txn.TimestampSource != timestampSourceBody {
+			t.Errorf("transaction %d: date %q timestamp %v (source %q), want %v from the body", i, txn.Date, txn.Timestamp, txn.TimestampSource, want)
+		}
+	}
+}
+
+func TestDedupKey(t *testing.T) {
+	ts := time.Date(2025, 11, 11, 12, 38, 0, 0, time.UTC)
+	base := CreditCardTransaction{CardNumber: "1234", AmountMinorUnits: 45000, Currency: "INR", Merchant: "SWIGGY", Timestamp: ts}
+	key := base.DedupKey()
+
+	tests := []struct {
+		name   string
+		change func(txn *CreditCardTransaction)
+		same   bool
+	}{
+		{"re-delivered alert", func(txn *CreditCardTransaction) {}, true},
+		{"merchant case and spacing", func(txn *CreditCardTransaction) { txn.Merchant = " swiggy " }, true},
+		{"other merchant, same amount and time", func(txn *CreditCardTransaction) { txn.Merchant = "ZOMATO" }, false},
+		{"other amount", func(txn *CreditCardTransaction) { txn.AmountMinorUnits = 45100 }, false},
+		{"other time", func(txn *CreditCardTransaction) { txn.Timestamp = ts.Add(time.Minute) }, false},
+		{"other line of the same digest", func(txn *CreditCardTransaction) { txn.Segment = 2 }, false},
+		{"reference ID wins", func(txn *CreditCardTransaction) { txn.ReferenceID = "R1" }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			txn := base
+			tt.change(&txn)
+			if got := txn.DedupKey(); (got == key) != tt.same {
+				t.Errorf("DedupKey = %q vs %q, want same %v", got, key, tt.same)
+			}
+		})
+	}
+}
+
+func TestClassifyTransactionEmail(t *testing.T) {
+	tests := []struct {
+		name        string
+		subject     string
+		body        string
+		transaction bool
+		reason      string
+	}{
+		{"card spend", "Transaction alert", "Rs.424.00 spent on your credit card XX1234 at AMAZON on 11 Nov, 2025", true, "matched card transaction keywords"},
+		{"upi debit", "UPI alert", "Rs.250.00 debited from A/c XX1234 via UPI to VPA swiggy@icici. UPI Ref 432912345678", true, "matched account debit/credit keywords"},
+		{"otp", "OTP for your credit card", "Your OTP for credit card transaction is 123456. Valid for 10 minutes.", false, "excluded: OTP email without amount and merchant"},
+		{"otp with amount only", "OTP", "123456 is the one-time password for your transaction of Rs.4,999.00 on your credit card ending 1234.", false, "excluded: OTP email without amount and merchant"},
+		{"verification code", "Verification code", "Use verification code 482913 to complete the transaction on your credit card.", false, "excluded: OTP email without amount and merchant"},
+		{"otp naming the purchase", "OTP", "OTP 123456 for Rs.424.00 on your credit card XX1234 at AMAZON.", true, "matched card transaction keywords"},
+		{"lifetime-free promo", "Get a lifetime-free credit card!", "Apply now for a credit card with zero joining fee. Unsubscribe from these emails.", false, "excluded: promotional email without debit verb"},
+		{"pre-approved offer", "You're pre-approved", "Your pre-approved credit card offer is waiting. Limited period offer, apply today!", false, "excluded: promotional email without debit verb"},
+		{"alert with unsubscribe footer", "Transaction alert", "Rs.424.00 spent on your credit card XX1234 at AMAZON. To unsubscribe from alerts, visit the app.", true, "matched card transaction keywords"},
+		{"newsletter", "Weekly deals", "Save 20% on your next order.", false, "no transaction keywords"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, reason := classifyTransactionEmail(tt.subject, tt.body)
+			if ok != tt.transaction || reason != tt.reason {
+				t.Errorf("got %v %q, want %v %q", ok, reason, tt.transaction, tt.reason)
+			}
+			if ok != isCreditCardTransactionEmail(tt.subject, tt.body) {
+				t.Errorf("isCreditCardTransactionEmail disagrees with classifyTransactionEmail")
+			}
+		})
+	}
+}
+
+func TestClassifyEmail(t *testing.T) {
+	tests := []struct {
+		name    string
+		subject string
+		body    string
+		want    EmailKind
+	}{
+		{"transaction", "Transaction alert", "Rs.424.00 spent on your credit card XX1234 at AMAZON on 11 Nov, 2025", emailKindTransaction},
+		{"otp naming the purchase", "OTP", "OTP 123456 for Rs.424.00 on your credit card XX1234 at AMAZON.", emailKindTransaction},
+		{"otp", "Your OTP", "123456 is your OTP to log in. Do not share it with anyone.", emailKindOTP},
+		{"one-time password", "Login", "Your one-time password is 482913. It expires in 5 minutes.", emailKindOTP},
+		{"verification code", "Verify your email", "Use verification code 482913 to verify your email address.", emailKindOTP},
+		{"card otp without merchant", "OTP for your credit card", "Your OTP for credit card transaction is 123456. Valid for 10 minutes.", emailKindOTP},
+		{"new sign-in", "New sign-in to your account", "We noticed a new sign-in from Chrome on Windows. If this was you, ignore this email.", emailKindSecurityAlert},
+		{"suspicious login", "Security notice", "We blocked a suspicious login attempt to your net banking account.", emailKindSecurityAlert},
+		{"password changed", "Account update", "Your password was changed on 11 Nov, 2025. Contact us if you didn't do this.", emailKindSecurityAlert},
+		{"otp beats security alert", "New sign-in", "Enter OTP 123456 to approve the new sign-in.", emailKindOTP},
+		{"newsletter", "Weekly deals", "Save 20% on your next order.", emailKindOther},
+		{"promotion", "You're pre-approved", "Your pre-approved credit card offer is waiting. Apply now!", emailKindOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyEmail(tt.subject, tt.body); got != tt.want {
+				t.Errorf("classifyEmail = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// benchmarkEmails are a typical push burst: three transaction alerts followed
+// by mail that must be rejected
+var benchmarkEmails = []struct{ subject, body string }{
+	{"Transaction alert", "Rs.424.00 spent on your credit card XX1234 at AMAZON on 11 Nov, 2025 at 12:38 PM. Avl limit: Rs.1,23,456.00"},
+	{"Alert: Debit on your card", "Dear Customer, INR 2,499.00 has been debited from your HDFC Bank Credit Card ending 5678 at SWIGGY on 12-11-2025 14:05:12. Not you? Call 1800-000-000."},
+	{"UPI payment", "Rs 150.00 debited from A/c XX9012 to VPA shop@okicici on 13-11-25. UPI Ref No 531234567890."},
+	{"Your weekly newsletter", "Ten ways to save money this festive season. Read more on our blog and get 20% off your next order."},
+	{"Meeting notes", "Hi team, attaching the notes from today's sync. Action items are listed below; please reply with updates by Friday."},
+}
+
+func BenchmarkIsCreditCardTransactionEmail(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		email := benchmarkEmails[i%len(benchmarkEmails)]
+		isCreditCardTransactionEmail(email.subject, email.body)
+	}
+}
+
+func BenchmarkParseCreditCardTransaction(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		email := benchmarkEmails[i%3] // The transaction alerts
+		parseCreditCardTransaction(email.subject, email.body)
+	}
+}
+
+func TestMerchantExtraction(t *testing.T) {
+	tests := []struct {
+		merchant string // As it appears in the alert
+		want     string
+	}{
+		{"AMAZON.IN", "AMAZON.IN"},
+		{"Store 24x7", "Store 24x7"},
+		{"PAYTM-MOVIES", "PAYTM-MOVIES"},
+		{"McDONALD'S", "McDONALD'S"},
+		{"RAZ*SWIGGY", "RAZ*SWIGGY"},
+		{"POS DMART", "DMART"},
+		{"ECOM FLIPKART", "FLIPKART"},
+		{"POS*CROMA", "CROMA"},
+		{"SWIGGY BANGALORE IN", "SWIGGY"},
+		{"UBER INDIA SYSTEMS PVT LTD MUMBAI IN", "UBER INDIA SYSTEMS"},
+		{"STARBUCKS NEW DELHI", "STARBUCKS"},
+		{"IRCTC E-TICKETING", "IRCTC E-TICKETING"},
+		{"BOOKMYSHOW.COM", "BOOKMYSHOW.COM"},
+		{"ZOMATO LTD", "ZOMATO"},
+		{"NETFLIX.COM US", "NETFLIX.COM"},
+		{"APOLLO PHARMACY 1123", "APOLLO PHARMACY 1123"},
+		{"BIG BAZAAR", "BIG BAZAAR"},
+		{"H&M", "H&M"},
+		{"7-ELEVEN", "7-ELEVEN"},
+		{"MAKEMYTRIP INDIA PVT LTD", "MAKEMYTRIP INDIA"},
+		{"GOOGLE *YOUTUBE", "GOOGLE *YOUTUBE"},
+		{"SPOTIFY AB", "SPOTIFY AB"},
+		{"JIO RECHARGE GURGAON", "JIO RECHARGE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.merchant, func(t *testing.T) {
+			for _, body := range []string{
+				"Rs.499.00 spent on your credit card XX1234 at " + tt.merchant + " on 11 Nov, 2025",
+				"Rs.499.00 spent on your credit card XX1234 at " + tt.merchant + ". Avl Limit: Rs.50,000.00",
+				"Rs.499.00 spent on your credit card XX1234 at " + tt.merchant + " Info: call us if this was not you",
+				"A refund of Rs.499.00 from " + tt.merchant + " has been credited to your credit card XX1234",
+				"Your credit card XX1234 was used at " + tt.merchant + " Rs.499.00 on 11 Nov, 2025",
+				"Card XX1234 used at " + tt.merchant + " INR 499.00",
+			} {
+				txn := parseCreditCardTransaction("Transaction alert", body)
+				if txn == nil || txn.Merchant != tt.want {
+					t.Errorf("merchant from %q = %q, want %q", body, merchantOf(txn), tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPronounsAreNotMerchants(t *testing.T) {
+	for _, body := range []string{
+		"123456 is the OTP for Rs.424.00 on your credit card XX4321. Do not share it with anyone.",
+		"Rs.424.00 spent on your credit card XX4321. Please contact us.",
+	} {
+		if txn := parseCreditCardTransaction("Alert", body); txn != nil && txn.Merchant != "" {
+			t.Errorf("merchant from %q = %q, want none", body, txn.Merchant)
+		}
+	}
+	if ok, reason := classifyTransactionEmail("OTP for your credit card", "123456 is the OTP for Rs.424.00 on your credit card XX4321. Do not share it with anyone."); ok {
+		t.Errorf("OTP classified as a transaction: %s", reason)
+	}
+}
+
+func merchantOf(txn *CreditCardTransaction) string {
+	if txn == nil {
+		return "<no transaction>"
+	}
+	return txn.Merchant
+}
+
+func TestTransactionStatus(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		want  string
+		spend bool
+	}{
+		{"completed", "Rs.5,000.00 spent on your credit card XX1234 at AMAZON on 11 Nov, 2025", statusCompleted, true},
+		{"declined", "Your transaction of Rs.5,000 at AMAZON on your credit card XX1234 was DECLINED due to insufficient limit", statusDeclined, false},
+		{"failed", "Transaction of Rs.5,000.00 at AMAZON using your credit card XX1234 has failed", statusDeclined, false},
+		{"unsuccessful", "Your payment of Rs.5,000.00 at AMAZON on credit card XX1234 was unsuccessful", statusDeclined, false},
+		{"could not be processed", "Rs.5,000.00 transaction at AMAZON on your credit card XX1234 could not be processed", statusDeclined, false},
+		{"being processed", "Your transaction of Rs.5,000.00 spent at AMAZON on credit card XX1234 is being processed", statusPending, true},
+		{"authorization hold", "An authorization hold of Rs.5,000.00 was placed on your credit card XX1234 at AMAZON", statusPending, false},
+		{"no outcome or direction", "Rs.5,000.00 on your credit card XX1234 at AMAZON", statusUnknown, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			txn := parseCreditCardTransaction("Transaction alert", tt.body)
+			if txn.Status != tt.want {
+				t.Errorf("status = %q (direction %q), want %q", txn.Status, txn.Direction, tt.want)
+			}
+			if txn.CountsTowardSpend() != tt.spend {
+				t.Errorf("CountsTowardSpend = %v (direction %q), want %v", txn.CountsTowardSpend(), txn.Direction, tt.spend)
+			}
+		})
+	}
+}
+
+func TestTrickyAmounts(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		want     int64 // Minor units
+		currency string
+	}{
+		{"year in footer first", "INR 2025 rewards programme. Rs.424.00 spent on your credit card XX1234 at AMAZON", 42400, "INR"},
+		{"year in footer last", "Rs.424.00 spent on your credit card XX1234 at AMAZON. Copyright INR 2025", 42400, "INR"},
+		{"promo dollars", "Get $ 4 off your next ride! USD 12.50 was charged to your credit card XX1234 at UBER", 1250, "USD"},
+		{"dollar in url", "Rs.424.00 spent on your credit card XX1234 at AMAZON. Manage at https://bank.example/offers?$5=off", 42400, "INR"},
+		{"phone number", "Call 1800 266 4332 to block your card. Rs.1,299.00 debited from your credit card XX1234 at SWIGGY", 129900, "INR"},
+		{"order id", "Order #INR402-5567 confirmed. Your credit card XX1234 was charged Rs.899.00 at FLIPKART", 89900, "INR"},
+		{"limit before amount", "Available limit: Rs.45,000.00. Rs.424.00 spent on your credit card XX1234 at AMAZON", 42400, "INR"},
+		{"multiple currencies", "Your credit card XX1234 was debited EUR 89.99 at BOOKING.COM (INR 8,100.00 at today's rate)", 8999, "EUR"},
+		{"whole amount near verb beats decimal far away", "Rs.3,000 spent on your credit card XX1234 at DMART. Your reward points are worth a voucher, see the offers page for a Rs.199.00 voucher", 300000, "INR"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			txn := parseCreditCardTransaction("Transaction alert", tt.body)
+			if txn.AmountMinorUnits != tt.want || txn.Currency != tt.currency {
+				t.Errorf("amount = %d %s (raw %q), want %d %s", txn.AmountMinorUnits, txn.Currency, txn.RawAmount, tt.want, tt.currency)
+			}
+		})
+	}
+}
+
+func TestUPITransaction(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		amount   int64
+		vpa      string
+		ref      string
+		merchant string
+		date     string
+	}{
+		{"debit alert", "Rs.150.00 has been debited from account XX9012 to VPA chaiwala@okicici on 13-11-2025. UPI Ref No 531234567890. Not you? Call us.",
+			15000, "chaiwala@okicici", "531234567890", "", "13-11-2025"},
+		{"payee name", "Rs.250.00 debited from A/c XX1234 via UPI. VPA swiggy@icici SWIGGY on 11-11-25. UPI Ref 432912345678",
+			25000, "swiggy@icici", "432912345678", "SWIGGY", "11-11-25"},
+		{"narration", "INR 89.00 debited from A/c XX4321 for UPI/P2M/629012345678/BLINKIT on 15-Nov-25.",
+			8900, "", "629012345678", "BLINKIT", "15-Nov-25"},
+		{"person to person", "Rs.500.00 debited from A/c XX4321 for UPI/DR/629012345679/Ramesh K/YESB.",
+			50000, "", "629012345679", "Ramesh K", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			txn := parseCreditCardTransaction("UPI transaction alert", tt.body)
+			if txn == nil {
+				t.Fatal("no transaction parsed")
+			}
+			if txn.Channel != channelUPI || txn.CardNumber != "" {
+				t.Errorf("channel %q card %q, want upi with no card", txn.Channel, txn.CardNumber)
+			}
+			if txn.AmountMinorUnits != tt.amount || txn.VPA != tt.vpa || txn.UPIRef != tt.ref {
+				t.Errorf("got amount %d vpa %q ref %q, want %d %q %q", txn.AmountMinorUnits, txn.VPA, txn.UPIRef, tt.amount, tt.vpa, tt.ref)
+			}
+			if txn.Merchant != tt.merchant || txn.Date != tt.date {
+				t.Errorf("got merchant %q date %q, want %q %q", txn.Merchant, txn.Date, tt.merchant, tt.date)
+			}
+			if txn.ReferenceID != tt.ref {
+				t.Errorf("reference = %q, want the UPI ref %q", txn.ReferenceID, tt.ref)
+			}
+		})
+	}
+}
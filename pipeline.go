@@ -0,0 +1,105 @@
+package main
+
+import (
+	"html"
+	"strings"
+)
+
+// emailAnalysis is the outcome of running an email through transaction detection and parsing
+type emailAnalysis struct {
+	Kind          EmailKind
+	IsTransaction bool
+	Reason        string
+	Forwarded     bool             // Classification and parsing used the forwarded message
+	Embedded      forwardedMessage // The forwarded message, embedded or inline, if any
+	FromSnippet   bool             // The body was empty, so the Gmail snippet was used instead
+	RawBody       string           // Body (or snippet) as received
+	Body          string           // RawBody with quoted text stripped; what was classified and parsed
+	Stripped      bool             // Quoted text was removed, so Body differs from RawBody
+	Transactions  []*CreditCardTransaction
+	BillReminder  *BillReminder // Set instead of Transactions for statement and bill-due emails
+}
+
+// analyzeEmail classifies and parses a decoded email the way the push pipeline does:
+// quoted text is stripped, forwarded content is preferred when it is a transaction,
+// the recipient filter is applied, and the sender's parser extracts the transactions.
+// headers needs From and Subject, plus To and Cc for recipient filtering. When
+// the body couldn't be extracted, the message snippet stands in for it.
+// forwarded is the embedded message/rfc822 part; without one, an inline
+// forward in the body is used instead.
+func analyzeEmail(headers map[string]string, rawBody string, forwarded forwardedMessage, snippet string) emailAnalysis {
+	from, subject := headers["From"], headers["Subject"]
+
+	var analysis emailAnalysis
+	if strings.TrimSpace(rawBody) == "" && snippet != "" {
+		// Gmail snippets are HTML-escaped ("Rs.424 at Domino&#39;s")
+		rawBody = html.UnescapeString(snippet)
+		analysis.FromSnippet = true
+	}
+
+	// Drop quoted replies and signatures so stale alerts aren't re-detected
+	body, stripped := stripQuotedTextIfEnabled(rawBody)
+	if stripped {
+		debugf("Stripped %d bytes of quoted text", len(rawBody)-len(body))
+	}
+	analysis.RawBody, analysis.Body, analysis.Stripped = rawBody, body, stripped
+	if forwarded.Body == "" {
+		forwarded = parseInlineForward(rawBody)
+	}
+	analysis.Embedded = forwarded
+
+	// Statements mention cards and amounts too, so they are checked first
+	if ok, reason := classifyBillReminder(subject, body); ok {
+		analysis.Kind, analysis.Reason = emailKindBillReminder, reason
+		analysis.BillReminder = parseBillReminder(subject, body)
+		if analysis.BillReminder.Issuer == "" {
+			if entry, ok := bankParserFor(from); ok {
+				analysis.BillReminder.Issuer = entry.name
+			}
+		}
+		return analysis
+	}
+
+	// Forwarded content is classified and parsed as its original sender sent it,
+	// so BANK_DOMAINS and the bank parsers see the bank rather than the forwarder
+	forwardedFrom, forwardedSubject := from, subject
+	if forwarded.From != "" {
+		forwardedFrom = forwarded.From
+	}
+	if forwarded.Subject != "" {
+		forwardedSubject = forwarded.Subject
+	}
+
+	// Check if this is a credit card transaction email, preferring forwarded content
+	analysis.IsTransaction, analysis.Reason = classifyTransactionEmailFromSender(from, subject, body)
+	if forwarded.Body != "" {
+		if ok, forwardedReason := classifyTransactionEmailFromSender(forwardedFrom, forwardedSubject, forwarded.Body); ok {
+			analysis.Forwarded, analysis.IsTransaction, analysis.Reason = true, true, forwardedReason
+		}
+	}
+	if filter := transactionRecipientFilter(); analysis.IsTransaction && !recipientMatches(filter, decodeHeader(headers["To"]), decodeHeader(headers["Cc"])) {
+		analysis.IsTransaction, analysis.Reason = false, "recipient does not match "+filter
+	}
+	if !analysis.IsTransaction {
+		analysis.Kind = classifyNonTransactionEmail(subject, body)
+		return analysis
+	}
+	analysis.Kind = emailKindTransaction
+
+	if analysis.Forwarded {
+		analysis.Transactions = parseTransactionsFromSender(forwardedFrom, forwardedSubject, forwarded.Body)
+	} else {
+		analysis.Transactions = parseTransactionsFromSender(from, subject, body)
+	}
+
+	// A forward arrives after the original, so its Date beats internalDate
+	if ts, ok := forwarded.Time(); ok && analysis.Forwarded {
+		for _, txn := range analysis.Transactions {
+			if txn.Timestamp.IsZero() {
+				txn.Timestamp = ts.In(transactionLocation())
+				txn.TimestampSource = timestampSourceForwardedDate
+			}
+		}
+	}
+	return analysis
+}
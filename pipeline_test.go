@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestAnalyzeEmailSnippetFallback(t *testing.T) {
+	headers := map[string]string{"From": "alerts@examplebank.com", "Subject": "Transaction alert"}
+	tests := []struct {
+		name        string
+		body        string
+		snippet     string
+		fromSnippet bool
+		merchant    string // Empty when no transaction should be recorded
+	}{
+		{"empty body", "", "Rs.424.00 spent on your credit card XX1234 at Domino&#39;s on 11 Nov, 2025", true, "Domino's"},
+		{"blank body", " \r\n ", "Rs.424.00 spent on your credit card XX1234 at SWIGGY on 11 Nov, 2025", true, "SWIGGY"},
+		{"body preferred", "Rs.424.00 spent on your credit card XX1234 at AMAZON on 11 Nov, 2025", "Rs.424.00 spent on your credit card XX1234 at SWIGGY", false, "AMAZON"},
+		{"unparseable snippet", "", "Your weekly newsletter is here", true, ""},
+		{"no snippet", "", "", false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			analysis := analyzeEmail(headers, tt.body, forwardedMessage{}, tt.snippet)
+			if analysis.FromSnippet != tt.fromSnippet {
+				t.Errorf("FromSnippet = %v, want %v", analysis.FromSnippet, tt.fromSnippet)
+			}
+			if tt.merchant == "" {
+				if analysis.IsTransaction {
+					t.Errorf("classified as a transaction (%s)", analysis.Reason)
+				}
+				return
+			}
+			if !analysis.IsTransaction || len(analysis.Transactions) != 1 {
+				t.Fatalf("is_transaction = %v (%s) with %d transactions, want 1", analysis.IsTransaction, analysis.Reason, len(analysis.Transactions))
+			}
+			if txn := analysis.Transactions[0]; txn.AmountMinorUnits != 42400 || txn.Merchant != tt.merchant {
+				t.Errorf("amount %d merchant %q, want 42400 %q", txn.AmountMinorUnits, txn.Merchant, tt.merchant)
+			}
+		})
+	}
+}
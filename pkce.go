@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// loginStateTTL bounds how long a login may take between /auth-url and the callback
+const loginStateTTL = 10 * time.Minute
+
+// pendingLogin is an OAuth state waiting for its callback, with the PKCE code
+// verifier when OAUTH_PKCE is on
+type pendingLogin struct {
+	verifier string
+	created  time.Time
+}
+
+// pkceEnabled reports whether OAUTH_PKCE=true, for public and native OAuth clients
+func pkceEnabled() bool {
+	return strings.EqualFold(os.Getenv("OAUTH_PKCE"), "true")
+}
+
+// stateKey returns the part of an OAuth state that identifies the login,
+// without the redirect added by encodeRedirectState
+func stateKey(state string) string {
+	key, _, _ := strings.Cut(state, ":")
+	return key
+}
+
+// newLoginState generates a random OAuth state for a login through provider
+func newLoginState(provider string) (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("unable to generate OAuth state: %v", err)
+	}
+	return providerState(provider, hex.EncodeToString(b[:])), nil
+}
+
+// beginLogin remembers state until its callback, generating a PKCE code
+// verifier when enabled, and returns the AuthCodeURL options for the login
+func (s *Server) beginLogin(state string) []oauth2.AuthCodeOption {
+	pending := pendingLogin{created: time.Now()}
+	var opts []oauth2.AuthCodeOption
+	if pkceEnabled() {
+		pending.verifier = oauth2.GenerateVerifier()
+		opts = append(opts, oauth2.S256ChallengeOption(pending.verifier))
+	}
+
+	s.loginStates.Lock()
+	defer s.loginStates.Unlock()
+	for key, login := range s.loginStates.pending {
+		if pending.created.Sub(login.created) > loginStateTTL {
+			delete(s.loginStates.pending, key)
+		}
+	}
+	s.loginStates.pending[stateKey(state)] = pending
+	return opts
+}
+
+// takeLogin removes and returns the login started with state, so each state is
+// accepted once. Unknown and expired states return false.
+func (s *Server) takeLogin(state string) (pendingLogin, bool) {
+	key := stateKey(state)
+
+	s.loginStates.Lock()
+	defer s.loginStates.Unlock()
+	pending, ok := s.loginStates.pending[key]
+	if !ok {
+		return pendingLogin{}, false
+	}
+	delete(s.loginStates.pending, key)
+	if time.Since(pending.created) > loginStateTTL {
+		return pendingLogin{}, false
+	}
+	return pending, true
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+// issuedState asks /auth-url for a consent URL and returns the state in it
+func issuedState(t *testing.T, handler http.Handler) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth-url", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/auth-url status = %d: %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		AuthURL string `json:"auth_url"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode /auth-url: %v", err)
+	}
+	u, err := url.Parse(body.AuthURL)
+	if err != nil {
+		t.Fatalf("parse auth_url: %v", err)
+	}
+	return u.Query().Get("state")
+}
+
+func TestOAuthCallbackState(t *testing.T) {
+	for _, pkce := range []string{"false", "true"} {
+		t.Run("pkce="+pkce, func(t *testing.T) {
+			t.Setenv("OAUTH_PKCE", pkce)
+			t.Setenv("SESSION_SECRET", "test-secret")
+			// The token endpoint refuses every code, so a callback that gets past the
+			// state check fails the exchange instead
+			tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, `{"error": "invalid_grant"}`, http.StatusBadRequest)
+			}))
+			defer tokenServer.Close()
+			s := newTestServer(t)
+			s.oauthConfig.Endpoint.TokenURL = tokenServer.URL
+			handler := s.Handler()
+
+			first, second := issuedState(t, handler), issuedState(t, handler)
+			if first == "" || first == second {
+				t.Fatalf("states %q and %q, want distinct random states", first, second)
+			}
+
+			tests := []struct {
+				name  string
+				state string
+				want  string
+			}{
+				{"forged", "state-token", "Invalid or expired OAuth state\n"},
+				{"issued", first, "Failed to exchange token\n"},
+				{"replayed", first, "Invalid or expired OAuth state\n"},
+			}
+			for _, tt := range tests {
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/oauth2/callback?code=abc&state="+url.QueryEscape(tt.state), nil))
+				if rec.Body.String() != tt.want {
+					t.Errorf("%s state: got %d %q, want %q", tt.name, rec.Code, rec.Body, tt.want)
+				}
+				if cookies := rec.Result().Cookies(); len(cookies) != 0 {
+					t.Errorf("%s state: set cookies %v", tt.name, cookies)
+				}
+			}
+		})
+	}
+}
+
+func TestPKCE(t *testing.T) {
+	for _, pkce := range []bool{false, true} {
+		t.Run(fmt.Sprintf("pkce=%v", pkce), func(t *testing.T) {
+			t.Setenv("OAUTH_PKCE", strconv.FormatBool(pkce))
+			s := newTestServer(t)
+			newFakeGmail(t, "user@example.com").use(s)
+
+			// Records the verifier sent with the code, answering like Google's token endpoint
+			var verifier string
+			tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				r.ParseForm()
+				verifier = r.PostForm.Get("code_verifier")
+				writeFakeJSON(w, map[string]interface{}{"access_token": "access", "refresh_token": "refresh", "token_type": "Bearer", "expires_in": 3600})
+			}))
+			defer tokenServer.Close()
+			s.oauthConfig.Endpoint.TokenURL = tokenServer.URL
+			handler := s.Handler()
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth-url", nil))
+			var body struct {
+				AuthURL string `json:"auth_url"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatalf("decode /auth-url: %v", err)
+			}
+			authURL, err := url.Parse(body.AuthURL)
+			if err != nil {
+				t.Fatalf("parse auth_url: %v", err)
+			}
+			query := authURL.Query()
+			challenge := query.Get("code_challenge")
+			if pkce != (challenge != "") {
+				t.Fatalf("auth URL %s: code_challenge present = %v, want %v", authURL, challenge != "", pkce)
+			}
+			if pkce && query.Get("code_challenge_method") != "S256" {
+				t.Errorf("code_challenge_method = %q, want S256", query.Get("code_challenge_method"))
+			}
+
+			rec = httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/oauth2/callback?format=json&code=abc&state="+url.QueryEscape(query.Get("state")), nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("callback status = %d: %s", rec.Code, rec.Body)
+			}
+			if !pkce {
+				if verifier != "" {
+					t.Errorf("code_verifier %q sent without PKCE", verifier)
+				}
+				return
+			}
+			if verifier == "" || oauth2.S256ChallengeFromVerifier(verifier) != challenge {
+				t.Errorf("code_verifier %q does not match the challenge %q", verifier, challenge)
+			}
+		})
+	}
+}
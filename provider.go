@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/gmail/v1"
+)
+
+// Mail providers users can authenticate against
+const (
+	providerGmail   = "gmail"
+	providerOutlook = "outlook"
+	providerIMAP    = "imap"
+)
+
+// mailQuery selects the messages returned by MailProvider.ListMessages
+type mailQuery struct {
+	Days      int    // Received within the last Days days
+	Recipient string // Optional address matched against To and Cc
+	From      string // Optional sender address or domain, already checked by senderQuery
+	Limit     int
+}
+
+// mailMessage is a fetched message in the shape shared by every provider
+type mailMessage struct {
+	ID           string
+	Headers      map[string]string // Raw header values by name; Subject, From, To, Cc and Date at least
+	Snippet      string
+	InternalDate int64            // Milliseconds since epoch
+	Body         EmailBody        // Only filled in for the "full" format
+	Forwarded    forwardedMessage // Embedded forwarded message, "full" format only
+}
+
+// MailProvider is a mailbox users read through the server
+// Formats are Gmail's: "minimal" (no headers), "metadata" (headers only) and
+// "full" (headers and body); other providers select the same fields.
+type MailProvider interface {
+	Name() string
+	ListMessages(ctx context.Context, userEmail string, token *oauth2.Token, query mailQuery) (ids []string, estimate int64, err error)
+	FetchMessage(ctx context.Context, userEmail string, token *oauth2.Token, msgID, format string) (*mailMessage, error)
+	// Watch starts or renews push notifications for the user's inbox
+	Watch(ctx context.Context, userEmail string, token *oauth2.Token) (expiration time.Time, err error)
+}
+
+// oauthProvider is implemented by providers users sign in to through /auth-url
+type oauthProvider interface {
+	AuthCodeURL(state string, opts ...oauth2.AuthCodeOption) string
+	Exchange(ctx context.Context, code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error)
+	UserEmail(ctx context.Context, token *oauth2.Token) (string, error)
+}
+
+// watchConfigError is returned by Watch when the server's push configuration
+// is missing or invalid, as opposed to the provider rejecting the watch
+type watchConfigError struct {
+	what string // e.g. "Pub/Sub topic"
+	err  error
+}
+
+func (e *watchConfigError) Error() string {
+	return fmt.Sprintf("invalid %s configuration: %v", e.what, e.err)
+}
+
+func (e *watchConfigError) Unwrap() error { return e.err }
+
+// providerFactories builds each provider for a Server, returning nil when the provider is not configured
+var providerFactories = map[string]func(s *Server) MailProvider{}
+
+// registerProvider adds a provider factory; called from the providers' init functions
+func registerProvider(name string, factory func(s *Server) MailProvider) {
+	providerFactories[name] = factory
+}
+
+// Gmail is always available, configured from credentials.json
+func init() {
+	registerProvider(providerGmail, func(s *Server) MailProvider { return gmailProvider{s} })
+}
+
+// newProviders builds the configured providers for s, by name
+func newProviders(s *Server) map[string]MailProvider {
+	providers := make(map[string]MailProvider)
+	for name, factory := range providerFactories {
+		if p := factory(s); p != nil {
+			providers[name] = p
+		}
+	}
+	return providers
+}
+
+// provider returns the named provider if it is configured
+func (s *Server) provider(name string) (MailProvider, bool) {
+	p, ok := s.providers[name]
+	return p, ok
+}
+
+// accountKey identifies a mailbox the server holds a token for; the same
+// address can be signed in with several providers at once
+type accountKey struct {
+	Provider string
+	Email    string
+}
+
+// userToken returns the user's token for the named provider
+func (s *Server) userToken(provider, userEmail string) (*oauth2.Token, bool) {
+	s.tokenStore.RLock()
+	defer s.tokenStore.RUnlock()
+	token, ok := s.tokenStore.tokens[accountKey{provider, userEmail}]
+	return token, ok
+}
+
+// userProviders returns the names of the providers the user is signed in with, sorted
+func (s *Server) userProviders(userEmail string) []string {
+	var names []string
+	s.tokenStore.RLock()
+	for key := range s.tokenStore.tokens {
+		if key.Email == userEmail {
+			names = append(names, key.Provider)
+		}
+	}
+	s.tokenStore.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+// storeToken saves a user's token for the provider that issued it, leaving
+// the user's tokens from other providers in place
+func (s *Server) storeToken(provider, userEmail string, token *oauth2.Token) {
+	s.tokenStore.Lock()
+	s.tokenStore.tokens[accountKey{provider, userEmail}] = token
+	s.tokenStore.Unlock()
+}
+
+// resolveProvider returns the named provider, or without a name the provider
+// userEmail is signed in with, which must then be unambiguous
+// Users who aren't signed in resolve to Gmail, so callers report them as unauthenticated.
+func (s *Server) resolveProvider(userEmail, name string) (MailProvider, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		switch names := s.userProviders(userEmail); len(names) {
+		case 0:
+			name = providerGmail
+		case 1:
+			name = names[0]
+		default:
+			return nil, fmt.Errorf("user is signed in with several providers (%s); pass provider", strings.Join(names, ", "))
+		}
+	}
+	p, ok := s.provider(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown or unconfigured provider %q", name)
+	}
+	return p, nil
+}
+
+// requestProvider resolves the provider named by the request's provider
+// parameter as resolveProvider does, answering 400 when it can't
+func (s *Server) requestProvider(w http.ResponseWriter, r *http.Request, userEmail string) (MailProvider, bool) {
+	p, err := s.resolveProvider(userEmail, r.FormValue("provider"))
+	if err != nil {
+		http.Error(w, "Invalid provider: "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return p, true
+}
+
+// providerState prefixes an OAuth state with a non-Gmail provider's name, e.g.
+// "outlook.<state>", so the shared callback knows which provider issued the code
+func providerState(provider, state string) string {
+	if provider == providerGmail {
+		return state
+	}
+	return provider + "." + state
+}
+
+// stateProvider returns the provider named in an OAuth state built by providerState
+func stateProvider(state string) string {
+	if name, _, ok := strings.Cut(stateKey(state), "."); ok {
+		if _, registered := providerFactories[name]; registered {
+			return name
+		}
+	}
+	return providerGmail
+}
+
+// processFetchedMessage skips ignored senders and hands the rest to detectMessage
+// Every provider, Gmail included, processes fetched messages through it.
+func (s *Server) processFetchedMessage(ctx context.Context, logger *log.Logger, emailAddress string, msg *mailMessage) string {
+	if match := ignoredSenderMatch(decodeHeader(msg.Headers["From"])); match != "" {
+		logger.Printf("Skipped message %s from ignored sender %s (matched %s)", msg.ID, msg.Headers["From"], match)
+		return outcomeSkipped
+	}
+	if msg.Body.Truncated {
+		logger.Printf("Body of message %s exceeds %d bytes, detecting on truncated content", msg.ID, maxBodyBytes())
+	}
+	return s.detectMessage(ctx, logger, emailAddress, msg)
+}
+
+// detectMessage classifies a fetched message and hands it to the registered
+// detectors, returning the outcome as processPushedMessage does
+func (s *Server) detectMessage(ctx context.Context, logger *log.Logger, emailAddress string, msg *mailMessage) string {
+	analysis := analyzeEmail(msg.Headers, msg.Body.Best(), msg.Forwarded, msg.Snippet)
+	if analysis.FromSnippet {
+		logger.Printf("Body of message %s is empty, using snippet instead", msg.ID)
+	}
+	logger.Printf("Classified message %s as %s: %s", msg.ID, analysis.Kind, analysis.Reason)
+
+	email := &pushedEmail{
+		UserEmail:    emailAddress,
+		MessageID:    msg.ID,
+		InternalDate: msg.InternalDate,
+		Headers:      msg.Headers,
+		Subject:      msg.Headers["Subject"],
+		Snippet:      msg.Snippet,
+		Analysis:     analysis,
+		Logger:       logger,
+	}
+	return s.runDetectors(ctx, email)
+}
+
+// gmailProvider reads mail through the Gmail API with the server's Google OAuth client
+type gmailProvider struct {
+	s *Server
+}
+
+// Name returns "gmail"
+func (p gmailProvider) Name() string { return providerGmail }
+
+// AuthCodeURL returns the Google consent URL, asking for a refresh token
+func (p gmailProvider) AuthCodeURL(state string, opts ...oauth2.AuthCodeOption) string {
+	opts = append([]oauth2.AuthCodeOption{oauth2.AccessTypeOffline, oauth2.ApprovalForce}, opts...)
+	return p.s.oauthConfig.AuthCodeURL(state, opts...)
+}
+
+// Exchange trades an authorization code for a Google token
+func (p gmailProvider) Exchange(ctx context.Context, code string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	return p.s.oauthConfig.Exchange(ctx, code, opts...)
+}
+
+// UserEmail returns the address of the Gmail account token belongs to
+func (p gmailProvider) UserEmail(ctx context.Context, token *oauth2.Token) (string, error) {
+	srv, err := p.s.getGmailService(ctx, token)
+	if err != nil {
+		return "", err
+	}
+	return getUserEmail(ctx, srv)
+}
+
+// ListMessages searches the mailbox, newest first
+func (p gmailProvider) ListMessages(ctx context.Context, userEmail string, token *oauth2.Token, query mailQuery) ([]string, int64, error) {
+	srv, err := p.s.getUserGmailService(userEmail, token)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	q := fmt.Sprintf("newer_than:%dd", query.Days)
+	if query.Recipient != "" {
+		q += " " + recipientQuery(query.Recipient)
+	}
+	if query.From != "" {
+		clause, err := senderQuery(query.From)
+		if err != nil {
+			return nil, 0, err
+		}
+		q += " " + clause
+	}
+	msgs, err := srv.Users.Messages.List(userID(userEmail)).Q(q).MaxResults(int64(query.Limit)).Context(ctx).Do()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ids := make([]string, 0, len(msgs.Messages))
+	for _, m := range msgs.Messages {
+		ids = append(ids, m.Id)
+	}
+	// Use ResultSizeEstimate if available, otherwise count actual results
+	estimate := msgs.ResultSizeEstimate
+	if estimate <= 0 {
+		estimate = int64(len(ids))
+	}
+	return ids, estimate, nil
+}
+
+// FetchMessage gets a message in the given format, decoding the body (and any
+// forwarded message) for the full format
+func (p gmailProvider) FetchMessage(ctx context.Context, userEmail string, token *oauth2.Token, msgID, format string) (*mailMessage, error) {
+	srv, err := p.s.getUserGmailService(userEmail, token)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := getMessage(ctx, srv, userID(userEmail), msgID, format)
+	if err != nil {
+		return nil, err
+	}
+
+	// Minimal format has no payload
+	headers := make(map[string]string)
+	if msg.Payload != nil {
+		for _, h := range msg.Payload.Headers {
+			headers[h.Name] = h.Value
+		}
+	}
+	m := &mailMessage{ID: msg.Id, Headers: headers, Snippet: msg.Snippet, InternalDate: msg.InternalDate}
+	if format == "full" {
+		m.Body = extractEmailBodyWithFetch(ctx, srv, userID(userEmail), msg.Id, msg.Payload)
+		m.Forwarded = extractForwardedMessage(ctx, srv, userID(userEmail), msg.Id, msg.Payload)
+	}
+	return m, nil
+}
+
+// Watch publishes the user's inbox changes to the Pub/Sub topic and records
+// the starting history ID and the watch expiration
+func (p gmailProvider) Watch(ctx context.Context, userEmail string, token *oauth2.Token) (time.Time, error) {
+	srv, err := p.s.getUserGmailService(userEmail, token)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// Fail fast on missing project configuration rather than letting Gmail reject the watch
+	topicName, err := pubsubTopicName()
+	if err != nil {
+		return time.Time{}, &watchConfigError{what: "Pub/Sub topic", err: err}
+	}
+
+	req := &gmail.WatchRequest{
+		TopicName: topicName,
+		LabelIds:  []string{"INBOX"},
+	}
+	res, err := srv.Users.Watch(userID(userEmail), req).Context(ctx).Do()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	key := accountKey{providerGmail, userEmail}
+	p.s.historyStore.Lock()
+	p.s.historyStore.history[key] = res.HistoryId
+	p.s.historyStore.Unlock()
+
+	// Gmail reports the watch expiration in milliseconds since the epoch
+	expiration := time.UnixMilli(res.Expiration)
+	p.s.watchStore.Lock()
+	p.s.watchStore.expirations[key] = expiration
+	p.s.watchStore.Unlock()
+	return expiration, nil
+}
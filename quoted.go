@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"strings"
+)
+
+// quoteBoundaryPatterns match lines that start a quoted reply, forward, or signature
+// Everything from the first matching line onward is dropped.
+var quoteBoundaryPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^-- ?$`),
+	regexp.MustCompile(`(?i)^-+\s*forwarded message\s*-+$`),
+	regexp.MustCompile(`(?i)^-+\s*original message\s*-+$`),
+	regexp.MustCompile(`(?i)^begin forwarded message:?$`),
+	regexp.MustCompile(`(?i)^on .+ wrote:$`),
+}
+
+// stripQuotedText removes quoted reply chains, forwarded-message sections,
+// and signatures from a plain text body
+func stripQuotedText(text string) string {
+	var kept []string
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(strings.TrimSuffix(line, "\r"))
+
+		boundary := false
+		for _, pattern := range quoteBoundaryPatterns {
+			if pattern.MatchString(trimmed) {
+				boundary = true
+				break
+			}
+		}
+		if boundary {
+			break
+		}
+
+		// Drop individual quoted lines
+		if strings.HasPrefix(trimmed, ">") {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.TrimSpace(strings.Join(kept, "\n"))
+}
+
+// quoteStrippingEnabled reports whether quoted text is stripped before
+// transaction detection; set STRIP_QUOTED_TEXT=false to disable
+func quoteStrippingEnabled() bool {
+	return !strings.EqualFold(os.Getenv("STRIP_QUOTED_TEXT"), "false")
+}
+
+// stripQuotedTextIfEnabled applies stripQuotedText unless STRIP_QUOTED_TEXT=false,
+// reporting whether anything beyond surrounding whitespace was removed
+func stripQuotedTextIfEnabled(text string) (string, bool) {
+	if !quoteStrippingEnabled() {
+		return text, false
+	}
+	stripped := stripQuotedText(text)
+	return stripped, stripped != strings.TrimSpace(text)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStripQuotedText(t *testing.T) {
+	const alert = "Rs.424.00 spent on your credit card XX1234 at AMAZON on 11 Nov, 2025"
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"nothing quoted", alert, alert},
+		{"quoted lines", "Thanks!\n> " + alert + "\n>> older\nSee you", "Thanks!\nSee you"},
+		{"reply header", "Got it.\r\nOn Tue, 11 Nov 2025 at 10:00, Alerts <alerts@examplebank.com> wrote:\r\n" + alert, "Got it."},
+		{"signature", "Got it.\n-- \nJane\n" + alert, "Got it."},
+		{"signature without space", "Got it.\n--\n" + alert, "Got it."},
+		{"forwarded message", "FYI\n---------- Forwarded message ---------\n" + alert, "FYI"},
+		{"original message", "FYI\n-----Original Message-----\n" + alert, "FYI"},
+		{"begin forwarded message", "FYI\n\nBegin forwarded message:\n\n" + alert, "FYI"},
+		{"dash inside a line", "Balance - Rs.100\n" + alert, "Balance - Rs.100\n" + alert},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripQuotedText(tt.text); got != tt.want {
+				t.Errorf("stripQuotedText(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnalyzeEmailQuotedText(t *testing.T) {
+	headers := map[string]string{"From": "alerts@examplebank.com", "Subject": "Re: Transaction alert"}
+	body := "Was this you?\n\nOn Tue, 11 Nov 2025, Alerts wrote:\n> Rs.424.00 spent on your credit card XX1234 at AMAZON on 11 Nov, 2025"
+
+	analysis := analyzeEmail(headers, body, forwardedMessage{}, "")
+	if !analysis.Stripped || analysis.Body != "Was this you?" || analysis.RawBody != body {
+		t.Errorf("stripped %v body %q raw %q, want the reply alone with the raw body kept", analysis.Stripped, analysis.Body, analysis.RawBody)
+	}
+	if analysis.IsTransaction {
+		t.Errorf("quoted alert was detected as a transaction (%s)", analysis.Reason)
+	}
+
+	t.Setenv("STRIP_QUOTED_TEXT", "false")
+	analysis = analyzeEmail(headers, body, forwardedMessage{}, "")
+	if analysis.Stripped || analysis.Body != body {
+		t.Errorf("with STRIP_QUOTED_TEXT=false stripped %v body %q, want the raw body", analysis.Stripped, analysis.Body)
+	}
+}
+
+func TestDebugParseReportsStrippedBody(t *testing.T) {
+	t.Setenv("DEBUG_ENDPOINTS", "true")
+	handler := newTestServer(t).Handler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/debug/parse", strings.NewReader(`{"subject":"Re: alert","body":"Thanks\n> Rs.424.00 spent on your credit card XX1234 at AMAZON"}`)))
+	var got struct {
+		IsTransaction bool   `json:"is_transaction"`
+		RawBody       string `json:"raw_body"`
+		Body          string `json:"body"`
+		Stripped      bool   `json:"stripped"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode %d: %v", rec.Code, err)
+	}
+	if !got.Stripped || got.Body != "Thanks" || !strings.HasSuffix(got.RawBody, "at AMAZON") || got.IsTransaction {
+		t.Errorf("response = %+v, want the quoted alert stripped and not detected", got)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+)
+
+// defaultMaxRawBytes caps /emails/raw downloads when MAX_RAW_BYTES is unset
+const defaultMaxRawBytes = 25 << 20
+
+// messageIDPattern matches Gmail message IDs, which also name the downloaded file
+var messageIDPattern = regexp.MustCompile(`^[A-Za-z0-9]{1,64}$`)
+
+// maxRawBytes returns the largest raw message /emails/raw returns, read from MAX_RAW_BYTES
+func maxRawBytes() int {
+	value := strings.TrimSpace(os.Getenv("MAX_RAW_BYTES"))
+	if value == "" {
+		return defaultMaxRawBytes
+	}
+	if n, err := strconv.Atoi(value); err == nil && n > 0 {
+		return n
+	}
+	log.Printf("Invalid MAX_RAW_BYTES %q, using %d", value, defaultMaxRawBytes)
+	return defaultMaxRawBytes
+}
+
+// rawEmailHandler returns an authenticated user's message as the original RFC 822
+// source, for auditing and disputes
+func (s *Server) rawEmailHandler(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r.Context())
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form body", http.StatusBadRequest)
+		return
+	}
+
+	userEmail, ok := s.requestUser(w, r, r.FormValue("userEmail"))
+	if !ok {
+		return
+	}
+	msgID := r.FormValue("messageId")
+	if !messageIDPattern.MatchString(msgID) {
+		http.Error(w, "Missing or invalid messageId parameter", http.StatusBadRequest)
+		return
+	}
+
+	// Only the user's Gmail mailbox can be read this way, whatever else they signed in with
+	token, exists := s.userToken(providerGmail, userEmail)
+	if !exists && s.isAuthenticated(userEmail) {
+		http.Error(w, "Raw messages are only available for Gmail accounts", http.StatusBadRequest)
+		return
+	}
+	if !exists {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := gmailContext(r)
+	defer cancel()
+	srv, err := s.getUserGmailService(userEmail, token)
+	if err != nil {
+		logger.Printf("Unable to create Gmail service: %v", err)
+		http.Error(w, "Failed to create Gmail service", http.StatusInternalServerError)
+		return
+	}
+
+	msg, err := getMessage(ctx, srv, userID(userEmail), msgID, "raw")
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Printf("Unable to get raw message %s: %v", msgID, err)
+		http.Error(w, "Failed to get message", gmailErrorStatus(err))
+		return
+	}
+
+	limit := maxRawBytes()
+	if msg.SizeEstimate > int64(limit) {
+		http.Error(w, "Message too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	data, err := decodeBase64Flexible(msg.Raw)
+	if err != nil {
+		logger.Printf("Unable to decode raw message %s: %v", msgID, err)
+		http.Error(w, "Failed to decode message", http.StatusBadGateway)
+		return
+	}
+	if len(data) > limit {
+		http.Error(w, "Message too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	logger.Printf("Serving raw message %s for %s (%d bytes)", msgID, userEmail, len(data))
+	w.Header().Set("Content-Type", "message/rfc822")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": msgID + ".eml"}))
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+}
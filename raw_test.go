@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRawEmailHandler(t *testing.T) {
+	s := newTestServer(t)
+	fake := newFakeGmail(t, "user@example.com")
+	fake.use(s)
+	authenticate(s, "user@example.com")
+	msg := fake.addMessage("m1", map[string]string{"Subject": "Transaction alert", "From": "alerts@examplebank.com"},
+		"\u20b9424.00 spent at AMAZON >>>???", "", time.Now())
+	if !strings.ContainsAny(msg.Raw, "-_") {
+		t.Fatalf("raw %q doesn't exercise the base64url alphabet", msg.Raw)
+	}
+	want, err := base64.URLEncoding.DecodeString(msg.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/emails/raw?userEmail=user@example.com&messageId=m1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Body.String(); got != string(want) {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "message/rfc822" {
+		t.Errorf("Content-Type = %q, want message/rfc822", got)
+	}
+	if got := rec.Header().Get("Content-Disposition"); got != "attachment; filename=m1.eml" {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+	if got := fake.query(http.MethodGet, "/gmail/v1/users/me/messages/m1").Get("format"); got != "raw" {
+		t.Errorf("format = %q, want raw", got)
+	}
+}
+
+func TestRawEmailHandlerErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		maxBytes string // MAX_RAW_BYTES
+		want     int
+	}{
+		{"missing messageId", "userEmail=user@example.com", "", http.StatusBadRequest},
+		{"invalid messageId", "userEmail=user@example.com&messageId=../m1", "", http.StatusBadRequest},
+		{"missing userEmail", "messageId=m1", "", http.StatusBadRequest},
+		{"unknown user", "userEmail=stranger@example.com&messageId=m1", "", http.StatusUnauthorized},
+		{"unknown message", "userEmail=user@example.com&messageId=m9", "", http.StatusNotFound},
+		{"too large", "userEmail=user@example.com&messageId=m1", "16", http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("MAX_RAW_BYTES", tt.maxBytes)
+			s := newTestServer(t)
+			fake := newFakeGmail(t, "user@example.com")
+			fake.use(s)
+			authenticate(s, "user@example.com")
+			fake.addMessage("m1", map[string]string{"Subject": "Hello"}, "hello", "", time.Now())
+
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/emails/raw?"+tt.query, nil))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d (%s), want %d", rec.Code, rec.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestRawEmailRequiresSession(t *testing.T) {
+	t.Setenv("SESSION_SECRET", "test-session-secret")
+	s := newTestServer(t)
+	fake := newFakeGmail(t, "user@example.com")
+	fake.use(s)
+	authenticate(s, "user@example.com")
+	fake.addMessage("m1", map[string]string{"Subject": "Hello"}, "hello", "", time.Now())
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/emails/raw?messageId=m1", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status without a session = %d, want 401", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/emails/raw?messageId=m1", nil)
+	req.AddCookie(sessionCookie(t, "user@example.com"))
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("status with a session = %d: %s", rec.Code, rec.Body)
+	}
+}
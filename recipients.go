@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"os"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// headerDecoder decodes RFC 2047 encoded words, including non-UTF-8 charsets
+var headerDecoder = &mime.WordDecoder{
+	CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+		enc, err := htmlindex.Get(charset)
+		if err != nil {
+			return nil, fmt.Errorf("unsupported charset %q: %v", charset, err)
+		}
+		return enc.NewDecoder().Reader(input), nil
+	},
+}
+
+// decodeHeader MIME-decodes a header value, returning it unchanged if decoding fails
+func decodeHeader(value string) string {
+	decoded, err := headerDecoder.DecodeHeader(value)
+	if err != nil {
+		debugf("Unable to decode header %q: %v", value, err)
+		return value
+	}
+	return decoded
+}
+
+// recipientAddresses returns the bare addresses listed in To/Cc header values
+// Values that don't parse as address lists are split on commas instead.
+func recipientAddresses(values ...string) []string {
+	var addrs []string
+	for _, value := range values {
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+		list, err := mail.ParseAddressList(value)
+		if err != nil {
+			for _, part := range strings.Split(value, ",") {
+				if part = strings.TrimSpace(part); part != "" {
+					addrs = append(addrs, senderAddress(part))
+				}
+			}
+			continue
+		}
+		for _, addr := range list {
+			addrs = append(addrs, strings.ToLower(addr.Address))
+		}
+	}
+	return addrs
+}
+
+// recipientMatches reports whether any To/Cc address contains filter (case-insensitive)
+// so "+bank" matches a plus-addressed alias and a full address matches exactly that recipient
+func recipientMatches(filter string, values ...string) bool {
+	filter = strings.ToLower(strings.TrimSpace(filter))
+	if filter == "" {
+		return true
+	}
+	for _, addr := range recipientAddresses(values...) {
+		if strings.Contains(addr, filter) {
+			return true
+		}
+	}
+	return false
+}
+
+// transactionRecipientFilter returns TRANSACTION_RECIPIENT_FILTER; when set, only
+// emails addressed (To/Cc) to a matching recipient are treated as transactions
+func transactionRecipientFilter() string {
+	return strings.TrimSpace(os.Getenv("TRANSACTION_RECIPIENT_FILTER"))
+}
+
+// recipientQuery builds a Gmail search clause matching filter in To or Cc
+func recipientQuery(filter string) string {
+	quoted := `"` + strings.ReplaceAll(filter, `"`, "") + `"`
+	return "{to:" + quoted + " cc:" + quoted + "}"
+}
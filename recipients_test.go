@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDecodeHeader(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"me+bank@example.com", "me+bank@example.com"},
+		{"=?UTF-8?B?UmFodWwgU2hhcm1h?= <me+bank@example.com>", "Rahul Sharma <me+bank@example.com>"},
+		{"=?UTF-8?Q?Ren=C3=A9e?= <renee@example.com>", "Ren\u00e9e <renee@example.com>"},
+		{"=?ISO-8859-1?Q?Jos=E9?= <jose@example.com>", "Jos\u00e9 <jose@example.com>"},
+		{"=?x-unknown?Q?abc?= <a@example.com>", "=?x-unknown?Q?abc?= <a@example.com>"},
+	}
+	for _, tt := range tests {
+		if got := decodeHeader(tt.value); got != tt.want {
+			t.Errorf("decodeHeader(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestRecipientMatches(t *testing.T) {
+	tests := []struct {
+		filter string
+		to     string
+		cc     string
+		want   bool
+	}{
+		{"", "anyone@example.com", "", true},
+		{"me+bank@example.com", "Me <me+bank@example.com>", "", true},
+		{"ME+BANK@EXAMPLE.COM", "me+bank@example.com", "", true},
+		{"+bank", "me+bank@example.com, other@example.com", "", true},
+		{"+bank", "other@example.com", "Me <me+bank@example.com>", true},
+		{"+bank", "me@example.com", "family@example.com", false},
+		{"me+bank@example.com", "", "", false},
+		// Display names are not matched, only addresses
+		{"+bank", `"Me +bank" <me@example.com>`, "", false},
+		// Unparseable lists are split on commas
+		{"+bank", "me+bank@example.com, not an address <", "", true},
+	}
+	for _, tt := range tests {
+		if got := recipientMatches(tt.filter, tt.to, tt.cc); got != tt.want {
+			t.Errorf("recipientMatches(%q, %q, %q) = %v, want %v", tt.filter, tt.to, tt.cc, got, tt.want)
+		}
+	}
+}
+
+func TestTransactionRecipientFilter(t *testing.T) {
+	headers := func(to, cc string) map[string]string {
+		return map[string]string{"From": "alerts@examplebank.com", "Subject": "Transaction alert", "To": to, "Cc": cc}
+	}
+	const body = "Rs.424.00 spent on your credit card XX1234 at AMAZON on 11 Nov, 2025"
+	tests := []struct {
+		name    string
+		filter  string
+		headers map[string]string
+		want    bool
+	}{
+		{"no filter", "", headers("me@example.com", ""), true},
+		{"to the alias", "+bank", headers("=?UTF-8?B?TWU=?= <me+bank@example.com>", ""), true},
+		{"cc the alias", "+bank", headers("family@example.com", "me+bank@example.com"), true},
+		{"not the alias", "+bank", headers("me@example.com", ""), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TRANSACTION_RECIPIENT_FILTER", tt.filter)
+			analysis := analyzeEmail(tt.headers, body, forwardedMessage{}, "")
+			if analysis.IsTransaction != tt.want {
+				t.Errorf("transaction = %v (%s), want %v", analysis.IsTransaction, analysis.Reason, tt.want)
+			}
+			if !tt.want && analysis.Reason != "recipient does not match "+tt.filter {
+				t.Errorf("reason = %q", analysis.Reason)
+			}
+		})
+	}
+}
+
+func TestSummaryRecipients(t *testing.T) {
+	s := newTestServer(t)
+	fake := newFakeGmail(t, "me@example.com")
+	fake.use(s)
+	authenticate(s, "me@example.com")
+	fake.addMessage("m1", map[string]string{
+		"Subject": "Transaction alert",
+		"To":      "=?UTF-8?B?UmFodWwgU2hhcm1h?= <me+bank@example.com>",
+		"Cc":      "=?UTF-8?Q?Ren=C3=A9e?= <renee@example.com>",
+	}, "Rs.424.00 spent", "", time.Now())
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/emails/summary?userEmail=me@example.com&recipientFilter=me%2Bbank@example.com", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var got struct {
+		RecipientFilter string `json:"recipient_filter"`
+		LatestEmail     struct {
+			To string `json:"to"`
+			Cc string `json:"cc"`
+		} `json:"latest_email"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.LatestEmail.To != "Rahul Sharma <me+bank@example.com>" || got.LatestEmail.Cc != "Ren\u00e9e <renee@example.com>" {
+		t.Errorf("to %q, cc %q, want the decoded headers", got.LatestEmail.To, got.LatestEmail.Cc)
+	}
+	if got.RecipientFilter != "me+bank@example.com" {
+		t.Errorf("recipient_filter = %q", got.RecipientFilter)
+	}
+	want := `newer_than:30d {to:"me+bank@example.com" cc:"me+bank@example.com"}`
+	if q := fake.query(http.MethodGet, "/gmail/v1/users/me/messages").Get("q"); q != want {
+		t.Errorf("Gmail query = %q, want %q", q, want)
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// parserRulesFile is the JSON document read from PARSER_RULES_FILE
+//
+//	{"rules": [{"name": "mybank", "senders": "@mybank\\.com$",
+//	  "keywords": ["debited from card"], "exclusions": ["statement"],
+//	  "amount": ["INR ([\\d,]+\\.\\d{2})"], "reference": ["Txn#(\\w+)"],
+//	  "currency": "EUR", "locale": "de"}],
+//	 "categories": [{"merchant": "SWIGGY*", "category": "food_delivery"}]}
+type parserRulesFile struct {
+	Rules      []parserRuleGroup  `json:"rules"`
+	Categories []merchantCategory `json:"categories"`
+}
+
+// parserRuleGroup holds user-defined detection and extraction regexps for one group of senders
+// Extraction regexps use their first capture group as the value.
+type parserRuleGroup struct {
+	Name       string   `json:"name"`
+	Senders    string   `json:"senders"`
+	Keywords   []string `json:"keywords"`
+	Exclusions []string `json:"exclusions"`
+	Amount     []string `json:"amount"`
+	Card       []string `json:"card"`
+	Merchant   []string `json:"merchant"`
+	Date       []string `json:"date"`
+	Reference  []string `json:"reference"`
+	Currency   string   `json:"currency"` // Applied when no currency symbol is found
+	Locale     string   `json:"locale"`   // Amount separators, overriding LOCALE
+}
+
+// compiledRuleGroup is a parserRuleGroup with every regexp compiled
+type compiledRuleGroup struct {
+	name       string
+	senders    *regexp.Regexp
+	keywords   []*regexp.Regexp
+	exclusions []*regexp.Regexp
+	amount     []*regexp.Regexp
+	card       []*regexp.Regexp
+	merchant   []*regexp.Regexp
+	date       []*regexp.Regexp
+	reference  []*regexp.Regexp
+	currency   string
+	amountFmt  *amountFormat // nil uses LOCALE
+}
+
+// parserRules holds the rule groups and merchant categories loaded from PARSER_RULES_FILE
+var parserRules = struct {
+	sync.RWMutex
+	groups     []compiledRuleGroup
+	categories []compiledMerchantCategory
+}{}
+
+// parserRulesFilePath returns the rules file configured with PARSER_RULES_FILE
+func parserRulesFilePath() string {
+	return strings.TrimSpace(os.Getenv("PARSER_RULES_FILE"))
+}
+
+// loadParserRules reads and validates a rules file, compiling every regexp
+// Unknown fields and invalid regexps are rejected.
+func loadParserRules(path string) ([]compiledRuleGroup, []compiledMerchantCategory, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to open rules file: %v", err)
+	}
+	defer f.Close()
+
+	var file parserRulesFile
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return nil, nil, fmt.Errorf("unable to parse rules file: %v", err)
+	}
+
+	groups := make([]compiledRuleGroup, 0, len(file.Rules))
+	for i, rule := range file.Rules {
+		group, err := compileRuleGroup(rule)
+		if err != nil {
+			return nil, nil, fmt.Errorf("rule %d (%s): %v", i, rule.Name, err)
+		}
+		groups = append(groups, group)
+	}
+
+	categories := make([]compiledMerchantCategory, 0, len(file.Categories))
+	for i, mapping := range file.Categories {
+		category, err := compileMerchantCategory(mapping)
+		if err != nil {
+			return nil, nil, fmt.Errorf("category %d (%s): %v", i, mapping.Merchant, err)
+		}
+		categories = append(categories, category)
+	}
+	return groups, categories, nil
+}
+
+// compileRuleGroup compiles the regexps of a single rule group
+func compileRuleGroup(rule parserRuleGroup) (compiledRuleGroup, error) {
+	if rule.Senders == "" {
+		return compiledRuleGroup{}, fmt.Errorf("missing senders pattern")
+	}
+	senders, err := regexp.Compile("(?i)" + rule.Senders)
+	if err != nil {
+		return compiledRuleGroup{}, fmt.Errorf("invalid senders pattern: %v", err)
+	}
+
+	currency := strings.ToUpper(strings.TrimSpace(rule.Currency))
+	if currency != "" && !currencyCodePattern.MatchString(currency) {
+		return compiledRuleGroup{}, fmt.Errorf("invalid currency %q (use an ISO 4217 code)", rule.Currency)
+	}
+
+	group := compiledRuleGroup{name: rule.Name, senders: senders, currency: currency}
+	if rule.Locale != "" {
+		format, ok := lookupAmountFormat(rule.Locale)
+		if !ok {
+			return compiledRuleGroup{}, fmt.Errorf("unknown locale %q", rule.Locale)
+		}
+		group.amountFmt = &format
+	}
+	fields := []struct {
+		name     string
+		patterns []string
+		dest     *[]*regexp.Regexp
+		capture  bool
+	}{
+		{"keywords", rule.Keywords, &group.keywords, false},
+		{"exclusions", rule.Exclusions, &group.exclusions, false},
+		{"amount", rule.Amount, &group.amount, true},
+		{"card", rule.Card, &group.card, true},
+		{"merchant", rule.Merchant, &group.merchant, true},
+		{"date", rule.Date, &group.date, true},
+		{"reference", rule.Reference, &group.reference, true},
+	}
+	for _, field := range fields {
+		for _, pattern := range field.patterns {
+			re, err := regexp.Compile("(?i)" + pattern)
+			if err != nil {
+				return compiledRuleGroup{}, fmt.Errorf("invalid %s pattern %q: %v", field.name, pattern, err)
+			}
+			if field.capture && re.NumSubexp() < 1 {
+				return compiledRuleGroup{}, fmt.Errorf("%s pattern %q needs a capture group", field.name, pattern)
+			}
+			*field.dest = append(*field.dest, re)
+		}
+	}
+	return group, nil
+}
+
+// reloadParserRules re-reads PARSER_RULES_FILE and swaps in the new rules,
+// returning the number of rule groups and merchant categories loaded
+// The current rules are kept if the file is invalid.
+func reloadParserRules() (int, int, error) {
+	path := parserRulesFilePath()
+	if path == "" {
+		return 0, 0, fmt.Errorf("PARSER_RULES_FILE is not set")
+	}
+	groups, categories, err := loadParserRules(path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	parserRules.Lock()
+	parserRules.groups = groups
+	parserRules.categories = categories
+	parserRules.Unlock()
+	return len(groups), len(categories), nil
+}
+
+// ruleGroupsFor returns the loaded rule groups whose senders pattern matches a From header
+func ruleGroupsFor(from string) []compiledRuleGroup {
+	address := senderAddress(from)
+	if address == "" {
+		return nil
+	}
+
+	parserRules.RLock()
+	defer parserRules.RUnlock()
+	var matched []compiledRuleGroup
+	for _, group := range parserRules.groups {
+		if group.senders.MatchString(address) {
+			matched = append(matched, group)
+		}
+	}
+	return matched
+}
+
+// classifyTransactionEmailFromSender applies the sender's rule groups before the
+// built-in classification: exclusions reject, keywords accept, otherwise the
+// built-in keywords decide. Senders outside BANK_DOMAINS, when set, are rejected first.
+func classifyTransactionEmailFromSender(from, subject, body string) (bool, string) {
+	if ok, domain := bankDomainAllowed(from); !ok {
+		return false, fmt.Sprintf("sender domain %q not in BANK_DOMAINS", domain)
+	}
+
+	text := subject + " " + body
+	for _, group := range ruleGroupsFor(from) {
+		if matchesAny(group.exclusions, text) {
+			return false, "excluded by rule " + group.name
+		}
+		if matchesAny(group.keywords, text) {
+			return true, "keywords from rule " + group.name
+		}
+	}
+	return classifyTransactionEmail(subject, body)
+}
+
+// applyParserRules overrides parsed fields with values extracted by the sender's rule groups
+// Rules only apply to single-transaction emails, where the whole text describes one transaction.
+func applyParserRules(from, subject, body string, txns []*CreditCardTransaction) {
+	if len(txns) != 1 {
+		return
+	}
+	txn := txns[0]
+	text := subject + " " + body
+
+	for _, group := range ruleGroupsFor(from) {
+		format := defaultAmountFormat()
+		if group.amountFmt != nil {
+			format = *group.amountFmt
+		}
+		if value := firstCapture(group.amount, text); value != "" {
+			if minor, err := parseAmountMinorUnitsIn(value, format); err == nil {
+				txn.Amount = value
+				txn.RawAmount = value
+				txn.AmountMinorUnits = minor
+				txn.AmountValue = float64(minor) / 100
+			}
+		} else if group.amountFmt != nil && txn.Amount != "" {
+			// Re-read the built-in amount with the rule's separators
+			if minor, err := parseAmountMinorUnitsIn(txn.Amount, format); err == nil {
+				txn.AmountMinorUnits = minor
+				txn.AmountValue = float64(minor) / 100
+			}
+		}
+		if value := firstCapture(group.card, text); value != "" {
+			txn.CardNumber = value
+		}
+		if value := firstCapture(group.merchant, text); value != "" {
+			txn.Merchant = normalizeMerchant(value)
+		}
+		if value := firstCapture(group.reference, text); value != "" {
+			txn.ReferenceID = value
+		}
+		if value := firstCapture(group.date, text); value != "" {
+			txn.Date = value
+			applyTimestamp(txn)
+		}
+		if txn.Currency == "" && group.currency != "" {
+			txn.Currency = group.currency
+		}
+	}
+}
+
+// currencyCodePattern matches ISO 4217 currency codes
+var currencyCodePattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// matchesAny reports whether any of patterns matches text
+func matchesAny(patterns []*regexp.Regexp, text string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstCapture returns the first capture group of the first pattern matching text
+func firstCapture(patterns []*regexp.Regexp, text string) string {
+	for _, pattern := range patterns {
+		if matches := pattern.FindStringSubmatch(text); len(matches) > 1 {
+			if value := strings.TrimSpace(matches[1]); value != "" {
+				return value
+			}
+		}
+	}
+	return ""
+}
+
+// parserReloadHandler re-reads PARSER_RULES_FILE so rules can change without a restart
+func parserReloadHandler(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r.Context())
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	count, categories, err := reloadParserRules()
+	if err != nil {
+		logger.Printf("Unable to reload parser rules: %v", err)
+		http.Error(w, "Failed to reload parser rules: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	logger.Printf("Reloaded %d parser rule groups and %d merchant categories from %s", count, categories, parserRulesFilePath())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":     "ok",
+		"groups":     count,
+		"categories": categories,
+	})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// useParserRules loads rules as PARSER_RULES_FILE, restoring the previous rules when the test ends
+func useParserRules(t *testing.T, rules string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+	parserRules.RLock()
+	groups, categories := parserRules.groups, parserRules.categories
+	parserRules.RUnlock()
+	t.Cleanup(func() {
+		parserRules.Lock()
+		parserRules.groups, parserRules.categories = groups, categories
+		parserRules.Unlock()
+	})
+
+	t.Setenv("PARSER_RULES_FILE", path)
+	if _, _, err := reloadParserRules(); err != nil {
+		t.Fatalf("reloadParserRules: %v", err)
+	}
+}
+
+func TestParserReloadRequiresAdmin(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin")
+	t.Setenv("PARSER_RULES_FILE", "")
+	handler := newTestServer(t).Handler()
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"wrong token", "guess", http.StatusUnauthorized},
+		// Reaches the handler, which has no rules file to read
+		{"admin token", "admin", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/parser/reload", nil)
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestSenderDefaultCurrency(t *testing.T) {
+	// Both groups read amounts without a currency marker; only mybank declares a currency
+	useParserRules(t, `{"rules": [
+		{"name": "mybank", "senders": "@mybank\\.example$", "amount": ["Amount: ([\\d,]+\\.\\d{2})"], "currency": "eur"},
+		{"name": "hdfc", "senders": "@hdfcbank\\.net$", "amount": ["Amount: ([\\d,]+\\.\\d{2})"]},
+		{"name": "other", "senders": "@otherbank\\.example$", "amount": ["Amount: ([\\d,]+\\.\\d{2})"]}
+	]}`)
+
+	tests := []struct {
+		name string
+		from string
+		body string
+		want string
+	}{
+		{"rule default", "alerts@mybank.example", "Amount: 424.00 spent on your credit card XX1234 at SWIGGY", "EUR"},
+		{"stated currency wins", "alerts@mybank.example", "USD 424.00 spent on your credit card XX1234 at SWIGGY", "USD"},
+		{"bank parser default", "alerts@hdfcbank.net", "Amount: 424.00 spent on your credit card XX1234 at SWIGGY", "INR"},
+		{"no default", "alerts@otherbank.example", "Amount: 424.00 spent on your credit card XX1234 at SWIGGY", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			txns := parseTransactionsFromSender(tt.from, "Transaction alert", tt.body)
+			if len(txns) != 1 {
+				t.Fatalf("parsed %d transactions, want 1", len(txns))
+			}
+			if txns[0].AmountMinorUnits != 42400 || txns[0].Currency != tt.want {
+				t.Errorf("amount %d currency %q, want 42400 %q", txns[0].AmountMinorUnits, txns[0].Currency, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"html"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	xhtml "golang.org/x/net/html"
+)
+
+// allowedHTMLTags lists the elements kept by sanitizeHTML and the attributes allowed on each
+var allowedHTMLTags = map[string][]string{
+	"p": nil, "br": nil, "div": nil, "span": nil, "hr": nil,
+	"b": nil, "strong": nil, "i": nil, "em": nil, "u": nil,
+	"ul": nil, "ol": nil, "li": nil,
+	"h1": nil, "h2": nil, "h3": nil, "h4": nil, "h5": nil, "h6": nil,
+	"table": nil, "thead": nil, "tbody": nil, "tr": nil,
+	"td": {"colspan", "rowspan"}, "th": {"colspan", "rowspan"},
+	"a":   {"href"},
+	"img": {"alt"},
+}
+
+// droppedHTMLContent lists elements removed together with everything inside them
+var droppedHTMLContent = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true,
+	"embed": true, "noscript": true, "template": true, "svg": true, "head": true,
+}
+
+// sanitizeHTML reduces an HTML body to an allowlist of formatting tags
+// Scripts, event handlers, styles, and image sources (tracking pixels) are
+// removed, and links are limited to http, https, and mailto with rel=noopener.
+func sanitizeHTML(input string) string {
+	var out strings.Builder
+	tokenizer := xhtml.NewTokenizer(strings.NewReader(input))
+	skipDepth := 0
+
+	for {
+		tt := tokenizer.Next()
+		if tt == xhtml.ErrorToken {
+			if tokenizer.Err() != io.EOF {
+				debugf("HTML sanitizer stopped early: %v", tokenizer.Err())
+			}
+			return out.String()
+		}
+
+		token := tokenizer.Token()
+		switch tt {
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken:
+			if droppedHTMLContent[token.Data] {
+				if tt == xhtml.StartTagToken {
+					skipDepth++
+				}
+				continue
+			}
+			if skipDepth > 0 {
+				continue
+			}
+			if attrs, ok := allowedHTMLTags[token.Data]; ok {
+				out.WriteString(renderSanitizedTag(token, attrs, tt == xhtml.SelfClosingTagToken))
+			}
+		case xhtml.EndTagToken:
+			if droppedHTMLContent[token.Data] {
+				if skipDepth > 0 {
+					skipDepth--
+				}
+				continue
+			}
+			if skipDepth > 0 {
+				continue
+			}
+			if _, ok := allowedHTMLTags[token.Data]; ok {
+				out.WriteString("</" + token.Data + ">")
+			}
+		case xhtml.TextToken:
+			if skipDepth == 0 {
+				out.WriteString(html.EscapeString(token.Data))
+			}
+		}
+	}
+}
+
+// renderSanitizedTag writes a start tag keeping only the allowed attributes
+func renderSanitizedTag(token xhtml.Token, allowedAttrs []string, selfClosing bool) string {
+	var b strings.Builder
+	b.WriteString("<" + token.Data)
+	for _, attr := range token.Attr {
+		if attr.Namespace != "" || !containsString(allowedAttrs, attr.Key) {
+			continue
+		}
+		if attr.Key == "href" && !isSafeLink(attr.Val) {
+			continue
+		}
+		b.WriteString(" " + attr.Key + `="` + html.EscapeString(attr.Val) + `"`)
+	}
+	if token.Data == "a" {
+		b.WriteString(` rel="noopener noreferrer"`)
+	}
+	if selfClosing {
+		b.WriteString(" /")
+	}
+	b.WriteString(">")
+	return b.String()
+}
+
+// isSafeLink reports whether a link target uses an allowed scheme
+func isSafeLink(href string) bool {
+	u, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https", "mailto":
+		return true
+	}
+	return false
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldSanitizeHTML reports whether HTML bodies should be sanitized for this request,
+// either via ?sanitize=true or globally with SANITIZE_HTML=true
+func shouldSanitizeHTML(sanitizeParam string) bool {
+	if sanitizeParam != "" {
+		return strings.EqualFold(sanitizeParam, "true")
+	}
+	return strings.EqualFold(os.Getenv("SANITIZE_HTML"), "true")
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"formatting kept", `<p>Spent <b>Rs.424</b><br>at AMAZON</p>`, `<p>Spent <b>Rs.424</b><br>at AMAZON</p>`},
+		{"script tag", `<p>Hi</p><script>alert(document.cookie)</script><p>there</p>`, `<p>Hi</p><p>there</p>`},
+		{"script in uppercase", `<SCRIPT type="text/javascript">steal()</SCRIPT>ok`, `ok`},
+		{"nested dropped content", `<noscript><script>x()</script><p>hidden</p></noscript>shown`, `shown`},
+		{"style block", `<style>p{display:none}</style><p style="color:red">Rs.10</p>`, `<p>Rs.10</p>`},
+		{"inline event handlers", `<p onclick="steal()">Pay</p><img src=x onerror="steal()">`, `<p>Pay</p><img>`},
+		{"event handler on link", `<a href="https://bank.example" onmouseover="steal()">Bank</a>`, `<a href="https://bank.example" rel="noopener noreferrer">Bank</a>`},
+		{"javascript url", `<a href="javascript:alert(1)">Click</a>`, `<a rel="noopener noreferrer">Click</a>`},
+		{"javascript url with spaces", `<a href=" JavaScript:alert(1)">Click</a>`, `<a rel="noopener noreferrer">Click</a>`},
+		{"data url link", `<a href="data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==">Open</a>`, `<a rel="noopener noreferrer">Open</a>`},
+		{"data url image", `<img src="data:image/svg+xml;base64,PHN2Zz48L3N2Zz4=" alt="logo">`, `<img alt="logo">`},
+		{"tracking pixel", `<img src="https://track.example/open.gif" width="1" height="1" />`, `<img />`},
+		{"mailto link", `<a href="mailto:support@bank.example" target="_blank">Mail us</a>`, `<a href="mailto:support@bank.example" rel="noopener noreferrer">Mail us</a>`},
+		{"table", `<table border="1"><tr><td colspan="2" class="x">Total</td></tr></table>`, `<table><tr><td colspan="2">Total</td></tr></table>`},
+		{"iframe", `<iframe src="https://evil.example"></iframe>after`, `after`},
+		{"unknown tags keep their text", `<font color="red"><center>Rs.424</center></font>`, `Rs.424`},
+		{"escaped text", `<p>5 &lt; 6 &amp; "quotes"</p>`, `<p>5 &lt; 6 &amp; &#34;quotes&#34;</p>`},
+		{"attribute quoting", `<a href="https://bank.example/?a=1&b=&quot;2&quot;">x</a>`, `<a href="https://bank.example/?a=1&amp;b=&#34;2&#34;" rel="noopener noreferrer">x</a>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeHTML(tt.input); got != tt.want {
+				t.Errorf("sanitizeHTML(%q)\n got %q\nwant %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShouldSanitizeHTML(t *testing.T) {
+	tests := []struct {
+		param  string
+		global string
+		want   bool
+	}{
+		{"", "", false},
+		{"true", "", true},
+		{"TRUE", "", true},
+		{"", "true", true},
+		{"false", "true", false},
+		{"no", "", false},
+	}
+	for _, tt := range tests {
+		t.Setenv("SANITIZE_HTML", tt.global)
+		if got := shouldSanitizeHTML(tt.param); got != tt.want {
+			t.Errorf("sanitize=%q, SANITIZE_HTML=%q: got %v, want %v", tt.param, tt.global, got, tt.want)
+		}
+	}
+}
+
+func TestSummarySanitizesHTMLOnly(t *testing.T) {
+	t.Setenv("SANITIZE_HTML", "")
+	s := newTestServer(t)
+	fake := newFakeGmail(t, "user@example.com")
+	fake.use(s)
+	authenticate(s, "user@example.com")
+	const text = "Spent <b>Rs.424</b> <script>"
+	fake.addMessage("m1", map[string]string{"Subject": "Alert"}, text, `<p onclick="x()">Spent</p><script>steal()</script>`, time.Now())
+
+	for param, wantHTML := range map[string]string{
+		"":     `<p onclick="x()">Spent</p><script>steal()</script>`,
+		"true": `<p>Spent</p>`,
+	} {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/emails/summary?userEmail=user@example.com&sanitize="+param, nil))
+		var got struct {
+			LatestEmail struct {
+				BodyText string `json:"body_text"`
+				BodyHTML string `json:"body_html"`
+			} `json:"latest_email"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatalf("sanitize=%q: decode: %v", param, err)
+		}
+		if got.LatestEmail.BodyHTML != wantHTML {
+			t.Errorf("sanitize=%q: body_html = %q, want %q", param, got.LatestEmail.BodyHTML, wantHTML)
+		}
+		// Plain text is returned as-is either way
+		if got.LatestEmail.BodyText != text {
+			t.Errorf("sanitize=%q: body_text = %q, want %q", param, got.LatestEmail.BodyText, text)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// senderFilterPattern is what a from filter may contain: an address, a local
+// part or a domain, with none of the quotes, spaces or brackets Gmail search
+// treats as operators
+var senderFilterPattern = regexp.MustCompile(`^[A-Za-z0-9._%+\-]*@?[A-Za-z0-9.\-]*$`)
+
+// senderQuery builds a Gmail search clause matching mail from sender, e.g.
+// from:"alerts@bank.com"; values that could alter the query are rejected
+func senderQuery(sender string) (string, error) {
+	sender = strings.TrimSpace(sender)
+	switch {
+	case sender == "" || sender == "@":
+		return "", errors.New("empty sender")
+	case len(sender) > 254:
+		return "", errors.New("sender is longer than 254 characters")
+	case !senderFilterPattern.MatchString(sender):
+		return "", errors.New("use an email address or domain")
+	}
+	return `from:"` + sender + `"`, nil
+}
+
+// ignoredSenderMatch returns the IGNORED_SENDERS entry matching a decoded From header
+// Entries are comma-separated; "/pattern/" entries are case-insensitive regexps and
+// anything else is a case-insensitive substring. Returns "" when nothing matches.
+func ignoredSenderMatch(from string) string {
+	lower := strings.ToLower(from)
+	for _, entry := range strings.Split(os.Getenv("IGNORED_SENDERS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if len(entry) > 2 && strings.HasPrefix(entry, "/") && strings.HasSuffix(entry, "/") {
+			pattern, err := regexp.Compile("(?i)" + entry[1:len(entry)-1])
+			if err != nil {
+				log.Printf("Invalid IGNORED_SENDERS pattern %q: %v", entry, err)
+				continue
+			}
+			if pattern.MatchString(from) {
+				return entry
+			}
+			continue
+		}
+
+		if strings.Contains(lower, strings.ToLower(entry)) {
+			return entry
+		}
+	}
+	return ""
+}
+
+// bankDomainAllowed reports whether a From header's domain is listed in
+// BANK_DOMAINS (comma-separated; subdomains match too) and returns the domain
+// An empty BANK_DOMAINS allows every sender.
+func bankDomainAllowed(from string) (bool, string) {
+	address := senderAddress(from)
+	domain := address[strings.LastIndex(address, "@")+1:]
+
+	configured := false
+	for _, entry := range strings.Split(os.Getenv("BANK_DOMAINS"), ",") {
+		entry = strings.ToLower(strings.Trim(strings.TrimSpace(entry), "@."))
+		if entry == "" {
+			continue
+		}
+		configured = true
+		if domain == entry || strings.HasSuffix(domain, "."+entry) {
+			return true, domain
+		}
+	}
+	return !configured, domain
+}
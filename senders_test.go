@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBankDomainAllowlist(t *testing.T) {
+	const (
+		transaction = "Rs.424.00 spent on your credit card XX1234 at AMAZON on 11 Nov, 2025"
+		marketing   = "Spent Rs.5,000 on your credit card this month? Get 10% cashback at AMAZON on your next purchase!"
+	)
+	tests := []struct {
+		name        string
+		domains     string
+		from        string
+		body        string
+		transaction bool
+	}{
+		{"allowed domain", "hdfcbank.net, icicibank.com", "HDFC Bank InstaAlerts <alerts@hdfcbank.net>", transaction, true},
+		{"allowed subdomain", "hdfcbank.net", "alerts@mail.hdfcbank.net", transaction, true},
+		{"entry with leading @", "@icicibank.com", "credit_cards@ICICIBANK.COM", transaction, true},
+		{"marketing from blocked domain", "hdfcbank.net, icicibank.com", "Deals <offers@shop.example>", marketing, false},
+		{"transaction wording from blocked domain", "hdfcbank.net", "alerts@hdfcbank.net.evil.example", transaction, false},
+		{"empty list allows every sender", "", "alerts@examplebank.com", transaction, true},
+		// The keyword match alone accepts the marketing text; only the allowlist rejects it
+		{"marketing with empty list", "", "Deals <offers@shop.example>", marketing, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("BANK_DOMAINS", tt.domains)
+			got, reason := classifyTransactionEmailFromSender(tt.from, "Transaction alert", tt.body)
+			if got != tt.transaction {
+				t.Errorf("transaction = %v (%s), want %v", got, reason, tt.transaction)
+			}
+		})
+	}
+}
+
+func TestBankDomainAllowlistForwarded(t *testing.T) {
+	t.Setenv("BANK_DOMAINS", "hdfcbank.net")
+	const alert = "Rs.424.00 spent on your HDFC Bank credit card XX1234 at AMAZON on 11 Nov, 2025"
+	headers := map[string]string{"From": "Jane <jane@gmail.com>", "Subject": "Fwd: Alert : Update on your HDFC Bank Credit Card"}
+
+	tests := []struct {
+		name      string
+		body      string
+		forwarded forwardedMessage
+	}{
+		{"embedded message", "FYI", forwardedMessage{Body: alert, From: "HDFC Bank InstaAlerts <alerts@hdfcbank.net>", Subject: "Alert : Update on your HDFC Bank Credit Card"}},
+		{"inline forward", "FYI\n\n---------- Forwarded message ---------\nFrom: HDFC Bank InstaAlerts <alerts@hdfcbank.net>\nDate: Tue, 11 Nov 2025 at 12:38\nSubject: Alert : Update on your HDFC Bank Credit Card\nTo: <jane@gmail.com>\n\n" + alert, forwardedMessage{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			analysis := analyzeEmail(headers, tt.body, tt.forwarded, "")
+			if !analysis.IsTransaction || !analysis.Forwarded || len(analysis.Transactions) != 1 {
+				t.Fatalf("transaction %v forwarded %v (%s) with %d transactions, want 1 forwarded", analysis.IsTransaction, analysis.Forwarded, analysis.Reason, len(analysis.Transactions))
+			}
+			if txn := analysis.Transactions[0]; txn.Issuer != "HDFC Bank" || txn.Merchant != "AMAZON" {
+				t.Errorf("issuer %q merchant %q, want HDFC Bank and AMAZON", txn.Issuer, txn.Merchant)
+			}
+		})
+	}
+
+	// The forwarder's own mail is still held to the allowlist
+	if analysis := analyzeEmail(headers, alert, forwardedMessage{}, ""); analysis.IsTransaction {
+		t.Errorf("alert sent directly from gmail.com accepted (%s)", analysis.Reason)
+	}
+}
+
+func TestIgnoredSenderMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		ignored string
+		from    string
+		want    string
+	}{
+		{"unset", "", "Deals <news@shop.example>", ""},
+		{"substring", "news@shop.example", "Deals <NEWS@shop.example>", "news@shop.example"},
+		{"display name", "Weekly Digest", "Weekly Digest <digest@bank.example>", "Weekly Digest"},
+		{"regexp", "/^promo.*@/", "promo-team@bank.example", "/^promo.*@/"},
+		{"regexp ignores case", "/NEWSLETTER@/", "newsletter@bank.example", "/NEWSLETTER@/"},
+		{"invalid regexp skipped", "/[/, news@", "news@shop.example", "news@"},
+		{"no match", "news@shop.example, /^promo/", "alerts@hdfcbank.net", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("IGNORED_SENDERS", tt.ignored)
+			if got := ignoredSenderMatch(tt.from); got != tt.want {
+				t.Errorf("ignoredSenderMatch(%q) = %q, want %q", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPushSkipsIgnoredSenders(t *testing.T) {
+	t.Setenv("IGNORED_SENDERS", "Bank Newsletter")
+	logs := captureLog(t)
+	s := newTestServer(t)
+	fake := newFakeGmail(t, "user@example.com")
+	fake.use(s)
+	authenticate(s, "user@example.com")
+	s.historyStore.history[accountKey{providerGmail, "user@example.com"}] = 1000
+	// Only the decoded From header contains the ignored display name
+	msg := fake.addMessage("m1", map[string]string{"Subject": "Transaction alert", "From": "=?UTF-8?B?QmFuayBOZXdzbGV0dGVy?= <newsletter@bank.example>"},
+		"Rs.424.00 spent on your credit card XX1234 at AMAZON on 11 Nov, 2025", "", time.Now())
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, pushRequest(base64.StdEncoding, "user@example.com", msg.HistoryId))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	out := logs.String()
+	if !strings.Contains(out, "Skipped message m1 from ignored sender") {
+		t.Errorf("log lacks the skipped line:\n%s", out)
+	}
+	if strings.Contains(out, "Classified message m1") {
+		t.Errorf("ignored message was classified:\n%s", out)
+	}
+	if records, _, err := s.transactions.Query("user@example.com", TransactionFilter{}); err != nil || len(records) != 0 {
+		t.Errorf("recorded %v (%v), want nothing", records, err)
+	}
+}
+
+func TestSenderQuery(t *testing.T) {
+	tests := []struct {
+		sender string
+		want   string // Empty when the sender is rejected
+	}{
+		{"alerts@bank.com", `from:"alerts@bank.com"`},
+		{"  alerts@bank.com ", `from:"alerts@bank.com"`},
+		{"hdfcbank.net", `from:"hdfcbank.net"`},
+		{"@hdfcbank.net", `from:"@hdfcbank.net"`},
+		{"credit_cards+alerts@icicibank.com", `from:"credit_cards+alerts@icicibank.com"`},
+		{"", ""},
+		{"@", ""},
+		{`alerts@bank.com" OR "x`, ""},
+		{"alerts@bank.com OR label:spam", ""},
+		{"alerts@bank.com}", ""},
+		{"{from:x", ""},
+		{"from:alerts@bank.com", ""},
+		{"(alerts@bank.com)", ""},
+		{"a@b@c.com", ""},
+		{strings.Repeat("a", 250) + "@b.com", ""},
+	}
+	for _, tt := range tests {
+		got, err := senderQuery(tt.sender)
+		if got != tt.want || (err != nil) != (tt.want == "") {
+			t.Errorf("senderQuery(%q) = %q, %v; want %q", tt.sender, got, err, tt.want)
+		}
+	}
+}
+
+func TestSummaryFromFilter(t *testing.T) {
+	s := newTestServer(t)
+	fake := newFakeGmail(t, "user@example.com")
+	fake.use(s)
+	authenticate(s, "user@example.com")
+
+	tests := []struct {
+		name  string
+		query string
+		want  int
+		q     string // Gmail search sent to messages.list
+	}{
+		{"no filter", "", http.StatusOK, "newer_than:30d"},
+		{"address", "&from=alerts@bank.com", http.StatusOK, `newer_than:30d from:"alerts@bank.com"`},
+		{"domain", "&from=hdfcbank.net", http.StatusOK, `newer_than:30d from:"hdfcbank.net"`},
+		{"with recipient", "&from=alerts@bank.com&recipientFilter=me@example.com", http.StatusOK,
+			`newer_than:30d {to:"me@example.com" cc:"me@example.com"} from:"alerts@bank.com"`},
+		{"quote injection", "&from=" + url.QueryEscape(`x" OR "y`), http.StatusBadRequest, ""},
+		{"operator injection", "&from=" + url.QueryEscape("x in:anywhere"), http.StatusBadRequest, ""},
+		{"braces", "&from=" + url.QueryEscape("{x}"), http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(fake.calls())
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/emails/summary?userEmail=user@example.com"+tt.query, nil))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			if tt.want != http.StatusOK {
+				if !strings.Contains(rec.Body.String(), "Invalid from parameter") {
+					t.Errorf("body = %s, want an invalid from error", rec.Body)
+				}
+				if calls := fake.calls(); len(calls) != before {
+					t.Errorf("Gmail calls %v after a rejected sender", calls[before:])
+				}
+				return
+			}
+			if got := fake.query(http.MethodGet, "/gmail/v1/users/me/messages").Get("q"); got != tt.q {
+				t.Errorf("q = %q, want %q", got, tt.q)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/gmail/v1"
+)
+
+// Server holds the OAuth configuration and in-memory stores behind the HTTP handlers
+// Each Server is independent, so several can run in one process.
+type Server struct {
+	oauthConfig *oauth2.Config
+
+	// tokenStore holds the token of each mailbox users signed in to, by provider and email
+	tokenStore struct {
+		sync.RWMutex
+		tokens map[accountKey]*oauth2.Token
+	}
+
+	// providers holds the configured mail providers by name; see registerProvider
+	providers map[string]MailProvider
+
+	// imapAccounts holds the registered IMAP mailboxes, with their pollers' stop
+	// channels and health; accounts are saved to IMAP_ACCOUNTS_PATH
+	imapAccounts struct {
+		sync.RWMutex
+		accounts map[string]*imapAccount
+		pollers  map[string]chan struct{}
+		status   map[string]*imapPollStatus
+	}
+
+	// uidStore records each IMAP user's UID high-water mark, as historyStore does Gmail history IDs
+	uidStore struct {
+		sync.RWMutex
+		marks map[string]imapMark
+	}
+
+	// graphSubscriptions maps Microsoft Graph subscription IDs to their user and
+	// secret, persisted to GRAPH_SUBSCRIPTIONS_PATH
+	graphSubscriptions struct {
+		sync.Mutex
+		subscriptions map[string]*graphSubscription
+		loaded        bool
+	}
+
+	// historyStore records the last history ID processed for each Gmail mailbox
+	historyStore struct {
+		sync.RWMutex
+		history map[accountKey]uint64
+	}
+
+	// watchStore records when each mailbox's Gmail watch or Graph subscription
+	// expires, and which expiration checkWatchExpirations last warned about
+	watchStore struct {
+		sync.RWMutex
+		expirations map[accountKey]time.Time
+		warned      map[accountKey]time.Time
+	}
+
+	// serviceCache holds one Gmail service per user, rebuilt when the user's token changes
+	serviceCache struct {
+		sync.Mutex
+		entries map[string]cachedGmailService
+	}
+
+	// transactions stores the transactions parsed from pushed and replayed messages
+	transactions TransactionStore
+
+	// alertRulesMu serializes changes to the alert rules kept in transactions
+	alertRulesMu sync.Mutex
+
+	// loginStates holds each login's OAuth state, with its PKCE code verifier,
+	// from /auth-url until the callback
+	loginStates struct {
+		sync.Mutex
+		pending map[string]pendingLogin
+	}
+
+	// deadLetters holds outbound deliveries that failed after every retry
+	deadLetters struct {
+		sync.Mutex
+		entries []deadLetter
+		nextID  int64
+		dropped int64 // Entries discarded to stay under maxDeadLetters
+	}
+
+	// telegramChats maps users to the Telegram chat that receives their notifications,
+	// persisted to TELEGRAM_CHATS_PATH
+	telegramChats struct {
+		sync.Mutex
+		chats  map[string]string
+		loaded bool
+	}
+
+	// events publishes new transactions and bill reminders to /events subscribers
+	events *eventBroker
+
+	// sheets batches transaction rows for the users' spreadsheets
+	sheets sheetsState
+
+	// outbound publishes transactions (and optionally email metadata) to OUTBOUND_TOPIC
+	outbound outboundPublisher
+
+	// billsDue keeps bill reminders for the daily digest and calendar feed until
+	// billRetention after their due date
+	billsDue struct {
+		sync.Mutex
+		bills map[string][]*BillReminder
+	}
+
+	// digests records the date of the last digest sent to each user, persisted to DIGEST_STATE_PATH
+	digests struct {
+		sync.Mutex
+		sent   map[string]string
+		loaded bool
+	}
+
+	// restHooks are the REST hook subscriptions by ID, persisted to HOOKS_PATH
+	restHooks struct {
+		sync.Mutex
+		hooks  map[string]*restHook
+		loaded bool
+	}
+
+	// calendarTokens holds each user's secret for the bill calendar feed,
+	// persisted to CALENDAR_TOKENS_PATH
+	calendarTokens struct {
+		sync.Mutex
+		tokens map[string]string
+		loaded bool
+	}
+
+	// detectors handle pushed emails, in order; see registerDetector
+	detectors []Detector
+
+	// notifiers fans new transactions, bill reminders and spend alerts out to the configured sinks
+	notifiers *notifierDispatcher
+
+	// redeliver retries a dead letter requeued from /admin/deadletter,
+	// returning the attempts made
+	redeliver func(ctx context.Context, entry deadLetter) (int, error)
+
+	// gmailServiceFactory builds Gmail service clients
+	// Tests can override it to return a service pointed at a fake Gmail server.
+	gmailServiceFactory func(ctx context.Context, token *oauth2.Token) (*gmail.Service, error)
+
+	// onHistoryEvent, when set, receives message deletions and label changes from push history
+	onHistoryEvent func(emailAddress string, event historyEvent)
+}
+
+// NewServer creates a Server with empty stores using the given OAuth configuration
+func NewServer(config *oauth2.Config) *Server {
+	s := &Server{oauthConfig: config}
+	s.tokenStore.tokens = make(map[accountKey]*oauth2.Token)
+	s.graphSubscriptions.subscriptions = make(map[string]*graphSubscription)
+	s.imapAccounts.accounts = make(map[string]*imapAccount)
+	s.imapAccounts.pollers = make(map[string]chan struct{})
+	s.imapAccounts.status = make(map[string]*imapPollStatus)
+	s.uidStore.marks = make(map[string]imapMark)
+	s.historyStore.history = make(map[accountKey]uint64)
+	s.watchStore.expirations = make(map[accountKey]time.Time)
+	s.watchStore.warned = make(map[accountKey]time.Time)
+	s.serviceCache.entries = make(map[string]cachedGmailService)
+	s.loginStates.pending = make(map[string]pendingLogin)
+	s.transactions = newMemoryTransactionStore()
+	s.redeliver = s.redeliverWebhook
+	s.telegramChats.chats = make(map[string]string)
+	s.events = newEventBroker()
+	s.sheets.pending = make(map[string]*sheetsBatch)
+	s.sheets.appended = make(map[string]time.Time)
+	s.billsDue.bills = make(map[string][]*BillReminder)
+	s.digests.sent = make(map[string]string)
+	s.calendarTokens.tokens = make(map[string]string)
+	s.restHooks.hooks = make(map[string]*restHook)
+	s.gmailServiceFactory = s.newGmailService
+	s.providers = newProviders(s)
+	s.notifiers = newNotifierDispatcher(s)
+	s.detectors = newDetectors(s)
+	return s
+}
+
+// Handler returns the Server's routes on a fresh ServeMux
+// Documented routes are listed in apiRoutes for /openapi.json.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	// JSON API handlers are CORS-enabled and gzip-compressed; the event stream is
+	// not compressed, and the Pub/Sub and Graph push endpoints are server-to-server and left untouched
+	mux.HandleFunc("/auth-url", requestIDMiddleware(gzipMiddleware(corsMiddleware(s.authURLHandler))))
+	mux.HandleFunc("/oauth2/callback", requestIDMiddleware(gzipMiddleware(corsMiddleware(s.oauth2CallbackHandler))))
+	mux.HandleFunc("/logout", requestIDMiddleware(corsMiddleware(s.logoutHandler)))
+	mux.HandleFunc("/emails/summary", requestIDMiddleware(gzipMiddleware(corsMiddleware(s.emailSummaryHandler))))
+	mux.HandleFunc("/emails/raw", requestIDMiddleware(gzipMiddleware(corsMiddleware(s.rawEmailHandler))))
+	mux.HandleFunc("/token/status", requestIDMiddleware(gzipMiddleware(corsMiddleware(s.tokenStatusHandler))))
+	mux.HandleFunc("/watch/start", requestIDMiddleware(gzipMiddleware(corsMiddleware(s.watchStartHandler))))
+	mux.HandleFunc("/transactions", requestIDMiddleware(gzipMiddleware(corsMiddleware(s.transactionsHandler))))
+	mux.HandleFunc("/transactions/subscriptions", requestIDMiddleware(gzipMiddleware(corsMiddleware(s.subscriptionsHandler))))
+	mux.HandleFunc("/transactions/export", requestIDMiddleware(gzipMiddleware(corsMiddleware(s.transactionsExportHandler))))
+	mux.HandleFunc("/transactions/summary", requestIDMiddleware(gzipMiddleware(corsMiddleware(s.spendSummaryHandler))))
+	mux.HandleFunc("/alerts", requestIDMiddleware(gzipMiddleware(corsMiddleware(s.alertsHandler))))
+	mux.HandleFunc("/users/", requestIDMiddleware(gzipMiddleware(corsMiddleware(s.telegramChatHandler))))
+	mux.HandleFunc("/bills/calendar.ics", requestIDMiddleware(gzipMiddleware(s.billsCalendarHandler)))
+	mux.HandleFunc("/bills/calendar-token", requestIDMiddleware(gzipMiddleware(corsMiddleware(s.calendarTokenHandler))))
+	mux.HandleFunc("/events", requestIDMiddleware(corsMiddleware(s.eventsHandler)))
+	mux.HandleFunc("/gmail/push", requestIDMiddleware(s.gmailPushHandler))
+	mux.HandleFunc("/graph/push", requestIDMiddleware(s.graphPushHandler))
+	mux.HandleFunc("/metrics", requestIDMiddleware(metricsHandler))
+	mux.HandleFunc("/accounts", requestIDMiddleware(gzipMiddleware(adminMiddleware(s.accountsHandler))))
+	mux.HandleFunc("/emails/summary/bulk", requestIDMiddleware(gzipMiddleware(adminMiddleware(s.bulkSummaryHandler))))
+	mux.HandleFunc("/admin/reprocess", requestIDMiddleware(gzipMiddleware(adminMiddleware(s.reprocessHandler))))
+	mux.HandleFunc("/admin/deadletter", requestIDMiddleware(gzipMiddleware(adminMiddleware(s.deadLetterHandler))))
+	mux.HandleFunc("/imap/accounts", requestIDMiddleware(gzipMiddleware(adminMiddleware(s.imapAccountsHandler))))
+	mux.HandleFunc("/imap/status", requestIDMiddleware(gzipMiddleware(adminMiddleware(s.imapStatusHandler))))
+	mux.HandleFunc("/hooks", requestIDMiddleware(gzipMiddleware(adminMiddleware(s.hooksHandler))))
+	mux.HandleFunc("/hooks/", requestIDMiddleware(gzipMiddleware(adminMiddleware(s.hooksHandler))))
+	mux.HandleFunc("/webhook/test", requestIDMiddleware(gzipMiddleware(adminMiddleware(s.webhookTestHandler))))
+	mux.HandleFunc("/parser/reload", requestIDMiddleware(gzipMiddleware(adminMiddleware(parserReloadHandler))))
+	mux.HandleFunc("/parser/test", requestIDMiddleware(gzipMiddleware(corsMiddleware(parserTestHandler))))
+	mux.HandleFunc("/openapi.json", requestIDMiddleware(gzipMiddleware(corsMiddleware(openAPIHandler))))
+
+	// Debug endpoints are off unless explicitly enabled
+	if debugEndpointsEnabled() {
+		mux.HandleFunc("/debug/parse", requestIDMiddleware(gzipMiddleware(debugParseHandler)))
+	}
+	return mux
+}
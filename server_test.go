@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// newTestServer creates a Server with a placeholder OAuth client, keeping the
+// files it persists to inside the test's temporary directory
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("HOOKS_PATH", dir+"/hooks.json")
+	t.Setenv("DIGEST_STATE_PATH", dir+"/digest_state.json")
+	t.Setenv("IMAP_ACCOUNTS_PATH", dir+"/imap_accounts.json")
+	t.Setenv("GRAPH_SUBSCRIPTIONS_PATH", dir+"/graph_subscriptions.json")
+	t.Setenv("SHEETS_INDEX_PATH", dir+"/sheets_index.json")
+	t.Setenv("CALENDAR_TOKENS_PATH", dir+"/calendar_tokens.json")
+	t.Setenv("TELEGRAM_CHATS_PATH", dir+"/telegram_chats.json")
+	return NewServer(&oauth2.Config{
+		ClientID:     "test-client",
+		ClientSecret: "test-secret",
+		RedirectURL:  "http://localhost:8080/oauth2/callback",
+		Endpoint:     oauth2.Endpoint{AuthURL: "https://accounts.example.com/auth", TokenURL: "https://accounts.example.com/token"},
+	})
+}
+
+// discardLogger is a logger for code under test whose output isn't checked
+func discardLogger() *log.Logger {
+	return log.New(io.Discard, "", 0)
+}
+
+// authenticate stores a token for userEmail, as a completed OAuth callback would
+func authenticate(s *Server, userEmail string) {
+	s.tokenStore.Lock()
+	s.tokenStore.tokens[accountKey{providerGmail, userEmail}] = &oauth2.Token{AccessToken: "access-" + userEmail, RefreshToken: "refresh"}
+	s.tokenStore.Unlock()
+}
+
+// sessionCookie returns a signed session cookie for userEmail; SESSION_SECRET must be set
+func sessionCookie(t *testing.T, userEmail string) *http.Cookie {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	setSessionCookie(rec, userEmail)
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("setSessionCookie set %d cookies, want 1", len(cookies))
+	}
+	return cookies[0]
+}
+
+func TestServersAreIndependent(t *testing.T) {
+	first, second := newTestServer(t), newTestServer(t)
+	authenticate(first, "user@example.com")
+	first.historyStore.history[accountKey{providerGmail, "user@example.com"}] = 4242
+	record := storeRecord("user@example.com", "a", 42400, "AMAZON", channelCreditCard, time.Now())
+	if _, err := first.transactions.Add(record); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		s    *Server
+		want int
+	}{
+		{"first", first, http.StatusOK},
+		{"second", second, http.StatusNotFound},
+	} {
+		rec := httptest.NewRecorder()
+		tt.s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/token/status?userEmail=user@example.com", nil))
+		if rec.Code != tt.want {
+			t.Errorf("%s server: token status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+
+	if _, ok := second.historyStore.history[accountKey{providerGmail, "user@example.com"}]; ok {
+		t.Error("second server shares the first's history IDs")
+	}
+	if records, _, err := second.transactions.Query("user@example.com", TransactionFilter{}); err != nil || len(records) != 0 {
+		t.Errorf("second server records = %v (%v), want none", records, err)
+	}
+	if records, _, err := first.transactions.Query("user@example.com", TransactionFilter{}); err != nil || len(records) != 1 {
+		t.Errorf("first server records = %v (%v), want 1", records, err)
+	}
+	if first.oauthConfig == second.oauthConfig {
+		t.Error("servers share an OAuth config")
+	}
+}
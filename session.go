@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sessionCookieName is the cookie holding a signed-in user's session
+const sessionCookieName = "session"
+
+// sessionLifetime is how long a session lasts after sign-in
+const sessionLifetime = 30 * 24 * time.Hour
+
+// sessionSecret returns the HMAC key for session cookies from SESSION_SECRET;
+// empty disables sessions, leaving the userEmail parameter open as before
+func sessionSecret() string {
+	return os.Getenv("SESSION_SECRET")
+}
+
+// sessionSignature returns the hex HMAC-SHA256 of a session's email and expiry
+func sessionSignature(secret, encodedEmail, expires string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(encodedEmail + "." + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// setSessionCookie signs the user in: the cookie carries the email and expiry,
+// signed with SESSION_SECRET. Does nothing when sessions are disabled.
+func setSessionCookie(w http.ResponseWriter, userEmail string) {
+	secret := sessionSecret()
+	if secret == "" {
+		return
+	}
+	expiresAt := time.Now().Add(sessionLifetime)
+	encodedEmail := base64.RawURLEncoding.EncodeToString([]byte(userEmail))
+	expires := strconv.FormatInt(expiresAt.Unix(), 10)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    encodedEmail + "." + expires + "." + sessionSignature(secret, encodedEmail, expires),
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   true,
+		// Lax still sends the cookie on the top-level redirect back from the consent screen
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// sessionUser returns the email in the request's session cookie, if it is
+// present, correctly signed and unexpired
+func sessionUser(r *http.Request) (string, bool) {
+	secret := sessionSecret()
+	if secret == "" {
+		return "", false
+	}
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return "", false
+	}
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 3 {
+		return "", false
+	}
+	encodedEmail, expires, signature := parts[0], parts[1], parts[2]
+	if !hmac.Equal([]byte(signature), []byte(sessionSignature(secret, encodedEmail, expires))) {
+		return "", false
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() >= unix {
+		return "", false
+	}
+	email, err := base64.RawURLEncoding.DecodeString(encodedEmail)
+	if err != nil || len(email) == 0 {
+		return "", false
+	}
+	return string(email), true
+}
+
+// requestUser resolves the user a request is for, writing the error response
+// when it can't
+// With sessions enabled, the signed-in user is used unless the request names
+// one with userEmail, which is then only honoured with the admin token, for
+// service-to-service calls. Without SESSION_SECRET, userEmail is required as before.
+func (s *Server) requestUser(w http.ResponseWriter, r *http.Request, userEmail string) (string, bool) {
+	if sessionSecret() == "" {
+		if userEmail == "" {
+			http.Error(w, "Missing userEmail parameter", http.StatusBadRequest)
+			return "", false
+		}
+		return userEmail, true
+	}
+
+	if userEmail != "" {
+		if !hasAdminToken(r) {
+			http.Error(w, "The userEmail parameter requires the admin token; sign in instead", http.StatusForbidden)
+			return "", false
+		}
+		return userEmail, true
+	}
+	sessionEmail, ok := sessionUser(r)
+	if !ok {
+		http.Error(w, "Not signed in", http.StatusUnauthorized)
+		return "", false
+	}
+	return sessionEmail, true
+}
+
+// logoutHandler clears the session cookie
+func (s *Server) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r.Context())
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if userEmail, ok := sessionUser(r); ok {
+		logger.Printf("User signed out: %s", userEmail)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
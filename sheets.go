@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+)
+
+const (
+	// sheetsBatchDelay is how long appends wait so transactions arriving together
+	// are written in one request
+	sheetsBatchDelay = 2 * time.Second
+
+	// sheetsIndexSize bounds the appended message IDs remembered for dedup
+	sheetsIndexSize = 5000
+
+	// sheetsIndexRetention is how long an appended message is remembered; Pub/Sub
+	// stops redelivering a push after at most a week
+	sheetsIndexRetention = 30 * 24 * time.Hour
+
+	// sheetsAppendTimeout bounds each Sheets append request
+	sheetsAppendTimeout = 30 * time.Second
+)
+
+// sheetsState batches rows per user and remembers which messages were already appended
+type sheetsState struct {
+	sync.Mutex
+	pending   map[string]*sheetsBatch // By user
+	scheduled bool
+	appended  map[string]time.Time // When each sheetsKey(user, message ID) was appended
+	order     []string             // appended keys, oldest first, for trimming
+	loaded    bool
+}
+
+// sheetsIndexEntry is one appended message in the saved index
+type sheetsIndexEntry struct {
+	Key        string    `json:"key"`
+	AppendedAt time.Time `json:"appended_at"`
+}
+
+// sheetsBatch is the rows waiting to be appended to one user's spreadsheet
+type sheetsBatch struct {
+	keys []string
+	rows [][]interface{}
+}
+
+// sheetsSpreadsheetFor returns the spreadsheet for a user: their SHEETS_SPREADSHEET_MAP
+// entry ("alice@example.com=<id>,..."), else SHEETS_SPREADSHEET_ID, else ""
+func sheetsSpreadsheetFor(userEmail string) string {
+	for _, entry := range strings.Split(os.Getenv("SHEETS_SPREADSHEET_MAP"), ",") {
+		user, id, ok := strings.Cut(entry, "=")
+		if ok && strings.EqualFold(strings.TrimSpace(user), userEmail) {
+			return strings.TrimSpace(id)
+		}
+	}
+	return strings.TrimSpace(os.Getenv("SHEETS_SPREADSHEET_ID"))
+}
+
+// sheetsEnabled reports whether any spreadsheet is configured, which also adds
+// the spreadsheets scope to the OAuth config
+func sheetsEnabled() bool {
+	return strings.TrimSpace(os.Getenv("SHEETS_SPREADSHEET_ID")) != "" ||
+		strings.TrimSpace(os.Getenv("SHEETS_SPREADSHEET_MAP")) != ""
+}
+
+// sheetsRange returns the A1 range rows are appended to, from SHEETS_RANGE
+func sheetsRange() string {
+	if value := strings.TrimSpace(os.Getenv("SHEETS_RANGE")); value != "" {
+		return value
+	}
+	return "Sheet1!A:G"
+}
+
+// sheetsIndexPath returns the file holding appended message IDs, from SHEETS_INDEX_PATH
+func sheetsIndexPath() string {
+	if value := strings.TrimSpace(os.Getenv("SHEETS_INDEX_PATH")); value != "" {
+		return value
+	}
+	return "sheets_index.json"
+}
+
+// sheetsKey identifies one user's message in the appended index
+func sheetsKey(userEmail, msgID string) string {
+	return userEmail + "/" + msgID
+}
+
+// sheetsRow renders a transaction as timestamp, merchant, amount, currency, card,
+// category, message ID
+func sheetsRow(msgID string, txn *CreditCardTransaction) []interface{} {
+	timestamp := strings.TrimSpace(txn.Date + " " + txn.Time)
+	if !txn.Timestamp.IsZero() {
+		timestamp = txn.Timestamp.In(transactionLocation()).Format("2006-01-02 15:04:05")
+	}
+	card := txn.CardNumber
+	if card == "" {
+		card = txn.AccountLast4
+	}
+	return []interface{}{timestamp, txn.Merchant, txn.AmountValue, txn.Currency, card, txn.Category, msgID}
+}
+
+// appendToSheet queues a message's transactions for the user's spreadsheet
+// Messages already appended are skipped; the write happens in the background
+// after sheetsBatchDelay so failures never affect push processing.
+func (s *Server) appendToSheet(logger *log.Logger, userEmail, msgID string, txns []*CreditCardTransaction) {
+	if len(txns) == 0 || sheetsSpreadsheetFor(userEmail) == "" {
+		return
+	}
+	key := sheetsKey(userEmail, msgID)
+
+	s.sheets.Lock()
+	defer s.sheets.Unlock()
+	s.loadSheetsIndexLocked()
+	if _, ok := s.sheets.appended[key]; ok {
+		logger.Printf("Message %s was already appended to the spreadsheet, skipping", msgID)
+		return
+	}
+	s.markSheetsAppendedLocked(key, time.Now())
+
+	batch := s.sheets.pending[userEmail]
+	if batch == nil {
+		batch = &sheetsBatch{}
+		s.sheets.pending[userEmail] = batch
+	}
+	batch.keys = append(batch.keys, key)
+	for _, txn := range txns {
+		batch.rows = append(batch.rows, sheetsRow(msgID, txn))
+	}
+	if !s.sheets.scheduled {
+		s.sheets.scheduled = true
+		time.AfterFunc(sheetsBatchDelay, s.flushSheets)
+	}
+}
+
+// flushSheets appends every pending batch; a failed batch is logged and its
+// messages forgotten so a later reprocess can append them
+func (s *Server) flushSheets() {
+	s.sheets.Lock()
+	pending := s.sheets.pending
+	s.sheets.pending = make(map[string]*sheetsBatch)
+	s.sheets.scheduled = false
+	s.sheets.Unlock()
+
+	for userEmail, batch := range pending {
+		err := s.appendRows(userEmail, batch.rows)
+
+		s.sheets.Lock()
+		if err != nil {
+			log.Printf("Unable to append %d rows to the spreadsheet for %s: %v", len(batch.rows), userEmail, err)
+			s.forgetSheetsKeysLocked(batch.keys)
+		} else {
+			log.Printf("Appended %d rows to the spreadsheet for %s", len(batch.rows), userEmail)
+		}
+		s.saveSheetsIndexLocked()
+		s.sheets.Unlock()
+	}
+}
+
+// appendRows writes rows to the user's spreadsheet with their Google OAuth token
+func (s *Server) appendRows(userEmail string, rows [][]interface{}) error {
+	token, ok := s.userToken(providerGmail, userEmail)
+	if !ok {
+		return errors.New("user not authenticated with Google")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sheetsAppendTimeout)
+	defer cancel()
+	srv, err := sheets.NewService(ctx, option.WithHTTPClient(s.oauthConfig.Client(ctx, token)))
+	if err != nil {
+		return err
+	}
+	_, err = srv.Spreadsheets.Values.Append(sheetsSpreadsheetFor(userEmail), sheetsRange(), &sheets.ValueRange{Values: rows}).
+		ValueInputOption("USER_ENTERED").
+		InsertDataOption("INSERT_ROWS").
+		Context(ctx).
+		Do()
+	return err
+}
+
+// markSheetsAppendedLocked records key as appended at, forgetting the oldest
+// beyond sheetsIndexSize
+func (s *Server) markSheetsAppendedLocked(key string, at time.Time) {
+	s.sheets.appended[key] = at
+	s.sheets.order = append(s.sheets.order, key)
+	for len(s.sheets.order) > sheetsIndexSize {
+		delete(s.sheets.appended, s.sheets.order[0])
+		s.sheets.order = s.sheets.order[1:]
+	}
+}
+
+// forgetSheetsKeysLocked removes keys from the appended index
+func (s *Server) forgetSheetsKeysLocked(keys []string) {
+	for _, key := range keys {
+		delete(s.sheets.appended, key)
+	}
+	kept := s.sheets.order[:0]
+	for _, key := range s.sheets.order {
+		if _, ok := s.sheets.appended[key]; ok {
+			kept = append(kept, key)
+		}
+	}
+	s.sheets.order = kept
+}
+
+// pruneSheetsIndexLocked forgets messages appended before cutoff, returning how many
+func (s *Server) pruneSheetsIndexLocked(cutoff time.Time) int {
+	pruned := 0
+	for len(s.sheets.order) > 0 && s.sheets.appended[s.sheets.order[0]].Before(cutoff) {
+		delete(s.sheets.appended, s.sheets.order[0])
+		s.sheets.order = s.sheets.order[1:]
+		pruned++
+	}
+	return pruned
+}
+
+// loadSheetsIndexLocked reads the appended index from disk on first use
+func (s *Server) loadSheetsIndexLocked() {
+	if s.sheets.loaded {
+		return
+	}
+	s.sheets.loaded = true
+
+	data, err := os.ReadFile(sheetsIndexPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Unable to read spreadsheet index: %v", err)
+		}
+		return
+	}
+	var entries []sheetsIndexEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		// Indexes saved before entries had times hold just the keys
+		var keys []string
+		if json.Unmarshal(data, &keys) != nil {
+			log.Printf("Unable to parse spreadsheet index: %v", err)
+			return
+		}
+		now := time.Now()
+		entries = nil
+		for _, key := range keys {
+			entries = append(entries, sheetsIndexEntry{Key: key, AppendedAt: now})
+		}
+	}
+	for _, entry := range entries {
+		s.markSheetsAppendedLocked(entry.Key, entry.AppendedAt)
+	}
+}
+
+// saveSheetsIndexLocked writes the appended index to disk
+func (s *Server) saveSheetsIndexLocked() {
+	entries := make([]sheetsIndexEntry, len(s.sheets.order))
+	for i, key := range s.sheets.order {
+		entries[i] = sheetsIndexEntry{Key: key, AppendedAt: s.sheets.appended[key]}
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		log.Printf("Unable to encode spreadsheet index: %v", err)
+		return
+	}
+	if err := os.WriteFile(sheetsIndexPath(), data, 0600); err != nil {
+		log.Printf("Unable to write spreadsheet index: %v", err)
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// slackBatchWindow is the period over which SLACK_BATCH_THRESHOLD is counted
+const slackBatchWindow = time.Minute
+
+// defaultSlackBatchThreshold is used when SLACK_BATCH_THRESHOLD is unset
+const defaultSlackBatchThreshold = 10
+
+// currencySymbols prefix amounts in notifications; other currencies use their code
+var currencySymbols = map[string]string{"INR": "₹", "USD": "$", "EUR": "€", "GBP": "£"}
+
+// slackNotifier posts detected transactions to SLACK_WEBHOOK_URL
+// Once more than SLACK_BATCH_THRESHOLD messages go out within slackBatchWindow,
+// the rest of the window is held and posted as one summary per channel.
+type slackNotifier struct {
+	sync.Mutex
+	windowStart time.Time
+	sent        int
+	held        map[string][]*CreditCardTransaction // By channel
+	flushing    bool
+}
+
+// The Slack sink is registered when SLACK_WEBHOOK_URL is set
+func init() {
+	registerNotifier("slack", func(s *Server) Notifier {
+		if slackWebhookURL() == "" {
+			return nil
+		}
+		return newSlackNotifier()
+	})
+}
+
+// newSlackNotifier creates a slackNotifier with an empty batch
+func newSlackNotifier() *slackNotifier {
+	return &slackNotifier{held: make(map[string][]*CreditCardTransaction)}
+}
+
+// slackWebhookURL returns the Slack incoming webhook from SLACK_WEBHOOK_URL; empty disables Slack
+func slackWebhookURL() string {
+	return strings.TrimSpace(os.Getenv("SLACK_WEBHOOK_URL"))
+}
+
+// slackBatchThreshold returns the messages allowed per slackBatchWindow before batching
+func slackBatchThreshold() int {
+	value := strings.TrimSpace(os.Getenv("SLACK_BATCH_THRESHOLD"))
+	if value == "" {
+		return defaultSlackBatchThreshold
+	}
+	if n, err := strconv.Atoi(value); err == nil && n > 0 {
+		return n
+	}
+	log.Printf("Invalid SLACK_BATCH_THRESHOLD %q, using %d", value, defaultSlackBatchThreshold)
+	return defaultSlackBatchThreshold
+}
+
+// slackChannelFor returns the channel for a user from SLACK_CHANNEL_MAP
+// ("alice@example.com=#alice,bob@example.com=#bob"), or "" for the webhook's default
+func slackChannelFor(userEmail string) string {
+	for _, entry := range strings.Split(os.Getenv("SLACK_CHANNEL_MAP"), ",") {
+		user, channel, ok := strings.Cut(entry, "=")
+		if ok && strings.EqualFold(strings.TrimSpace(user), userEmail) {
+			return strings.TrimSpace(channel)
+		}
+	}
+	return ""
+}
+
+// formatAmount renders a transaction amount with its currency symbol, e.g. "₹1424.00"
+func formatAmount(minorUnits int64, currency string) string {
+	amount := fmt.Sprintf("%.2f", float64(minorUnits)/100)
+	if symbol, ok := currencySymbols[currency]; ok {
+		return symbol + amount
+	}
+	return strings.TrimSpace(currency + " " + amount)
+}
+
+// Name identifies the sink in logs and metrics
+func (n *slackNotifier) Name() string { return "slack" }
+
+// NotifyTransaction posts a transaction to Slack, or holds it for the window's
+// summary once the rate limit is reached
+func (n *slackNotifier) NotifyTransaction(ctx context.Context, userEmail string, txn *CreditCardTransaction) error {
+	target := slackWebhookURL()
+	if target == "" {
+		return nil
+	}
+	channel := slackChannelFor(userEmail)
+	now := time.Now()
+
+	n.Lock()
+	if now.Sub(n.windowStart) >= slackBatchWindow {
+		n.windowStart, n.sent = now, 0
+	}
+	if n.sent < slackBatchThreshold() {
+		n.sent++
+		n.Unlock()
+		return n.post(ctx, target, slackTransactionMessage(channel, userEmail, txn))
+	}
+	n.held[channel] = append(n.held[channel], txn)
+	if !n.flushing {
+		n.flushing = true
+		logger := notificationFrom(ctx).Logger
+		time.AfterFunc(n.windowStart.Add(slackBatchWindow).Sub(now), func() { n.flush(logger, target) })
+	}
+	n.Unlock()
+	return nil
+}
+
+// NotifyBill does nothing; Slack only receives transactions
+func (n *slackNotifier) NotifyBill(ctx context.Context, userEmail string, bill *BillReminder) error {
+	return nil
+}
+
+// NotifyAlert posts a triggered spend alert, outside the per-minute rate limit
+func (n *slackNotifier) NotifyAlert(ctx context.Context, userEmail string, alert *spendAlert) error {
+	target := slackWebhookURL()
+	if target == "" {
+		return nil
+	}
+	return n.post(ctx, target, slackAlertMessage(slackChannelFor(userEmail), userEmail, alert))
+}
+
+// NotifyDigest posts the daily digest, outside the per-minute rate limit
+func (n *slackNotifier) NotifyDigest(ctx context.Context, userEmail string, digest *dailyDigest) error {
+	target := slackWebhookURL()
+	if target == "" {
+		return nil
+	}
+	return n.post(ctx, target, slackDigestMessage(slackChannelFor(userEmail), userEmail, digest))
+}
+
+// flush posts one summary per channel for the transactions held back by the rate limit
+func (n *slackNotifier) flush(logger *log.Logger, target string) {
+	n.Lock()
+	held := n.held
+	n.held = make(map[string][]*CreditCardTransaction)
+	n.flushing = false
+	n.Unlock()
+
+	for channel, txns := range held {
+		ctx, cancel := context.WithTimeout(context.Background(), webhookClient.Timeout)
+		if err := n.post(ctx, target, slackSummaryMessage(channel, txns)); err != nil {
+			logger.Printf("Unable to post Slack summary: %v", err)
+		}
+		cancel()
+	}
+}
+
+// post sends one message to the Slack webhook
+func (n *slackNotifier) post(ctx context.Context, target string, message map[string]interface{}) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("unable to encode Slack message: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid Slack webhook: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to post to Slack: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Slack webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// slackTransactionMessage builds the Block Kit message for one transaction
+func slackTransactionMessage(channel, userEmail string, txn *CreditCardTransaction) map[string]interface{} {
+	amount := formatAmount(txn.AmountMinorUnits, txn.Currency)
+	merchant := txn.Merchant
+	if merchant == "" {
+		merchant = "unknown merchant"
+	}
+	headline := fmt.Sprintf("*%s* at *%s*", amount, merchant)
+	if txn.Direction == directionCredit {
+		headline = fmt.Sprintf("*%s* credited from *%s*", amount, merchant)
+	}
+
+	card := txn.CardNumber
+	if card == "" {
+		card = txn.AccountLast4
+	}
+	var details []string
+	if card != "" {
+		details = append(details, "Card XX"+card)
+	}
+	if !txn.Timestamp.IsZero() {
+		details = append(details, txn.Timestamp.Format("2 Jan 2006 15:04 MST"))
+	}
+	details = append(details, fmt.Sprintf("confidence %.2f", txn.Confidence), userEmail)
+
+	message := map[string]interface{}{
+		"text": fmt.Sprintf("%s at %s", amount, merchant),
+		"blocks": []map[string]interface{}{
+			{"type": "section", "text": map[string]string{"type": "mrkdwn", "text": headline}},
+			{"type": "context", "elements": []map[string]string{{"type": "mrkdwn", "text": strings.Join(details, " · ")}}},
+		},
+	}
+	if channel != "" {
+		message["channel"] = channel
+	}
+	return message
+}
+
+// slackSummaryMessage builds one message summarizing transactions held by the rate limit
+func slackSummaryMessage(channel string, txns []*CreditCardTransaction) map[string]interface{} {
+	totals := make(map[string]int64)
+	for _, txn := range txns {
+		if txn.CountsTowardSpend() {
+			totals[txn.Currency] += txn.AmountMinorUnits
+		}
+	}
+	var spent []string
+	for currency, total := range totals {
+		spent = append(spent, formatAmount(total, currency))
+	}
+	sort.Strings(spent)
+
+	var lines []string
+	for i, txn := range txns {
+		if i == 10 {
+			lines = append(lines, fmt.Sprintf("…and %d more", len(txns)-i))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("• %s at %s", formatAmount(txn.AmountMinorUnits, txn.Currency), txn.Merchant))
+	}
+
+	headline := fmt.Sprintf("*%d more transactions* in the last minute", len(txns))
+	if len(spent) > 0 {
+		headline += ", spent " + strings.Join(spent, " + ")
+	}
+	message := map[string]interface{}{
+		"text": fmt.Sprintf("%d more transactions", len(txns)),
+		"blocks": []map[string]interface{}{
+			{"type": "section", "text": map[string]string{"type": "mrkdwn", "text": headline}},
+			{"type": "section", "text": map[string]string{"type": "mrkdwn", "text": strings.Join(lines, "\n")}},
+		},
+	}
+	if channel != "" {
+		message["channel"] = channel
+	}
+	return message
+}
+
+// digestSpent renders a digest's spend per currency, e.g. "₹1424.00 + $12.00"
+func digestSpent(digest *dailyDigest) string {
+	var spent []string
+	for currency, total := range digest.Spent {
+		spent = append(spent, formatAmount(total, currency))
+	}
+	sort.Strings(spent)
+	return strings.Join(spent, " + ")
+}
+
+// slackDigestMessage builds the Block Kit message for a daily digest
+func slackDigestMessage(channel, userEmail string, digest *dailyDigest) map[string]interface{} {
+	headline := fmt.Sprintf("*Daily digest for %s*: no spend", digest.Date)
+	if digest.Count > 0 {
+		headline = fmt.Sprintf("*Daily digest for %s*: %d transactions", digest.Date, digest.Count)
+		if spent := digestSpent(digest); spent != "" {
+			headline += ", spent " + spent
+		}
+	}
+	blocks := []map[string]interface{}{
+		{"type": "section", "text": map[string]string{"type": "mrkdwn", "text": headline}},
+	}
+
+	if len(digest.TopMerchants) > 0 {
+		lines := []string{"*Top merchants*"}
+		for _, merchant := range digest.TopMerchants {
+			lines = append(lines, fmt.Sprintf("• %s: %s (%d)", merchant.Merchant, formatAmount(merchant.SpentMinorUnits, merchant.Currency), merchant.Count))
+		}
+		blocks = append(blocks, map[string]interface{}{"type": "section", "text": map[string]string{"type": "mrkdwn", "text": strings.Join(lines, "\n")}})
+	}
+	if len(digest.BillsDue) > 0 {
+		lines := []string{"*Bills due soon*"}
+		for _, bill := range digest.BillsDue {
+			lines = append(lines, "• "+digestBillLine(bill))
+		}
+		blocks = append(blocks, map[string]interface{}{"type": "section", "text": map[string]string{"type": "mrkdwn", "text": strings.Join(lines, "\n")}})
+	}
+	blocks = append(blocks, map[string]interface{}{"type": "context", "elements": []map[string]string{{"type": "mrkdwn", "text": userEmail}}})
+
+	message := map[string]interface{}{
+		"text":   "Daily digest for " + digest.Date,
+		"blocks": blocks,
+	}
+	if channel != "" {
+		message["channel"] = channel
+	}
+	return message
+}
+
+// slackAlertMessage builds the message for a triggered spend alert
+func slackAlertMessage(channel, userEmail string, alert *spendAlert) map[string]interface{} {
+	text := alertText(alert)
+	message := map[string]interface{}{
+		"text": "Spend alert: " + text,
+		"blocks": []map[string]interface{}{
+			{"type": "section", "text": map[string]string{"type": "mrkdwn", "text": "*Spend alert*: " + text}},
+			{"type": "context", "elements": []map[string]string{{"type": "mrkdwn", "text": userEmail}}},
+		},
+	}
+	if channel != "" {
+		message["channel"] = channel
+	}
+	return message
+}
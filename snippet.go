@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// decodeSnippet turns a Gmail snippet into display text
+// Snippets are HTML-escaped ("Rs.424 at Domino&#39;s") and may carry runs of whitespace.
+func decodeSnippet(snippet string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(validUTF8(snippet))), " ")
+}
+
+// truncateSnippet shortens text to at most maxRunes characters, cutting at a word
+// boundary where one is close and marking the cut with "…". maxRunes 0 means no limit.
+func truncateSnippet(text string, maxRunes int) string {
+	if maxRunes <= 0 || utf8.RuneCountInString(text) <= maxRunes {
+		return text
+	}
+	if maxRunes == 1 {
+		return "…"
+	}
+
+	runes := []rune(text)
+	cut := string(runes[:maxRunes-1])
+	if space := strings.LastIndex(cut, " "); space > len(cut)/2 {
+		cut = cut[:space]
+	}
+	return strings.TrimRight(cut, " ,.;:-") + "…"
+}
+
+// snippetLength reads the snippetLen parameter; 0 (the default) keeps snippets whole
+func snippetLength(r *http.Request) (int, error) {
+	value := strings.TrimSpace(r.FormValue("snippetLen"))
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("snippetLen must be a non-negative integer")
+	}
+	return n, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDecodeSnippet(t *testing.T) {
+	tests := []struct {
+		snippet string
+		want    string
+	}{
+		{"Rs.424 spent at Domino&#39;s", "Rs.424 spent at Domino's"},
+		{"M&amp;S &quot;sale&quot; &lt;today&gt;", "M&S \"sale\" <today>"},
+		{"Rs.424&nbsp;&nbsp;at\r\n  AMAZON ", "Rs.424 at AMAZON"},
+		{"&#8377;424 at &#x42;IGBASKET", "\u20b9424 at BIGBASKET"},
+		{"&amp;amp; stays decoded once", "&amp; stays decoded once"},
+		{"caf\xe9 menu", "caf\ufffd menu"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := decodeSnippet(tt.snippet); got != tt.want {
+			t.Errorf("decodeSnippet(%q) = %q, want %q", tt.snippet, got, tt.want)
+		}
+	}
+}
+
+func TestTruncateSnippet(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		max  int
+		want string
+	}{
+		{"no limit", "Rs.424.00 spent at AMAZON", 0, "Rs.424.00 spent at AMAZON"},
+		{"fits", "Rs.424.00 spent at AMAZON", 25, "Rs.424.00 spent at AMAZON"},
+		{"word boundary", "Rs.424.00 spent at AMAZON", 20, "Rs.424.00 spent at\u2026"},
+		{"trailing punctuation", "Spent Rs.424.00, at AMAZON today", 17, "Spent Rs.424.00\u2026"},
+		{"no nearby space", "Supercalifragilistic expialidocious", 10, "Supercali\u2026"},
+		{"counts runes", "\u20b9424 \u20b9424 \u20b9424", 7, "\u20b9424\u2026"},
+		{"one", "Rs.424.00 spent", 1, "\u2026"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := truncateSnippet(tt.text, tt.max); got != tt.want {
+				t.Errorf("truncateSnippet(%q, %d) = %q, want %q", tt.text, tt.max, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummarySnippet(t *testing.T) {
+	s := newTestServer(t)
+	fake := newFakeGmail(t, "user@example.com")
+	fake.use(s)
+	authenticate(s, "user@example.com")
+	msg := fake.addMessage("m1", map[string]string{"Subject": "Order", "From": "orders@shop.example"}, "body", "", time.Now())
+	msg.Snippet = "Your M&amp;S order of Rs.424.00 from Domino&#39;s has shipped"
+
+	tests := []struct {
+		name        string
+		query       string
+		snippet     string
+		fullSnippet string // Empty when snippet_full should be absent
+	}{
+		{"decoded", "", "Your M&S order of Rs.424.00 from Domino's has shipped", ""},
+		{"truncated", "&snippetLen=24", "Your M&S order of\u2026", ""},
+		{"truncated with full", "&snippetLen=24&fullSnippet=true", "Your M&S order of\u2026", "Your M&S order of Rs.424.00 from Domino's has shipped"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/emails/summary?userEmail=user@example.com"+tt.query, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			var got struct {
+				LatestEmail map[string]interface{} `json:"latest_email"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if got.LatestEmail["snippet"] != tt.snippet {
+				t.Errorf("snippet = %q, want %q", got.LatestEmail["snippet"], tt.snippet)
+			}
+			full, ok := got.LatestEmail["snippet_full"]
+			if ok != (tt.fullSnippet != "") || (ok && full != tt.fullSnippet) {
+				t.Errorf("snippet_full = %v (present %v), want %q", full, ok, tt.fullSnippet)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/emails/summary?userEmail=user@example.com&snippetLen=-1", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("snippetLen=-1 status = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// spendGroupers derive the group key of a transaction for each groupBy value
+var spendGroupers = map[string]func(TransactionRecord) string{
+	"merchant": func(r TransactionRecord) string { return r.Merchant },
+	"category": func(r TransactionRecord) string { return r.Category },
+	"month": func(r TransactionRecord) string {
+		// Months are calendar months in TZ, so late-night purchases stay in their own month
+		return r.Timestamp.In(transactionLocation()).Format("2006-01")
+	},
+	"bank": func(r TransactionRecord) string { return r.Bank },
+	"card": func(r TransactionRecord) string {
+		if r.CardNumber != "" {
+			return r.CardNumber
+		}
+		return r.AccountLast4
+	},
+}
+
+// spendGroup is the net spend of one group in one currency
+type spendGroup struct {
+	Key             string             `json:"key"`
+	Currency        string             `json:"currency"`
+	TotalMinorUnits int64              `json:"total_minor_units"` // Debits minus credits
+	Total           float64            `json:"total"`
+	Count           int                `json:"count"`
+	Largest         *TransactionRecord `json:"largest"` // Largest single transaction in the group
+}
+
+// aggregateSpend groups records by key and currency, netting debits against credits
+// Declined and unknown-direction transactions are left out.
+func aggregateSpend(records []TransactionRecord, key func(TransactionRecord) string) []*spendGroup {
+	groups := make(map[string]*spendGroup)
+	order := []*spendGroup{}
+	for i := range records {
+		record := &records[i]
+		var sign int64
+		switch {
+		case record.Status == statusDeclined:
+			continue
+		case record.Direction == directionDebit:
+			sign = 1
+		case record.Direction == directionCredit:
+			sign = -1
+		default:
+			continue
+		}
+
+		name := key(*record)
+		if name == "" {
+			name = "unknown"
+		}
+		group, ok := groups[name+"|"+record.Currency]
+		if !ok {
+			group = &spendGroup{Key: name, Currency: record.Currency}
+			groups[name+"|"+record.Currency] = group
+			order = append(order, group)
+		}
+		group.TotalMinorUnits += sign * record.AmountMinorUnits
+		group.Count++
+		if group.Largest == nil || record.AmountMinorUnits > group.Largest.AmountMinorUnits {
+			group.Largest = record
+		}
+	}
+
+	for _, group := range order {
+		group.Total = float64(group.TotalMinorUnits) / 100
+	}
+	sort.SliceStable(order, func(i, j int) bool { return order[i].TotalMinorUnits > order[j].TotalMinorUnits })
+	return order
+}
+
+// primaryCurrency returns the currency most of a user's transactions are in
+func primaryCurrency(records []TransactionRecord) string {
+	counts := make(map[string]int)
+	primary := ""
+	for _, record := range records {
+		if record.Currency == "" {
+			continue
+		}
+		counts[record.Currency]++
+		if counts[record.Currency] > counts[primary] {
+			primary = record.Currency
+		}
+	}
+	return primary
+}
+
+// spendSummaryHandler reports a user's net spend grouped by merchant, category, month, or card
+// Groups in other currencies than the user's primary one are listed separately.
+// Parameters: userEmail, groupBy (default category), from, to, and top.
+func (s *Server) spendSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r.Context())
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form body", http.StatusBadRequest)
+		return
+	}
+
+	userEmail, ok := s.requestUser(w, r, r.FormValue("userEmail"))
+	if !ok {
+		return
+	}
+	if !s.isAuthenticated(userEmail) {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	groupBy := strings.ToLower(strings.TrimSpace(r.FormValue("groupBy")))
+	if groupBy == "" {
+		groupBy = "category"
+	}
+	grouper, ok := spendGroupers[groupBy]
+	if !ok {
+		http.Error(w, "Invalid groupBy parameter (use merchant, category, month, bank, or card)", http.StatusBadRequest)
+		return
+	}
+	top := 0
+	if value := strings.TrimSpace(r.FormValue("top")); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid top parameter", http.StatusBadRequest)
+			return
+		}
+		top = n
+	}
+	filter, err := parseTransactionFilter(r)
+	if err != nil {
+		http.Error(w, "Invalid parameter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	records, _, err := s.transactions.Query(userEmail, filter)
+	if err != nil {
+		logger.Printf("Unable to query transactions for %s: %v", userEmail, err)
+		http.Error(w, "Failed to query transactions", http.StatusInternalServerError)
+		return
+	}
+
+	groups := aggregateSpend(records, grouper)
+	if top > 0 && len(groups) > top {
+		groups = groups[:top]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user_email":       userEmail,
+		"group_by":         groupBy,
+		"primary_currency": primaryCurrency(records),
+		"groups":           groups,
+	})
+}
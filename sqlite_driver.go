@@ -0,0 +1,7 @@
+//go:build sqlite
+
+package main
+
+// The SQLite driver is opt-in so default builds stay free of it:
+// go build -tags sqlite
+import _ "modernc.org/sqlite"
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// sqliteDriverName is the database/sql driver registered by sqlite_driver.go
+const sqliteDriverName = "sqlite"
+
+// defaultSQLitePath is the database file used when SQLITE_PATH is unset
+const defaultSQLitePath = "transactions.db"
+
+// sqliteMigrations are applied in order at startup; schema_migrations records
+// how many have run, so append new statements rather than editing old ones
+var sqliteMigrations = []string{
+	`CREATE TABLE transactions (
+		id                 INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_email         TEXT    NOT NULL,
+		message_id         TEXT    NOT NULL,
+		dedup_key          TEXT    NOT NULL,
+		reference_id       TEXT    NOT NULL,
+		amount_minor_units INTEGER NOT NULL,
+		currency           TEXT    NOT NULL,
+		direction          TEXT    NOT NULL,
+		merchant           TEXT    NOT NULL,
+		category           TEXT    NOT NULL,
+		card_last4         TEXT    NOT NULL,
+		channel            TEXT    NOT NULL,
+		timestamp_ms       INTEGER NOT NULL,
+		confidence         REAL    NOT NULL,
+		subject            TEXT    NOT NULL,
+		record             TEXT    NOT NULL
+	)`,
+	`CREATE UNIQUE INDEX transactions_dedup ON transactions (user_email, dedup_key)`,
+	`CREATE INDEX transactions_user_time ON transactions (user_email, timestamp_ms)`,
+	`CREATE TABLE alert_rules (
+		user_email TEXT PRIMARY KEY,
+		rules      TEXT NOT NULL
+	)`,
+}
+
+// sqliteTransactionStore is a TransactionStore in a SQLite database
+// Filtered columns are stored individually for indexed queries; the full
+// record is kept as JSON, as is each user's array of alert rules.
+type sqliteTransactionStore struct {
+	db *sql.DB
+}
+
+// transactionStoreFromEnv returns the TransactionStore selected by TRANSACTION_STORE
+// ("memory", the default, or "sqlite" at SQLITE_PATH)
+func transactionStoreFromEnv() (TransactionStore, error) {
+	switch kind := strings.ToLower(strings.TrimSpace(os.Getenv("TRANSACTION_STORE"))); kind {
+	case "", "memory":
+		return newMemoryTransactionStore(), nil
+	case "sqlite":
+		path := strings.TrimSpace(os.Getenv("SQLITE_PATH"))
+		if path == "" {
+			path = defaultSQLitePath
+		}
+		return openSQLiteTransactionStore(path)
+	default:
+		return nil, fmt.Errorf("unknown TRANSACTION_STORE %q (use memory or sqlite)", kind)
+	}
+}
+
+// openSQLiteTransactionStore opens the database at path and applies pending migrations
+func openSQLiteTransactionStore(path string) (*sqliteTransactionStore, error) {
+	if !containsString(sql.Drivers(), sqliteDriverName) {
+		return nil, fmt.Errorf("SQLite driver not compiled in (build with -tags sqlite)")
+	}
+	db, err := sql.Open(sqliteDriverName, path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open SQLite database: %v", err)
+	}
+	// SQLite allows one writer; a single connection avoids "database is locked"
+	db.SetMaxOpenConns(1)
+
+	if err := migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteTransactionStore{db: db}, nil
+}
+
+// migrateSQLite applies the sqliteMigrations that haven't run yet
+func migrateSQLite(db *sql.DB) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER NOT NULL)`); err != nil {
+		return fmt.Errorf("unable to create schema_migrations: %v", err)
+	}
+	var version int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return fmt.Errorf("unable to read schema version: %v", err)
+	}
+
+	for i := version; i < len(sqliteMigrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("unable to start migration %d: %v", i+1, err)
+		}
+		if _, err := tx.Exec(sqliteMigrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d failed: %v", i+1, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES (?)`, i+1); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("unable to record migration %d: %v", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("unable to commit migration %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// Add implements TransactionStore
+func (s *sqliteTransactionStore) Add(record TransactionRecord) (bool, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return false, fmt.Errorf("unable to encode transaction: %v", err)
+	}
+	card := record.CardNumber
+	if card == "" {
+		card = record.AccountLast4
+	}
+
+	res, err := s.db.Exec(`INSERT INTO transactions (user_email, message_id, dedup_key, reference_id,
+		amount_minor_units, currency, direction, merchant, category, card_last4, channel,
+		timestamp_ms, confidence, subject, record)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_email, dedup_key) DO NOTHING`,
+		record.UserEmail, record.MessageID, record.DedupKey(), record.ReferenceID,
+		record.AmountMinorUnits, record.Currency, record.Direction, record.Merchant, record.Category, card, record.Channel,
+		record.Timestamp.UnixMilli(), record.Confidence, record.Subject, string(data))
+	if err != nil {
+		return false, fmt.Errorf("unable to insert transaction: %v", err)
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("unable to insert transaction: %v", err)
+	}
+	return rows > 0, nil
+}
+
+// Query implements TransactionStore
+func (s *sqliteTransactionStore) Query(userEmail string, filter TransactionFilter) ([]TransactionRecord, int, error) {
+	where := []string{"user_email = ?"}
+	args := []interface{}{userEmail}
+	if !filter.From.IsZero() {
+		where = append(where, "timestamp_ms >= ?")
+		args = append(args, filter.From.UnixMilli())
+	}
+	if !filter.To.IsZero() {
+		where = append(where, "timestamp_ms < ?")
+		args = append(args, filter.To.UnixMilli())
+	}
+	if filter.Merchant != "" {
+		where = append(where, `merchant LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(filter.Merchant)+"%")
+	}
+	if filter.MinAmountMinorUnits > 0 {
+		where = append(where, "amount_minor_units >= ?")
+		args = append(args, filter.MinAmountMinorUnits)
+	}
+	if filter.Channel != "" {
+		where = append(where, "channel = ? COLLATE NOCASE")
+		args = append(args, filter.Channel)
+	}
+	clause := " WHERE " + strings.Join(where, " AND ")
+
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM transactions`+clause, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("unable to count transactions: %v", err)
+	}
+
+	// SQLite treats a negative LIMIT as no limit
+	limit := -1
+	if filter.Limit > 0 {
+		limit = filter.Limit
+	}
+	order := " ORDER BY timestamp_ms DESC, id DESC"
+	if filter.Oldest {
+		order = " ORDER BY timestamp_ms, id"
+	}
+	rows, err := s.db.Query(`SELECT record FROM transactions`+clause+order+` LIMIT ? OFFSET ?`,
+		append(args, limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to query transactions: %v", err)
+	}
+	defer rows.Close()
+
+	records := []TransactionRecord{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, 0, fmt.Errorf("unable to read transaction: %v", err)
+		}
+		var record TransactionRecord
+		if err := json.Unmarshal([]byte(data), &record); err != nil {
+			return nil, 0, fmt.Errorf("unable to decode transaction: %v", err)
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("unable to query transactions: %v", err)
+	}
+	return records, total, nil
+}
+
+// AlertRules implements TransactionStore
+func (s *sqliteTransactionStore) AlertRules(userEmail string) ([]alertRule, error) {
+	var data string
+	err := s.db.QueryRow(`SELECT rules FROM alert_rules WHERE user_email = ?`, userEmail).Scan(&data)
+	if err == sql.ErrNoRows {
+		return []alertRule{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read alert rules: %v", err)
+	}
+	rules := []alertRule{}
+	if err := json.Unmarshal([]byte(data), &rules); err != nil {
+		return nil, fmt.Errorf("unable to decode alert rules: %v", err)
+	}
+	return rules, nil
+}
+
+// SetAlertRules implements TransactionStore
+func (s *sqliteTransactionStore) SetAlertRules(userEmail string, rules []alertRule) error {
+	if rules == nil {
+		rules = []alertRule{}
+	}
+	data, err := json.Marshal(rules)
+	if err != nil {
+		return fmt.Errorf("unable to encode alert rules: %v", err)
+	}
+	if _, err := s.db.Exec(`INSERT INTO alert_rules (user_email, rules) VALUES (?, ?)
+		ON CONFLICT (user_email) DO UPDATE SET rules = excluded.rules`, userEmail, string(data)); err != nil {
+		return fmt.Errorf("unable to store alert rules: %v", err)
+	}
+	return nil
+}
+
+// escapeLike escapes LIKE wildcards so a filter value matches literally
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
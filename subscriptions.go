@@ -0,0 +1,166 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// subscriptionAmountTolerance is how far, as a fraction of the typical amount,
+// a charge may differ and still count as the same subscription
+const subscriptionAmountTolerance = 0.10
+
+// subscriptionCadence is a billing interval recurring charges are checked against
+type subscriptionCadence struct {
+	name           string
+	days           float64 // Expected days between charges
+	toleranceDays  float64 // Allowed deviation per interval
+	minOccurrences int     // Charges needed before the cadence is trusted
+	next           func(time.Time) time.Time
+}
+
+// subscriptionCadences are tried in order against the intervals between a merchant's charges
+var subscriptionCadences = []subscriptionCadence{
+	{"weekly", 7, 2, 3, func(t time.Time) time.Time { return t.AddDate(0, 0, 7) }},
+	{"monthly", 30.4, 4, 3, func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }},
+	{"quarterly", 91.3, 8, 3, func(t time.Time) time.Time { return t.AddDate(0, 3, 0) }},
+	{"annual", 365.2, 12, 2, func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }},
+}
+
+// subscription is a recurring charge detected from a user's transactions
+type subscription struct {
+	Merchant                string    `json:"merchant"`
+	Currency                string    `json:"currency"`
+	TypicalAmountMinorUnits int64     `json:"typical_amount_minor_units"` // Median charge
+	TypicalAmount           float64   `json:"typical_amount"`
+	Cadence                 string    `json:"cadence"` // weekly, monthly, quarterly, or annual
+	Occurrences             int       `json:"occurrences"`
+	LastSeen                time.Time `json:"last_seen"`
+	NextExpected            time.Time `json:"next_expected"`
+
+	key string // subscriptionKey of the merchant
+}
+
+// subscriptionKey normalizes a merchant name so "NETFLIX.COM" and "Netflix *1234" group together
+func subscriptionKey(merchant string) string {
+	merchant, _, _ = strings.Cut(strings.ToUpper(merchant), "*")
+	words := strings.FieldsFunc(merchant, func(r rune) bool { return !unicode.IsLetter(r) })
+	kept := words[:0]
+	for _, word := range words {
+		if word != "COM" && word != "WWW" {
+			kept = append(kept, word)
+		}
+	}
+	return strings.Join(kept, " ")
+}
+
+// detectSubscriptions finds recurring charges among a user's transactions: spend
+// at the same merchant, in the same currency, with stable amounts and intervals
+// that fit one of subscriptionCadences
+func detectSubscriptions(txns []*CreditCardTransaction) []subscription {
+	groups := make(map[string][]*CreditCardTransaction)
+	for _, txn := range txns {
+		if !txn.CountsTowardSpend() || txn.IsRefund || txn.Timestamp.IsZero() || txn.AmountMinorUnits <= 0 {
+			continue
+		}
+		key := subscriptionKey(txn.Merchant)
+		if key == "" {
+			continue
+		}
+		groups[key+"|"+txn.Currency] = append(groups[key+"|"+txn.Currency], txn)
+	}
+
+	var subs []subscription
+	for _, charges := range groups {
+		if sub, ok := recurringCharge(charges); ok {
+			subs = append(subs, sub)
+		}
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].NextExpected.Before(subs[j].NextExpected) })
+	return subs
+}
+
+// recurringCharge checks one merchant's charges for a stable amount and cadence
+func recurringCharge(charges []*CreditCardTransaction) (subscription, bool) {
+	sort.Slice(charges, func(i, j int) bool { return charges[i].Timestamp.Before(charges[j].Timestamp) })
+
+	amounts := make([]int64, len(charges))
+	for i, txn := range charges {
+		amounts[i] = txn.AmountMinorUnits
+	}
+	typical := medianInt64(amounts)
+	for _, amount := range amounts {
+		if !amountWithinTolerance(amount, typical) {
+			return subscription{}, false
+		}
+	}
+
+	for _, cadence := range subscriptionCadences {
+		if len(charges) < cadence.minOccurrences {
+			continue
+		}
+		regular := true
+		for i := 1; i < len(charges); i++ {
+			days := charges[i].Timestamp.Sub(charges[i-1].Timestamp).Hours() / 24
+			if math.Abs(days-cadence.days) > cadence.toleranceDays {
+				regular = false
+				break
+			}
+		}
+		if !regular {
+			continue
+		}
+
+		last := charges[len(charges)-1]
+		return subscription{
+			Merchant:                last.Merchant,
+			Currency:                last.Currency,
+			TypicalAmountMinorUnits: typical,
+			TypicalAmount:           float64(typical) / 100,
+			Cadence:                 cadence.name,
+			Occurrences:             len(charges),
+			LastSeen:                last.Timestamp,
+			NextExpected:            cadence.next(last.Timestamp),
+			key:                     subscriptionKey(last.Merchant),
+		}, true
+	}
+	return subscription{}, false
+}
+
+// matches reports whether a new transaction is another charge of the subscription
+func (sub subscription) matches(txn *CreditCardTransaction) bool {
+	return txn.CountsTowardSpend() &&
+		subscriptionKey(txn.Merchant) == sub.key &&
+		txn.Currency == sub.Currency &&
+		amountWithinTolerance(txn.AmountMinorUnits, sub.TypicalAmountMinorUnits)
+}
+
+// markRecurring sets IsRecurring on transactions matching any of subs
+func markRecurring(txns []*CreditCardTransaction, subs []subscription) {
+	for _, txn := range txns {
+		for _, sub := range subs {
+			if sub.matches(txn) {
+				txn.IsRecurring = true
+				break
+			}
+		}
+	}
+}
+
+// amountWithinTolerance reports whether amount is within subscriptionAmountTolerance of typical
+func amountWithinTolerance(amount, typical int64) bool {
+	return math.Abs(float64(amount-typical)) <= float64(typical)*subscriptionAmountTolerance
+}
+
+// medianInt64 returns the median of values, which must not be empty
+func medianInt64(values []int64) int64 {
+	sorted := append([]int64(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxBulkSummaryUsers caps the users one /emails/summary/bulk request may name
+const maxBulkSummaryUsers = 50
+
+// defaultBulkSummaryConcurrency is the number of summaries fetched at once
+// when BULK_SUMMARY_CONCURRENCY is unset
+const defaultBulkSummaryConcurrency = 4
+
+// summaryOptions are the /emails/summary parameters shared by every user
+type summaryOptions struct {
+	Format      string // minimal, metadata, or full
+	Limit       int
+	SnippetLen  int
+	FullSnippet bool
+	Sanitize    bool
+	Recipient   string
+	From        string
+	Provider    string // Empty uses the provider each user signed in with
+}
+
+// summaryError is a failed summary: the status and message sent to the
+// client, the cause having been logged already
+type summaryError struct {
+	status  int
+	message string
+}
+
+// parseSummaryOptions reads the summary parameters from the query string or form body
+func parseSummaryOptions(r *http.Request) (summaryOptions, *summaryError) {
+	// Message format for the latest email: minimal, metadata, or full (default)
+	opts := summaryOptions{Format: r.FormValue("format")}
+	if opts.Format == "" {
+		opts.Format = "full"
+	}
+	if !isValidMessageFormat(opts.Format) {
+		return opts, &summaryError{http.StatusBadRequest, "Invalid format parameter (use minimal, metadata, or full)"}
+	}
+
+	var err error
+	opts.Limit, err = resultLimit(r)
+	if err != nil {
+		return opts, &summaryError{http.StatusBadRequest, "Invalid limit parameter: " + err.Error()}
+	}
+
+	// Snippets are decoded and optionally truncated; fullSnippet=true also returns the whole one
+	opts.SnippetLen, err = snippetLength(r)
+	if err != nil {
+		return opts, &summaryError{http.StatusBadRequest, "Invalid snippetLen parameter: " + err.Error()}
+	}
+	opts.FullSnippet = strings.EqualFold(r.FormValue("fullSnippet"), "true")
+	opts.Sanitize = shouldSanitizeHTML(r.FormValue("sanitize"))
+
+	// The mailbox to read when a user signed in with several providers
+	opts.Provider = strings.TrimSpace(r.FormValue("provider"))
+
+	// Optionally only count mail sent to a given recipient or from a given sender
+	opts.Recipient = strings.TrimSpace(r.FormValue("recipientFilter"))
+	opts.From = strings.TrimSpace(r.FormValue("from"))
+	if opts.From != "" {
+		if _, err := senderQuery(opts.From); err != nil {
+			return opts, &summaryError{http.StatusBadRequest, "Invalid from parameter: " + err.Error()}
+		}
+	}
+	return opts, nil
+}
+
+// emailSummary counts the user's mail from the last 30 days and describes the
+// latest email, through opts.Provider or the provider the user authenticated with
+func (s *Server) emailSummary(ctx context.Context, logger *log.Logger, userEmail string, opts summaryOptions) (map[string]interface{}, *summaryError) {
+	provider, err := s.resolveProvider(userEmail, opts.Provider)
+	if err != nil {
+		return nil, &summaryError{http.StatusBadRequest, "Invalid provider: " + err.Error()}
+	}
+
+	// Retrieve tokens
+	token, exists := s.userToken(provider.Name(), userEmail)
+	if !exists {
+		return nil, &summaryError{http.StatusUnauthorized, "User not authenticated"}
+	}
+
+	// The provider lists and fetches the messages; the response is the same for all of them
+	query := mailQuery{Days: 30, Recipient: opts.Recipient, From: opts.From, Limit: opts.Limit}
+	msgIDs, count, err := provider.ListMessages(ctx, userEmail, token, query)
+	if err != nil {
+		logger.Printf("Unable to list messages: %v", err)
+		return nil, &summaryError{gmailErrorStatus(err), "Failed to list messages"}
+	}
+
+	var latestEmail map[string]interface{}
+	if len(msgIDs) > 0 {
+		// Get the first (latest) message in the requested format
+		msg, err := provider.FetchMessage(ctx, userEmail, token, msgIDs[0], opts.Format)
+		if err != nil {
+			logger.Printf("Unable to get message: %v", err)
+			return nil, &summaryError{gmailErrorStatus(err), "Failed to get message"}
+		}
+		headers := msg.Headers
+
+		latestEmail = map[string]interface{}{
+			"id":       msg.ID,
+			"subject":  headers["Subject"],
+			"from":     headers["From"],
+			"to":       decodeHeader(headers["To"]),
+			"cc":       decodeHeader(headers["Cc"]),
+			"date":     displayDate(headers["Date"]),
+			"date_raw": headers["Date"],
+			"snippet":  truncateSnippet(decodeSnippet(msg.Snippet), opts.SnippetLen),
+		}
+		if opts.FullSnippet {
+			latestEmail["snippet_full"] = decodeSnippet(msg.Snippet)
+		}
+
+		// Only full messages carry a body worth decoding
+		if opts.Format == "full" {
+			body, forwarded := msg.Body, msg.Forwarded
+
+			if opts.Sanitize {
+				body.HTML = sanitizeHTML(body.HTML)
+			}
+
+			latestEmail["body"] = body.Best()
+			latestEmail["body_text"] = body.PlainText
+			latestEmail["body_html"] = body.HTML
+			latestEmail["attachments"] = body.Attachments
+			latestEmail["truncated"] = body.Truncated
+			latestEmail["forwarded"] = forwarded.Body != ""
+			if forwarded.Body != "" {
+				latestEmail["forwarded_body"] = forwarded.Body
+				latestEmail["forwarded_from"] = forwarded.From
+				latestEmail["forwarded_subject"] = forwarded.Subject
+				latestEmail["forwarded_date"] = forwarded.Date
+			}
+		}
+	}
+
+	response := map[string]interface{}{
+		"user_email":         userEmail,
+		"count_last_30_days": count,
+		"latest_email":       latestEmail,
+		"limit":              opts.Limit,
+	}
+	if opts.Recipient != "" {
+		response["recipient_filter"] = opts.Recipient
+	}
+	if opts.From != "" {
+		response["from"] = opts.From
+	}
+
+	// Log the summary
+	logger.Printf("Email summary for %s: count=%d", userEmail, count)
+	if latestEmail != nil {
+		logger.Printf("Latest email: subject=%s, from=%s", latestEmail["subject"], latestEmail["from"])
+	}
+	return response, nil
+}
+
+// bulkSummaryConcurrency returns how many summaries a bulk request fetches at
+// once, read from BULK_SUMMARY_CONCURRENCY
+func bulkSummaryConcurrency() int {
+	value := strings.TrimSpace(os.Getenv("BULK_SUMMARY_CONCURRENCY"))
+	if value == "" {
+		return defaultBulkSummaryConcurrency
+	}
+	if n, err := strconv.Atoi(value); err == nil && n > 0 {
+		return n
+	}
+	log.Printf("Invalid BULK_SUMMARY_CONCURRENCY %q, using %d", value, defaultBulkSummaryConcurrency)
+	return defaultBulkSummaryConcurrency
+}
+
+// bulkSummaryHandler summarizes several users' mailboxes in one call
+// The body is {"user_emails": [...]}; the /emails/summary parameters go in the
+// query string and apply to every user. Each user's result, or an error with
+// its status for users that failed or aren't authenticated, is keyed by email.
+func (s *Server) bulkSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r.Context())
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		UserEmails []string `json:"user_emails"`
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Duplicates are summarized once
+	var userEmails []string
+	seen := make(map[string]bool)
+	for _, email := range req.UserEmails {
+		if email = strings.TrimSpace(email); email != "" && !seen[email] {
+			seen[email] = true
+			userEmails = append(userEmails, email)
+		}
+	}
+	if len(userEmails) == 0 {
+		http.Error(w, "Missing user_emails", http.StatusBadRequest)
+		return
+	}
+	if len(userEmails) > maxBulkSummaryUsers {
+		http.Error(w, fmt.Sprintf("At most %d users are allowed", maxBulkSummaryUsers), http.StatusBadRequest)
+		return
+	}
+
+	opts, serr := parseSummaryOptions(r)
+	if serr != nil {
+		http.Error(w, serr.message, serr.status)
+		return
+	}
+
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		summaries = make(map[string]interface{}, len(userEmails))
+		failed    int
+	)
+	sem := make(chan struct{}, bulkSummaryConcurrency())
+	for _, email := range userEmails {
+		wg.Add(1)
+		go func(email string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			// Each user gets the full Gmail timeout rather than sharing one
+			ctx, cancel := gmailContext(r)
+			defer cancel()
+			var result interface{}
+			summary, serr := s.emailSummary(ctx, logger, email, opts)
+			if serr != nil {
+				result = map[string]interface{}{"error": serr.message, "status": serr.status}
+			} else {
+				result = summary
+			}
+
+			mu.Lock()
+			summaries[email] = result
+			if serr != nil {
+				failed++
+			}
+			mu.Unlock()
+		}(email)
+	}
+	wg.Wait()
+
+	logger.Printf("Bulk summary for %d users: %d failed", len(userEmails), failed)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"count":     len(userEmails),
+		"failed":    failed,
+		"summaries": summaries,
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBulkSummaryHandler(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin")
+	s := newTestServer(t)
+	fake := newFakeGmail(t, "a@example.com")
+	fake.use(s)
+	fake.addMessage("m1", map[string]string{"Subject": "Hello"}, "hi", "", time.Now())
+	authenticate(s, "a@example.com")
+
+	req := httptest.NewRequest(http.MethodPost, "/emails/summary/bulk?format=metadata",
+		strings.NewReader(`{"user_emails": ["a@example.com", "b@example.com", "a@example.com"]}`))
+	req.Header.Set("X-Admin-Token", "admin")
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	var got struct {
+		Count     int                        `json:"count"`
+		Failed    int                        `json:"failed"`
+		Summaries map[string]json.RawMessage `json:"summaries"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.Count != 2 || got.Failed != 1 {
+		t.Errorf("count %d failed %d, want 2 users with 1 failure", got.Count, got.Failed)
+	}
+	var a struct {
+		Count int64 `json:"count_last_30_days"`
+	}
+	if err := json.Unmarshal(got.Summaries["a@example.com"], &a); err != nil || a.Count != 1 {
+		t.Errorf("a@example.com = %s, want a summary of 1 message", got.Summaries["a@example.com"])
+	}
+	var b struct {
+		Error  string `json:"error"`
+		Status int    `json:"status"`
+	}
+	if err := json.Unmarshal(got.Summaries["b@example.com"], &b); err != nil || b.Status != http.StatusUnauthorized {
+		t.Errorf("b@example.com = %s, want a 401 error", got.Summaries["b@example.com"])
+	}
+}
+
+func TestBulkSummaryHandlerRejectsBadBodies(t *testing.T) {
+	s := newTestServer(t)
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"empty list", `{"user_emails": []}`, "Missing user_emails\n"},
+		{"blank entries", `{"user_emails": [" ", ""]}`, "Missing user_emails\n"},
+		{"camelCase key", `{"userEmails": ["a@example.com"]}`, "Invalid JSON body: json: unknown field \"userEmails\"\n"},
+		{"too many users", `{"user_emails": [` + bulkEmails(maxBulkSummaryUsers+1) + `]}`, "At most 50 users are allowed\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.bulkSummaryHandler(rec, httptest.NewRequest(http.MethodPost, "/emails/summary/bulk", strings.NewReader(tt.body)))
+			if rec.Code != http.StatusBadRequest || rec.Body.String() != tt.want {
+				t.Errorf("got %d %q, want 400 %q", rec.Code, rec.Body, tt.want)
+			}
+		})
+	}
+}
+
+// bulkEmails returns n distinct quoted addresses, comma-separated
+func bulkEmails(n int) string {
+	emails := make([]string, n)
+	for i := range emails {
+		emails[i] = fmt.Sprintf(`"user%d@example.com"`, i)
+	}
+	return strings.Join(emails, ",")
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// telegramAPIBase is the Bot API host; tests can point it at a fake server
+var telegramAPIBase = "https://api.telegram.org"
+
+// telegramMaxAttempts bounds sends per message before it is dropped
+const telegramMaxAttempts = 3
+
+// telegramMarkdownReserved are the characters MarkdownV2 requires escaping
+const telegramMarkdownReserved = "_*[]()~`>#+-=|{}.!\\"
+
+// telegramBotToken returns the bot token from TELEGRAM_BOT_TOKEN; empty disables Telegram
+func telegramBotToken() string {
+	return strings.TrimSpace(os.Getenv("TELEGRAM_BOT_TOKEN"))
+}
+
+// escapeTelegramMarkdown escapes text for a MarkdownV2 message, so merchant
+// names such as "AMAZON_PAY*IN" render literally
+func escapeTelegramMarkdown(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		if strings.ContainsRune(telegramMarkdownReserved, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// telegramTransactionText renders the message for a detected transaction
+func telegramTransactionText(txn *CreditCardTransaction) string {
+	merchant := txn.Merchant
+	if merchant == "" {
+		merchant = "unknown merchant"
+	}
+	verb := "spent at"
+	if txn.Direction == directionCredit {
+		verb = "credited from"
+	}
+	text := fmt.Sprintf("💳 *%s* %s *%s*", escapeTelegramMarkdown(formatAmount(txn.AmountMinorUnits, txn.Currency)), verb, escapeTelegramMarkdown(merchant))
+	if txn.CardNumber != "" {
+		text += escapeTelegramMarkdown(" (card XX" + txn.CardNumber + ")")
+	}
+	return text
+}
+
+// telegramBillText renders the message for a bill reminder
+func telegramBillText(bill *BillReminder) string {
+	lines := []string{"🧾 *Bill due " + escapeTelegramMarkdown(bill.DueDate) + "*"}
+	if bill.Issuer != "" || bill.CardNumber != "" {
+		lines = append(lines, escapeTelegramMarkdown(strings.TrimSpace(bill.Issuer+" card XX"+bill.CardNumber)))
+	}
+	lines = append(lines, "Total due: "+escapeTelegramMarkdown(strings.TrimSpace(bill.TotalDue+" "+bill.Currency)))
+	if bill.MinimumDue != "" {
+		lines = append(lines, "Minimum due: "+escapeTelegramMarkdown(strings.TrimSpace(bill.MinimumDue+" "+bill.Currency)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// telegramDigestText renders the message for a daily digest
+func telegramDigestText(digest *dailyDigest) string {
+	lines := []string{"📊 *Daily digest " + escapeTelegramMarkdown(digest.Date) + "*"}
+	if digest.Count == 0 {
+		lines = append(lines, "No spend today")
+	} else {
+		summary := fmt.Sprintf("%d transactions", digest.Count)
+		if spent := digestSpent(digest); spent != "" {
+			summary += ", spent " + spent
+		}
+		lines = append(lines, escapeTelegramMarkdown(summary))
+	}
+	if len(digest.TopMerchants) > 0 {
+		lines = append(lines, "", "*Top merchants*")
+		for _, merchant := range digest.TopMerchants {
+			lines = append(lines, escapeTelegramMarkdown(fmt.Sprintf("• %s: %s", merchant.Merchant, formatAmount(merchant.SpentMinorUnits, merchant.Currency))))
+		}
+	}
+	if len(digest.BillsDue) > 0 {
+		lines = append(lines, "", "*Bills due soon*")
+		for _, bill := range digest.BillsDue {
+			lines = append(lines, escapeTelegramMarkdown("• "+digestBillLine(bill)))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// telegramChatsPath returns the file chat registrations are saved to, from TELEGRAM_CHATS_PATH
+func telegramChatsPath() string {
+	if value := strings.TrimSpace(os.Getenv("TELEGRAM_CHATS_PATH")); value != "" {
+		return value
+	}
+	return "telegram_chats.json"
+}
+
+// telegramChat returns the chat ID registered for a user
+func (s *Server) telegramChat(userEmail string) (string, bool) {
+	s.telegramChats.Lock()
+	defer s.telegramChats.Unlock()
+	s.loadTelegramChatsLocked()
+	chatID, ok := s.telegramChats.chats[userEmail]
+	return chatID, ok
+}
+
+// setTelegramChat registers a user's chat, or removes it when chatID is empty
+// The change is saved at once, so registrations survive a restart.
+func (s *Server) setTelegramChat(userEmail, chatID string) {
+	s.telegramChats.Lock()
+	defer s.telegramChats.Unlock()
+	s.loadTelegramChatsLocked()
+	if chatID == "" {
+		delete(s.telegramChats.chats, userEmail)
+	} else {
+		s.telegramChats.chats[userEmail] = chatID
+	}
+	s.saveTelegramChatsLocked()
+}
+
+// loadTelegramChatsLocked reads the saved chat registrations from disk once; s.telegramChats must be locked
+func (s *Server) loadTelegramChatsLocked() {
+	if s.telegramChats.loaded {
+		return
+	}
+	s.telegramChats.loaded = true
+
+	data, err := os.ReadFile(telegramChatsPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Unable to read Telegram chats: %v", err)
+		}
+		return
+	}
+	var chats map[string]string
+	if err := json.Unmarshal(data, &chats); err != nil {
+		log.Printf("Unable to parse Telegram chats: %v", err)
+		return
+	}
+	for userEmail, chatID := range chats {
+		s.telegramChats.chats[userEmail] = chatID
+	}
+}
+
+// saveTelegramChatsLocked writes the chat registrations to disk; s.telegramChats must be locked
+func (s *Server) saveTelegramChatsLocked() {
+	data, err := json.MarshalIndent(s.telegramChats.chats, "", "  ")
+	if err != nil {
+		log.Printf("Unable to encode Telegram chats: %v", err)
+		return
+	}
+	if err := os.WriteFile(telegramChatsPath(), data, 0600); err != nil {
+		log.Printf("Unable to write Telegram chats: %v", err)
+	}
+}
+
+// The Telegram sink is registered when TELEGRAM_BOT_TOKEN is set
+func init() {
+	registerNotifier("telegram", func(s *Server) Notifier {
+		if telegramBotToken() == "" {
+			return nil
+		}
+		return telegramNotifier{s}
+	})
+}
+
+// telegramNotifier messages users who have registered a Telegram chat
+type telegramNotifier struct {
+	s *Server
+}
+
+// Name identifies the sink in logs and metrics
+func (n telegramNotifier) Name() string { return "telegram" }
+
+// NotifyTransaction messages the user about a transaction
+func (n telegramNotifier) NotifyTransaction(ctx context.Context, userEmail string, txn *CreditCardTransaction) error {
+	return n.s.notifyTelegram(ctx, userEmail, telegramTransactionText(txn))
+}
+
+// NotifyBill messages the user about a bill reminder
+func (n telegramNotifier) NotifyBill(ctx context.Context, userEmail string, bill *BillReminder) error {
+	return n.s.notifyTelegram(ctx, userEmail, telegramBillText(bill))
+}
+
+// NotifyAlert messages the user about a triggered spend alert
+func (n telegramNotifier) NotifyAlert(ctx context.Context, userEmail string, alert *spendAlert) error {
+	return n.s.notifyTelegram(ctx, userEmail, "🚨 *Spend alert*\n"+escapeTelegramMarkdown(alertText(alert)))
+}
+
+// NotifyDigest messages the user their daily digest
+func (n telegramNotifier) NotifyDigest(ctx context.Context, userEmail string, digest *dailyDigest) error {
+	return n.s.notifyTelegram(ctx, userEmail, telegramDigestText(digest))
+}
+
+// notifyTelegram sends text to the user's chat when TELEGRAM_BOT_TOKEN is set
+// and the user has registered a chat
+func (s *Server) notifyTelegram(ctx context.Context, userEmail, text string) error {
+	token := telegramBotToken()
+	if token == "" {
+		return nil
+	}
+	chatID, ok := s.telegramChat(userEmail)
+	if !ok {
+		return nil
+	}
+
+	backoff := webhookBaseBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := sendTelegramMessage(ctx, token, chatID, text)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt == telegramMaxAttempts {
+			return fmt.Errorf("failed after %d attempts: %v", attempt, err)
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return fmt.Errorf("%v (retry cancelled: %v)", err, ctx.Err())
+		}
+		backoff *= 2
+	}
+}
+
+// sendTelegramMessage calls sendMessage once and reports whether a failure is
+// worth retrying (network errors, rate limits and server errors)
+func sendTelegramMessage(ctx context.Context, token, chatID, text string) (bool, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"chat_id":    chatID,
+		"text":       text,
+		"parse_mode": "MarkdownV2",
+	})
+	if err != nil {
+		return false, fmt.Errorf("unable to encode message: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, webhookClient.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, telegramAPIBase+"/bot"+token+"/sendMessage", bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("invalid request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		// The error includes the URL, and with it the bot token
+		return true, fmt.Errorf("request failed: %v", errors.Unwrap(err))
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode == http.StatusOK && result.OK {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("Telegram returned %s: %s", resp.Status, result.Description)
+}
+
+// telegramChatHandler registers a user's Telegram chat:
+// PUT /users/{email}/telegram with {"chat_id": "..."} sets it, DELETE removes it
+// and GET returns it
+func (s *Server) telegramChatHandler(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r.Context())
+
+	escaped, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.EscapedPath(), "/users/"), "/telegram")
+	if !ok || escaped == "" || strings.Contains(escaped, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	userEmail, err := url.PathUnescape(escaped)
+	if err != nil {
+		http.Error(w, "Invalid user email", http.StatusBadRequest)
+		return
+	}
+	// With sessions, only the signed-in user's own chat may be managed, unless
+	// the admin token is sent
+	if sessionSecret() != "" && !hasAdminToken(r) {
+		sessionEmail, ok := s.requestUser(w, r, "")
+		if !ok {
+			return
+		}
+		if sessionEmail != userEmail {
+			http.Error(w, "Not allowed to manage another user's chat", http.StatusForbidden)
+			return
+		}
+	}
+	if !s.isAuthenticated(userEmail) {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		// Chat IDs are numbers for users and groups, or "@name" for public channels
+		var body struct {
+			ChatID interface{} `json:"chat_id"`
+		}
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10))
+		decoder.UseNumber()
+		if err := decoder.Decode(&body); err != nil {
+			http.Error(w, "Invalid JSON body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		var chatID string
+		switch id := body.ChatID.(type) {
+		case json.Number:
+			chatID = id.String()
+		case string:
+			chatID = strings.TrimSpace(id)
+		}
+		if chatID == "" {
+			http.Error(w, "Missing chat_id", http.StatusBadRequest)
+			return
+		}
+
+		s.setTelegramChat(userEmail, chatID)
+		logger.Printf("Registered Telegram chat for %s", userEmail)
+	case http.MethodDelete:
+		s.setTelegramChat(userEmail, "")
+		logger.Printf("Removed Telegram chat for %s", userEmail)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	chatID, registered := s.telegramChat(userEmail)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user_email": userEmail,
+		"registered": registered,
+		"chat_id":    chatID,
+		"enabled":    telegramBotToken() != "",
+	})
+}
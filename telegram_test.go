@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTelegramChatHandlerSessions(t *testing.T) {
+	t.Setenv("SESSION_SECRET", "test-secret")
+	t.Setenv("ADMIN_TOKEN", "admin")
+	s := newTestServer(t)
+	authenticate(s, "alice@example.com")
+	authenticate(s, "bob@example.com")
+	handler := s.Handler()
+
+	tests := []struct {
+		name    string
+		path    string
+		session string
+		admin   bool
+		want    int
+	}{
+		{"own chat", "/users/alice@example.com/telegram", "alice@example.com", false, http.StatusOK},
+		{"another user's chat", "/users/bob@example.com/telegram", "alice@example.com", false, http.StatusForbidden},
+		{"not signed in", "/users/alice@example.com/telegram", "", false, http.StatusUnauthorized},
+		{"admin token", "/users/bob@example.com/telegram", "", true, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, tt.path, strings.NewReader(`{"chat_id": 12345}`))
+			if tt.session != "" {
+				req.AddCookie(sessionCookie(t, tt.session))
+			}
+			if tt.admin {
+				req.Header.Set("X-Admin-Token", "admin")
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/users/alice@example.com/telegram", nil)
+	req.AddCookie(sessionCookie(t, "alice@example.com"))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	var got map[string]interface{}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got["user_email"] != "alice@example.com" || got["chat_id"] != "12345" || got["registered"] != true {
+		t.Errorf("GET = %v, want alice's chat 12345", got)
+	}
+}
+
+func TestTelegramChatsPersist(t *testing.T) {
+	s := newTestServer(t)
+	authenticate(s, "alice@example.com")
+	authenticate(s, "bob@example.com")
+	handler := s.Handler()
+
+	for _, step := range []struct{ method, user string }{
+		{http.MethodPut, "alice@example.com"},
+		{http.MethodPut, "bob@example.com"},
+		{http.MethodDelete, "bob@example.com"},
+	} {
+		req := httptest.NewRequest(step.method, "/users/"+step.user+"/telegram", strings.NewReader(`{"chat_id": "@alerts"}`))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s %s: status = %d: %s", step.method, step.user, rec.Code, rec.Body)
+		}
+	}
+
+	// A restarted server loads the saved chats, so notifications keep reaching them
+	restarted := NewServer(s.oauthConfig)
+	if chatID, ok := restarted.telegramChat("alice@example.com"); !ok || chatID != "@alerts" {
+		t.Errorf("after restart, alice's chat = %q, %v; want @alerts", chatID, ok)
+	}
+	if chatID, ok := restarted.telegramChat("bob@example.com"); ok {
+		t.Errorf("after restart, bob's removed chat %q is registered", chatID)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	_ "time/tzdata" // Transaction timezones must resolve even on hosts without zoneinfo
+)
+
+// Sources reported in CreditCardTransaction.TimestampSource
+const (
+	timestampSourceBody          = "body"
+	timestampSourceForwardedDate = "forwarded_date" // Date header of the forwarded message
+	timestampSourceInternalDate  = "internal_date"
+)
+
+// defaultTransactionTZ is used when TZ is unset
+const defaultTransactionTZ = "Asia/Kolkata"
+
+// transactionDateLayouts are tried in order against normalized date strings
+// Numeric dates are day-first, as Indian banks write them.
+var transactionDateLayouts = []string{
+	"2 Jan 2006",
+	"2 January 2006",
+	"02-01-2006",
+	"2-1-2006",
+	"02/01/2006",
+	"2/1/2006",
+	"2006-01-02",
+	"2006/01/02",
+	"02-Jan-2006",
+	"02-Jan-06",
+	"02-01-06",
+	"02/01/06",
+}
+
+// localeMonthNames lists month names and abbreviations per locale, January first
+// Dates are matched against every locale in order; a name shared by several
+// locales ("mar", "mai") always means the same month.
+var localeMonthNames = []struct {
+	locale string
+	months [12][]string
+}{
+	{"en", [12][]string{
+		{"jan", "january"}, {"feb", "february"}, {"mar", "march"}, {"apr", "april"},
+		{"may"}, {"jun", "june"}, {"jul", "july"}, {"aug", "august"},
+		{"sep", "sept", "september"}, {"oct", "october"}, {"nov", "november"}, {"dec", "december"},
+	}},
+	{"de", [12][]string{
+		{"jan", "januar", "jän", "jänner"}, {"feb", "februar"}, {"mär", "märz", "mrz"}, {"apr", "april"},
+		{"mai"}, {"jun", "juni"}, {"jul", "juli"}, {"aug", "august"},
+		{"sep", "sept", "september"}, {"okt", "oktober"}, {"nov", "november"}, {"dez", "dezember"},
+	}},
+	{"fr", [12][]string{
+		{"janv", "janvier"}, {"févr", "fevr", "février", "fevrier"}, {"mars"}, {"avr", "avril"},
+		{"mai"}, {"juin"}, {"juil", "juillet"}, {"août", "aout"},
+		{"sept", "septembre"}, {"oct", "octobre"}, {"nov", "novembre"}, {"déc", "décembre", "decembre"},
+	}},
+	{"es", [12][]string{
+		{"ene", "enero"}, {"feb", "febrero"}, {"mar", "marzo"}, {"abr", "abril"},
+		{"may", "mayo"}, {"jun", "junio"}, {"jul", "julio"}, {"ago", "agosto"},
+		{"sep", "sept", "septiembre", "setiembre"}, {"oct", "octubre"}, {"nov", "noviembre"}, {"dic", "diciembre"},
+	}},
+	{"pt", [12][]string{
+		{"jan", "janeiro"}, {"fev", "fevereiro"}, {"mar", "março", "marco"}, {"abr", "abril"},
+		{"mai", "maio"}, {"jun", "junho"}, {"jul", "julho"}, {"ago", "agosto"},
+		{"set", "setembro"}, {"out", "outubro"}, {"nov", "novembro"}, {"dez", "dezembro"},
+	}},
+	// Hindi, both transliterated and in Devanagari
+	{"hi", [12][]string{
+		{"janvari", "janavari", "जनवरी"}, {"farvari", "pharvari", "फरवरी", "फ़रवरी"}, {"maarch", "मार्च"}, {"aprail", "अप्रैल"},
+		{"mai", "मई"}, {"joon", "जून"}, {"julai", "जुलाई"}, {"agast", "अगस्त"},
+		{"sitambar", "सितंबर", "सितम्बर"}, {"aktubar", "aktoobar", "अक्टूबर"}, {"navambar", "नवंबर", "नवम्बर"}, {"disambar", "दिसंबर", "दिसम्बर"},
+	}},
+}
+
+// monthByName maps every lower-cased name in localeMonthNames to its month, and
+// localeDateExpr matches "11 Dez 2025", "11. Dezember 2025", "11 de diciembre de 2025"
+// with the day, month name, and year as capture groups
+var monthByName, localeDateExpr = buildMonthTables()
+
+// localeDatePattern matches a whole date written with a month name in any supported locale
+var localeDatePattern = regexp.MustCompile(`^` + localeDateExpr + `$`)
+
+// buildMonthTables indexes localeMonthNames and builds the date expression matching them
+func buildMonthTables() (map[string]time.Month, string) {
+	byName := make(map[string]time.Month)
+	var names []string
+	for _, locale := range localeMonthNames {
+		for i, forms := range locale.months {
+			for _, name := range forms {
+				if _, ok := byName[name]; !ok {
+					byName[name] = time.Month(i + 1)
+					names = append(names, regexp.QuoteMeta(name))
+				}
+			}
+		}
+	}
+	// Longest first so "march" isn't cut short at "mar"
+	sort.SliceStable(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+	expr := `(?i)(\d{1,2})\.?[\s-]+(?:de\s+)?(` + strings.Join(names, "|") + `)\.?\s*[,-]?\s*(?:de\s+)?(\d{4})`
+	return byName, expr
+}
+
+// normalizeMonthName rewrites a date with a localized month name as "2 Jan 2006"
+// Other dates are returned unchanged.
+func normalizeMonthName(date string) string {
+	matches := localeDatePattern.FindStringSubmatch(date)
+	if matches == nil {
+		return date
+	}
+	month, ok := monthByName[strings.ToLower(matches[2])]
+	if !ok {
+		return date
+	}
+	return fmt.Sprintf("%s %s %s", matches[1], month.String()[:3], matches[3])
+}
+
+// transactionTimeLayouts are tried in order against normalized time strings
+var transactionTimeLayouts = []string{
+	"15:04:05",
+	"15:04",
+	"3:04:05PM",
+	"3:04PM",
+}
+
+// sentenceBoundaryPattern matches the end of a sentence or line
+var sentenceBoundaryPattern = regexp.MustCompile(`[.!?;]\s+|\n`)
+
+// transactionLocation returns the timezone for body timestamps from TZ
+func transactionLocation() *time.Location {
+	name := strings.TrimSpace(os.Getenv("TZ"))
+	if name == "" {
+		name = defaultTransactionTZ
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("Unknown TZ %q, using UTC: %v", name, err)
+		return time.UTC
+	}
+	return loc
+}
+
+// applyTimestamp combines the transaction's raw Date and Time into Timestamp
+// Leaves Timestamp zero when the date cannot be parsed.
+func applyTimestamp(txn *CreditCardTransaction) {
+	ts, ok := parseTransactionTimestamp(txn.Date, txn.Time, transactionLocation())
+	if !ok {
+		return
+	}
+	txn.Timestamp = ts
+	txn.TimestampSource = timestampSourceBody
+}
+
+// applyInternalDateFallback sets the timestamp of transactions whose body had
+// no parseable date from the message's internalDate (milliseconds since epoch)
+func applyInternalDateFallback(txns []*CreditCardTransaction, internalDate int64) {
+	if internalDate <= 0 {
+		return
+	}
+	for _, txn := range txns {
+		if txn.Timestamp.IsZero() {
+			txn.Timestamp = time.UnixMilli(internalDate).In(transactionLocation())
+			txn.TimestampSource = timestampSourceInternalDate
+		}
+	}
+}
+
+// parseTransactionTimestamp parses raw date and time strings in loc
+// A missing or unparseable time yields midnight on the parsed date.
+func parseTransactionTimestamp(rawDate, rawTime string, loc *time.Location) (time.Time, bool) {
+	date := normalizeMonthName(strings.Join(strings.Fields(strings.ReplaceAll(rawDate, ",", " ")), " "))
+	if date == "" {
+		return time.Time{}, false
+	}
+
+	var day time.Time
+	parsed := false
+	for _, layout := range transactionDateLayouts {
+		if d, err := time.ParseInLocation(layout, date, loc); err == nil {
+			day, parsed = d, true
+			break
+		}
+	}
+	if !parsed {
+		return time.Time{}, false
+	}
+
+	clock := strings.ToUpper(strings.ReplaceAll(rawTime, " ", ""))
+	for _, layout := range transactionTimeLayouts {
+		if t, err := time.Parse(layout, clock); err == nil {
+			return time.Date(day.Year(), day.Month(), day.Day(), t.Hour(), t.Minute(), t.Second(), 0, loc), true
+		}
+	}
+	return day, true
+}
+
+// sentenceAround returns the sentence of text containing the span [start, end)
+func sentenceAround(text string, start, end int) string {
+	sentenceStart := 0
+	for _, loc := range sentenceBoundaryPattern.FindAllStringIndex(text[:start], -1) {
+		sentenceStart = loc[1]
+	}
+	sentenceEnd := len(text)
+	if loc := sentenceBoundaryPattern.FindStringIndex(text[end:]); loc != nil {
+		sentenceEnd = end + loc[0]
+	}
+	return text[sentenceStart:sentenceEnd]
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TransactionRecord is a parsed transaction stored for a user, with the message it came from
+type TransactionRecord struct {
+	UserEmail string `json:"user_email"`
+	MessageID string `json:"message_id"` // Gmail message ID, for linking back to the email
+	Subject   string `json:"subject"`
+	CreditCardTransaction
+}
+
+// TransactionFilter narrows a TransactionStore query
+type TransactionFilter struct {
+	From                time.Time // Inclusive lower bound on Timestamp, zero for none
+	To                  time.Time // Exclusive upper bound on Timestamp, zero for none
+	Merchant            string    // Case-insensitive substring of the merchant
+	MinAmountMinorUnits int64
+	Channel             string
+	Oldest              bool // Order oldest first instead of newest first
+	Limit               int  // Zero returns every match
+	Offset              int
+}
+
+// matches reports whether a record passes the filter, ignoring paging
+func (f TransactionFilter) matches(record TransactionRecord) bool {
+	if !f.From.IsZero() && record.Timestamp.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !record.Timestamp.Before(f.To) {
+		return false
+	}
+	if f.Merchant != "" && !strings.Contains(strings.ToLower(record.Merchant), strings.ToLower(f.Merchant)) {
+		return false
+	}
+	if record.AmountMinorUnits < f.MinAmountMinorUnits {
+		return false
+	}
+	return f.Channel == "" || strings.EqualFold(record.Channel, f.Channel)
+}
+
+// TransactionStore persists parsed transactions and spend alert rules per user
+// Transactions are deduplicated per user by CreditCardTransaction.DedupKey.
+type TransactionStore interface {
+	// Add stores a record, reporting false when it duplicates one already stored
+	Add(record TransactionRecord) (bool, error)
+	// Query returns a user's records matching filter, newest first unless
+	// filter.Oldest is set, and the number of matches before Limit and Offset are applied
+	Query(userEmail string, filter TransactionFilter) ([]TransactionRecord, int, error)
+	// AlertRules returns a user's alert rules, empty when none are set
+	AlertRules(userEmail string) ([]alertRule, error)
+	// SetAlertRules replaces a user's alert rules
+	SetAlertRules(userEmail string, rules []alertRule) error
+}
+
+// memoryTransactionStore is a TransactionStore kept in process memory
+type memoryTransactionStore struct {
+	sync.RWMutex
+	records map[string][]TransactionRecord
+	keys    map[string]map[string]bool
+	alerts  map[string][]alertRule
+}
+
+// newMemoryTransactionStore creates an empty in-memory TransactionStore
+func newMemoryTransactionStore() *memoryTransactionStore {
+	return &memoryTransactionStore{
+		records: make(map[string][]TransactionRecord),
+		keys:    make(map[string]map[string]bool),
+		alerts:  make(map[string][]alertRule),
+	}
+}
+
+// Add implements TransactionStore
+func (m *memoryTransactionStore) Add(record TransactionRecord) (bool, error) {
+	key := record.DedupKey()
+
+	m.Lock()
+	defer m.Unlock()
+	if m.keys[record.UserEmail] == nil {
+		m.keys[record.UserEmail] = make(map[string]bool)
+	}
+	if m.keys[record.UserEmail][key] {
+		return false, nil
+	}
+	m.keys[record.UserEmail][key] = true
+	m.records[record.UserEmail] = append(m.records[record.UserEmail], record)
+	return true, nil
+}
+
+// Query implements TransactionStore
+func (m *memoryTransactionStore) Query(userEmail string, filter TransactionFilter) ([]TransactionRecord, int, error) {
+	m.RLock()
+	var matched []TransactionRecord
+	for _, record := range m.records[userEmail] {
+		if filter.matches(record) {
+			matched = append(matched, record)
+		}
+	}
+	m.RUnlock()
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		if filter.Oldest {
+			return matched[i].Timestamp.Before(matched[j].Timestamp)
+		}
+		return matched[i].Timestamp.After(matched[j].Timestamp)
+	})
+	total := len(matched)
+	if filter.Offset >= total {
+		return []TransactionRecord{}, total, nil
+	}
+	matched = matched[filter.Offset:]
+	if filter.Limit > 0 && len(matched) > filter.Limit {
+		matched = matched[:filter.Limit]
+	}
+	return matched, total, nil
+}
+
+// AlertRules implements TransactionStore
+func (m *memoryTransactionStore) AlertRules(userEmail string) ([]alertRule, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return append([]alertRule{}, m.alerts[userEmail]...), nil
+}
+
+// SetAlertRules implements TransactionStore
+func (m *memoryTransactionStore) SetAlertRules(userEmail string, rules []alertRule) error {
+	m.Lock()
+	defer m.Unlock()
+	m.alerts[userEmail] = append([]alertRule{}, rules...)
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// transactionStoreBackends opens each TransactionStore implementation for a test;
+// SQLite is skipped unless the driver is compiled in (go test -tags sqlite)
+var transactionStoreBackends = []struct {
+	name string
+	open func(t *testing.T) TransactionStore
+}{
+	{"memory", func(t *testing.T) TransactionStore { return newMemoryTransactionStore() }},
+	{"sqlite", func(t *testing.T) TransactionStore {
+		if !containsString(sql.Drivers(), sqliteDriverName) {
+			t.Skip("SQLite driver not compiled in (build with -tags sqlite)")
+		}
+		store, err := openSQLiteTransactionStore(filepath.Join(t.TempDir(), "transactions.db"))
+		if err != nil {
+			t.Fatalf("open SQLite store: %v", err)
+		}
+		t.Cleanup(func() { store.db.Close() })
+		return store
+	}},
+}
+
+// storeRecord builds a record for userEmail with the given amount, merchant and time
+func storeRecord(userEmail, ref string, minor int64, merchant, channel string, ts time.Time) TransactionRecord {
+	return TransactionRecord{
+		UserEmail: userEmail,
+		MessageID: "msg-" + ref,
+		Subject:   "Transaction alert",
+		CreditCardTransaction: CreditCardTransaction{
+			ReferenceID:      ref,
+			AmountMinorUnits: minor,
+			Currency:         "INR",
+			Direction:        directionDebit,
+			Merchant:         merchant,
+			Channel:          channel,
+			Timestamp:        ts,
+		},
+	}
+}
+
+func TestTransactionStores(t *testing.T) {
+	day := time.Date(2025, 11, 10, 12, 0, 0, 0, time.UTC)
+	records := []TransactionRecord{
+		storeRecord("a@example.com", "r1", 10000, "AMAZON", channelCreditCard, day),
+		storeRecord("a@example.com", "r2", 25000, "Swiggy", channelUPI, day.Add(24*time.Hour)),
+		storeRecord("a@example.com", "r3", 5000, "Amazon Pay", channelCreditCard, day.Add(48*time.Hour)),
+		storeRecord("b@example.com", "r4", 99900, "AMAZON", channelCreditCard, day),
+	}
+
+	for _, backend := range transactionStoreBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			store := backend.open(t)
+			for _, record := range records {
+				if isNew, err := store.Add(record); err != nil || !isNew {
+					t.Fatalf("Add(%s) = %t, %v; want new", record.ReferenceID, isNew, err)
+				}
+			}
+			if isNew, err := store.Add(records[0]); err != nil || isNew {
+				t.Fatalf("Add(duplicate) = %t, %v; want not new", isNew, err)
+			}
+
+			tests := []struct {
+				name   string
+				filter TransactionFilter
+				total  int
+				refs   []string
+			}{
+				{"all, newest first", TransactionFilter{}, 3, []string{"r3", "r2", "r1"}},
+				{"oldest first", TransactionFilter{Oldest: true}, 3, []string{"r1", "r2", "r3"}},
+				{"oldest first, paged", TransactionFilter{Oldest: true, Limit: 2, Offset: 1}, 3, []string{"r2", "r3"}},
+				{"merchant ignores case", TransactionFilter{Merchant: "amazon"}, 2, []string{"r3", "r1"}},
+				{"min amount", TransactionFilter{MinAmountMinorUnits: 10000}, 2, []string{"r2", "r1"}},
+				{"channel", TransactionFilter{Channel: "UPI"}, 1, []string{"r2"}},
+				{"from inclusive, to exclusive", TransactionFilter{From: day, To: day.Add(48 * time.Hour)}, 2, []string{"r2", "r1"}},
+				{"paged", TransactionFilter{Limit: 1, Offset: 1}, 3, []string{"r2"}},
+				{"offset past the end", TransactionFilter{Offset: 5}, 3, nil},
+			}
+			for _, tt := range tests {
+				got, total, err := store.Query("a@example.com", tt.filter)
+				if err != nil {
+					t.Fatalf("%s: Query: %v", tt.name, err)
+				}
+				var refs []string
+				for _, record := range got {
+					refs = append(refs, record.ReferenceID)
+				}
+				if total != tt.total || !equalStrings(refs, tt.refs) {
+					t.Errorf("%s: got %v (total %d), want %v (total %d)", tt.name, refs, total, tt.refs, tt.total)
+				}
+			}
+		})
+	}
+}
+
+func TestTransactionStoreAlertRules(t *testing.T) {
+	rules := []alertRule{
+		{ID: "1", Type: alertSingleTransaction, MaxAmount: 50000},
+		{ID: "2", Type: alertMonthlyCategory, Category: "Shopping", MaxAmount: 100000},
+	}
+
+	for _, backend := range transactionStoreBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			store := backend.open(t)
+			if got, err := store.AlertRules("a@example.com"); err != nil || got == nil || len(got) != 0 {
+				t.Fatalf("AlertRules before set = %v, %v; want empty", got, err)
+			}
+			if err := store.SetAlertRules("a@example.com", rules); err != nil {
+				t.Fatalf("SetAlertRules: %v", err)
+			}
+			got, err := store.AlertRules("a@example.com")
+			if err != nil || len(got) != 2 || got[1] != rules[1] {
+				t.Errorf("AlertRules = %v, %v; want %v", got, err, rules)
+			}
+			if other, err := store.AlertRules("b@example.com"); err != nil || len(other) != 0 {
+				t.Errorf("AlertRules(other user) = %v, %v; want empty", other, err)
+			}
+
+			if err := store.SetAlertRules("a@example.com", rules[:1]); err != nil {
+				t.Fatalf("SetAlertRules(replace): %v", err)
+			}
+			if got, err := store.AlertRules("a@example.com"); err != nil || len(got) != 1 || got[0] != rules[0] {
+				t.Errorf("AlertRules after replace = %v, %v; want %v", got, err, rules[:1])
+			}
+		})
+	}
+}
+
+// equalStrings reports whether two string slices hold the same values in order
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// recordTransactions stores the transactions parsed from a message, logging
+// duplicates; new ones are checked against the user's alert rules, fanned out
+// to the notification sinks and appended to the user's spreadsheet, unless
+// they are low-value and MIN_TRANSACTION_SKIP=true
+func (s *Server) recordTransactions(logger *log.Logger, emailAddress, msgID, subject string, txns []*CreditCardTransaction) {
+	var added []*CreditCardTransaction
+	for _, txn := range txns {
+		record := TransactionRecord{
+			UserEmail:             emailAddress,
+			MessageID:             msgID,
+			Subject:               subject,
+			CreditCardTransaction: *txn,
+		}
+		isNew, err := s.transactions.Add(record)
+		switch {
+		case err != nil:
+			logger.Printf("Unable to store transaction from message %s: %v", msgID, err)
+		case !isNew:
+			logger.Printf("Transaction %s from message %s already recorded", txn.DedupKey(), msgID)
+		case txn.LowValue && skipLowValueTransactions():
+			logger.Printf("Transaction %s from message %s is below MIN_TRANSACTION_AMOUNT, not notifying", txn.DedupKey(), msgID)
+		default:
+			s.evaluateAlerts(logger, record)
+			s.notifiers.transaction(logger, emailAddress, msgID, subject, txn)
+			added = append(added, txn)
+		}
+	}
+	s.appendToSheet(logger, emailAddress, msgID, added)
+}
+
+// userSubscriptions detects recurring charges in a user's stored transactions
+func (s *Server) userSubscriptions(userEmail string) ([]subscription, error) {
+	records, _, err := s.transactions.Query(userEmail, TransactionFilter{})
+	if err != nil {
+		return nil, err
+	}
+	txns := make([]*CreditCardTransaction, len(records))
+	for i := range records {
+		txns[i] = &records[i].CreditCardTransaction
+	}
+	return detectSubscriptions(txns), nil
+}
+
+// parseTransactionFilter reads the /transactions filter parameters, without paging
+// from and to are dates ("2025-11-01") in TZ, both inclusive, or RFC 3339 times;
+// minAmount is in major units.
+func parseTransactionFilter(r *http.Request) (TransactionFilter, error) {
+	filter := TransactionFilter{
+		Merchant: strings.TrimSpace(r.FormValue("merchant")),
+		Channel:  strings.TrimSpace(r.FormValue("channel")),
+	}
+
+	loc := transactionLocation()
+	if value := strings.TrimSpace(r.FormValue("from")); value != "" {
+		from, _, err := parseFilterTime(value, loc)
+		if err != nil {
+			return filter, fmt.Errorf("invalid from: %v", err)
+		}
+		filter.From = from
+	}
+	if value := strings.TrimSpace(r.FormValue("to")); value != "" {
+		to, dateOnly, err := parseFilterTime(value, loc)
+		if err != nil {
+			return filter, fmt.Errorf("invalid to: %v", err)
+		}
+		if dateOnly {
+			to = to.AddDate(0, 0, 1)
+		}
+		filter.To = to
+	}
+
+	if value := strings.TrimSpace(r.FormValue("minAmount")); value != "" {
+		minor, err := parseAmountMinorUnitsIn(value, pointDecimalFormat)
+		if err != nil || minor < 0 {
+			return filter, fmt.Errorf("invalid minAmount %q", value)
+		}
+		filter.MinAmountMinorUnits = minor
+	}
+
+	return filter, nil
+}
+
+// parseTransactionPaging reads the limit and offset parameters into filter
+func parseTransactionPaging(r *http.Request, filter *TransactionFilter) error {
+	limit, err := resultLimit(r)
+	if err != nil {
+		return err
+	}
+	filter.Limit = limit
+	if value := strings.TrimSpace(r.FormValue("offset")); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return fmt.Errorf("offset must be a non-negative integer")
+		}
+		filter.Offset = offset
+	}
+	return nil
+}
+
+// parseFilterTime parses a date or RFC 3339 time, reporting whether it was a date
+func parseFilterTime(value string, loc *time.Location) (time.Time, bool, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, loc); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("use YYYY-MM-DD or RFC 3339")
+	}
+	return t, false, nil
+}
+
+// transactionsHandler lists a user's stored transactions, newest first
+// Filters: from, to, merchant, minAmount, channel; paging: limit, offset.
+func (s *Server) transactionsHandler(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r.Context())
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form body", http.StatusBadRequest)
+		return
+	}
+
+	userEmail, ok := s.requestUser(w, r, r.FormValue("userEmail"))
+	if !ok {
+		return
+	}
+	if !s.isAuthenticated(userEmail) {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	filter, err := parseTransactionFilter(r)
+	if err == nil {
+		err = parseTransactionPaging(r, &filter)
+	}
+	if err != nil {
+		http.Error(w, "Invalid parameter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	records, total, err := s.transactions.Query(userEmail, filter)
+	if err != nil {
+		logger.Printf("Unable to query transactions for %s: %v", userEmail, err)
+		http.Error(w, "Failed to query transactions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user_email":   userEmail,
+		"total":        total,
+		"limit":        filter.Limit,
+		"offset":       filter.Offset,
+		"transactions": records,
+	})
+}
+
+// subscriptionsHandler lists the recurring charges detected in a user's stored transactions
+func (s *Server) subscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r.Context())
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form body", http.StatusBadRequest)
+		return
+	}
+
+	userEmail, ok := s.requestUser(w, r, r.FormValue("userEmail"))
+	if !ok {
+		return
+	}
+	if !s.isAuthenticated(userEmail) {
+		http.Error(w, "User not authenticated", http.StatusUnauthorized)
+		return
+	}
+
+	subs, err := s.userSubscriptions(userEmail)
+	if err != nil {
+		logger.Printf("Unable to query transactions for %s: %v", userEmail, err)
+		http.Error(w, "Failed to query transactions", http.StatusInternalServerError)
+		return
+	}
+	if subs == nil {
+		subs = []subscription{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"user_email":    userEmail,
+		"subscriptions": subs,
+	})
+}
+
+// isAuthenticated reports whether a token is stored for userEmail with any provider
+func (s *Server) isAuthenticated(userEmail string) bool {
+	return len(s.userProviders(userEmail)) > 0
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"testing"
+	"time"
+)
+
+// fakeTransactionSink is a Notifier that records the transactions it receives
+type fakeTransactionSink struct {
+	txns chan *CreditCardTransaction
+}
+
+func (f fakeTransactionSink) Name() string { return "fake" }
+func (f fakeTransactionSink) NotifyTransaction(ctx context.Context, userEmail string, txn *CreditCardTransaction) error {
+	f.txns <- txn
+	return nil
+}
+func (f fakeTransactionSink) NotifyBill(ctx context.Context, userEmail string, bill *BillReminder) error {
+	return nil
+}
+func (f fakeTransactionSink) NotifyAlert(ctx context.Context, userEmail string, alert *spendAlert) error {
+	return nil
+}
+
+func TestRecordDigestEqualAmounts(t *testing.T) {
+	s := newTestServer(t)
+	sink := fakeTransactionSink{txns: make(chan *CreditCardTransaction, 4)}
+	s.notifiers.notifiers = []Notifier{sink}
+
+	// Two identical lines with no reference ID or time of their own
+	const body = "Transactions on your credit card ending 1234 on 11 Nov 2025:\nRs.450.00 spent at SWIGGY\nRs.450.00 spent at SWIGGY"
+	txns := parseTransactionsFromSender("alerts@examplebank.com", "Transaction alert", body)
+	if len(txns) != 2 {
+		t.Fatalf("parsed %d transactions, want 2", len(txns))
+	}
+
+	var buf bytes.Buffer
+	s.recordTransactions(log.New(&buf, "", 0), "user@example.com", "m1", "Transaction alert", txns)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-sink.txns:
+		case <-time.After(time.Second):
+			t.Fatalf("notified %d transactions, want 2:\n%s", i, buf.String())
+		}
+	}
+
+	// A re-delivered digest is still deduplicated
+	again := parseTransactionsFromSender("alerts@examplebank.com", "Transaction alert", body)
+	s.recordTransactions(log.New(&buf, "", 0), "user@example.com", "m1", "Transaction alert", again)
+	records, _, err := s.transactions.Query("user@example.com", TransactionFilter{})
+	if err != nil || len(records) != 2 {
+		t.Fatalf("stored %d records (%v), want 2:\n%s", len(records), err, buf.String())
+	}
+	select {
+	case txn := <-sink.txns:
+		t.Errorf("re-delivered transaction %s notified again", txn.DedupKey())
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// defaultWatchExpiryWarning is how far ahead of expiry watches are flagged when
+// WATCH_EXPIRY_WARNING is unset
+const defaultWatchExpiryWarning = 24 * time.Hour
+
+// watchCheckInterval is how often monitorWatchExpirations looks for expiring watches
+const watchCheckInterval = 15 * time.Minute
+
+// webhookEventWatchExpiring is sent when WATCH_EXPIRY_WEBHOOK=true and a watch nears expiry
+const webhookEventWatchExpiring = "watch.expiring"
+
+// watchExpiryWarning returns the warning window from WATCH_EXPIRY_WARNING (a Go duration)
+func watchExpiryWarning() time.Duration {
+	value := strings.TrimSpace(os.Getenv("WATCH_EXPIRY_WARNING"))
+	if value == "" {
+		return defaultWatchExpiryWarning
+	}
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return d
+	}
+	log.Printf("Invalid WATCH_EXPIRY_WARNING %q, using %v", value, defaultWatchExpiryWarning)
+	return defaultWatchExpiryWarning
+}
+
+// autoWatchEnabled reports whether AUTO_WATCH=true, which starts push
+// notifications as soon as a user completes OAuth
+func autoWatchEnabled() bool {
+	return strings.EqualFold(os.Getenv("AUTO_WATCH"), "true")
+}
+
+// autoWatch starts the watch for a user who just authenticated, returning the
+// result for the callback's response
+// Failures are logged and reported in the result so they don't fail the sign-in.
+func (s *Server) autoWatch(ctx context.Context, logger *log.Logger, provider MailProvider, userEmail string, token *oauth2.Token) map[string]interface{} {
+	expiration, err := provider.Watch(ctx, userEmail, token)
+	if err != nil {
+		logger.Printf("Unable to start watch for %s after sign-in: %v", userEmail, err)
+		return map[string]interface{}{"status": "watch_failed", "error": err.Error()}
+	}
+
+	result := map[string]interface{}{"status": "watch_started"}
+	if !expiration.IsZero() {
+		result["expiration"] = expiration.UnixMilli()
+	}
+	if provider.Name() == providerGmail {
+		s.historyStore.RLock()
+		result["history_id"] = s.historyStore.history[accountKey{providerGmail, userEmail}]
+		s.historyStore.RUnlock()
+	}
+	logger.Printf("Watch started for user %s (%s) after sign-in: expiration=%v", userEmail, provider.Name(), expiration)
+	return result
+}
+
+// monitorWatchExpirations checks for expiring watches every watchCheckInterval until stop is closed
+func (s *Server) monitorWatchExpirations(stop <-chan struct{}) {
+	ticker := time.NewTicker(watchCheckInterval)
+	defer ticker.Stop()
+	for {
+		s.checkWatchExpirations(time.Now())
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// checkWatchExpirations warns about each watch expiring within watchExpiryWarning of now,
+// once per expiration, and returns the mailboxes warned about
+func (s *Server) checkWatchExpirations(now time.Time) []accountKey {
+	window := watchExpiryWarning()
+	notify := strings.EqualFold(os.Getenv("WATCH_EXPIRY_WEBHOOK"), "true")
+
+	var warned []accountKey
+	s.watchStore.Lock()
+	for key, expiration := range s.watchStore.expirations {
+		if expiration.Sub(now) > window || s.watchStore.warned[key].Equal(expiration) {
+			continue
+		}
+		s.watchStore.warned[key] = expiration
+		warned = append(warned, key)
+	}
+	expirations := make(map[accountKey]time.Time, len(warned))
+	for _, key := range warned {
+		expirations[key] = s.watchStore.expirations[key]
+	}
+	s.watchStore.Unlock()
+
+	for _, key := range warned {
+		expiration := expirations[key]
+		if expiration.After(now) {
+			log.Printf("WARNING: Watch for %s (%s) expires in %v (at %s)", key.Email, key.Provider, expiration.Sub(now).Round(time.Minute), expiration.Format(time.RFC3339))
+		} else {
+			log.Printf("WARNING: Watch for %s (%s) expired at %s", key.Email, key.Provider, expiration.Format(time.RFC3339))
+		}
+		if notify {
+			s.sendWebhook(log.Default(), webhookPayload{Event: webhookEventWatchExpiring, UserEmail: key.Email, WatchExpiration: &expiration})
+		}
+	}
+	return warned
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckWatchExpirations(t *testing.T) {
+	t.Setenv("WATCH_EXPIRY_WARNING", "24h")
+	t.Setenv("WATCH_EXPIRY_WEBHOOK", "")
+	logs := captureLog(t)
+	s := newTestServer(t)
+	now := time.Date(2025, 11, 11, 12, 0, 0, 0, time.UTC)
+	s.watchStore.expirations[accountKey{providerGmail, "near@example.com"}] = now.Add(2 * time.Hour)
+	s.watchStore.expirations[accountKey{providerGmail, "far@example.com"}] = now.Add(5 * 24 * time.Hour)
+	s.watchStore.expirations[accountKey{providerGmail, "expired@example.com"}] = now.Add(-time.Hour)
+
+	check := func(at time.Time) []string {
+		var warned []string
+		for _, key := range s.checkWatchExpirations(at) {
+			warned = append(warned, key.Email)
+		}
+		sort.Strings(warned)
+		return warned
+	}
+
+	if got := check(now); !equalStrings(got, []string{"expired@example.com", "near@example.com"}) {
+		t.Errorf("warned %v, want the expired and near-expiry watches", got)
+	}
+	out := logs.String()
+	if !strings.Contains(out, "WARNING: Watch for near@example.com (gmail) expires in 2h0m0s") || !strings.Contains(out, "WARNING: Watch for expired@example.com (gmail) expired at") {
+		t.Errorf("warnings missing from log:\n%s", out)
+	}
+	if strings.Contains(out, "far@example.com") {
+		t.Errorf("warned about a watch days from expiry:\n%s", out)
+	}
+
+	// Each expiration is warned about once; later checks only pick up new ones
+	if got := check(now.Add(time.Minute)); len(got) != 0 {
+		t.Errorf("second check warned %v again", got)
+	}
+	if got := check(now.Add(4*24*time.Hour + time.Hour)); !equalStrings(got, []string{"far@example.com"}) {
+		t.Errorf("warned %v four days later, want far@example.com", got)
+	}
+
+	// A renewed watch that nears its new expiration is warned about again
+	s.watchStore.Lock()
+	s.watchStore.expirations[accountKey{providerGmail, "near@example.com"}] = now.Add(7 * 24 * time.Hour)
+	s.watchStore.Unlock()
+	if got := check(now.Add(6*24*time.Hour + time.Hour)); !equalStrings(got, []string{"near@example.com"}) {
+		t.Errorf("warned %v after renewal, want near@example.com", got)
+	}
+}
+
+func TestWatchExpiryWebhook(t *testing.T) {
+	t.Setenv("WATCH_EXPIRY_WARNING", "1h")
+	t.Setenv("WATCH_EXPIRY_WEBHOOK", "true")
+	captureLog(t)
+	payloads := make(chan webhookPayload, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload webhookPayload
+		json.NewDecoder(r.Body).Decode(&payload)
+		payloads <- payload
+	}))
+	defer server.Close()
+	t.Setenv("WEBHOOK_URL", server.URL)
+
+	s := newTestServer(t)
+	now := time.Date(2025, 11, 11, 12, 0, 0, 0, time.UTC)
+	expiration := now.Add(30 * time.Minute)
+	s.watchStore.expirations[accountKey{providerGmail, "user@example.com"}] = expiration
+	s.checkWatchExpirations(now)
+
+	select {
+	case payload := <-payloads:
+		if payload.Event != webhookEventWatchExpiring || payload.UserEmail != "user@example.com" || payload.WatchExpiration == nil || !payload.WatchExpiration.Equal(expiration) {
+			t.Errorf("payload = %+v", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no watch.expiring webhook sent")
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event types sent to WEBHOOK_URL
+const (
+	webhookEventTransaction  = "transaction.created"
+	webhookEventBillReminder = "bill_reminder.created"
+	webhookEventAlert        = "alert.triggered"
+	webhookEventDigest       = "digest.daily"
+	webhookEventTest         = "webhook.test"
+)
+
+// defaultWebhookMaxAttempts is used when WEBHOOK_MAX_ATTEMPTS is unset
+const defaultWebhookMaxAttempts = 5
+
+// webhookBaseBackoff is the wait before the first retry; each retry doubles it
+var webhookBaseBackoff = time.Second
+
+// webhookClient sends webhook deliveries; the timeout bounds each attempt
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// webhookPayload is the JSON body POSTed to WEBHOOK_URL
+type webhookPayload struct {
+	Event           string                 `json:"event"`
+	UserEmail       string                 `json:"user_email"`
+	MessageID       string                 `json:"message_id"`
+	Transaction     *CreditCardTransaction `json:"transaction,omitempty"`
+	BillReminder    *BillReminder          `json:"bill_reminder,omitempty"`
+	Alert           *spendAlert            `json:"alert,omitempty"`
+	Digest          *dailyDigest           `json:"digest,omitempty"`
+	WatchExpiration *time.Time             `json:"watch_expiration,omitempty"`
+	SentAt          time.Time              `json:"sent_at"`
+}
+
+// webhookURL returns the delivery target from WEBHOOK_URL; empty disables webhooks
+func webhookURL() string {
+	return strings.TrimSpace(os.Getenv("WEBHOOK_URL"))
+}
+
+// webhookMaxAttempts returns the delivery attempts per event, read from WEBHOOK_MAX_ATTEMPTS
+func webhookMaxAttempts() int {
+	value := strings.TrimSpace(os.Getenv("WEBHOOK_MAX_ATTEMPTS"))
+	if value == "" {
+		return defaultWebhookMaxAttempts
+	}
+	if n, err := strconv.Atoi(value); err == nil && n > 0 {
+		return n
+	}
+	log.Printf("Invalid WEBHOOK_MAX_ATTEMPTS %q, using %d", value, defaultWebhookMaxAttempts)
+	return defaultWebhookMaxAttempts
+}
+
+// webhookSignature returns the X-Signature value for body, keyed with WEBHOOK_SECRET
+// Returns empty string when no secret is configured.
+func webhookSignature(body []byte) string {
+	secret := os.Getenv("WEBHOOK_SECRET")
+	if secret == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// The webhook sink is registered when WEBHOOK_URL is set
+func init() {
+	registerNotifier("webhook", func(s *Server) Notifier {
+		if webhookURL() == "" {
+			return nil
+		}
+		return webhookNotifier{s}
+	})
+}
+
+// webhookNotifier sends transaction, bill reminder and alert events to WEBHOOK_URL
+type webhookNotifier struct {
+	s *Server
+}
+
+// Name identifies the sink in logs and metrics
+func (n webhookNotifier) Name() string { return "webhook" }
+
+// NotifyTransaction delivers a transaction event
+func (n webhookNotifier) NotifyTransaction(ctx context.Context, userEmail string, txn *CreditCardTransaction) error {
+	return n.s.deliverPayload(ctx, webhookPayload{Event: webhookEventTransaction, UserEmail: userEmail, MessageID: notificationFrom(ctx).MessageID, Transaction: txn})
+}
+
+// NotifyBill delivers a bill reminder event
+func (n webhookNotifier) NotifyBill(ctx context.Context, userEmail string, bill *BillReminder) error {
+	return n.s.deliverPayload(ctx, webhookPayload{Event: webhookEventBillReminder, UserEmail: userEmail, MessageID: notificationFrom(ctx).MessageID, BillReminder: bill})
+}
+
+// NotifyAlert delivers a triggered spend alert event
+func (n webhookNotifier) NotifyAlert(ctx context.Context, userEmail string, alert *spendAlert) error {
+	return n.s.deliverPayload(ctx, webhookPayload{Event: webhookEventAlert, UserEmail: userEmail, MessageID: notificationFrom(ctx).MessageID, Alert: alert})
+}
+
+// NotifyDigest delivers the daily digest event
+func (n webhookNotifier) NotifyDigest(ctx context.Context, userEmail string, digest *dailyDigest) error {
+	return n.s.deliverPayload(ctx, webhookPayload{Event: webhookEventDigest, UserEmail: userEmail, Digest: digest})
+}
+
+// sendWebhook delivers payload in the background when WEBHOOK_URL is set
+func (s *Server) sendWebhook(logger *log.Logger, payload webhookPayload) {
+	go func() {
+		if err := s.deliverPayload(context.Background(), payload); err != nil {
+			logger.Printf("Webhook %s for message %s failed: %v", payload.Event, payload.MessageID, err)
+		}
+	}()
+}
+
+// deliverPayload signs and delivers payload to WEBHOOK_URL, if set
+// Deliveries that fail after every retry go to the dead-letter store.
+func (s *Server) deliverPayload(ctx context.Context, payload webhookPayload) error {
+	target := webhookURL()
+	if target == "" {
+		return nil
+	}
+	payload.SentAt = time.Now()
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("unable to encode %s webhook: %v", payload.Event, err)
+	}
+
+	attempts, err := deliverWebhook(ctx, target, payload.Event, body)
+	if err != nil {
+		s.addDeadLetter(deadLetter{Target: target, Event: payload.Event, Payload: body, Attempts: attempts, LastError: err.Error()})
+		return fmt.Errorf("failed after %d attempts: %v", attempts, err)
+	}
+	debugf("Delivered %s webhook for message %s", payload.Event, payload.MessageID)
+	return nil
+}
+
+// deliverWebhook POSTs a signed body to target, retrying 5xx responses and
+// network errors with exponential backoff. Returns the attempts made.
+func deliverWebhook(ctx context.Context, target, event string, body []byte) (int, error) {
+	maxAttempts := webhookMaxAttempts()
+	backoff := webhookBaseBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		var retryable bool
+		retryable, err = postWebhook(ctx, target, event, body)
+		if err == nil {
+			return attempt, nil
+		}
+		if !retryable || attempt >= maxAttempts {
+			return attempt, err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return attempt, fmt.Errorf("%v (retry cancelled: %v)", err, ctx.Err())
+		}
+		backoff *= 2
+	}
+}
+
+// postWebhook makes one delivery attempt, reporting whether a failure is worth retrying
+func postWebhook(ctx context.Context, target, event string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("invalid webhook request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", event)
+	if signature := webhookSignature(body); signature != "" {
+		req.Header.Set("X-Signature", signature)
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("webhook request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	switch {
+	case resp.StatusCode >= 500:
+		return true, &webhookStatusError{resp.StatusCode, resp.Status}
+	case resp.StatusCode >= 300:
+		return false, &webhookStatusError{resp.StatusCode, resp.Status}
+	}
+	return false, nil
+}
+
+// webhookStatusError is a delivery the target answered with a non-2xx status
+type webhookStatusError struct {
+	code   int
+	status string // e.g. "410 Gone"
+}
+
+func (e *webhookStatusError) Error() string {
+	return "webhook returned " + e.status
+}
+
+// redeliverWebhook retries a dead-lettered webhook from /admin/deadletter,
+// returning the attempts deliverWebhook made
+func (s *Server) redeliverWebhook(ctx context.Context, entry deadLetter) (int, error) {
+	return deliverWebhook(ctx, entry.Target, entry.Event, entry.Payload)
+}
+
+// webhookTestHandler sends a sample transaction to WEBHOOK_URL and reports the result
+func (s *Server) webhookTestHandler(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r.Context())
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	target := webhookURL()
+	if target == "" {
+		http.Error(w, "WEBHOOK_URL is not set", http.StatusServiceUnavailable)
+		return
+	}
+
+	body, err := json.Marshal(webhookPayload{
+		Event:       webhookEventTest,
+		UserEmail:   "user@example.com",
+		MessageID:   "sample",
+		Transaction: sampleTransaction(),
+		SentAt:      time.Now(),
+	})
+	if err != nil {
+		http.Error(w, "Failed to encode sample payload", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), redeliveryTimeout)
+	defer cancel()
+	attempts, err := deliverWebhook(ctx, target, webhookEventTest, body)
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		logger.Printf("Test webhook failed after %d attempts: %v", attempts, err)
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "failed", "attempts": attempts, "error": err.Error()})
+		return
+	}
+	logger.Printf("Test webhook delivered after %d attempts", attempts)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "delivered", "attempts": attempts})
+}
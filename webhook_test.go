@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// webhookTarget starts a webhook receiver answering with *status, counting requests
+func webhookTarget(t *testing.T, status *int32, hits *int32) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(hits, 1)
+		w.WriteHeader(int(atomic.LoadInt32(status)))
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("WEBHOOK_URL", server.URL)
+
+	backoff := webhookBaseBackoff
+	webhookBaseBackoff = time.Millisecond
+	t.Cleanup(func() { webhookBaseBackoff = backoff })
+}
+
+// deadLetterRequest calls /admin/deadletter with the admin token
+func deadLetterRequest(s *Server, method string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/admin/deadletter", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Admin-Token", "admin-secret")
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestWebhookDeadLetter(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+	t.Setenv("WEBHOOK_MAX_ATTEMPTS", "3")
+	status, hits := int32(http.StatusInternalServerError), int32(0)
+	webhookTarget(t, &status, &hits)
+	s := newTestServer(t)
+
+	txn := parseCreditCardTransaction("Transaction alert", "Rs.424.00 spent on your credit card XX1234 at AMAZON on 11 Nov, 2025")
+	err := s.deliverPayload(context.Background(), webhookPayload{Event: webhookEventTransaction, UserEmail: "user@example.com", MessageID: "m1", Transaction: txn})
+	if err == nil {
+		t.Fatal("delivery to an always-failing webhook succeeded")
+	}
+	if got := atomic.LoadInt32(&hits); got != 3 {
+		t.Errorf("webhook received %d attempts, want 3", got)
+	}
+
+	rec := deadLetterRequest(s, http.MethodGet, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET status = %d: %s", rec.Code, rec.Body)
+	}
+	var list struct {
+		Count       int          `json:"count"`
+		DeadLetters []deadLetter `json:"dead_letters"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil || list.Count != 1 {
+		t.Fatalf("dead letters = %+v (%v), want 1", list, err)
+	}
+	entry := list.DeadLetters[0]
+	if entry.Event != webhookEventTransaction || entry.Attempts != 3 || entry.LastError != "webhook returned 500 Internal Server Error" {
+		t.Errorf("dead letter = %+v", entry)
+	}
+	var payload webhookPayload
+	if err := json.Unmarshal(entry.Payload, &payload); err != nil || payload.MessageID != "m1" || payload.Transaction == nil || payload.Transaction.AmountMinorUnits != 42400 {
+		t.Errorf("dead-lettered payload = %s (%v)", entry.Payload, err)
+	}
+
+	// A requeue that fails again goes back on the list under the same ID,
+	// counting every attempt the redelivery made
+	requeue := url.Values{"id": {strconv.FormatInt(entry.ID, 10)}, "action": {"requeue"}}
+	if rec := deadLetterRequest(s, http.MethodPost, requeue); !strings.Contains(rec.Body.String(), `"failed"`) {
+		t.Fatalf("requeue to a failing webhook = %d %s", rec.Code, rec.Body)
+	}
+	s.deadLetters.Lock()
+	requeued := append([]deadLetter{}, s.deadLetters.entries...)
+	s.deadLetters.Unlock()
+	if len(requeued) != 1 || requeued[0].ID != entry.ID || requeued[0].Attempts != 6 {
+		t.Fatalf("after a failed requeue dead letters = %+v, want entry %d with 6 attempts", requeued, entry.ID)
+	}
+
+	atomic.StoreInt32(&status, http.StatusOK)
+	if rec := deadLetterRequest(s, http.MethodPost, requeue); !strings.Contains(rec.Body.String(), `"delivered"`) {
+		t.Fatalf("requeue = %d %s, want delivered", rec.Code, rec.Body)
+	}
+	s.deadLetters.Lock()
+	defer s.deadLetters.Unlock()
+	if len(s.deadLetters.entries) != 0 {
+		t.Errorf("dead letters after delivery = %+v, want none", s.deadLetters.entries)
+	}
+}
+
+func TestWebhookClientErrorsAreNotRetried(t *testing.T) {
+	t.Setenv("WEBHOOK_MAX_ATTEMPTS", "3")
+	status, hits := int32(http.StatusBadRequest), int32(0)
+	webhookTarget(t, &status, &hits)
+	s := newTestServer(t)
+
+	if err := s.deliverPayload(context.Background(), webhookPayload{Event: webhookEventTransaction, MessageID: "m1"}); err == nil {
+		t.Fatal("delivery answered with 400 succeeded")
+	}
+	if got := atomic.LoadInt32(&hits); got != 1 {
+		t.Errorf("webhook received %d attempts, want 1", got)
+	}
+	s.deadLetters.Lock()
+	defer s.deadLetters.Unlock()
+	if len(s.deadLetters.entries) != 1 || s.deadLetters.entries[0].Attempts != 1 {
+		t.Errorf("dead letters = %+v, want the one failed attempt", s.deadLetters.entries)
+	}
+}
+
+func TestDeadLettersAreCapped(t *testing.T) {
+	s := newTestServer(t)
+	for i := 0; i < maxDeadLetters+5; i++ {
+		s.addDeadLetter(deadLetter{Target: "https://example.com/hook", Event: webhookEventTransaction})
+	}
+
+	s.deadLetters.Lock()
+	defer s.deadLetters.Unlock()
+	if got := len(s.deadLetters.entries); got != maxDeadLetters {
+		t.Fatalf("dead letters = %d, want %d", got, maxDeadLetters)
+	}
+	if first := s.deadLetters.entries[0].ID; first != 6 {
+		t.Errorf("oldest kept dead letter = %d, want 6", first)
+	}
+	if s.deadLetters.dropped != 5 {
+		t.Errorf("dropped = %d, want 5", s.deadLetters.dropped)
+	}
+}
+
+func TestDeadLetterRequiresAdmin(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+	rec := httptest.NewRecorder()
+	newTestServer(t).Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/deadletter", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", rec.Code)
+	}
+}